@@ -0,0 +1,316 @@
+// Package diff compares the rows of a table held by two databases. It works
+// on row streams provided by the caller and does no I/O of its own, so it
+// builds for any target including GOOS=js GOARCH=wasm, and tooling can
+// preview a sync with the same engine the CLI uses.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Kind tells how a row differs between the source and the target.
+type Kind string
+
+const (
+	SourceOnly Kind = "source-only" // the target lacks the row
+	TargetOnly Kind = "target-only" // the source lacks the row
+	Changed    Kind = "changed"     // both hold the key with other values
+)
+
+// Table describes the rows of a compared table: the key columns followed by
+// Columns. KeyColumns may name columns also listed in Columns.
+//
+// JSONColumns lists the columns holding JSON documents, compared document by
+// document when both sides hold valid JSON, see JSONPaths.
+type Table struct {
+	Name        string
+	KeyColumns  []string
+	Columns     []string
+	JSONColumns []string
+}
+
+// Row is a row that is not the same on both sides. Source and Target map
+// column names to values, the missing side is nil, and Changed lists the
+// columns that differ for changed rows. Paths holds the JSON paths that
+// differ in the changed JSON columns.
+type Row struct {
+	Table   string                 `json:"table"`
+	Kind    Kind                   `json:"kind"`
+	Key     []interface{}          `json:"key"`
+	Source  map[string]interface{} `json:"source,omitempty"`
+	Target  map[string]interface{} `json:"target,omitempty"`
+	Changed []string               `json:"changed,omitempty"`
+	Paths   map[string][]string    `json:"paths,omitempty"`
+}
+
+// TableStats holds the row counts for a single compared table.
+type TableStats struct {
+	Table      string
+	SourceOnly int64
+	TargetOnly int64
+	Changed    int64
+	Identical  int64
+}
+
+// Different reports whether any row of the table differs.
+func (s TableStats) Different() bool {
+	return s.SourceOnly > 0 || s.TargetOnly > 0 || s.Changed > 0
+}
+
+// RowReader yields the rows of one side of a table, laid out as described by
+// Table and sorted by key with CompareKeys. Next returns io.EOF after the
+// last row; the returned slice may be reused by the following call.
+type RowReader interface {
+	Next() ([]interface{}, error)
+}
+
+// Rows returns a RowReader over rows held in memory, which must already be
+// sorted by key.
+func Rows(rows [][]interface{}) RowReader {
+	return &sliceReader{rows: rows}
+}
+
+type sliceReader struct {
+	rows [][]interface{}
+}
+
+func (r *sliceReader) Next() ([]interface{}, error) {
+	if len(r.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+// Compare merges the source and target rows of a table by key and calls fn
+// for every row that differs. Only the current row of each side is held, so
+// memory use doesn't grow with the table size.
+func Compare(table Table, source, target RowReader, fn func(Row) error) (TableStats, error) {
+	stats := TableStats{Table: table.Name}
+	nkey := len(table.KeyColumns)
+
+	next := func(r RowReader, side string) ([]interface{}, error) {
+		values, err := r.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", side, err)
+		}
+		if len(values) != nkey+len(table.Columns) {
+			return nil, fmt.Errorf("reading %s: got %d values, want %d", side, len(values), nkey+len(table.Columns))
+		}
+		return values, nil
+	}
+	row := func(values []interface{}) map[string]interface{} {
+		m := make(map[string]interface{}, len(table.Columns))
+		for i, c := range table.Columns {
+			m[c] = values[nkey+i]
+		}
+		return m
+	}
+	key := func(values []interface{}) []interface{} {
+		return append([]interface{}{}, values[:nkey]...)
+	}
+
+	a, err := next(source, "source")
+	if err != nil {
+		return stats, err
+	}
+	b, err := next(target, "target")
+	if err != nil {
+		return stats, err
+	}
+	for a != nil || b != nil {
+		cmp := 0
+		switch {
+		case b == nil:
+			cmp = -1
+		case a == nil:
+			cmp = 1
+		default:
+			cmp = CompareKeys(a[:nkey], b[:nkey])
+		}
+
+		var d *Row
+		switch {
+		case cmp < 0:
+			stats.SourceOnly++
+			d = &Row{Kind: SourceOnly, Key: key(a), Source: row(a)}
+		case cmp > 0:
+			stats.TargetOnly++
+			d = &Row{Kind: TargetOnly, Key: key(b), Target: row(b)}
+		default:
+			if changed, paths := changedColumns(table, a[nkey:], b[nkey:]); len(changed) > 0 {
+				stats.Changed++
+				d = &Row{Kind: Changed, Key: key(a), Source: row(a), Target: row(b), Changed: changed, Paths: paths}
+			} else {
+				stats.Identical++
+			}
+		}
+		if d != nil {
+			d.Table = table.Name
+			if err := fn(*d); err != nil {
+				return stats, err
+			}
+		}
+
+		if cmp <= 0 {
+			if a, err = next(source, "source"); err != nil {
+				return stats, err
+			}
+		}
+		if cmp >= 0 {
+			if b, err = next(target, "target"); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// changedColumns returns the columns whose values differ in storage class or
+// value between two rows, and the differing paths of the JSON columns.
+func changedColumns(table Table, a, b []interface{}) ([]string, map[string][]string) {
+	var (
+		changed []string
+		paths   map[string][]string
+	)
+	for i, c := range table.Columns {
+		if Equal(a[i], b[i]) {
+			continue
+		}
+		if isJSONColumn(table, c) {
+			if p, ok := JSONPaths(a[i], b[i]); ok {
+				if len(p) == 0 {
+					continue
+				}
+				if paths == nil {
+					paths = map[string][]string{}
+				}
+				paths[c] = p
+			}
+		}
+		changed = append(changed, c)
+	}
+	return changed, paths
+}
+
+func isJSONColumn(table Table, column string) bool {
+	for _, c := range table.JSONColumns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether two values have the same storage class and value, so
+// 1 and 1.0, or 'a' and x'61', differ.
+func Equal(a, b interface{}) bool {
+	if storageClass(a) != storageClass(b) {
+		return false
+	}
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	_, aTime := a.(time.Time)
+	_, bTime := b.(time.Time)
+	return aFloat == bFloat && aTime == bTime && CompareValues(a, b) == 0
+}
+
+// CompareKeys orders two keys column by column, see CompareValues.
+func CompareKeys(a, b []interface{}) int {
+	for i := range a {
+		if c := CompareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// CompareValues orders values the way SQLite does with the BINARY collation:
+// NULL first, then numbers, text and blobs. Times, read from date columns,
+// sort with the text.
+func CompareValues(a, b interface{}) int {
+	ca, cb := storageClass(a), storageClass(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case int64, float64, bool:
+		ia, aInt := toInt64(a)
+		ib, bInt := toInt64(b)
+		if aInt && bInt {
+			return compareOrdered(ia, ib)
+		}
+		return compareOrdered(toFloat64(a), toFloat64(b))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	default:
+		return strings.Compare(textOf(a), textOf(b))
+	}
+}
+
+func storageClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64, bool:
+		return 1
+	case []byte:
+		return 3
+	default:
+		return 2
+	}
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return x, true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) float64 {
+	if x, ok := v.(float64); ok {
+		return x
+	}
+	n, _ := toInt64(v)
+	return float64(n)
+}
+
+func textOf(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareValues(t *testing.T) {
+	// Sorted the way SQLite sorts them
+	sorted := []interface{}{nil, int64(-1), 0.5, true, int64(2), 2.5, "", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "B", "a", []byte{}, []byte("a")}
+	for i := range sorted {
+		for j := range sorted {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := CompareValues(sorted[i], sorted[j]); got != want {
+				t.Errorf("CompareValues(%#v, %#v) = %d, want %d", sorted[i], sorted[j], got, want)
+			}
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{int64(1), int64(1), true},
+		{int64(1), true, true},
+		{int64(1), 1.0, false},
+		{"a", []byte("a"), false},
+		{nil, "", false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.equal {
+			t.Errorf("Equal(%#v, %#v) = %v, want %v", tt.a, tt.b, got, tt.equal)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	table := Table{Name: "t", KeyColumns: []string{"id"}, Columns: []string{"id", "v"}}
+	source := Rows([][]interface{}{
+		{int64(1), int64(1), "a"},
+		{int64(2), int64(2), "b"},
+		{int64(4), int64(4), "d"},
+	})
+	target := Rows([][]interface{}{
+		{int64(2), int64(2), "B"},
+		{int64(3), int64(3), "c"},
+		{int64(4), int64(4), "d"},
+	})
+
+	var got []Row
+	stats, err := Compare(table, source, target, func(r Row) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	want := []Row{
+		{Table: "t", Kind: SourceOnly, Key: []interface{}{int64(1)}, Source: map[string]interface{}{"id": int64(1), "v": "a"}},
+		{Table: "t", Kind: Changed, Key: []interface{}{int64(2)},
+			Source:  map[string]interface{}{"id": int64(2), "v": "b"},
+			Target:  map[string]interface{}{"id": int64(2), "v": "B"},
+			Changed: []string{"v"}},
+		{Table: "t", Kind: TargetOnly, Key: []interface{}{int64(3)}, Target: map[string]interface{}{"id": int64(3), "v": "c"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() rows = %+v, want %+v", got, want)
+	}
+	wantStats := TableStats{Table: "t", SourceOnly: 1, TargetOnly: 1, Changed: 1, Identical: 1}
+	if stats != wantStats {
+		t.Errorf("Compare() stats = %+v, want %+v", stats, wantStats)
+	}
+}
+
+func TestCompareRowWidth(t *testing.T) {
+	table := Table{Name: "t", KeyColumns: []string{"id"}, Columns: []string{"v"}}
+	_, err := Compare(table, Rows([][]interface{}{{int64(1)}}), Rows(nil), func(Row) error { return nil })
+	if err == nil {
+		t.Error("Compare() accepted a row missing values")
+	}
+}
+
+func TestJSONPaths(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		paths []string
+		ok    bool
+	}{
+		{`{"a": 1, "b": [1, 2]}`, `{"b":[1,2],"a":1.0}`, nil, true},
+		{`{"a": {"x": 1, "y": 2}}`, `{"a": {"x": 1, "y": 3, "z": 0}}`, []string{"$.a.y", "$.a.z"}, true},
+		{`{"list": [1, 2, 3]}`, `{"list": [1, 5, 3]}`, []string{"$.list[1]"}, true},
+		{`{"list": [1]}`, `{"list": [1, 2]}`, []string{"$.list"}, true},
+		{`{"my key": null}`, `{"my key": false}`, []string{`$."my key"`}, true},
+		{`{"a": 1}`, `not json`, nil, false},
+	}
+	for _, tt := range tests {
+		paths, ok := JSONPaths(tt.a, tt.b)
+		if ok != tt.ok || !reflect.DeepEqual(paths, tt.paths) {
+			t.Errorf("JSONPaths(%s, %s) = %q, %v, want %q, %v", tt.a, tt.b, paths, ok, tt.paths, tt.ok)
+		}
+	}
+}
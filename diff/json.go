@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// JSONPaths compares two JSON documents and returns the paths at which they
+// differ, in SQLite JSON path syntax: $.a.b for object members, $.a[2] for
+// array elements. Key order and whitespace don't matter. ok is false when
+// either value is not a JSON text.
+func JSONPaths(a, b interface{}) (paths []string, ok bool) {
+	va, ok := decodeJSON(a)
+	if !ok {
+		return nil, false
+	}
+	vb, ok := decodeJSON(b)
+	if !ok {
+		return nil, false
+	}
+	return jsonPaths("$", va, vb, nil), true
+}
+
+func decodeJSON(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return nil, false
+	}
+	return doc, true
+}
+
+func jsonPaths(path string, a, b interface{}, paths []string) []string {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok {
+			return append(paths, path)
+		}
+		keys := make([]string, 0, len(x)+len(y))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			va, inA := x[k]
+			vb, inB := y[k]
+			if !inA || !inB {
+				paths = append(paths, memberPath(path, k))
+				continue
+			}
+			paths = jsonPaths(memberPath(path, k), va, vb, paths)
+		}
+		return paths
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return append(paths, path)
+		}
+		for i := range x {
+			paths = jsonPaths(fmt.Sprintf("%s[%d]", path, i), x[i], y[i], paths)
+		}
+		return paths
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok || !sameNumber(x, y) {
+			return append(paths, path)
+		}
+		return paths
+	default:
+		// strings, booleans and null
+		if a != b {
+			return append(paths, path)
+		}
+		return paths
+	}
+}
+
+// sameNumber compares JSON numbers by value, so 1.0 and 1 are equal.
+func sameNumber(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	fa, errA := a.Float64()
+	fb, errB := b.Float64()
+	return errA == nil && errB == nil && fa == fb
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func memberPath(path, key string) string {
+	if plainKey.MatchString(key) {
+		return path + "." + key
+	}
+	quoted, _ := json.Marshal(key)
+	return path + "." + string(quoted)
+}
@@ -2,9 +2,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/alvarolm/rslite/diff"
 	"github.com/alvarolm/rslite/sync"
 	"github.com/spf13/cobra"
 )
@@ -19,46 +33,1504 @@ const ExampleUsage = `
   # Sync using "Primary Key" filters (sync records with "Primary Key" > 100)
   rslite source.db target.db -f gt -p 100
 
+  # Sync the rows created since a date, whatever the primary key
+  rslite source.db target.db -f gte -v 2024-01-01 --filter-column created_at
+
+  # Sync the sharded log tables, leaving the temporary ones out
+  rslite source.db target.db -t 'log_*' --exclude-tables '*_tmp'
+
   # Sync specific tables without deleting existing records
   rslite source.db target.db -t users,orders -n
 
   # Complex sync with filters and specific tables
-  rslite source.db target.db -t users,orders -f gte -p 1000 -n`
+  rslite source.db target.db -t users,orders -f gte -p 1000 -n
+
+  # Sync the rows matching arbitrary conditions
+  rslite source.db target.db --where "status != 'archived'" --table-where "orders=created_at > '2024-01-01'"
+
+  # Consolidate legacy tables under their new names
+  rslite legacy.db app.db --map src_users=users,legacy_orders=orders
+
+  # Keep the password hashes out of the target
+  rslite source.db target.db --exclude-columns users=password_hash
+
+  # Sync with per-table keys, conditions, excluded columns and target names
+  rslite source.db target.db --config sync.yaml
+
+  # Produce a sanitized staging copy with the masking rules of a config file
+  rslite prod.db staging.db --config mask.yaml
+
+  # Sync one source to several replicas, reading it once
+  rslite source.db replica1.db replica2.db replica3.db
+
+  # Update either every replica or none of them
+  rslite source.db replica1.db replica2.db --all-or-nothing --verify
+
+  # Back up the database of an edge device over SSH
+  rslite ssh://pi@sensor:2222/var/lib/app/app.db backup.db
+
+  # Sync from a database published as a static file, downloaded only once changed
+  rslite https://data.example.com/catalog.db catalog.db
+
+  # Back up to S3, encrypted with a KMS key
+  rslite app.db s3://backups/app.db --sse aws:kms --sse-kms-key alias/backups
+
+  # Serve a database, then pull only the new rows from another host
+  rslite serve --db app.db --listen :8080 --token secret
+  rslite rslite://db-host:8080 replica.db --token secret --state replica.state --updated-column updated_at
+
+  # Over a slow link, only pull the rows of the key ranges that differ
+  rslite rslite://db-host:8080 replica.db --token secret --range-diff
+
+  # Serve on a unix socket behind a local reverse proxy
+  rslite serve --db app.db --listen unix:/run/rslite/app.sock --token secret
+
+  # Serve the tokens of grants.yaml only the tables and rows they are granted
+  rslite serve --db app.db --grants grants.yaml --token admin-secret
+
+  # Sync a local database to a hosted Turso database
+  rslite app.db libsql://app-myorg.turso.io --libsql-token "$TURSO_TOKEN"
+
+  # Sync the rows modified after a point in time
+  rslite source.db target.db -n --updated-column updated_at --since 2024-06-01T00:00:00Z
+
+  # Follow a long sync table by table, with rows/s and ETA
+  rslite big.db target.db --progress
+
+  # Log the tables and statements of a run as JSON
+  rslite source.db target.db --verbose --verbose --log-format json
+
+  # Sync only the rows added since the previous run
+  rslite source.db target.db -n --state sync.state
+
+  # Incremental syncs deleting the rows marked deleted in the source
+  rslite source.db target.db -n --state sync.state --updated-column updated_at --soft-delete-column deleted_at
+
+  # Match the rows of tables without a primary key on all their columns
+  rslite source.db target.db --keyless columns
+
+  # Refuse to empty a replica from a truncated source
+  rslite source.db replica.db --max-delete-fraction 0.2
+
+  # Make the target a full replica, with the views, indexes and triggers
+  rslite source.db replica.db --schema-objects --drop-schema-objects
+
+  # Carry the schema migration version of the source over to the target
+  rslite source.db target.db --copy-version
+
+  # Keep the AUTOINCREMENT counters of a writable replica ahead of the source
+  rslite source.db replica.db --sequences bump
+
+  # Sync the articles, then rebuild their full-text index on the target
+  rslite source.db target.db -t 'articles*' --rebuild-fts
+
+  # Sync into a target whose columns were reordered, matching them by name
+  rslite source.db target.db --ignore-schema-diff
+
+  # Add the columns and indexes new in the source to the target first
+  rslite source.db target.db --migrate-schema
+
+  # Fill a column the migration adds in the rows an incremental sync skips
+  rslite source.db target.db --state sync.state --migrate-schema --backfill --backfill-column "users.region='eu'"
+
+  # Make the target an exact copy, dropping its tables the source lacks
+  rslite source.db replica.db --mirror --protect 'local_*'
+
+  # Check the synced tables against the source with SHA-256 checksums
+  rslite source.db target.db --snapshot --verify --checksum sha256
+
+  # Spot-check 1000 random rows of every synced table against the source
+  rslite source.db target.db --verify-sample 1000
+
+  # Refuse to copy from a corrupt source, and check the target afterwards
+  rslite source.db target.db --check-source --check-target
+
+  # Speed up a bulk load, the journal mode is set back afterwards
+  rslite source.db target.db --dst-pragmas journal_mode=WAL,synchronous=NORMAL,cache_size=-200000
+
+  # Keep a heavily churned target compact, with fresh planner statistics
+  rslite source.db target.db --analyze --vacuum
+
+  # From cron, wait up to 30s for a previous run of the same target to finish
+  rslite source.db target.db --wait-lock 30s
+
+  # Consolidate per-day files into one database
+  rslite day1.db all.db -n --union day2.db --union day3.db
+
+  # Sync the uploaded files along with the rows referencing them
+  rslite source.db target.db --attachments uploads=/srv/backup/uploads
+
+  # Let a scheduled job parse the outcome of the run
+  rslite source.db target.db -q --report run.json
+
+  # Post the outcome of every run to a webhook, and page on failures
+  rslite source.db target.db --notify-url https://hooks.example.com/rslite \
+    --notify-cmd '[ "$RSLITE_SUCCESS" = true ] || page-oncall'
+
+  # Record a sync and replay it later against a copy of the target
+  rslite source.db target.db --record run.rec
+  rslite replay run.rec --against target-copy.db
+
+  # Check in CI that a job runs against the production schema
+  rslite fixtures.db schema-only.db --simulate
+
+  # Show the tables, columns and conditions a sync would use
+  rslite explain source.db target.db -t users,orders --where "active = 1"
+
+  # Review the statements of a sync before running them
+  rslite plan source.db target.db -o changes.sql
+
+  # Then run them, unless the target changed in the meantime
+  rslite apply changes.sql target.db
+
+  # Log the changes of the source, then only sync the changed rows
+  rslite track enable source.db
+  rslite source.db target.db --tracked
+
+  # Keep a replica synced every 30 seconds
+  rslite watch source.db replica.db --interval 30s
+
+  # Keep a read replica nearly real-time, syncing when the source is written
+  rslite watch source.db replica.db --on-change
+
+  # Run the sync jobs of a file, each on its own cron schedule
+  rslite watch --job-file jobs.yaml
+
+  # Let Prometheus scrape the outcome of the jobs
+  rslite watch --job-file jobs.yaml --metrics-listen :9090
+
+  # Manage the jobs over HTTP, then run one of them right away
+  rslite watch --job-file jobs.yaml --api-listen :8081 --api-token secret
+  curl -X POST -H "Authorization: Bearer secret" localhost:8081/v1/jobs/replica/run
+
+  # Replace a drifted replica with a fresh copy of the source
+  rslite rebuild source.db replica.db
+
+  # Check that the target matches the source, exiting with 1 if not
+  rslite diff source.db target.db -t users,orders
+
+  # Print the content hashes of tables, to compare with another machine's
+  rslite hash db.sqlite -t users,orders
+
+  # Record every run in a table of the target, then list the last ten
+  rslite source.db target.db --history
+  rslite history target.db -n 10
+
+  # Sum up the last hundred runs: success rate, durations, busiest tables
+  rslite history target.db -n 100 --summary`
 
 func main() {
-	var cfg sync.Config
+	var (
+		opts     syncOptions
+		logs     logOptions
+		progress bool
+	)
 
 	rootCmd := &cobra.Command{
 		Version: "v0.0.1",
-		Use:     `syncs [source db] [target db]`,
+		Use:     `syncs [source db] [target db]...`,
 		Short:   "sqlite row based synchronization for local dbs",
-		Long:    "sqlite row based synchronization for local dbs",
+		Long: `sqlite row based synchronization for local dbs
+
+Exit codes:
+  0  the sync succeeded
+  1  the sync failed for another reason
+  2  invalid arguments, flags or configuration
+  3  the source database can't be opened or read
+  4  the target database can't be opened
+  5  the target lacks a synced table or column
+  6  some tables were synced and committed, others failed
+  7  the target failed the checks made after the sync`,
 		Example: ExampleUsage,
-		Args:    cobra.ExactArgs(2),
+		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg.SrcDbPath = args[0]
-			cfg.DstDbPath = args[1]
-			return sync.Sync(cfg)
+			targets := args[1:]
+			if opts.configPath != "" {
+				listed, err := sync.LoadTargets(opts.configPath)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, listed...)
+			}
+			if len(targets) == 0 {
+				return errors.New("no target db: give one after the source, or list them under targets in --config")
+			}
+			cfg, err := opts.config([]string{args[0], targets[0]})
+			if err != nil {
+				return err
+			}
+			if cfg.Logger, err = logs.logger(cmd.ErrOrStderr()); err != nil {
+				return err
+			}
+			if progress {
+				cfg.Progress = newProgressPrinter(cmd.ErrOrStderr()).report
+			}
+			if len(targets) > 1 {
+				return syncTargets(cmd, cfg, targets, logs.quiet)
+			}
+			stats, err := sync.SyncContext(cmd.Context(), cfg)
+			if !logs.quiet {
+				printStats(cmd.OutOrStdout(), stats)
+			}
+			printIssues(cmd.ErrOrStderr(), stats)
+			return syncExit(err, stats, cfg.Atomic)
 		},
 	}
+	opts.addFlags(rootCmd)
+	rootCmd.Flags().BoolVar(&progress, "progress", false, "show the progress of every table on stderr")
+	logs.addFlags(rootCmd)
 
-	flags := rootCmd.Flags()
-	flags.StringVarP(&cfg.Filter, "filter", "f", "", "filter type: gt, lt, gte, or lte")
-	flags.StringVarP(&cfg.Value, "value", "v", "", "filter value")
-	flags.BoolVarP(&cfg.NoDelete, "nodelete", "n", false, "don't delete records from target")
-	flags.StringSliceVarP(&cfg.Tables, "tables", "t", nil, "tables to sync (comma-separated)")
+	rootCmd.AddCommand(newReplayCmd(), newDiffCmd(), newExplainCmd(), newRebuildCmd(), newWatchCmd(), newServeCmd(), newPlanCmd(), newApplyCmd(), newTrackCmd(), newHashCmd(), newHistoryCmd())
 
 	// Custom error handling
 	rootCmd.SilenceErrors = true
 	rootCmd.SilenceUsage = true
 
-	if err := rootCmd.Execute(); err != nil {
+	// Cancel the running sync on SIGINT/SIGTERM so the in-flight table
+	// transaction is rolled back instead of the process being killed mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			if exit.err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", exit.err)
+			}
+			stop()
+			os.Exit(exit.code)
+		}
 		fmt.Fprintln(os.Stderr, "Error:", err)
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, rootCmd.Short)
 		fmt.Fprintln(os.Stderr)
 		rootCmd.Usage()
-		os.Exit(1)
+		stop()
+		os.Exit(exitUsage)
+	}
+}
+
+// syncOptions holds the flags of a sync, shared by the commands that take
+// them.
+type syncOptions struct {
+	cfg         sync.Config
+	tableWhere  []string
+	attachments []string
+	configPath  string
+	tableMap    []string
+	columns     []string
+	excluded    []string
+	backfill    []string
+}
+
+func (o *syncOptions) addFlags(cmd *cobra.Command) {
+	cfg := &o.cfg
+	flags := cmd.Flags()
+	flags.StringVarP(&cfg.Filter, "filter", "f", "", "filter type: gt, lt, gte, or lte")
+	flags.StringVarP(&cfg.Value, "value", "v", "", "filter value")
+	flags.StringVar(&cfg.FilterColumn, "filter-column", "", "column compared by the filter (default: primary key)")
+	flags.StringVar(&cfg.UpdatedColumn, "updated-column", "", "column holding the modification time of the rows")
+	flags.StringVar(&cfg.Since, "since", "", "only sync rows modified after this time (RFC 3339 or YYYY-MM-DD)")
+	flags.StringVar(&cfg.SoftDeleteColumn, "soft-delete-column", "", "column set on the source rows to delete from the target")
+	flags.StringVar(&cfg.KeylessMatch, "keyless", "", "matching of the rows of tables without a primary key: rowid or columns (default rowid)")
+	flags.Float64Var(&cfg.MaxDeleteFraction, "max-delete-fraction", 0, "fail a table whose sync would delete more than this fraction of its target rows (0 disables)")
+	flags.BoolVar(&cfg.Force, "force", false, "sync past --max-delete-fraction")
+	flags.BoolVarP(&cfg.NoDelete, "nodelete", "n", false, "don't delete records from target")
+	flags.StringSliceVarP(&cfg.Tables, "tables", "t", nil, "tables to sync, by name or glob pattern (comma-separated)")
+	flags.StringSliceVar(&cfg.ExcludeTables, "exclude-tables", nil, "tables not to sync, by name or glob pattern (comma-separated)")
+	flags.StringVar(&cfg.Where, "where", "", "SQL condition selecting the source rows to sync")
+	flags.StringArrayVar(&o.tableWhere, "table-where", nil, "SQL condition for a single table, as TABLE=CONDITION (repeatable)")
+	flags.StringVar(&o.configPath, "config", "", "per-table settings read from this YAML file")
+	flags.StringSliceVar(&o.tableMap, "map", nil, "tables written under another name in the target, as SOURCE=TARGET (comma-separated)")
+	flags.StringArrayVar(&o.columns, "columns", nil, "the only columns copied besides the key, as TABLE=COLUMN,... (repeatable)")
+	flags.StringArrayVar(&o.excluded, "exclude-columns", nil, "`columns` never copied, as TABLE=COLUMN,... (repeatable)")
+	flags.StringArrayVar(&cfg.UnionSources, "union", nil, "more source databases read after the first, later ones win on key conflicts (repeatable)")
+	flags.StringVar(&cfg.StatePath, "state", "", "file keeping the per-table watermarks of incremental syncs")
+	flags.StringVar(&cfg.WatermarkColumn, "watermark-column", "", "column tracked by incremental syncs (default: updated column or primary key)")
+	flags.StringVar(&cfg.RecordPath, "record", "", "record the rows and decisions of the run to this file")
+	flags.StringVar(&cfg.ReportPath, "report", "", "write a JSON report of the run to this file")
+	flags.BoolVar(&cfg.History, "history", false, "record the run in a history table of the target, listed by rslite history")
+	flags.StringVar(&cfg.NotifyURL, "notify-url", "", "POST the JSON report of every run to this URL")
+	flags.StringVar(&cfg.NotifyCmd, "notify-cmd", "", "run this shell command with the JSON report of every run on its standard input")
+	flags.IntVar(&cfg.PageSize, "page-size", 0, "rows read from the source per query (default 1000)")
+	flags.IntVar(&cfg.BatchSize, "batch-size", 0, "commit the target every N rows (0 commits once per table)")
+	flags.BoolVar(&cfg.KeepGoing, "keep-going", false, "sync the remaining tables when one fails")
+	flags.BoolVar(&cfg.Atomic, "atomic", false, "sync all tables in a single target transaction")
+	flags.BoolVar(&cfg.AllOrNothing, "all-or-nothing", false, "with several targets, change them all or none")
+	flags.BoolVar(&cfg.SingleTx, "single-tx", false, "write each table in a single transaction, ignoring --batch-size")
+	flags.IntVarP(&cfg.Jobs, "jobs", "j", 1, "number of tables to sync concurrently")
+	flags.DurationVar(&cfg.BusyTimeout, "busy-timeout", 0, "how long to wait for a locked database (default 5s)")
+	flags.IntVar(&cfg.Retries, "retries", 3, "times a table failing on a locked database is synced again")
+	flags.StringVar(&cfg.DstTxLock, "tx-lock", "", "target transaction locking: deferred, immediate or exclusive")
+	flags.Int64Var(&cfg.MaxRowSize, "max-row-size", 0, "flag rows larger than this many bytes (0 disables)")
+	flags.BoolVar(&cfg.CheckUTF8, "check-utf8", false, "flag rows with invalid UTF-8 in text values")
+	flags.BoolVar(&cfg.SkipFlagged, "skip-flagged", false, "don't write rows flagged by the data guards")
+	flags.StringVar(&cfg.FixEncoding, "fix-encoding", "", "policy for text with invalid UTF-8: repair, blob or reject")
+	flags.BoolVar(&cfg.SkipUnchanged, "skip-unchanged", false, "only write rows that differ from the target")
+	flags.StringVar(&cfg.Checksum, "checksum", "", "row checksum algorithm: xxhash64, fnv or sha256 (default xxhash64)")
+	flags.BoolVar(&cfg.Replace, "replace", false, "write rows with INSERT OR REPLACE instead of updating them in place")
+	flags.Int64Var(&cfg.ExternalizeBlobs, "externalize-blobs", 0, "store BLOBs larger than this many bytes as files next to the target (0 disables)")
+	flags.StringVar(&cfg.BlobDir, "blob-dir", "", "directory of the externalized BLOBs (default: target path + .blobs)")
+	flags.StringSliceVar(&cfg.JSONColumns, "json-column", nil, "JSON columns merged member by member, as TABLE.COLUMN or COLUMN (comma-separated)")
+	flags.BoolVar(&cfg.DeferConstraints, "defer-constraints", false, "don't enforce foreign keys while syncing, check them at the end")
+	flags.BoolVar(&cfg.DisableTriggers, "disable-triggers", false, "drop the target triggers of the synced tables while syncing")
+	flags.BoolVar(&cfg.SchemaObjects, "schema-objects", false, "also sync the views, indexes and triggers")
+	flags.BoolVar(&cfg.DropSchemaObjects, "drop-schema-objects", false, "drop the target views, indexes and triggers missing from the source")
+	flags.BoolVar(&cfg.IgnoreSchemaDiff, "ignore-schema-diff", false, "sync tables whose columns differ in the target, mapping them by name")
+	flags.DurationVar(&cfg.WaitLock, "wait-lock", 0, "how long to wait for another sync of the same target to finish (default: fail at once)")
+	flags.BoolVar(&cfg.Analyze, "analyze", false, "run ANALYZE on the synced target tables after a successful sync")
+	flags.BoolVar(&cfg.Vacuum, "vacuum", false, "run VACUUM on the target after a successful sync")
+	flags.StringSliceVar(&cfg.DstPragmas, "dst-pragmas", nil, "PRAGMA settings of the target connections, as NAME=VALUE (comma-separated)")
+	flags.BoolVar(&cfg.CheckSource, "check-source", false, "run PRAGMA quick_check on the source before reading it")
+	flags.BoolVar(&cfg.CheckTarget, "check-target", false, "run PRAGMA quick_check on the target after writing it")
+	flags.BoolVar(&cfg.FlagCorruption, "flag-corruption", false, "report the problems the quick checks find without failing")
+	flags.BoolVar(&cfg.Verify, "verify", false, "compare the row counts and checksums of the synced tables with the source at the end")
+	flags.IntVar(&cfg.VerifySample, "verify-sample", 0, "verify the synced tables on this many random rows each")
+	flags.BoolVar(&cfg.Mirror, "mirror", false, "drop the target tables missing from the source")
+	flags.StringSliceVar(&cfg.Protect, "protect", nil, "target tables --mirror never drops, by name or glob pattern (comma-separated)")
+	flags.BoolVar(&cfg.MigrateSchema, "migrate-schema", false, "add the missing columns and indexes to the target tables before syncing")
+	flags.BoolVar(&cfg.Backfill, "backfill", false, "fill the columns --migrate-schema adds with the values of the source rows")
+	flags.StringArrayVar(&o.backfill, "backfill-column", nil, "fill a column --migrate-schema adds with an SQL expression, as TABLE.COLUMN=EXPR (repeatable)")
+	flags.BoolVar(&cfg.CopyVersion, "copy-version", false, "copy the user_version and application_id pragmas to the target")
+	flags.StringVar(&cfg.Sequences, "sequences", "", "sync the AUTOINCREMENT counters: copy or bump")
+	flags.BoolVar(&cfg.RebuildFTS, "rebuild-fts", false, "rebuild the full-text indexes of the target after syncing")
+	flags.BoolVar(&cfg.Simulate, "simulate", false, "sync into an in-memory copy of the target schema, leaving the target untouched")
+	flags.BoolVar(&cfg.NoAttach, "no-attach", false, "copy rows one by one instead of attaching the source to the target")
+	flags.BoolVar(&cfg.SrcImmutable, "src-immutable", false, "open the source without locking, for read-only media nothing writes to")
+	flags.BoolVar(&cfg.Snapshot, "snapshot", false, "sync every table from a point-in-time copy of the source")
+	flags.StringVar(&cfg.SSHCommand, "ssh", "", "command reaching the hosts of ssh:// and user@host:/path databases (default ssh)")
+	flags.StringVar(&cfg.CacheDir, "cache-dir", "", "directory of the source databases downloaded from URLs (default: user cache dir)")
+	flags.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "endpoint of the S3-compatible store of s3:// URLs")
+	flags.StringVar(&cfg.ObjectEncryption, "sse", "", "server-side encryption of uploaded targets: AES256 or aws:kms")
+	flags.StringVar(&cfg.ObjectKMSKey, "sse-kms-key", "", "KMS key encrypting uploaded targets with aws:kms")
+	flags.StringVar(&cfg.ServerToken, "token", "", "token of the rslite server of an rslite:// source")
+	flags.StringVar(&cfg.LibSQLToken, "libsql-token", "", "auth token of libsql:// databases")
+	flags.BoolVar(&cfg.RangeDiff, "range-diff", false, "only pull the key ranges differing from the target, from an rslite:// source")
+	flags.BoolVar(&cfg.Tracked, "tracked", false, "only sync the rows changed since the last run, as logged by rslite track enable")
+	flags.StringArrayVar(&o.attachments, "attachments", nil, "directory of files referenced by the rows, as SOURCE=TARGET (repeatable)")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+}
+
+// logOptions holds the logging flags.
+type logOptions struct {
+	verbose int
+	quiet   bool
+	format  string
+}
+
+func (o *logOptions) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.CountVar(&o.verbose, "verbose", "log the tables synced, repeat to log the statements and their timings")
+	flags.BoolVarP(&o.quiet, "quiet", "q", false, "only print errors, not the statistics")
+	flags.StringVar(&o.format, "log-format", "text", "format of the log lines on stderr: text or json")
+}
+
+// logger returns the logger writing to w at the level selected by the flags.
+// Warnings are logged by default.
+func (o *logOptions) logger(w io.Writer) (*slog.Logger, error) {
+	if o.verbose > 0 && o.quiet {
+		return nil, errors.New("--verbose and --quiet are mutually exclusive")
+	}
+	level := slog.LevelWarn
+	switch {
+	case o.quiet:
+		level = slog.LevelError
+	case o.verbose == 1:
+		level = slog.LevelInfo
+	case o.verbose > 1:
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch o.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: want text or json", o.format)
+	}
+}
+
+// config returns the Config of a sync from the source and target database
+// arguments and the flags.
+func (o *syncOptions) config(args []string) (sync.Config, error) {
+	cfg := o.cfg
+	cfg.SrcDbPath = args[0]
+	cfg.DstDbPath = args[1]
+	var err error
+	if cfg.TableWhere, err = parseTableWhere(o.tableWhere); err != nil {
+		return cfg, err
+	}
+	if cfg.AttachmentDirs, err = parseAttachments(o.attachments); err != nil {
+		return cfg, err
+	}
+	if o.configPath != "" {
+		if cfg.TableOptions, err = sync.LoadTableOptions(o.configPath); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.TableOptions, err = parseTableMap(cfg.TableOptions, o.tableMap); err != nil {
+		return cfg, err
+	}
+	if cfg.TableOptions, err = parseTableColumns(cfg.TableOptions, "--columns", o.columns, func(opts *sync.TableOptions, cols []string) {
+		opts.Columns = cols
+	}); err != nil {
+		return cfg, err
+	}
+	if cfg.TableOptions, err = parseTableColumns(cfg.TableOptions, "--exclude-columns", o.excluded, func(opts *sync.TableOptions, cols []string) {
+		opts.Exclude = cols
+	}); err != nil {
+		return cfg, err
+	}
+	if cfg.TableOptions, err = parseBackfill(cfg.TableOptions, o.backfill); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// parseTableWhere parses --table-where values, splitting each on its first
+// "=" since the condition may hold more.
+func parseTableWhere(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	where := make(map[string]string, len(values))
+	for _, v := range values {
+		table, cond, ok := strings.Cut(v, "=")
+		if !ok || table == "" || cond == "" {
+			return nil, fmt.Errorf("invalid --table-where %q: want TABLE=CONDITION", v)
+		}
+		if prev, ok := where[table]; ok {
+			cond = "(" + prev + ") AND (" + cond + ")"
+		}
+		where[table] = cond
+	}
+	return where, nil
+}
+
+// parseAttachments parses --attachments values.
+func parseAttachments(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	dirs := make(map[string]string, len(values))
+	for _, v := range values {
+		src, dst, ok := strings.Cut(v, "=")
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid --attachments %q: want SOURCE=TARGET", v)
+		}
+		dirs[src] = dst
+	}
+	return dirs, nil
+}
+
+// parseTableMap parses --map values into the target names of opts, which
+// they override.
+func parseTableMap(opts map[string]sync.TableOptions, values []string) (map[string]sync.TableOptions, error) {
+	if len(values) == 0 {
+		return opts, nil
+	}
+	if opts == nil {
+		opts = make(map[string]sync.TableOptions, len(values))
+	}
+	for _, v := range values {
+		src, dst, ok := strings.Cut(v, "=")
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid --map %q: want SOURCE=TARGET", v)
+		}
+		o := opts[src]
+		o.Target = dst
+		opts[src] = o
+	}
+	return opts, nil
+}
+
+// parseTableColumns parses the TABLE=COLUMN,... values of flag and sets
+// the columns of each table in opts.
+func parseTableColumns(opts map[string]sync.TableOptions, flag string, values []string, set func(*sync.TableOptions, []string)) (map[string]sync.TableOptions, error) {
+	if len(values) == 0 {
+		return opts, nil
+	}
+	if opts == nil {
+		opts = make(map[string]sync.TableOptions, len(values))
+	}
+	for _, v := range values {
+		table, list, ok := strings.Cut(v, "=")
+		if !ok || table == "" || list == "" {
+			return nil, fmt.Errorf("invalid %s %q: want TABLE=COLUMN,...", flag, v)
+		}
+		o := opts[table]
+		set(&o, strings.Split(list, ","))
+		opts[table] = o
+	}
+	return opts, nil
+}
+
+// parseBackfill parses --backfill-column values into the backfill
+// expressions of opts, splitting each on its first "=" since the expression
+// may hold more.
+func parseBackfill(opts map[string]sync.TableOptions, values []string) (map[string]sync.TableOptions, error) {
+	if len(values) == 0 {
+		return opts, nil
+	}
+	if opts == nil {
+		opts = make(map[string]sync.TableOptions, len(values))
+	}
+	for _, v := range values {
+		name, expr, ok := strings.Cut(v, "=")
+		table, column, dotted := strings.Cut(name, ".")
+		if !ok || !dotted || table == "" || column == "" || expr == "" {
+			return nil, fmt.Errorf("invalid --backfill-column %q: want TABLE.COLUMN=EXPR", v)
+		}
+		o := opts[table]
+		if o.Backfill == nil {
+			o.Backfill = make(map[string]string)
+		}
+		o.Backfill[column] = expr
+		opts[table] = o
+	}
+	return opts, nil
+}
+
+// syncTargets runs the sync of cfg to several targets, printing the stats
+// of each.
+func syncTargets(cmd *cobra.Command, cfg sync.Config, targets []string, quiet bool) error {
+	stats, err := sync.SyncTargets(cmd.Context(), cfg, targets)
+	// The exit code counts a target synced while another failed as partial
+	all := &sync.Stats{}
+	for i, s := range stats {
+		if !quiet && len(s.Tables) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s:\n", targets[i])
+			printStats(cmd.OutOrStdout(), s)
+		}
+		printIssues(cmd.ErrOrStderr(), s)
+		all.Tables = append(all.Tables, s.Tables...)
+	}
+	return syncExit(err, all, cfg.Atomic || cfg.AllOrNothing)
+}
+
+// printStats writes a per-table summary of a sync run.
+func printStats(w io.Writer, stats *sync.Stats) {
+	if stats == nil {
+		return
+	}
+	if f := stats.Files; f.Copied > 0 || f.Deleted > 0 {
+		fmt.Fprintf(w, "files: %d copied, %d deleted\n", f.Copied, f.Deleted)
+	}
+	if s := stats.Schema; s.Created > 0 || s.Replaced > 0 || s.Dropped > 0 {
+		fmt.Fprintf(w, "schema objects: %d created, %d replaced, %d dropped\n", s.Created, s.Replaced, s.Dropped)
+	}
+	if len(stats.Verified) > 0 {
+		var n int
+		for _, v := range stats.Verified {
+			if v.Matches() {
+				n++
+			}
+		}
+		fmt.Fprintf(w, "verified: %d of %d tables match the source\n", n, len(stats.Verified))
+	}
+	if len(stats.DroppedTables) > 0 {
+		fmt.Fprintf(w, "tables dropped: %s\n", strings.Join(stats.DroppedTables, ", "))
+	}
+	if m := stats.Migration; m.Columns > 0 || m.Indexes > 0 {
+		fmt.Fprintf(w, "schema migration: %d columns added, %d indexes created, %d rows backfilled\n", m.Columns, m.Indexes, m.Backfilled)
+	}
+	if len(stats.Tables) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tINSERTED\tREPLACED\tDELETED\tSKIPPED\tFLAGGED\tDURATION")
+	for _, t := range append(stats.Tables, stats.Total()) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			t.Table, t.Inserted, t.Replaced, t.Deleted, t.Skipped, t.Flagged, t.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
+
+// printIssues lists the problems found by the quick checks, the rows flagged
+// by the data guards and the foreign key violations left in the target.
+func printIssues(w io.Writer, stats *sync.Stats) {
+	if stats == nil {
+		return
+	}
+	for _, c := range stats.Corruption {
+		fmt.Fprintf(w, "Warning: %s failed the quick check: %s\n", c.Database, c.Message)
+	}
+	for _, v := range stats.Violations {
+		fmt.Fprintf(w, "Warning: %s row %v references a missing %s row\n", v.Table, formatValue(v.RowID), v.Parent)
+	}
+	for _, t := range stats.Tables {
+		for _, issue := range t.Issues {
+			fmt.Fprintf(w, "Warning: %s row %v: %s\n", t.Table, issue.PK, issue.Reason)
+		}
+		if more := t.Flagged - int64(len(t.Issues)); more > 0 {
+			fmt.Fprintf(w, "Warning: %s: %d more flagged rows not listed\n", t.Table, more)
+		}
+	}
+	printUnsupported(w, stats.Unsupported)
+}
+
+// printUnsupported writes the source objects a sync doesn't cover, with why.
+func printUnsupported(w io.Writer, objects []sync.Unsupported) {
+	for _, o := range objects {
+		fmt.Fprintf(w, "Not synced: %s %s: %s\n", o.Type, o.Name, o.Reason)
+	}
+}
+
+func newReplayCmd() *cobra.Command {
+	var against string
+
+	cmd := &cobra.Command{
+		Use:   "replay [recording]",
+		Short: "replay a recorded sync against a copy of the target",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stats, err := sync.Replay(cmd.Context(), args[0], against)
+			printStats(cmd.OutOrStdout(), stats)
+			return syncExit(err, stats, false)
+		},
+	}
+	cmd.Flags().StringVar(&against, "against", "", "target database to replay the recording on")
+	cmd.MarkFlagRequired("against")
+	return cmd
+}
+
+func newRebuildCmd() *cobra.Command {
+	var cfg sync.Config
+
+	cmd := &cobra.Command{
+		Use:   "rebuild [source db] [target db]",
+		Short: "replace the target with a fresh copy of the source",
+		Long: `replace the target with a fresh copy of the source
+
+The copy is written to a shadow file next to the target, checked and renamed
+over the target, so readers never see a partial database.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.SrcDbPath = args[0]
+			cfg.DstDbPath = args[1]
+			stats, err := sync.Rebuild(cmd.Context(), cfg)
+			printStats(cmd.OutOrStdout(), stats)
+			return syncExit(err, stats, true)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&cfg.SrcImmutable, "src-immutable", false, "open the source without locking, for read-only media nothing writes to")
+	flags.DurationVar(&cfg.BusyTimeout, "busy-timeout", 0, "how long to wait for a locked database (default 5s)")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	return cmd
+}
+
+func newWatchCmd() *cobra.Command {
+	var (
+		opts        syncOptions
+		logs        logOptions
+		interval    time.Duration
+		onChange    bool
+		debounce    time.Duration
+		schedule    string
+		jobsPath    string
+		metricsAddr string
+		apiAddr     string
+		apiToken    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch [source db] [target db]",
+		Short: "keep syncing the target on a schedule or when the source changes",
+		Long: `keep syncing the target on a schedule or when the source changes
+
+Syncs right away, then every interval until interrupted, printing a line per
+run. A failed run is reported and retried at the next interval. With
+--on-change, syncs again whenever the source database or its WAL was written,
+once no write happened for the debounce delay, instead of on a schedule. With
+--schedule, syncs at the times of a cron expression instead. Every run locks
+the target, so that other watchers and syncs of the same target take turns
+with it, see --wait-lock; runs of tables named with --tables only lock those,
+and go on along with the runs of other tables.
+
+With --job-file, runs every job of a YAML file on its own cron schedule, and
+takes no database arguments:
+
+  jobs:
+    replica:
+      source: app.db
+      target: replica.db
+      schedule: "*/5 * * * *"
+    archive:
+      source: app.db
+      target: archive.db
+      schedule: "0 3 * * *"
+      tables: [orders, invoices]
+      no_delete: true
+
+The sync flags don't apply to the jobs, which take tables, exclude_tables,
+where, no_delete, state, config (a file of per-table settings) and history
+(record the runs in the target) instead.
+
+With --api-listen, serves an HTTP API managing the jobs of --job-file, if
+any, while they run, and takes no database arguments either:
+
+  GET    /v1/jobs                the status of every job
+  POST   /v1/jobs                create a job, given as JSON with a name
+                                 and the fields of a job of --job-file
+  GET    /v1/jobs/NAME           the status of a job
+  DELETE /v1/jobs/NAME           stop a job and forget it
+  POST   /v1/jobs/NAME/run       run a job now, besides its schedule
+  GET    /v1/jobs/NAME/history   the reports of its last runs (?limit=N)
+  GET    /v1/jobs/NAME/logs      follow its logs as JSON lines (?follow=false)
+
+Clients must send --api-token as a bearer token, and POST job requests with
+a Content-Type of application/json. The token is required: the API runs
+syncs reading and writing any file, which a web page could otherwise ask
+for through a loopback address.
+
+With --metrics-listen, serves the outcome of the runs at /metrics in the
+Prometheus format: runs, failures and durations by job, the target path
+outside --job-file, rows written and deleted, failures and durations by
+table, and the time of the last success of each.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if jobsPath != "" || apiAddr != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modes := 0
+			for _, flag := range []string{"interval", "on-change", "schedule"} {
+				if cmd.Flags().Changed(flag) {
+					modes++
+				}
+			}
+			if jobsPath != "" || apiAddr != "" {
+				modes++
+			}
+			if modes > 1 {
+				return errors.New("--interval, --on-change, --schedule and --job-file or --api-listen are mutually exclusive")
+			}
+			logger, err := logs.logger(cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+
+			var metrics *sync.Metrics
+			if metricsAddr != "" {
+				ln, err := sync.Listen(metricsAddr)
+				if err != nil {
+					return &exitError{code: exitUsage, err: err}
+				}
+				metrics = sync.NewMetrics()
+				srv := &http.Server{Handler: metrics}
+				go srv.Serve(ln)
+				defer srv.Close()
+			}
+
+			var mu gosync.Mutex // jobs report concurrently
+			report := func(job, prefix string) func(*sync.Stats, error) {
+				return func(stats *sync.Stats, err error) {
+					if metrics != nil {
+						metrics.Observe(job, stats, err)
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "%s %sError: %v\n", time.Now().Format(time.DateTime), prefix, err)
+					} else if !logs.quiet {
+						printRun(cmd.OutOrStdout(), prefix, stats)
+					}
+					printIssues(cmd.ErrOrStderr(), stats)
+				}
+			}
+
+			if jobsPath != "" || apiAddr != "" {
+				var jobs []sync.ScheduledJob
+				if jobsPath != "" {
+					if jobs, err = sync.LoadScheduledJobs(jobsPath); err != nil {
+						return err
+					}
+				}
+				for i := range jobs {
+					jobs[i].Config.Logger = logger.With("job", jobs[i].Name)
+				}
+				reportJob := func(job string, stats *sync.Stats, err error) {
+					report(job, job+": ")(stats, err)
+				}
+				if apiAddr == "" {
+					return syncExit(sync.RunScheduledJobs(cmd.Context(), jobs, reportJob), nil, false)
+				}
+
+				if apiToken == "" {
+					return &exitError{code: exitUsage, err: errors.New("--api-listen needs --api-token")}
+				}
+				ln, err := sync.Listen(apiAddr)
+				if err != nil {
+					return &exitError{code: exitUsage, err: err}
+				}
+				manager := sync.NewJobManager(logger, apiToken, reportJob)
+				srv := &http.Server{Handler: manager}
+				go srv.Serve(ln)
+				defer srv.Close()
+				if !logs.quiet {
+					fmt.Fprintf(cmd.OutOrStdout(), "serving the job API on %s\n", apiAddr)
+				}
+				return syncExit(manager.Run(cmd.Context(), jobs), nil, false)
+			}
+
+			cfg, err := opts.config(args)
+			if err != nil {
+				return err
+			}
+			cfg.Logger = logger
+			switch {
+			case onChange:
+				err = sync.WatchChanges(cmd.Context(), cfg, debounce, report(cfg.DstDbPath, ""))
+			case schedule != "":
+				err = sync.WatchSchedule(cmd.Context(), cfg, schedule, report(cfg.DstDbPath, ""))
+			default:
+				err = sync.Watch(cmd.Context(), cfg, interval, report(cfg.DstDbPath, ""))
+			}
+			return syncExit(err, nil, false)
+		},
+	}
+	opts.addFlags(cmd)
+	logs.addFlags(cmd)
+	cmd.Flags().DurationVar(&interval, "interval", time.Minute, "time between the start of two syncs")
+	cmd.Flags().BoolVar(&onChange, "on-change", false, "sync when the source changes instead of every interval")
+	cmd.Flags().DurationVar(&debounce, "debounce", 500*time.Millisecond, "time without source changes before syncing, with --on-change")
+	cmd.Flags().StringVar(&schedule, "schedule", "", "sync at the times of a cron expression, like \"*/5 * * * *\"")
+	cmd.Flags().StringVar(&jobsPath, "job-file", "", "run the scheduled jobs of this YAML file")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-listen", "", "address to serve Prometheus metrics on, at /metrics, like serve --listen")
+	cmd.Flags().StringVar(&apiAddr, "api-listen", "", "address to serve the HTTP API managing the jobs on, like serve --listen")
+	cmd.Flags().StringVar(&apiToken, "api-token", "", "token clients of the job API must send (required with --api-listen)")
+	return cmd
+}
+
+func newServeCmd() *cobra.Command {
+	var (
+		logs     logOptions
+		cfg      sync.Config
+		listen   string
+		token    string
+		certFile string
+		keyFile  string
+		grants   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "serve a source database to syncs over HTTP",
+		Long: `serve a source database to syncs over HTTP
+
+Serves the database of --db, read-only, to the syncs given an
+rslite://host:port source (rslite+https://host:port with --tls-cert). They
+pull only the rows their filters and watermarks select, streamed and
+compressed, instead of the whole file. With --token, clients must pass the
+same --token. It is required unless --listen is a loopback address, like
+127.0.0.1:8080, or a unix socket, whose clients are then only refused
+when they're web pages of another site.
+
+With --grants, the clients sending the token of a grant of that YAML file
+only read the tables it lists, and of them only the rows its conditions
+select:
+
+  grants:
+    - token: reporting-secret
+      tables: [orders, "report_*"]
+      where:
+        orders: region = 'eu'
+
+Their own conditions, like --where, may then only read the synced table,
+without subqueries. --token still reads every table. Runs until
+interrupted.
+
+--listen takes a host:port address, IPv6 hosts in brackets like
+[::1]:8080, unix:PATH to listen on a unix socket behind a local reverse
+proxy, or systemd for the socket passed by systemd socket activation
+(systemd:NAME for the one of that FileDescriptorName).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.SrcDbPath == "" {
+				return &exitError{code: exitUsage, err: errors.New("--db is required")}
+			}
+			if (certFile == "") != (keyFile == "") {
+				return &exitError{code: exitUsage, err: errors.New("--tls-cert and --tls-key go together")}
+			}
+			logger, err := logs.logger(cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			cfg.Logger = logger
+			var list []sync.Grant
+			if grants != "" {
+				if list, err = sync.LoadGrants(grants); err != nil {
+					return err
+				}
+			}
+			ln, err := sync.Listen(listen)
+			if err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			// Any table and condition are served without a token: only to
+			// local clients
+			local := false
+			switch addr := ln.Addr().(type) {
+			case *net.TCPAddr:
+				local = addr.IP.IsLoopback()
+			case *net.UnixAddr:
+				local = true
+			}
+			if token == "" && len(list) == 0 && !local {
+				ln.Close()
+				return &exitError{code: exitUsage, err: fmt.Errorf("--listen %s needs --token, unless it's a loopback address or a unix socket", listen)}
+			}
+			server, err := sync.NewServer(cfg, token, list)
+			if err != nil {
+				ln.Close()
+				return syncExit(err, nil, false)
+			}
+			defer server.Close()
+			if !logs.quiet {
+				fmt.Fprintf(cmd.OutOrStdout(), "serving %s on %s\n", cfg.SrcDbPath, listen)
+			}
+			return server.Serve(cmd.Context(), ln, certFile, keyFile)
+		},
+	}
+	logs.addFlags(cmd)
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.SrcDbPath, "db", "", "`path` of the database to serve")
+	flags.StringVar(&listen, "listen", ":8080", "address to listen on: host:port, unix:PATH or systemd")
+	flags.StringVar(&token, "token", "", "token clients must send (required unless --listen is a loopback address or a unix socket)")
+	flags.StringVar(&grants, "grants", "", "YAML file of tokens restricting the tables and rows their clients read")
+	flags.StringVar(&certFile, "tls-cert", "", "certificate file, to serve HTTPS")
+	flags.StringVar(&keyFile, "tls-key", "", "key file of the certificate")
+	flags.BoolVar(&cfg.SrcImmutable, "src-immutable", false, "open the database without locking, for read-only media nothing writes to")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	return cmd
+}
+
+// printRun writes a one line summary of a run, after prefix.
+func printRun(w io.Writer, prefix string, stats *sync.Stats) {
+	t := stats.Total()
+	fmt.Fprintf(w, "%s %ssynced %d tables: %d inserted, %d replaced, %d deleted in %s\n",
+		time.Now().Format(time.DateTime), prefix, len(stats.Tables), t.Inserted, t.Replaced, t.Deleted,
+		stats.Duration.Round(time.Millisecond))
+}
+
+// Exit codes of the commands writing a target, and of hash, see syncExit.
+// Errors that aren't an exitError are usage errors.
+const (
+	exitFailure      = 1
+	exitUsage        = 2
+	exitSourceOpen   = 3
+	exitTargetOpen   = 4
+	exitSchema       = 5
+	exitPartial      = 6
+	exitVerification = 7
+)
+
+// syncExit returns the exitError of a failed run, classifying err. A failure
+// after some tables were committed is a partial sync, unless the run was
+// atomic.
+func syncExit(err error, stats *sync.Stats, atomic bool) error {
+	if err == nil {
+		return nil
+	}
+	code := exitFailure
+	switch {
+	case errors.Is(err, sync.ErrInvalidConfig):
+		code = exitUsage
+	case errors.Is(err, sync.ErrOpenSource):
+		code = exitSourceOpen
+	case errors.Is(err, sync.ErrOpenTarget):
+		code = exitTargetOpen
+	case errors.Is(err, sync.ErrVerification):
+		code = exitVerification
+	case !atomic && stats != nil && len(stats.Tables) > 0:
+		code = exitPartial
+	case errors.Is(err, sync.ErrSchemaMismatch):
+		code = exitSchema
 	}
+	return &exitError{code: code, err: err}
+}
+
+// exitError makes the process exit with code, printing err if not nil but
+// not the usage.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func newDiffCmd() *cobra.Command {
+	var (
+		cfg        sync.Config
+		tableWhere []string
+		configPath string
+		tableMap   []string
+		columns    []string
+		excluded   []string
+		jsonMode   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff [source db] [target db]",
+		Short: "compare the rows of two databases without modifying them",
+		Long: `compare the rows of two databases without modifying them
+
+Exits with 0 when the tables are identical, 1 when they differ and 2 on error.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.SrcDbPath = args[0]
+			cfg.DstDbPath = args[1]
+			var err error
+			if cfg.TableWhere, err = parseTableWhere(tableWhere); err != nil {
+				return &exitError{code: 2, err: err}
+			}
+			if configPath != "" {
+				if cfg.TableOptions, err = sync.LoadTableOptions(configPath); err != nil {
+					return &exitError{code: 2, err: err}
+				}
+			}
+			if cfg.TableOptions, err = parseTableMap(cfg.TableOptions, tableMap); err != nil {
+				return &exitError{code: 2, err: err}
+			}
+			if cfg.TableOptions, err = parseTableColumns(cfg.TableOptions, "--columns", columns, func(opts *sync.TableOptions, cols []string) {
+				opts.Columns = cols
+			}); err != nil {
+				return &exitError{code: 2, err: err}
+			}
+			if cfg.TableOptions, err = parseTableColumns(cfg.TableOptions, "--exclude-columns", excluded, func(opts *sync.TableOptions, cols []string) {
+				opts.Exclude = cols
+			}); err != nil {
+				return &exitError{code: 2, err: err}
+			}
 
+			out := cmd.OutOrStdout()
+			enc := json.NewEncoder(out)
+			stats, err := sync.Diff(cmd.Context(), cfg, func(d diff.Row) error {
+				if jsonMode {
+					return enc.Encode(d)
+				}
+				printRowDiff(out, d)
+				return nil
+			})
+			if !jsonMode {
+				printDiffStats(out, stats)
+			}
+			if err != nil {
+				return &exitError{code: 2, err: err}
+			}
+			if stats.Different() {
+				return &exitError{code: 1}
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&cfg.Filter, "filter", "f", "", "filter type: gt, lt, gte, or lte")
+	flags.StringVarP(&cfg.Value, "value", "v", "", "filter value")
+	flags.StringVar(&cfg.FilterColumn, "filter-column", "", "column compared by the filter (default: primary key)")
+	flags.StringVar(&cfg.UpdatedColumn, "updated-column", "", "column holding the modification time of the rows")
+	flags.StringVar(&cfg.Since, "since", "", "only compare rows modified after this time (RFC 3339 or YYYY-MM-DD)")
+	flags.StringSliceVarP(&cfg.Tables, "tables", "t", nil, "tables to compare, by name or glob pattern (comma-separated)")
+	flags.StringSliceVar(&cfg.ExcludeTables, "exclude-tables", nil, "tables not to compare, by name or glob pattern (comma-separated)")
+	flags.StringVar(&cfg.Where, "where", "", "SQL condition selecting the rows to compare")
+	flags.StringArrayVar(&tableWhere, "table-where", nil, "SQL condition for a single table, as TABLE=CONDITION (repeatable)")
+	flags.StringVar(&configPath, "config", "", "per-table settings read from this YAML file")
+	flags.StringSliceVar(&tableMap, "map", nil, "tables compared with another name in the target, as SOURCE=TARGET (comma-separated)")
+	flags.StringArrayVar(&columns, "columns", nil, "the only columns compared besides the key, as TABLE=COLUMN,... (repeatable)")
+	flags.StringArrayVar(&excluded, "exclude-columns", nil, "`columns` never compared, as TABLE=COLUMN,... (repeatable)")
+	flags.Int64Var(&cfg.ExternalizeBlobs, "externalize-blobs", 0, "compare BLOBs larger than this many bytes with their externalized reference (0 disables)")
+	flags.StringSliceVar(&cfg.JSONColumns, "json-column", nil, "JSON columns compared member by member, as TABLE.COLUMN or COLUMN (comma-separated)")
+	flags.BoolVar(&cfg.SrcImmutable, "src-immutable", false, "open the source without locking, for read-only media nothing writes to")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	flags.BoolVar(&jsonMode, "json", false, "print one JSON object per differing row")
+	return cmd
+}
+
+// printRowDiff writes a differing row on one line, with the source and
+// target values of the changed columns.
+func printRowDiff(w io.Writer, d diff.Row) {
+	key := make([]string, len(d.Key))
+	for i, v := range d.Key {
+		key[i] = formatValue(v)
+	}
+	fmt.Fprintf(w, "%s %s (%s)", d.Kind, d.Table, strings.Join(key, ", "))
+	for _, c := range d.Changed {
+		if paths, ok := d.Paths[c]; ok {
+			fmt.Fprintf(w, " %s: %s", c, strings.Join(paths, ", "))
+			continue
+		}
+		fmt.Fprintf(w, " %s: %s -> %s", c, formatValue(d.Source[c]), formatValue(d.Target[c]))
+	}
+	fmt.Fprintln(w)
+}
+
+// printDiffStats writes a per-table summary of a diff run.
+func printDiffStats(w io.Writer, stats *sync.DiffStats) {
+	if stats == nil || len(stats.Tables) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tSOURCE-ONLY\tTARGET-ONLY\tCHANGED\tIDENTICAL")
+	for _, t := range stats.Tables {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", t.Table, t.SourceOnly, t.TargetOnly, t.Changed, t.Identical)
+	}
+	tw.Flush()
+}
+
+// formatValue renders a value as an SQL literal.
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(x, "'", "''") + "'"
+	case []byte:
+		return fmt.Sprintf("x'%x'", x)
+	case time.Time:
+		return "'" + x.Format(time.RFC3339Nano) + "'"
+	}
+	return fmt.Sprint(v)
+}
+
+func newHashCmd() *cobra.Command {
+	var (
+		cfg        sync.Config
+		tableWhere []string
+		jsonMode   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "hash [db]",
+		Short: "print content hashes of the tables of a database",
+		Long: `print content hashes of the tables of a database
+
+Hashes the rows of every table in key order, and the table hashes into a
+digest of the whole database, without modifying it. Databases holding the
+same rows hash alike on any machine, so comparing the digests tells whether
+a sync is needed without transferring them, when computed with the same
+--checksum algorithm, printed along with them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.SrcDbPath = args[0]
+			var err error
+			if cfg.TableWhere, err = parseTableWhere(tableWhere); err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			digest, err := sync.Hash(cmd.Context(), cfg)
+			if err != nil {
+				return syncExit(err, nil, false)
+			}
+			out := cmd.OutOrStdout()
+			if jsonMode {
+				return json.NewEncoder(out).Encode(digest)
+			}
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "TABLE\tROWS\tHASH")
+			for _, t := range digest.Tables {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", t.Table, t.Rows, t.Hash)
+			}
+			tw.Flush()
+			fmt.Fprintln(out, "checksum:", digest.Checksum)
+			fmt.Fprintln(out, "digest:", digest.Digest)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cfg.Tables, "tables", "t", nil, "tables to hash, by name or glob pattern (comma-separated)")
+	flags.StringSliceVar(&cfg.ExcludeTables, "exclude-tables", nil, "tables not to hash, by name or glob pattern (comma-separated)")
+	flags.StringVar(&cfg.Where, "where", "", "SQL condition selecting the rows to hash")
+	flags.StringArrayVar(&tableWhere, "table-where", nil, "SQL condition for a single table, as TABLE=CONDITION (repeatable)")
+	flags.StringVar(&cfg.KeylessMatch, "keyless", "", "order of the rows of tables without a primary key: rowid or columns (default rowid)")
+	flags.StringVar(&cfg.Checksum, "checksum", "", "hash algorithm: xxhash64, fnv or sha256 (default xxhash64)")
+	flags.BoolVar(&cfg.SrcImmutable, "src-immutable", false, "open the database without locking, for read-only media nothing writes to")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	flags.BoolVar(&jsonMode, "json", false, "print the hashes as a JSON object")
+	return cmd
+}
+
+func newHistoryCmd() *cobra.Command {
+	var (
+		cfg      sync.Config
+		limit    int
+		summary  bool
+		jsonMode bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "history [target db]",
+		Short: "list the past syncs of a target",
+		Long: `list the past syncs of a target
+
+Lists the runs recorded in a target by the syncs given --history, latest
+first: when they started, how long they took, the rows they wrote and
+whether they succeeded. With --json, prints their full reports, including
+the configuration and the outcome of every table.
+
+With --summary, sums up the listed runs instead, without sending anything
+anywhere: their success rate and average duration, and for every table the
+rows they wrote and how long they took, the tables writing the most first.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.DstDbPath = args[0]
+			runs, err := sync.History(cmd.Context(), cfg, limit)
+			if err != nil {
+				return &exitError{code: exitUsage, err: err}
+			}
+			out := cmd.OutOrStdout()
+			if summary {
+				s := sync.Summarize(runs)
+				if jsonMode {
+					return json.NewEncoder(out).Encode(s)
+				}
+				if s.Runs == 0 {
+					fmt.Fprintln(out, "no runs recorded")
+					return nil
+				}
+				fmt.Fprintf(out, "runs: %d since %s, %d succeeded (%.1f%%)\n", s.Runs, s.Since.Local().Format(time.DateTime), s.Succeeded, 100*s.SuccessRate)
+				fmt.Fprintf(out, "average duration: %s\n\n", time.Duration(s.AverageMS)*time.Millisecond)
+				tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "TABLE\tRUNS\tFAILURES\tWRITTEN\tAVERAGE DURATION")
+				for _, t := range s.Tables {
+					fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", t.Table, t.Runs, t.Failures, t.Written, time.Duration(t.AverageMS)*time.Millisecond)
+				}
+				return tw.Flush()
+			}
+			if jsonMode {
+				if runs == nil {
+					runs = []sync.Run{}
+				}
+				return json.NewEncoder(out).Encode(runs)
+			}
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tSTARTED\tDURATION\tTABLES\tINSERTED\tUPDATED\tDELETED\tRESULT")
+			for _, r := range runs {
+				result := "ok"
+				if !r.Success {
+					result = "failed"
+					if len(r.Errors) > 0 {
+						result += ": " + strings.SplitN(r.Errors[0], "\n", 2)[0]
+					}
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", r.ID, r.Started.Local().Format(time.DateTime),
+					time.Duration(r.DurationMS)*time.Millisecond, len(r.Tables), r.Total.Inserted, r.Total.Updated, r.Total.Deleted, result)
+			}
+			return tw.Flush()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVarP(&limit, "limit", "n", 20, "number of runs listed, 0 for all")
+	flags.BoolVar(&summary, "summary", false, "sum up the runs: success rate, average duration and the tables writing the most rows")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	flags.BoolVar(&jsonMode, "json", false, "print the runs as a JSON array")
+	return cmd
+}
+
+func newExplainCmd() *cobra.Command {
+	var opts syncOptions
+
+	cmd := &cobra.Command{
+		Use:   "explain [source db] [target db]",
+		Short: "show what a sync would do without running it",
+		Long: `show what a sync would do without running it
+
+Takes the flags of a sync and prints, for every table it would copy, the
+columns, key and source conditions it would use. Mistakes a sync would ignore
+or only hit halfway through, like unknown tables or invalid conditions, are
+reported and make the command exit with 1.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.config(args)
+			if err != nil {
+				return err
+			}
+			exp, err := sync.Explain(cmd.Context(), cfg)
+			if err != nil {
+				return &exitError{code: 1, err: err}
+			}
+			printExplanation(cmd.OutOrStdout(), exp)
+			printUnsupported(cmd.ErrOrStderr(), exp.Unsupported)
+			for _, p := range exp.Problems {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Problem:", p)
+			}
+			if len(exp.Problems) > 0 {
+				return &exitError{code: 1}
+			}
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
+
+func newPlanCmd() *cobra.Command {
+	var (
+		opts   syncOptions
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "plan [source db] [target db]",
+		Short: "write the SQL statements a sync would run, without running it",
+		Long: `write the SQL statements a sync would run, without running it
+
+Takes the flags of a sync, runs it on a copy of the target, and writes the
+INSERT, UPDATE and DELETE statements turning the target into the synced copy,
+in a single transaction, to --output or stdout. The target is left untouched;
+the file can be reviewed, then run on it with the sqlite3 shell.
+
+The plan only holds row changes: --mirror, --migrate-schema,
+--schema-objects, --drop-schema-objects, --copy-version, --sequences and
+--rebuild-fts are refused, as are --attachments and --externalize-blobs.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.config(args)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			stats, err := sync.Plan(cmd.Context(), cfg, w)
+			if err == nil && output != "" {
+				printStats(cmd.OutOrStdout(), stats)
+			}
+			return syncExit(err, nil, false)
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the statements to (default stdout)")
+	return cmd
+}
+
+func newApplyCmd() *cobra.Command {
+	var cfg sync.Config
+
+	cmd := &cobra.Command{
+		Use:   "apply [plan file] [target db]",
+		Short: "run a plan written by rslite plan on the target",
+		Long: `run a plan written by rslite plan on the target
+
+Runs the statements of the plan in a single transaction, after checking the
+fingerprint the plan holds of its tables: a target whose planned tables
+changed since the plan was made is left untouched, and the command fails with
+exit code 7. A plan file of - is read from stdin.
+
+The ID of every applied plan is recorded in the target: applying a plan again
+does nothing and succeeds, so that deliveries can be retried.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.DstDbPath = args[1]
+			r := cmd.InOrStdin()
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			changed, err := sync.Apply(cmd.Context(), cfg, r)
+			switch {
+			case errors.Is(err, sync.ErrAlreadyApplied):
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %v, nothing to do\n", args[0], err)
+				return nil
+			case err == nil:
+				fmt.Fprintf(cmd.OutOrStdout(), "applied %s: %d rows changed\n", args[0], changed)
+			}
+			return syncExit(err, nil, true)
+		},
+	}
+	flags := cmd.Flags()
+	flags.DurationVar(&cfg.BusyTimeout, "busy-timeout", 0, "how long to wait for a locked database (default 5s)")
+	flags.StringArrayVar(&cfg.Extensions, "load-extension", nil, "`path` of a SQLite extension loaded on every connection (repeatable)")
+	return cmd
+}
+
+func newTrackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "track",
+		Short: "log the changes of a source for --tracked syncs",
+		Long: `log the changes of a source for --tracked syncs
+
+enable installs triggers on the tables of the source logging the primary key
+of every row inserted, updated or deleted to a _rslite_changes table. A sync
+with --tracked then only writes or deletes the logged rows, and trims the log.
+Sync the target in full once after enabling it: earlier changes aren't logged.
+disable removes the triggers, and the log when no table is given.`,
+	}
+	for _, enable := range []bool{true, false} {
+		var (
+			cfg    sync.Config
+			tables []string
+		)
+		use, short := "disable [source db]", "remove the change tracking of a source"
+		if enable {
+			use, short = "enable [source db]", "install the change tracking of a source"
+		}
+		sub := &cobra.Command{
+			Use:   use,
+			Short: short,
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cfg.SrcDbPath = args[0]
+				if enable {
+					return sync.EnableTracking(cmd.Context(), cfg, tables)
+				}
+				return sync.DisableTracking(cmd.Context(), cfg, tables)
+			},
+		}
+		flags := sub.Flags()
+		flags.StringSliceVarP(&tables, "tables", "t", nil, "tables to track, all by default (comma-separated)")
+		flags.DurationVar(&cfg.BusyTimeout, "busy-timeout", 0, "how long to wait for a locked database (default 5s)")
+		cmd.AddCommand(sub)
+	}
+	return cmd
+}
+
+// printExplanation writes the plan of every table of an explanation.
+func printExplanation(w io.Writer, exp *sync.Explanation) {
+	for _, t := range exp.Tables {
+		fmt.Fprintln(w, t.Table)
+		if t.Target != "" {
+			fmt.Fprintf(w, "  target:  %s\n", t.Target)
+		}
+		fmt.Fprintf(w, "  columns: %s\n", strings.Join(t.Columns, ", "))
+		fmt.Fprintf(w, "  key:     %s\n", strings.Join(t.Key, ", "))
+		if t.Where != "" {
+			args := make([]string, len(t.Args))
+			for i, a := range t.Args {
+				args[i] = formatValue(a)
+			}
+			fmt.Fprintf(w, "  where:   %s", t.Where)
+			if len(args) > 0 {
+				fmt.Fprintf(w, " [%s]", strings.Join(args, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+		if len(t.JSONColumns) > 0 {
+			fmt.Fprintf(w, "  json:    %s\n", strings.Join(t.JSONColumns, ", "))
+		}
+		write := t.Write
+		if t.Delete {
+			write += ", deleting orphaned rows"
+		}
+		fmt.Fprintf(w, "  write:   %s\n", write)
+	}
 }
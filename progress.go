@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/alvarolm/rslite/sync"
+)
+
+// progressLogInterval is the minimum time between two progress lines of a
+// table when the output is not a terminal.
+const progressLogInterval = 5 * time.Second
+
+// progressPrinter prints the progress reported by sync.Config.Progress: a
+// bar redrawn in place on a terminal, periodic lines otherwise.
+type progressPrinter struct {
+	mu     gosync.Mutex
+	w      io.Writer
+	tty    bool
+	logged map[string]time.Time
+}
+
+func newProgressPrinter(w io.Writer) *progressPrinter {
+	return &progressPrinter{w: w, tty: isTerminal(w), logged: make(map[string]time.Time)}
+}
+
+// isTerminal reports whether w is a character device, like a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressPrinter) report(pr sync.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tty {
+		fmt.Fprintf(p.w, "\r\033[K%s", progressLine(pr, true))
+		if pr.Done {
+			fmt.Fprintln(p.w)
+		}
+		return
+	}
+	if !pr.Done && time.Since(p.logged[pr.Table]) < progressLogInterval {
+		return
+	}
+	p.logged[pr.Table] = time.Now()
+	fmt.Fprintln(p.w, progressLine(pr, false))
+}
+
+// progressLine formats the progress of a table, with a bar if asked.
+func progressLine(pr sync.Progress, bar bool) string {
+	const width = 30
+
+	percent := 100.0
+	if pr.Total > 0 && pr.Rows < pr.Total {
+		percent = float64(pr.Rows) * 100 / float64(pr.Total)
+	}
+	line := pr.Table + " "
+	if bar {
+		filled := int(percent * width / 100)
+		line += "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "] "
+	}
+	line += fmt.Sprintf("%5.1f%% %d/%d rows", percent, pr.Rows, pr.Total)
+
+	var rate float64
+	if secs := pr.Elapsed.Seconds(); secs > 0 {
+		rate = float64(pr.Rows) / secs
+	}
+	line += fmt.Sprintf(", %.0f rows/s", rate)
+	switch {
+	case pr.Done:
+		line += fmt.Sprintf(", done in %s", pr.Elapsed.Round(time.Millisecond))
+	case rate > 0 && pr.Rows < pr.Total:
+		eta := time.Duration(float64(pr.Total-pr.Rows) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sharedTx is the target transaction of a Config.Atomic run, shared by the
+// writers of every table.
+type sharedTx struct {
+	conn     *sql.Conn
+	tx       *sql.Tx
+	attached bool
+}
+
+// syncAtomic is syncTables in a single target transaction, committed once
+// every table is synced and rolled back otherwise.
+func syncAtomic(ctx context.Context, srcs []*sql.DB, dst *sql.DB, tables []Table, cfg Config, rec *recorder, stats *Stats) error {
+	conn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	shared := &sharedTx{conn: conn}
+	defer shared.close()
+
+	// ATTACH fails inside a transaction
+	if canAttach(cfg) {
+		attach := readOnlyDSN(cfg.sourcePath(), sourceParams(cfg))
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+attachedSchema, attach); err != nil {
+			return fmt.Errorf("attaching source: %w", err)
+		}
+		shared.attached = true
+	}
+	if shared.tx, err = conn.BeginTx(ctx, cfg.DstTxOptions); err != nil {
+		return fmt.Errorf("starting target transaction: %w", err)
+	}
+
+	cfg.shared = shared
+	cfg.Jobs = 1
+	if err := syncTables(ctx, srcs, dst, tables, cfg, rec, stats); err != nil {
+		return err
+	}
+	if err := shared.tx.Commit(); err != nil {
+		return fmt.Errorf("committing target transaction: %w", err)
+	}
+	return nil
+}
+
+// close rolls back the transaction if it was not committed, and releases the
+// connection.
+func (s *sharedTx) close() {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	if s.attached {
+		s.conn.ExecContext(context.Background(), "DETACH DATABASE "+attachedSchema)
+	}
+	s.conn.Close()
+}
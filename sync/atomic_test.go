@@ -0,0 +1,45 @@
+package sync
+
+import "testing"
+
+func TestSyncAtomic(t *testing.T) {
+	for _, noAttach := range []bool{false, true} {
+		srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+			{
+				name:    "a",
+				schema:  `CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)`,
+				srcData: [][]interface{}{{1, "x"}, {2, "y"}},
+				tgtData: [][]interface{}{{1, "old"}},
+			},
+			{
+				name:    "b",
+				schema:  `CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT)`,
+				srcData: [][]interface{}{{1, nil}},
+			},
+		})
+		// The target rejects the row of the second table
+		if _, err := tgtDB.Exec(`DROP TABLE b; CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"a", "b"}, Atomic: true, NoAttach: noAttach}
+		if _, err := Sync(cfg); err == nil {
+			t.Fatal("Sync() succeeded writing a NULL into a NOT NULL column")
+		}
+		got, err := getTableData(tgtDB, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := [][]interface{}{{1, "old"}}; !compareData(got, want) {
+			t.Errorf("NoAttach %v: table a = %v, want %v rolled back", noAttach, got, want)
+		}
+
+		cfg.Tables = []string{"a"}
+		if _, err := Sync(cfg); err != nil {
+			t.Fatalf("NoAttach %v: Sync() error = %v", noAttach, err)
+		}
+		if n := countTestRows(t, tgtDB, "a"); n != 2 {
+			t.Errorf("NoAttach %v: table a has %d rows, want 2", noAttach, n)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// attachedSchema is the name the source database is attached under by the
+// fast path.
+const attachedSchema = "rslite_src"
+
+// canAttach reports whether the tables can be synced with set-based
+// statements, the source database being attached to the target connection,
+// instead of copying the rows one by one through Go. It takes a source file
+// on disk and no option looking at single rows.
+func canAttach(cfg Config) bool {
+	if cfg.NoAttach || readsRows(cfg) || (cfg.BatchSize > 0 && !cfg.SingleTx) {
+		return false
+	}
+	info, err := os.Stat(dbFile(cfg.SrcDbPath))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// readsRows reports whether an option needs the rows to go through Go one by
+// one.
+func readsRows(cfg Config) bool {
+	return cfg.RecordPath != "" || cfg.StatePath != "" || len(cfg.UnionSources) > 0 ||
+		cfg.MaxRowSize > 0 || cfg.CheckUTF8 || cfg.FixEncoding != "" ||
+		cfg.SkipUnchanged || cfg.ExternalizeBlobs > 0 || cfg.RowTransform != nil || cfg.masks() || cfg.Tracked || cfg.SoftDeleteColumn != "" ||
+		cfg.KeylessMatch == KeylessColumns
+}
+
+// syncAttached syncs a table through a writer attached to the source.
+func syncAttached(ctx context.Context, w *tableWriter, cfg Config) (TableStats, error) {
+	where, args := buildFilter(w.table, cfg)
+	var progress *progressReporter
+	if cfg.Progress != nil {
+		total, err := countSourceRows(ctx, w.tx, attachedSchema+"."+quoteIdent(w.table.name), where, args)
+		if err != nil {
+			return w.stats, err
+		}
+		progress = newProgressReporter(cfg, w.table, total)
+	}
+	if err := w.copyAttached(ctx, where, args); err != nil {
+		return w.stats, err
+	}
+	progress.add(w.written)
+	if cfg.deletes(w.table) {
+		if err := w.deleteAttachedOrphans(ctx); err != nil {
+			return w.stats, err
+		}
+	}
+	stats, err := w.commit(ctx)
+	if err == nil {
+		progress.done()
+	}
+	return stats, err
+}
+
+// copyAttached writes the source rows matching where with a single
+// INSERT ... SELECT from the attached source.
+func (w *tableWriter) copyAttached(ctx context.Context, where string, args []interface{}) error {
+	cols := quoteIdents(w.table.writtenColumns())
+	if where == "" {
+		// Without a WHERE the parser takes ON CONFLICT for a join constraint
+		where = "true"
+	}
+	query := fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM %s.%s WHERE %s",
+		quoteIdent(w.table.targetName()), cols, cols, attachedSchema, quoteIdent(w.table.name), where)
+	if w.conflict == "" {
+		query = "INSERT OR REPLACE" + strings.TrimPrefix(query, "INSERT")
+	} else {
+		query += " " + w.conflict
+	}
+
+	res, err := w.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	w.written += n
+	return nil
+}
+
+// deleteAttachedOrphans deletes the target rows whose key is not in the
+// attached source.
+func (w *tableWriter) deleteAttachedOrphans(ctx context.Context) error {
+	target := "main." + quoteIdent(w.table.targetName())
+	conds := make([]string, len(w.table.keyCols))
+	for i, c := range w.table.keyCols {
+		conds[i] = fmt.Sprintf("s.%s IS %s.%s", quoteIdent(c), target, quoteIdent(c))
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE NOT EXISTS (SELECT 1 FROM %s.%s AS s WHERE %s)",
+		target, attachedSchema, quoteIdent(w.table.name), strings.Join(conds, " AND "))
+	res, err := w.exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("deleting orphaned rows: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting orphaned rows: %w", err)
+	}
+	w.stats.Deleted += deleted
+	return w.checkDeletes()
+}
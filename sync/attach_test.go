@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSyncAttached(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, active INTEGER)`,
+		srcData: [][]interface{}{{1, "Alice", 1}, {2, "Bob", 0}, {3, "Carol", 1}, {4, "Dan", 1}},
+		tgtData: [][]interface{}{{1, "Alice Old", 1}, {2, "Bob Old", 1}, {5, "Eve", 1}},
+	}}
+
+	var results [2][][]interface{}
+	var stats [2]TableStats
+	for i, noAttach := range []bool{false, true} {
+		srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+		cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Filter: "lt", Value: "4", Where: "active = 1", NoAttach: noAttach}
+		if !noAttach && !canAttach(cfg) {
+			t.Fatal("canAttach() = false for a plain sync")
+		}
+		s, err := Sync(cfg)
+		if err != nil {
+			t.Fatalf("Sync(NoAttach: %v) error = %v", noAttach, err)
+		}
+		stats[i] = s.Tables[0]
+		stats[i].Duration = 0
+		if results[i], err = getTableData(tgtDB, "users"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]interface{}{{1, "Alice", 1}, {2, "Bob Old", 1}, {3, "Carol", 1}}
+	if !compareData(results[0], want) {
+		t.Errorf("attached sync got %v, want %v", results[0], want)
+	}
+	if !compareData(results[1], want) {
+		t.Errorf("row by row sync got %v, want %v", results[1], want)
+	}
+	if !reflect.DeepEqual(stats[0], stats[1]) {
+		t.Errorf("attached sync stats %+v, row by row %+v", stats[0], stats[1])
+	}
+}
@@ -1,11 +1,14 @@
 package sync
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -408,7 +411,7 @@ func TestSync(t *testing.T) {
 			}
 
 			// Perform sync
-			err = Sync(config)
+			_, err = Sync(config)
 			if (err != nil) != tt.wantError {
 				t.Fatalf("Sync() error = %v, wantError %v, config: %+v", err, tt.wantError, config)
 			}
@@ -441,6 +444,50 @@ func TestSync(t *testing.T) {
 	}
 }
 
+// setupTestDBs creates a source and a target database in a temporary
+// directory with the given tables and their initial data. Both databases are
+// closed when the test finishes.
+func setupTestDBs(t *testing.T, tables []testTable) (srcPath, tgtPath string, srcDB, tgtDB *sql.DB) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	srcPath = filepath.Join(tmpDir, "src.db")
+	tgtPath = filepath.Join(tmpDir, "tgt.db")
+
+	srcDB, err := createTestDB(srcPath, tables)
+	if err != nil {
+		t.Fatalf("Failed to create source database: %v", err)
+	}
+	t.Cleanup(func() { srcDB.Close() })
+
+	tgtDB, err = createTestDB(tgtPath, tables)
+	if err != nil {
+		t.Fatalf("Failed to create target database: %v", err)
+	}
+	t.Cleanup(func() { tgtDB.Close() })
+
+	for _, table := range tables {
+		if err := insertTestData(srcDB, table.name, table.srcData); err != nil {
+			t.Fatalf("Failed to insert source data for table %s: %v", table.name, err)
+		}
+		if err := insertTestData(tgtDB, table.name, table.tgtData); err != nil {
+			t.Fatalf("Failed to insert target data for table %s: %v", table.name, err)
+		}
+	}
+	return srcPath, tgtPath, srcDB, tgtDB
+}
+
+// openTestDB opens an existing database and closes it when the test finishes.
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database %s: %v", path, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func createTestDB(path string, tables []testTable) (*sql.DB, error) {
 	// Remove existing database file if it exists
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
@@ -505,6 +552,10 @@ func insertTestData(db *sql.DB, tableName string, data [][]interface{}) error {
 
 func getTableData(db *sql.DB, tableName string) ([][]interface{}, error) {
 	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY rowid", tableName))
+	if err != nil && strings.Contains(err.Error(), "no such column: rowid") {
+		// WITHOUT ROWID tables are returned in primary key order
+		rows, err = db.Query(fmt.Sprintf("SELECT * FROM %s", tableName))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("querying table %s: %w", tableName, err)
 	}
@@ -587,3 +638,376 @@ func joinStrings(strs []string, sep string) string {
 	}
 	return result
 }
+
+func TestSyncStats(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{1, "Alice Old"}, {3, "Charlie"}},
+	}}
+
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != 1 {
+		t.Fatalf("got stats for %d tables, want 1", len(stats.Tables))
+	}
+	got := stats.Tables[0]
+	if got.Table != "users" || got.Inserted != 1 || got.Replaced != 1 || got.Deleted != 1 || got.Skipped != 0 {
+		t.Errorf("unexpected table stats: %+v", got)
+	}
+	if total := stats.Total(); total.Inserted != 1 || total.Replaced != 1 || total.Deleted != 1 {
+		t.Errorf("unexpected total stats: %+v", total)
+	}
+}
+
+func TestWrittenColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  Table
+		values []interface{}
+		query  string
+		want   []interface{}
+	}{
+		{
+			name:   "Declared primary key written once",
+			table:  Table{name: "users", pkCol: "id", keyCols: []string{"id"}, columns: []string{"id", "name"}},
+			values: []interface{}{1, 1, "Alice"},
+			query:  `INSERT OR REPLACE INTO "users" ("id", "name") VALUES (?, ?)`,
+			want:   []interface{}{1, "Alice"},
+		},
+		{
+			name:   "Declared primary key in another case",
+			table:  Table{name: "users", pkCol: "ID", keyCols: []string{"ID"}, columns: []string{"id", "name"}},
+			values: []interface{}{1, 1, "Alice"},
+			query:  `INSERT OR REPLACE INTO "users" ("id", "name") VALUES (?, ?)`,
+			want:   []interface{}{1, "Alice"},
+		},
+		{
+			name:   "Rowid written along the columns",
+			table:  Table{name: "logs", pkCol: "rowid", keyCols: []string{"rowid"}, columns: []string{"msg"}},
+			values: []interface{}{7, "started"},
+			query:  `INSERT OR REPLACE INTO "logs" ("rowid", "msg") VALUES (?, ?)`,
+			want:   []interface{}{7, "started"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildInsertQuery(tt.table); got != tt.query {
+				t.Errorf("buildInsertQuery() = %q, want %q", got, tt.query)
+			}
+			if got := tt.table.writtenValues(tt.values); !compareData([][]interface{}{got}, [][]interface{}{tt.want}) {
+				t.Errorf("writtenValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncRowidTable(t *testing.T) {
+	// Without a primary key the rows are matched, and written, by rowid
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "logs",
+		schema:  `CREATE TABLE logs (msg TEXT)`,
+		srcData: [][]interface{}{{"started"}, {"slow"}, {"stopped"}},
+		tgtData: [][]interface{}{{"started"}, {"old"}},
+	}})
+	if _, err := srcDB.Exec(`UPDATE logs SET rowid = rowid * 10`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tgtDB.Exec(`UPDATE logs SET rowid = 20 WHERE msg = 'old'`); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 2 || got.Replaced != 1 || got.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	rows, err := tgtDB.Query(`SELECT rowid, msg FROM logs ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][]interface{}
+	for rows.Next() {
+		var rowid, msg interface{}
+		if err := rows.Scan(&rowid, &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []interface{}{rowid, msg})
+	}
+	want := [][]interface{}{{10, "started"}, {20, "slow"}, {30, "stopped"}}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncQuotedIdentifiers(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    `"order items"`,
+		schema:  `CREATE TABLE "order items" ("item-id" INTEGER PRIMARY KEY, "select" TEXT, "Say ""hi""" TEXT)`,
+		srcData: [][]interface{}{{1, "a", "x"}, {2, "b", "y"}, {3, "c", "z"}},
+		tgtData: [][]interface{}{{1, "a", "x"}, {2, "old", "y"}, {4, "gone", "w"}},
+	}})
+
+	for _, cfg := range []Config{
+		{SrcDbPath: srcPath, DstDbPath: tgtPath, PageSize: 2, SkipUnchanged: true, Filter: "gt", Value: "0"},
+		{SrcDbPath: srcPath, DstDbPath: tgtPath, Replace: true, FilterColumn: "select", Filter: "gte", Value: "a"},
+	} {
+		if _, err := Sync(cfg); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+		got, err := getTableData(tgtDB, `"order items"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]interface{}{{1, "a", "x"}, {2, "b", "y"}, {3, "c", "z"}}
+		if !compareData(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, FilterColumn: "id\x00", Filter: "gt", Value: "0"}
+	if _, err := Sync(cfg); err == nil {
+		t.Error("Sync() with a NUL in the filter column succeeded")
+	}
+}
+
+func TestSyncContextCancelled(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{1, "Alice Old"}},
+	}}
+
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := SyncContext(ctx, Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("SyncContext() error = %v, want context.Canceled", err)
+	}
+
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, tables[0].tgtData) {
+		t.Errorf("target modified by cancelled sync: %v", got)
+	}
+}
+
+func TestSyncWhere(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, status TEXT)`,
+			srcData: [][]interface{}{{1, "Alice", "active"}, {2, "Bob", "archived"}, {3, "Carol", "active"}, {4, "Dan", "active"}},
+		},
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, total REAL)`,
+			srcData: [][]interface{}{{1, "active", 10.0}, {2, "active", 99.0}, {3, "archived", 99.0}},
+		},
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	_, err := Sync(Config{
+		SrcDbPath:  srcPath,
+		DstDbPath:  tgtPath,
+		Filter:     "lt",
+		Value:      "4",
+		Where:      "status != 'archived'",
+		TableWhere: map[string]string{"orders": "total > 50 OR id = 1"},
+	})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	want := map[string][][]interface{}{
+		"users":  {{1, "Alice", "active"}, {3, "Carol", "active"}},
+		"orders": {{1, "active", 10.0}, {2, "active", 99.0}},
+	}
+	for table, rows := range want {
+		got, err := getTableData(tgtDB, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareData(got, rows) {
+			t.Errorf("table %s: got %v, want %v", table, got, rows)
+		}
+	}
+}
+
+func TestSyncFilterColumn(t *testing.T) {
+	tables := []testTable{{
+		name:   "events",
+		schema: `CREATE TABLE events (id TEXT PRIMARY KEY, created_at TEXT)`,
+		srcData: [][]interface{}{
+			{"f3a1", "2023-12-31"},
+			{"0b7e", "2024-01-01"},
+			{"9c42", "2024-03-15"},
+		},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Filter: "gte", Value: "2024-01-01", FilterColumn: "created_at"}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{"0b7e", "2024-01-01"}, {"9c42", "2024-03-15"}}; !compareData(sortRows(got), sortRows(want)) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	cfg.FilterColumn = "updated_at"
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "no filter column") {
+		t.Errorf("Sync() error = %v, want a missing filter column error", err)
+	}
+}
+
+func TestSyncUnionSources(t *testing.T) {
+	tables := []testTable{{
+		name:    "events",
+		schema:  `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "day1"}, {2, "day1"}},
+		tgtData: [][]interface{}{{9, "stale"}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	dir := t.TempDir()
+	day2 := filepath.Join(dir, "day2.db")
+	day2DB, err := createTestDB(day2, []testTable{{
+		name:   "events",
+		schema: `CREATE TABLE events (name TEXT, id INTEGER, PRIMARY KEY (id)) WITHOUT ROWID`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer day2DB.Close()
+	if _, err := day2DB.Exec(`INSERT INTO events VALUES ('day2', 2), ('day2', 3)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// A source without the table is skipped
+	day3 := filepath.Join(dir, "day3.db")
+	day3DB, err := createTestDB(day3, []testTable{{name: "other", schema: `CREATE TABLE other (id INTEGER PRIMARY KEY)`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day3DB.Close()
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, UnionSources: []string{day2, day3}}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "day1"}, {2, "day2"}, {3, "day2"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncSince(t *testing.T) {
+	tables := []testTable{{
+		name:   "events",
+		schema: `CREATE TABLE events (id INTEGER PRIMARY KEY, updated_at)`,
+		srcData: [][]interface{}{
+			{1, "2024-05-31 23:59:59"},
+			{2, "2024-06-01 00:00:01"}, // after, though it sorts before the T form
+			{3, "2024-06-01T02:00:00+02:00"},
+			{4, int64(1717200000)}, // unix seconds, exactly the since time
+			{5, int64(1717200060)},
+			{6, nil},
+		},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, UpdatedColumn: "updated_at", Since: "2024-06-01T00:00:00Z"}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{2, "2024-06-01 00:00:01"}, {5, int64(1717200060)}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	cfg.Since = "June 1st"
+	if _, err := Sync(cfg); err == nil {
+		t.Error("Sync() accepted an invalid since time")
+	}
+}
+
+func TestSyncSourceReadOnly(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, SrcImmutable: true, NoAttach: true}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("target has %d rows, want 1", n)
+	}
+
+	// A missing source is not created empty
+	missing := filepath.Join(t.TempDir(), "missing?.db")
+	if _, err := Sync(Config{SrcDbPath: missing, DstDbPath: tgtPath}); err == nil {
+		t.Error("Sync() error = nil for a missing source")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing source was created: %v", err)
+	}
+
+	src, err := sql.Open("sqlite3", readOnlyDSN(srcPath, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := src.Exec(`DELETE FROM users`); err == nil {
+		t.Error("deleting from a read-only source succeeded")
+	}
+}
+
+func TestSyncSnapshot(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{3, "Carol"}},
+	}})
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Snapshot: true}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice"}, {2, "Bob"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if left, _ := os.ReadDir(tmp); len(left) != 0 {
+		t.Errorf("snapshot files left behind: %v", left)
+	}
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blobRefPrefix starts the text stored in place of an externalized BLOB,
+// followed by the hex SHA-256 of its content.
+const blobRefPrefix = "rslite-blob:sha256:"
+
+// blobStore writes the BLOBs larger than threshold to content-addressed files
+// under dir, see Config.ExternalizeBlobs. A nil blobStore externalizes
+// nothing.
+type blobStore struct {
+	dir       string
+	threshold int64
+}
+
+func newBlobStore(cfg Config) *blobStore {
+	if cfg.ExternalizeBlobs <= 0 {
+		return nil
+	}
+	dir := cfg.BlobDir
+	if dir == "" {
+		dir = dbFile(cfg.DstDbPath) + ".blobs"
+	}
+	return &blobStore{dir: dir, threshold: cfg.ExternalizeBlobs}
+}
+
+// dbFile strips the file: scheme and driver parameters from a database path.
+func dbFile(path string) string {
+	path = strings.TrimPrefix(path, "file:")
+	path, _, _ = strings.Cut(path, "?")
+	return path
+}
+
+// refs returns values with the BLOBs to externalize replaced by their
+// reference, without writing them. values is returned as is when no BLOB is
+// large enough.
+func (s *blobStore) refs(values []interface{}) []interface{} {
+	if s == nil {
+		return values
+	}
+	var out []interface{}
+	for i, v := range values {
+		if b, ok := v.([]byte); ok && int64(len(b)) > s.threshold {
+			if out == nil {
+				out = append([]interface{}{}, values...)
+			}
+			out[i] = blobRefPrefix + blobHash(b)
+		}
+	}
+	if out == nil {
+		return values
+	}
+	return out
+}
+
+// externalize is refs, writing the BLOB files that don't exist yet.
+func (s *blobStore) externalize(values []interface{}) ([]interface{}, error) {
+	out := s.refs(values)
+	for i, v := range values {
+		b, isBlob := v.([]byte)
+		ref, isRef := out[i].(string)
+		if isBlob && isRef {
+			if err := s.write(strings.TrimPrefix(ref, blobRefPrefix), b); err != nil {
+				return nil, fmt.Errorf("externalizing blob: %w", err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// path returns the file holding the BLOB with the given hash.
+func (s *blobStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+func (s *blobStore) write(hash string, b []byte) error {
+	path := s.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil // same content, already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func blobHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+func TestSyncExternalizeBlobs(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 100)
+	tables := []testTable{{
+		name:    "files",
+		schema:  `CREATE TABLE files (id INTEGER PRIMARY KEY, data BLOB)`,
+		srcData: [][]interface{}{{1, large}, {2, []byte("small")}, {3, large}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, ExternalizeBlobs: 10, SkipUnchanged: true}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	ref := blobRefPrefix + blobHash(large)
+	got, err := getTableData(tgtDB, "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, ref}, {2, []byte("small")}, {3, ref}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	stored, err := os.ReadFile(filepath.Join(tgtPath+".blobs", blobHash(large)[:2], blobHash(large)))
+	if err != nil {
+		t.Fatalf("reading externalized blob: %v", err)
+	}
+	if !bytes.Equal(stored, large) {
+		t.Error("externalized blob content differs from the source")
+	}
+
+	// The references compare equal to the source content
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Unchanged != 3 {
+		t.Errorf("second run stats %+v, want 3 unchanged rows", got)
+	}
+	diffStats, err := Diff(context.Background(), cfg, func(d diff.Row) error {
+		t.Errorf("unexpected diff %+v", d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if diffStats.Different() {
+		t.Error("Different() = true, want false")
+	}
+}
@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// maxCheckMessages caps the problems PRAGMA quick_check reports for a
+// database.
+const maxCheckMessages = 20
+
+// IntegrityIssue is a problem PRAGMA quick_check found in a database, see
+// Config.CheckSource and CheckTarget.
+type IntegrityIssue struct {
+	Database string `json:"database"` // the path of the database
+	Message  string `json:"message"`
+}
+
+// quickCheck runs PRAGMA quick_check on db, which checks the structure of
+// the database but not that its indexes match their tables, and returns the
+// problems it reports. A database too corrupt to be checked is reported
+// as such.
+func quickCheck(ctx context.Context, db queryer, path string) ([]IntegrityIssue, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA quick_check(%d)", maxCheckMessages))
+	if err != nil {
+		return corruptIssues(path, err)
+	}
+	defer rows.Close()
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			issues = append(issues, IntegrityIssue{Database: path, Message: msg})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return corruptIssues(path, err)
+	}
+	return issues, nil
+}
+
+// corruptIssues returns the error of a quick check failing on a corrupt
+// database as its issue, or err otherwise.
+func corruptIssues(path string, err error) ([]IntegrityIssue, error) {
+	var e sqlite3.Error
+	if errors.As(err, &e) && (e.Code == sqlite3.ErrCorrupt || e.Code == sqlite3.ErrNotADB) {
+		return []IntegrityIssue{{Database: path, Message: err.Error()}}, nil
+	}
+	return nil, err
+}
+
+// checkIntegrity runs quickCheck on a database and adds its issues to
+// stats. It fails with class on a corrupt database, unless
+// Config.FlagCorruption is set and the issues are only logged.
+func checkIntegrity(ctx context.Context, db queryer, path string, class error, cfg Config, stats *Stats) error {
+	issues, err := quickCheck(ctx, db, path)
+	if err != nil {
+		return fmt.Errorf("checking %s: %w", path, err)
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	stats.Corruption = append(stats.Corruption, issues...)
+	if !cfg.FlagCorruption {
+		return classify(class, fmt.Errorf("%s failed the quick check: %s", path, issues[0].Message))
+	}
+	for _, issue := range issues {
+		cfg.logger().WarnContext(ctx, "database failed the quick check", "db", path, "problem", issue.Message)
+	}
+	return nil
+}
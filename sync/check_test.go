@@ -0,0 +1,90 @@
+package sync
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+)
+
+// corruptTable overwrites the header of the root page of a table, which
+// quick_check reports while the other tables can still be read.
+func corruptTable(t *testing.T, db *sql.DB, path, table string) {
+	t.Helper()
+	var root, pageSize int64
+	if err := db.QueryRow(`SELECT rootpage FROM sqlite_master WHERE name = ?`, table).Scan(&root); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte{0x0d, 0xff, 0xff, 0x00, 0x40, 0xff, 0xff}, (root-1)*pageSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncCheckIntegrity(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+		{
+			name:    "logs",
+			schema:  `CREATE TABLE logs (id INTEGER PRIMARY KEY, msg TEXT)`,
+			srcData: [][]interface{}{{1, "started"}},
+			tgtData: [][]interface{}{{1, "started"}},
+		},
+	}
+
+	t.Run("Source", func(t *testing.T) {
+		srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+		corruptTable(t, srcDB, srcPath, "logs")
+		cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"users"}, CheckSource: true}
+		stats, err := Sync(cfg)
+		if !errors.Is(err, ErrCorruptSource) || !errors.Is(err, ErrOpenSource) {
+			t.Fatalf("Sync() error = %v, want ErrCorruptSource", err)
+		}
+		if len(stats.Corruption) == 0 || stats.Corruption[0].Database != srcPath {
+			t.Errorf("Corruption = %+v", stats.Corruption)
+		}
+		if n := countTestRows(t, tgtDB, "users"); n != 0 {
+			t.Errorf("rows copied from a corrupt source: %d", n)
+		}
+
+		cfg.FlagCorruption = true
+		if stats, err = Sync(cfg); err != nil {
+			t.Fatalf("Sync() with FlagCorruption error = %v", err)
+		}
+		if len(stats.Corruption) == 0 {
+			t.Errorf("corruption not reported")
+		}
+		if n := countTestRows(t, tgtDB, "users"); n != 1 {
+			t.Errorf("users holds %d rows, want 1", n)
+		}
+	})
+
+	t.Run("Target", func(t *testing.T) {
+		srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+		cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"users"}, CheckSource: true, CheckTarget: true}
+		stats, err := Sync(cfg)
+		if err != nil || len(stats.Corruption) != 0 {
+			t.Fatalf("Sync() error = %v, corruption %+v", err, stats.Corruption)
+		}
+		corruptTable(t, tgtDB, tgtPath, "logs")
+		stats, err = Sync(cfg)
+		if !errors.Is(err, ErrVerification) {
+			t.Fatalf("Sync() error = %v, want ErrVerification", err)
+		}
+		if len(stats.Corruption) == 0 || stats.Corruption[0].Database != tgtPath {
+			t.Errorf("Corruption = %+v", stats.Corruption)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyViolation is a target row referencing a missing parent row, as
+// reported by PRAGMA foreign_key_check.
+type ForeignKeyViolation struct {
+	Table  string
+	RowID  interface{} // nil for WITHOUT ROWID tables
+	Parent string
+}
+
+// maxReportedViolations caps Stats.Violations.
+const maxReportedViolations = 100
+
+// checkForeignKeys runs PRAGMA foreign_key_check on the whole target, so
+// tables that were not synced but reference deleted rows are caught too. It
+// returns the first violations and their total count.
+func checkForeignKeys(ctx context.Context, dst *sql.DB) ([]ForeignKeyViolation, int, error) {
+	rows, err := dst.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, 0, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		violations []ForeignKeyViolation
+		n          int
+	)
+	for rows.Next() {
+		var (
+			v    ForeignKeyViolation
+			fkid int
+		)
+		if err := rows.Scan(&v.Table, &v.RowID, &v.Parent, &fkid); err != nil {
+			return nil, 0, fmt.Errorf("checking foreign keys: %w", err)
+		}
+		if n++; len(violations) < maxReportedViolations {
+			violations = append(violations, v)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("checking foreign keys: %w", err)
+	}
+	return violations, n, nil
+}
+
+// trigger is a target trigger dropped for the run by Config.DisableTriggers.
+type trigger struct {
+	name string
+	ddl  string
+}
+
+// dropTriggers drops the target triggers on the given tables in one
+// transaction and returns them so restoreTriggers can create them again.
+func dropTriggers(ctx context.Context, dst *sql.DB, tables []Table) ([]trigger, error) {
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(tables))
+	args := make([]interface{}, len(tables))
+	for i, t := range tables {
+		names[i] = "?"
+		args[i] = t.targetName()
+	}
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT name, sql FROM sqlite_master WHERE type = 'trigger' AND tbl_name IN (%s)",
+		strings.Join(names, ", ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing triggers: %w", err)
+	}
+	var triggers []trigger
+	for rows.Next() {
+		var t trigger
+		if err := rows.Scan(&t.name, &t.ddl); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("listing triggers: %w", err)
+		}
+		triggers = append(triggers, t)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("listing triggers: %w", err)
+	}
+
+	for _, t := range triggers {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER "+quoteIdent(t.name)); err != nil {
+			return nil, fmt.Errorf("dropping trigger %s: %w", t.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return triggers, nil
+}
+
+// restoreTriggers creates the triggers returned by dropTriggers again. It
+// doesn't take a context: the triggers have to come back even when the run
+// was cancelled.
+func restoreTriggers(dst *sql.DB, triggers []trigger) error {
+	if len(triggers) == 0 {
+		return nil
+	}
+	tx, err := dst.Begin()
+	if err != nil {
+		return fmt.Errorf("restoring triggers: %w", err)
+	}
+	defer tx.Rollback()
+	for _, t := range triggers {
+		if _, err := tx.Exec(t.ddl); err != nil {
+			return fmt.Errorf("restoring trigger %s: %w", t.name, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncDeferConstraints(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id))`,
+			srcData: [][]interface{}{{10, 1}, {11, 9}},
+		},
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+		{
+			name:   "log",
+			schema: `CREATE TABLE log (id INTEGER PRIMARY KEY, user_id INTEGER)`,
+		},
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+	if _, err := tgtDB.Exec(`CREATE TRIGGER users_log AFTER INSERT ON users BEGIN INSERT INTO log (user_id) VALUES (new.id); END`); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		SrcDbPath:        srcPath,
+		DstDbPath:        tgtPath,
+		Tables:           []string{"orders", "users"},
+		DeferConstraints: true,
+		DisableTriggers:  true,
+	}
+	stats, err := Sync(cfg)
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("Sync() error = %v, want the dangling order reported", err)
+	}
+	want := []ForeignKeyViolation{{Table: "orders", RowID: int64(11), Parent: "users"}}
+	if len(stats.Violations) != 1 || stats.Violations[0] != want[0] {
+		t.Errorf("Violations = %+v, want %+v", stats.Violations, want)
+	}
+	if n := countTestRows(t, tgtDB, "orders"); n != 2 {
+		t.Errorf("orders has %d rows, want the 2 synced ones kept", n)
+	}
+
+	if n := countTestRows(t, tgtDB, "log"); n != 0 {
+		t.Errorf("log has %d rows, want the trigger disabled during the sync", n)
+	}
+	var triggers int
+	if err := tgtDB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'users_log'`).Scan(&triggers); err != nil {
+		t.Fatal(err)
+	}
+	if triggers != 1 {
+		t.Error("trigger users_log was not restored")
+	}
+}
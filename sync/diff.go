@@ -0,0 +1,252 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+// DiffStats summarizes a diff run.
+type DiffStats struct {
+	Tables   []diff.TableStats
+	Duration time.Duration
+}
+
+// Different reports whether any compared row differs.
+func (s *DiffStats) Different() bool {
+	for _, t := range s.Tables {
+		if t.Different() {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff compares the rows of the tables Sync would copy, selected by the
+// Tables, Filter, FilterColumn, Value, Since and Where fields of cfg, and calls fn for every row that
+// differs. Neither database is modified. A table missing from the target
+// compares as empty; tables only the target holds are not compared.
+//
+// Rows are matched by primary key, both sides are read in key order and
+// merged by the diff package, so memory use doesn't grow with the table size.
+func Diff(ctx context.Context, cfg Config, fn func(diff.Row) error) (*DiffStats, error) {
+	start := time.Now()
+	stats := &DiffStats{}
+	defer func() { stats.Duration = time.Since(start) }()
+
+	if err := cfg.validate(); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	if len(cfg.UnionSources) > 0 {
+		return stats, fmt.Errorf("diff does not support union sources")
+	}
+
+	src, err := openReadOnly(cfg.driver(), cfg.SrcDbPath, sourceParams(cfg))
+	if err != nil {
+		return stats, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	defer src.Close()
+
+	dst, err := openReadOnly(cfg.driver(), cfg.DstDbPath, nil)
+	if err != nil {
+		return stats, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer dst.Close()
+
+	tables, _, err := getTables(ctx, src)
+	if err != nil {
+		return stats, err
+	}
+	if tables, err = selectTables(tables, cfg); err != nil {
+		return stats, err
+	}
+	if err := checkColumns(tables, cfg); err != nil {
+		return stats, err
+	}
+
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		tableStats, err := diffTable(ctx, src, dst, table, cfg, fn)
+		stats.Tables = append(stats.Tables, tableStats)
+		if err != nil {
+			return stats, fmt.Errorf("comparing table %s: %w", table.name, err)
+		}
+	}
+	return stats, nil
+}
+
+// openReadOnly opens an existing database in read-only mode, so that it can't
+// be written to, with the given driver and more driver parameters. Unlike
+// sql.Open it fails on a missing file instead of creating it.
+func openReadOnly(d driver.Driver, path string, params url.Values) (*sql.DB, error) {
+	if _, err := os.Stat(dbFile(path)); err != nil {
+		return nil, err
+	}
+	return openDB(d, readOnlyDSN(path, params))
+}
+
+// readOnlyDSN turns a database path into a URI opening it read-only, with
+// more driver parameters.
+func readOnlyDSN(path string, params url.Values) string {
+	// Query parameters are only kept with file: URIs
+	if !strings.HasPrefix(path, "file:") {
+		path = "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)
+	}
+	all := url.Values{"mode": {"ro"}}
+	for k, v := range params {
+		all[k] = v
+	}
+	return dsn(path, all)
+}
+
+// sourceParams returns the driver parameters of the source databases. An
+// immutable database is opened without any locking nor change detection,
+// which works on read-only media but returns wrong results if something
+// writes to it.
+func sourceParams(cfg Config) url.Values {
+	params := url.Values{}
+	if cfg.SrcImmutable {
+		params.Set("immutable", "1")
+	}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	return params
+}
+
+func diffTable(ctx context.Context, src, dst *sql.DB, table Table, cfg Config, fn func(diff.Row) error) (diff.TableStats, error) {
+	stats := diff.TableStats{Table: table.name}
+
+	exists, err := tableExists(ctx, dst, table.targetName())
+	if err != nil {
+		return stats, err
+	}
+	if exists {
+		target, err := getTableInfo(ctx, dst, table.targetName())
+		if err != nil {
+			return stats, err
+		}
+		for _, c := range table.columns {
+			if !containsFold(target.columns, c) {
+				return stats, classify(ErrSchemaMismatch, fmt.Errorf("target has no column %s", c))
+			}
+		}
+	}
+
+	srcTx, err := src.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return stats, fmt.Errorf("starting source transaction: %w", err)
+	}
+	defer srcTx.Rollback()
+	dstTx, err := dst.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return stats, fmt.Errorf("starting target transaction: %w", err)
+	}
+	defer dstTx.Rollback()
+
+	query, args := buildDiffQuery(table, table.name, cfg)
+	source, err := openCursor(ctx, srcTx, query, args, diffColumns(table))
+	if err != nil {
+		return stats, fmt.Errorf("reading source: %w", err)
+	}
+	defer source.close()
+	target := diff.Rows(nil)
+	if exists {
+		targetQuery, _ := buildDiffQuery(table, table.targetName(), cfg)
+		cursor, err := openCursor(ctx, dstTx, targetQuery, args, diffColumns(table))
+		if err != nil {
+			return stats, fmt.Errorf("reading target: %w", err)
+		}
+		defer cursor.close()
+		target = cursor
+	}
+
+	source.blobs = newBlobStore(cfg)
+	spec := diff.Table{Name: table.name, KeyColumns: table.keyCols, Columns: table.columns, JSONColumns: jsonColumns(table, cfg)}
+	return diff.Compare(spec, source, target, fn)
+}
+
+// buildDiffQuery selects the diffColumns, each once, from the table called
+// name, in key order. The keys are sorted with the BINARY collation whatever the declared
+// one, so the order matches diff.CompareKeys.
+func buildDiffQuery(table Table, name string, cfg Config) (string, []interface{}) {
+	order := make([]string, len(table.keyCols))
+	for i, k := range table.keyCols {
+		order[i] = quoteIdent(k) + " COLLATE BINARY"
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(diffColumns(table)), quoteIdent(name))
+	where, args := buildFilter(table, cfg)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	return query, args
+}
+
+// diffColumns returns the key columns followed by table.columns, the layout
+// of the rows of buildDiffQuery.
+func diffColumns(table Table) []string {
+	return append(append([]string{}, table.keyCols...), table.columns...)
+}
+
+func tableExists(ctx context.Context, db queryer, name string) (bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT 1 FROM sqlite_master WHERE type='table' AND name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// rowCursor is a diff.RowReader over the rows of a query.
+type rowCursor struct {
+	rows  *sql.Rows
+	row   *rowScanner
+	blobs *blobStore // compares the BLOBs to externalize by reference
+}
+
+func openCursor(ctx context.Context, db queryer, query string, args []interface{}, cols []string) (*rowCursor, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &rowCursor{rows: rows, row: newRowScanner(cols)}, nil
+}
+
+func (c *rowCursor) Next() ([]interface{}, error) {
+	if !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := c.row.scan(c.rows); err != nil {
+		return nil, err
+	}
+	return c.blobs.refs(c.row.values), nil
+}
+
+func (c *rowCursor) close() {
+	c.rows.Close()
+}
@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+func TestDiff(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)`,
+			srcData: [][]interface{}{{1, "a", "a@x"}, {2, "b", "b@x"}, {3, "c", "c@x"}, {10, "j", nil}},
+			tgtData: [][]interface{}{{1, "a", "a@x"}, {2, "b", "old@x"}, {4, "d", "d@x"}, {10, "j", nil}},
+		},
+		{
+			// The key order differs from the declared collation
+			name:    "tags",
+			schema:  `CREATE TABLE tags (kind TEXT COLLATE NOCASE, name TEXT, n INTEGER, PRIMARY KEY (name, kind)) WITHOUT ROWID`,
+			srcData: [][]interface{}{{"b", "x", 1}, {"A", "x", 1}, {"a", "y", 1}},
+			tgtData: [][]interface{}{{"b", "x", 1}, {"A", "x", 2}},
+		},
+	})
+
+	var diffs []diff.Row
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath}
+	stats, err := Diff(context.Background(), cfg, func(d diff.Row) error {
+		diffs = append(diffs, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if !stats.Different() {
+		t.Error("Different() = false, want true")
+	}
+
+	type summary struct {
+		table   string
+		kind    diff.Kind
+		key     []interface{}
+		changed []string
+	}
+	var got []summary
+	for _, d := range diffs {
+		got = append(got, summary{d.Table, d.Kind, d.Key, d.Changed})
+	}
+	want := []summary{
+		{"users", diff.Changed, []interface{}{int64(2)}, []string{"email"}},
+		{"users", diff.SourceOnly, []interface{}{int64(3)}, nil},
+		{"users", diff.TargetOnly, []interface{}{int64(4)}, nil},
+		{"tags", diff.Changed, []interface{}{"x", "A"}, []string{"n"}},
+		{"tags", diff.SourceOnly, []interface{}{"y", "a"}, nil},
+	}
+	// Tables come in schema order
+	if len(got) != len(want) {
+		t.Fatalf("got diffs %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("diff %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if users := stats.Tables[0]; users.Identical != 2 || users.Changed != 1 || users.SourceOnly != 1 || users.TargetOnly != 1 {
+		t.Errorf("unexpected users stats %+v", users)
+	}
+
+	// Diff must not write, and finds nothing once the target is synced
+	if n := countTestRows(t, tgtDB, "users"); n != 4 {
+		t.Errorf("target has %d users after Diff, want 4", n)
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	stats, err = Diff(context.Background(), cfg, func(d diff.Row) error {
+		t.Errorf("unexpected diff after sync %+v", d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if stats.Different() {
+		t.Error("Different() = true after sync, want false")
+	}
+}
+
+func TestDiffMissingTarget(t *testing.T) {
+	srcPath, _, _, _ := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "a"}},
+	}})
+
+	_, err := Diff(context.Background(), Config{SrcDbPath: srcPath, DstDbPath: filepath.Join(t.TempDir(), "missing.db")}, func(diff.Row) error { return nil })
+	if err == nil {
+		t.Error("Diff() against a missing target succeeded")
+	}
+}
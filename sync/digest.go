@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Digest holds the content hashes of the tables of a database, see Hash.
+type Digest struct {
+	Checksum string        `json:"checksum"` // algorithm of the hashes
+	Tables   []TableDigest `json:"tables"`
+	Digest   string        `json:"digest"` // hash of the table hashes
+	Duration time.Duration `json:"-"`
+}
+
+// TableDigest is the content hash of a table.
+type TableDigest struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+	Hash  string `json:"hash"`
+}
+
+// Hash computes the content hashes of the tables of the database at
+// cfg.SrcDbPath selected by the Tables, ExcludeTables and Where fields of
+// cfg, with the Checksum algorithm, without modifying it. A table hashes its
+// column names, then its rows in key order, see hashRow, so two databases
+// holding the same rows hash alike whatever their file layout; Digest hashes
+// the names and hashes of the tables sorted by name. Tables matched by
+// content with KeylessMatch are hashed in the order of their columns,
+// others without a primary key in the order of their rowids.
+func Hash(ctx context.Context, cfg Config) (*Digest, error) {
+	start := time.Now()
+	digest := &Digest{Checksum: checksumName(cfg.Checksum)}
+	defer func() { digest.Duration = time.Since(start) }()
+
+	if err := cfg.validate(); err != nil {
+		return digest, classify(ErrInvalidConfig, err)
+	}
+	db, err := openReadOnly(cfg.driver(), cfg.SrcDbPath, sourceParams(cfg))
+	if err != nil {
+		return digest, classify(ErrOpenSource, fmt.Errorf("opening db: %w", err))
+	}
+	defer db.Close()
+
+	tables, _, err := getTables(ctx, db)
+	if err != nil {
+		return digest, classify(ErrOpenSource, err)
+	}
+	if tables, err = selectTables(tables, cfg); err != nil {
+		return digest, classify(ErrInvalidConfig, err)
+	}
+	tables, _ = indexTables(tables)
+	if err := matchKeyless(tables, cfg); err != nil {
+		return digest, classify(ErrInvalidConfig, err)
+	}
+	slices.SortFunc(tables, func(a, b Table) int { return strings.Compare(a.name, b.name) })
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return digest, err
+	}
+	defer tx.Rollback()
+	all := newChecksum(cfg.Checksum)
+	for _, table := range tables {
+		t := TableDigest{Table: table.name}
+		h := newChecksum(cfg.Checksum)
+		cols := table.rowColumns()
+		if table.byContent {
+			cols = table.columns
+		}
+		for _, c := range cols {
+			writeTagged(h, tagText, []byte(c))
+		}
+		query, args, _ := orderedQuery(table, table.name, cfg)
+		rows, err := openCursor(ctx, tx, query, args, table.rowColumns())
+		if err != nil {
+			return digest, fmt.Errorf("reading table %s: %w", table.name, err)
+		}
+		for {
+			values, err := rows.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rows.close()
+				return digest, fmt.Errorf("reading table %s: %w", table.name, err)
+			}
+			// The rowid of a row matched by content isn't part of it
+			if table.byContent {
+				values = values[1:]
+			}
+			hashRow(h, values)
+			t.Rows++
+		}
+		rows.close()
+		t.Hash = hex.EncodeToString(h.Sum(nil))
+		digest.Tables = append(digest.Tables, t)
+		writeTagged(all, tagText, []byte(t.Table))
+		writeTagged(all, tagText, []byte(t.Hash))
+	}
+	digest.Digest = hex.EncodeToString(all.Sum(nil))
+	return digest, nil
+}
@@ -0,0 +1,57 @@
+package sync
+
+import (
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{2, "Bob"}, {1, "Alice"}},
+		},
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, total REAL)`,
+			srcData: [][]interface{}{{1, 9.5}},
+			tgtData: [][]interface{}{{1, 9.5}},
+		},
+	})
+	hash := func(path string, tables ...string) *Digest {
+		t.Helper()
+		d, err := Hash(t.Context(), Config{SrcDbPath: path, Tables: tables, Checksum: ChecksumSHA256})
+		if err != nil {
+			t.Fatalf("Hash(%s) error = %v", path, err)
+		}
+		return d
+	}
+
+	src, tgt := hash(srcPath), hash(tgtPath)
+	if len(src.Tables) != 2 || src.Tables[0].Table != "orders" || src.Tables[1].Rows != 2 || src.Checksum != ChecksumSHA256 {
+		t.Fatalf("unexpected digest %+v", src)
+	}
+	// Recorded with the default algorithm too
+	if d, err := Hash(t.Context(), Config{SrcDbPath: srcPath}); err != nil || d.Checksum != ChecksumXXHash64 || len(d.Digest) != 16 {
+		t.Errorf("default Hash() = %+v, %v, want an xxhash64 digest", d, err)
+	}
+	// Rows inserted in another order hash alike
+	if src.Digest != tgt.Digest {
+		t.Errorf("digests differ for the same rows: %s, %s", src.Digest, tgt.Digest)
+	}
+
+	if _, err := tgtDB.Exec(`UPDATE users SET name = 'Robert' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	tgt = hash(tgtPath)
+	if src.Digest == tgt.Digest || src.Tables[1].Hash == tgt.Tables[1].Hash {
+		t.Errorf("changed users hash alike")
+	}
+	if src.Tables[0].Hash != tgt.Tables[0].Hash {
+		t.Errorf("unchanged orders hash differently")
+	}
+	if d := hash(tgtPath, "orders"); len(d.Tables) != 1 || d.Tables[0] != src.Tables[0] {
+		t.Errorf("selected tables digest = %+v", d.Tables)
+	}
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// columnDef is a column of a table as declared, see PRAGMA table_info.
+type columnDef struct {
+	name    string
+	typ     string
+	notNull bool
+	dflt    sql.NullString // the default value expression
+}
+
+func (c columnDef) String() string {
+	if c.typ == "" {
+		return c.name
+	}
+	return c.name + " " + c.typ
+}
+
+// sameColumn reports whether two columns have the same name and declared
+// type, regardless of case.
+func sameColumn(a, b columnDef) bool {
+	return strings.EqualFold(a.name, b.name) && strings.EqualFold(a.typ, b.typ)
+}
+
+// schemaDiff returns the differences between the columns of a table in the
+// source and in the target, in names, order or declared types, as the lines
+// of a diff of the column lists: the columns only in the source prefixed
+// with "-", those only in the target with "+". It returns "" when they are
+// the same.
+func schemaDiff(src, dst []columnDef) string {
+	// Longest common subsequence of the two lists
+	lcs := make([][]int, len(src)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(dst)+1)
+	}
+	for i := len(src) - 1; i >= 0; i-- {
+		for j := len(dst) - 1; j >= 0; j-- {
+			if sameColumn(src[i], dst[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	if lcs[0][0] == len(src) && len(src) == len(dst) {
+		return ""
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(src) || j < len(dst) {
+		switch {
+		case i < len(src) && j < len(dst) && sameColumn(src[i], dst[j]):
+			fmt.Fprintf(&b, "    %s\n", src[i])
+			i, j = i+1, j+1
+		case j == len(dst) || i < len(src) && lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&b, "  - %s\n", src[i])
+			i++
+		default:
+			fmt.Fprintf(&b, "  + %s\n", dst[j])
+			j++
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// withoutUnsynced returns the columns of cols other than those of the source
+// table left out of the sync by its TableOptions, which the target may lay
+// out as it likes.
+func withoutUnsynced(table Table, cols []columnDef) []columnDef {
+	synced := table.rowColumns()
+	kept := make([]columnDef, 0, len(cols))
+	for _, c := range cols {
+		if containsFold(synced, c.name) || !containsColumn(table.schema, c.name) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// containsColumn reports whether cols holds one called name.
+func containsColumn(cols []columnDef, name string) bool {
+	for _, c := range cols {
+		if strings.EqualFold(c.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// migratedColumns returns cols as they compare once Config.MigrateSchema
+// migrated the target: ordered by name, since added columns come last, and
+// with their type affinity, since a declared type can't be altered without
+// rebuilding the table and those of the same affinity store the same values.
+func migratedColumns(cols []columnDef) []columnDef {
+	migrated := make([]columnDef, len(cols))
+	for i, c := range cols {
+		migrated[i] = columnDef{name: c.name, typ: affinity(c.typ)}
+	}
+	slices.SortFunc(migrated, func(a, b columnDef) int {
+		return strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	})
+	return migrated
+}
+
+// affinity returns the type affinity of a column of declared type typ, by
+// the rules of https://www.sqlite.org/datatype3.html#determination_of_column_affinity.
+func affinity(typ string) string {
+	typ = strings.ToUpper(typ)
+	switch {
+	case strings.Contains(typ, "INT"):
+		return "INTEGER"
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "CLOB"), strings.Contains(typ, "TEXT"):
+		return "TEXT"
+	case strings.Contains(typ, "BLOB"), typ == "":
+		return "BLOB"
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaDiff(t *testing.T) {
+	cols := func(defs ...string) []columnDef {
+		var c []columnDef
+		for _, d := range defs {
+			name, typ, _ := strings.Cut(d, " ")
+			c = append(c, columnDef{name: name, typ: typ})
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		src, dst []columnDef
+		want     string
+	}{
+		{"Same", cols("id INTEGER", "name TEXT"), cols("ID integer", "name text"), ""},
+		{"Reordered", cols("id INTEGER", "name TEXT", "email TEXT"), cols("id INTEGER", "email TEXT", "name TEXT"),
+			"    id INTEGER\n  - name TEXT\n    email TEXT\n  + name TEXT"},
+		{"Type changed", cols("id INTEGER", "score INTEGER"), cols("id INTEGER", "score TEXT"),
+			"    id INTEGER\n  - score INTEGER\n  + score TEXT"},
+		{"Extra target column", cols("id", "name"), cols("id", "name", "note"),
+			"    id\n    name\n  + note"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schemaDiff(tt.src, tt.dst); got != tt.want {
+				t.Errorf("schemaDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncSchemaDrift(t *testing.T) {
+	dir := t.TempDir()
+	srcPath, tgtPath := filepath.Join(dir, "src.db"), filepath.Join(dir, "tgt.db")
+	srcDB, err := createTestDB(srcPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDB.Close()
+	if err := insertTestData(srcDB, "users", [][]interface{}{{1, "Alice", "alice@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The columns of the target are swapped
+	tgtDB, err := createTestDB(tgtPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tgtDB.Close()
+
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("Sync() error = %v, want ErrSchemaMismatch", err)
+	}
+	if !strings.Contains(err.Error(), "  - name TEXT") || !strings.Contains(err.Error(), "  + name TEXT") {
+		t.Errorf("error lacks the column diff: %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 0 {
+		t.Errorf("target holds %d rows after a schema mismatch", n)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, IgnoreSchemaDiff: true}); err != nil {
+		t.Fatalf("Sync() with IgnoreSchemaDiff error = %v", err)
+	}
+	var name, email sql.NullString
+	if err := tgtDB.QueryRow(`SELECT name, email FROM users WHERE id = 1`).Scan(&name, &email); err != nil {
+		t.Fatal(err)
+	}
+	if name.String != "Alice" || email.String != "alice@example.com" {
+		t.Errorf("columns written by position: name = %q, email = %q", name.String, email.String)
+	}
+}
@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// The databases of a sync are opened through the database/sql/driver.Driver
+// interface: the local files by go-sqlite3, see Config.driver, and the
+// libsql:// URLs by Config.LibSQLDriver, the Hrana over HTTP driver of
+// libsql.go by default.
+
+// driver returns the SQLite driver opening the connections of a sync, which
+// loads Config.Extensions and calls Config.ConnectHook on each of them.
+func (cfg Config) driver() driver.Driver {
+	return cfg.sqliteDriver(cfg.ConnectHook)
+}
+
+// sqliteDriver returns the go-sqlite3 driver loading Config.Extensions and
+// calling hook on each connection.
+func (cfg Config) sqliteDriver(hook func(*sqlite3.SQLiteConn) error) *sqlite3.SQLiteDriver {
+	return &sqlite3.SQLiteDriver{Extensions: cfg.Extensions, ConnectHook: hook}
+}
+
+// libSQLDriver returns the driver opening the libsql:// URLs.
+func (cfg Config) libSQLDriver() driver.Driver {
+	if cfg.LibSQLDriver != nil {
+		return cfg.LibSQLDriver
+	}
+	return hranaDriver{}
+}
+
+// openDB is sql.Open for a driver that isn't registered by name.
+func openDB(d driver.Driver, dsn string) (*sql.DB, error) {
+	return sql.OpenDB(connector{driver: d, dsn: dsn}), nil
+}
+
+type connector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.driver
+}
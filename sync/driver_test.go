@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestSyncLoadExtension(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+	missing := filepath.Join(t.TempDir(), "missing_ext")
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Extensions: []string{missing}}
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "missing_ext") {
+		t.Errorf("Sync() error = %v, want the missing extension reported", err)
+	}
+}
+
+func TestSyncConnectHook(t *testing.T) {
+	hook := func(conn *sqlite3.SQLiteConn) error {
+		return conn.RegisterCollation("nocase_trim", func(a, b string) int {
+			return strings.Compare(strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b)))
+		})
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.db")
+	tgtPath := filepath.Join(dir, "tgt.db")
+	for _, path := range []string{srcPath, tgtPath} {
+		db, err := openDB(Config{ConnectHook: hook}.driver(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec(`CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT COLLATE nocase_trim UNIQUE)`); err != nil {
+			t.Fatal(err)
+		}
+		if path == srcPath {
+			if _, err := db.Exec(`INSERT INTO tags VALUES (1, 'Go'), (2, 'SQLite')`); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := db.Exec(`INSERT INTO tags VALUES (3, ' go ')`); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Replace: true}
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "nocase_trim") {
+		t.Errorf("Sync() without the hook error = %v, want the unknown collation reported", err)
+	}
+
+	cfg.ConnectHook = hook
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	db, err := openDB(cfg.driver(), tgtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ids []int
+	rows, err := db.Query(`SELECT id FROM tags ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("target ids = %v, want [1 2], ' go ' replaced by 'Go' under the collation", ids)
+	}
+}
@@ -0,0 +1,70 @@
+package sync
+
+import "errors"
+
+// Errors classifying why a run failed, matched with errors.Is. The errors
+// returned keep their own message. The errors of single tables are wrapped
+// in a TableError.
+var (
+	// ErrInvalidConfig is an invalid Config value.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrOpenSource is a source database that can't be opened or read.
+	ErrOpenSource = errors.New("opening source db")
+	// ErrOpenTarget is a target database that can't be opened.
+	ErrOpenTarget = errors.New("opening target db")
+	// ErrTargetLocked is a target another run is syncing, see
+	// Config.WaitLock. It is also an ErrOpenTarget.
+	ErrTargetLocked error = &classError{ErrOpenTarget, errors.New("target locked")}
+	// ErrSchemaMismatch is a table or column of the source missing from the
+	// target or from a union source.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+	// ErrCorruptSource is a source database failing the quick check of
+	// Config.CheckSource. It is also an ErrOpenSource.
+	ErrCorruptSource error = &classError{ErrOpenSource, errors.New("source corrupt")}
+	// ErrTableMissing is a synced table missing from the target. It is also
+	// an ErrSchemaMismatch.
+	ErrTableMissing error = &classError{ErrSchemaMismatch, errors.New("table missing")}
+	// ErrUnsupportedFilter is a Config.Filter other than gt, lt, gte and
+	// lte. It is also an ErrInvalidConfig.
+	ErrUnsupportedFilter error = &classError{ErrInvalidConfig, errors.New("unsupported filter")}
+	// ErrVerification is a target failing the checks made after the rows
+	// were written, like Config.DeferConstraints, Verify or CheckTarget.
+	ErrVerification = errors.New("verification failed")
+	// ErrTooManyDeletes is a table whose sync would delete more of its
+	// target rows than Config.MaxDeleteFraction allows.
+	ErrTooManyDeletes = errors.New("too many deletes")
+	// ErrTargetChanged is a target whose planned tables changed since the
+	// plan applied to it was made, see Apply. It is also an ErrVerification.
+	ErrTargetChanged error = &classError{ErrVerification, errors.New("target changed")}
+	// ErrAlreadyApplied is a plan applied to the target before, which Apply
+	// leaves alone, so that deliveries can be retried.
+	ErrAlreadyApplied = errors.New("plan already applied")
+)
+
+// TableError is the error of a table that failed to sync. A run going on
+// after a failed table, see Config.KeepGoing, returns their errors.Join.
+type TableError struct {
+	Table string
+	Err   error
+}
+
+func (e *TableError) Error() string { return "syncing table " + e.Table + ": " + e.Err.Error() }
+func (e *TableError) Unwrap() error { return e.Err }
+
+// classError tags an error with one of the Err values, or one of them with
+// a broader one.
+type classError struct {
+	class error
+	err   error
+}
+
+func classify(class, err error) error {
+	return &classError{class, err}
+}
+
+func (e *classError) Error() string { return e.err.Error() }
+func (e *classError) Unwrap() error { return e.err }
+
+func (e *classError) Is(target error) bool {
+	return e == target || errors.Is(e.class, target)
+}
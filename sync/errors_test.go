@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncErrorClasses(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	_, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, DstTxLock: "bad"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("invalid lock: error = %v, want ErrInvalidConfig", err)
+	}
+
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Filter: "eq", Value: "1"})
+	if !errors.Is(err, ErrUnsupportedFilter) || !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("unknown filter: error = %v, want ErrUnsupportedFilter", err)
+	}
+
+	_, err = Sync(Config{SrcDbPath: filepath.Join(t.TempDir(), "missing.db"), DstDbPath: tgtPath})
+	if !errors.Is(err, ErrOpenSource) {
+		t.Errorf("missing source: error = %v, want ErrOpenSource", err)
+	}
+
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: filepath.Join(t.TempDir(), "no", "dir.db"), Tables: []string{"users"}})
+	if !errors.Is(err, ErrOpenTarget) {
+		t.Errorf("target in a missing directory: error = %v, want ErrOpenTarget", err)
+	}
+
+	if _, err := tgtDB.Exec(`ALTER TABLE users RENAME TO people`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	var tableErr *TableError
+	if !errors.As(err, &tableErr) || tableErr.Table != "users" {
+		t.Errorf("target table missing: error = %v, want a TableError for users", err)
+	}
+	if !errors.Is(err, ErrTableMissing) || !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("target table missing: error = %v, want ErrTableMissing", err)
+	}
+	if _, err := tgtDB.Exec(`ALTER TABLE people RENAME TO users`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tgtDB.Exec(`ALTER TABLE users DROP COLUMN name`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if !errors.Is(err, ErrSchemaMismatch) || errors.Is(err, ErrTableMissing) {
+		t.Errorf("target column missing: error = %v, want ErrSchemaMismatch", err)
+	}
+	if want := "syncing table users: target table users has no column name"; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,201 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Explanation describes what Sync would do with a Config, see Explain.
+type Explanation struct {
+	Tables []TablePlan
+
+	// Problems lists the mistakes found in the Config that Sync would
+	// silently ignore, like unknown tables, or only hit halfway through
+	// the run, like an invalid WHERE condition.
+	Problems []string
+
+	// Unsupported lists the source objects Sync wouldn't cover, see
+	// Stats.Unsupported.
+	Unsupported []Unsupported
+}
+
+// TablePlan describes how Sync would copy a table.
+type TablePlan struct {
+	Table string
+	// Target is the name of the table in the target, when it differs.
+	Target  string
+	Columns []string
+	Key     []string
+	// Where is the condition selecting the source rows, with its
+	// arguments. An empty condition selects every row.
+	Where string
+	Args  []interface{}
+	// Write is how the rows are written: "attach" for a single INSERT ...
+	// SELECT on the attached source, "upsert" or "replace" row by row.
+	Write       string
+	JSONColumns []string
+	// Delete is whether the target rows missing from the source are
+	// deleted.
+	Delete bool
+}
+
+// Explain resolves cfg against the source schema, without writing anything
+// nor opening the target, and returns the tables Sync would copy and how.
+// Invalid option values are returned as an error, mistakes Sync would not
+// reject upfront as Problems.
+func Explain(ctx context.Context, cfg Config) (*Explanation, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, classify(ErrInvalidConfig, err)
+	}
+	if isRemote(cfg.SrcDbPath) {
+		dir, err := os.MkdirTemp("", "rslite-remote-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if cfg, err = fetchSources(ctx, cfg, dir); err != nil {
+			return nil, err
+		}
+	}
+	src, err := openReadOnly(cfg.driver(), cfg.SrcDbPath, sourceParams(cfg))
+	if err != nil {
+		return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	defer src.Close()
+
+	all, unsupported, err := getTables(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	exp := &Explanation{}
+	problem := func(format string, args ...interface{}) {
+		exp.Problems = append(exp.Problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, pattern := range cfg.Tables {
+		if !anyTableMatches(all, pattern) {
+			problem("no source table matches %s", pattern)
+		}
+	}
+	for _, pattern := range cfg.ExcludeTables {
+		if !anyTableMatches(all, pattern) {
+			problem("no source table matches excluded %s", pattern)
+		}
+	}
+	var tables []Table
+	for _, t := range all {
+		if cfg.selects(t.name) {
+			tables = append(tables, t)
+		}
+	}
+	for _, u := range unsupported {
+		if cfg.selects(u.Name) {
+			exp.Unsupported = append(exp.Unsupported, u)
+		}
+	}
+	objects, err := findUnsupported(ctx, src, tables, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("listing unsupported objects: %w", err)
+	}
+	exp.Unsupported = append(exp.Unsupported, objects...)
+
+	synced := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		synced[t.name] = true
+	}
+	for name := range cfg.TableWhere {
+		if !synced[name] {
+			problem("table condition for %s, which is not synced", name)
+		}
+	}
+	for name := range cfg.TableOptions {
+		if !synced[name] {
+			problem("table options for %s, which is not synced", name)
+		}
+	}
+	for i, t := range tables {
+		if tables[i], err = applyTableOptions(t, cfg.TableOptions[t.name]); err != nil {
+			problem("%v", err)
+			return exp, nil
+		}
+	}
+	for _, name := range cfg.JSONColumns {
+		if !jsonColumnUsed(tables, name) {
+			problem("JSON column %s matches no synced column", name)
+		}
+	}
+	if err := checkColumns(tables, cfg); err != nil {
+		problem("%v", err)
+		return exp, nil
+	}
+
+	// Load the watermarks so the plan shows the conditions of the next run
+	if cfg.StatePath != "" {
+		if cfg.state, err = loadState(cfg.StatePath, cfg.Checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	write := "upsert"
+	switch {
+	case canAttach(cfg):
+		write = "attach"
+	case cfg.Replace:
+		write = "replace"
+	}
+	for _, table := range tables {
+		where, args := buildFilter(table, cfg)
+		plan := TablePlan{
+			Table:       table.name,
+			Target:      table.target,
+			Columns:     table.columns,
+			Key:         table.keyCols,
+			Where:       where,
+			Args:        args,
+			Write:       write,
+			JSONColumns: jsonColumns(table, cfg),
+			Delete:      cfg.deletes(table),
+		}
+		if cfg.Replace {
+			plan.JSONColumns = nil
+		}
+		exp.Tables = append(exp.Tables, plan)
+
+		// Preparing the query catches unknown columns and syntax errors
+		if where != "" {
+			query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s", quoteIdent(table.name), where)
+			stmt, err := src.PrepareContext(ctx, query)
+			if err != nil {
+				problem("table %s: invalid condition %s: %v", table.name, where, err)
+				continue
+			}
+			stmt.Close()
+		}
+	}
+	return exp, nil
+}
+
+// anyTableMatches reports whether a table name matches pattern.
+func anyTableMatches(tables []Table, pattern string) bool {
+	for _, t := range tables {
+		if matchTable([]string{pattern}, t.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonColumnUsed reports whether a Config.JSONColumns entry names a column of
+// one of the tables.
+func jsonColumnUsed(tables []Table, name string) bool {
+	for _, t := range tables {
+		for _, c := range t.columns {
+			if strings.EqualFold(name, c) || strings.EqualFold(name, t.name+"."+c) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{
+		{name: "users", schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, active INTEGER)`},
+		{name: "orders", schema: `CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER)`},
+	})
+
+	exp, err := Explain(context.Background(), Config{
+		SrcDbPath:  srcPath,
+		DstDbPath:  tgtPath,
+		Tables:     []string{"users", "ordrs"},
+		Filter:     "gt",
+		Value:      "3",
+		TableWhere: map[string]string{"users": "actve = 1", "orders": "1"},
+		NoDelete:   true,
+		NoAttach:   true,
+	})
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	want := []TablePlan{{
+		Table:   "users",
+		Columns: []string{"id", "name", "active"},
+		Key:     []string{"id"},
+		Where:   `"id" > ? AND (actve = 1)`,
+		Args:    []interface{}{"3"},
+		Write:   "upsert",
+	}}
+	if !reflect.DeepEqual(exp.Tables, want) {
+		t.Errorf("Tables = %+v, want %+v", exp.Tables, want)
+	}
+	if len(exp.Problems) != 3 {
+		t.Errorf("Problems = %q, want the unknown table, the unused and the invalid condition", exp.Problems)
+	}
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStats counts the files synced between attachment directories, see
+// Config.AttachmentDirs.
+type FileStats struct {
+	Copied  int64
+	Deleted int64
+}
+
+// copyFiles copies the regular files under src to the same relative paths
+// under dst when they are missing there or their content differs. Files are
+// compared by size, then by SHA-256, and written through a rename so readers
+// never see a partial file.
+func copyFiles(ctx context.Context, src, dst string, stats *FileStats) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		same, err := sameFile(path, target)
+		if err != nil || same {
+			return err
+		}
+		if err := replaceFile(path, target); err != nil {
+			return fmt.Errorf("copying %s: %w", rel, err)
+		}
+		stats.Copied++
+		return nil
+	})
+}
+
+// pruneFiles removes the regular files under dst that have no counterpart
+// under src.
+func pruneFiles(ctx context.Context, src, dst string, stats *FileStats) error {
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		stats.Deleted++
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // nothing was ever copied
+	}
+	return err
+}
+
+// sameFile reports whether b exists with the same content as a.
+func sameFile(a, b string) (bool, error) {
+	bInfo, err := os.Stat(b)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	if aInfo.Size() != bInfo.Size() {
+		return false, nil
+	}
+	aSum, err := fileHash(a)
+	if err != nil {
+		return false, err
+	}
+	bSum, err := fileHash(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aSum, bSum), nil
+}
+
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func replaceFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(to), filepath.Base(to)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), to)
+}
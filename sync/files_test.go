@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAttachments(t *testing.T) {
+	tables := []testTable{{
+		name:    "docs",
+		schema:  `CREATE TABLE docs (id INTEGER PRIMARY KEY, file TEXT)`,
+		srcData: [][]interface{}{{1, "a.txt"}, {2, "sub/b.txt"}},
+		tgtData: [][]interface{}{{3, "old.txt"}},
+	}}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	srcDir := filepath.Join(t.TempDir(), "src")
+	dstDir := filepath.Join(t.TempDir(), "dst")
+	writeTestFiles(t, srcDir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	writeTestFiles(t, dstDir, map[string]string{"a.txt": "a", "sub/b.txt": "stale", "old.txt": "old"})
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, AttachmentDirs: map[string]string{srcDir: dstDir}}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if want := (FileStats{Copied: 1, Deleted: 1}); stats.Files != want {
+		t.Errorf("Files = %+v, want %+v", stats.Files, want)
+	}
+	if b, err := os.ReadFile(filepath.Join(dstDir, "sub/b.txt")); err != nil || string(b) != "b" {
+		t.Errorf("sub/b.txt = %q, %v, want the source content", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("old.txt was not removed: %v", err)
+	}
+
+	// Files are kept like rows with NoDelete
+	writeTestFiles(t, dstDir, map[string]string{"old.txt": "old"})
+	cfg.NoDelete = true
+	if stats, err = Sync(cfg); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if want := (FileStats{}); stats.Files != want {
+		t.Errorf("second run Files = %+v, want %+v", stats.Files, want)
+	}
+}
+
+// writeTestFiles creates files under dir, keyed by their relative path.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	gosync "sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"gopkg.in/yaml.v3"
+)
+
+// Grant restricts what the clients of a Server sending its Token may read:
+// only the tables matching Tables, by name or glob pattern, all of them
+// when empty, and of the tables in Where only the rows matching their
+// condition. The conditions sent by those clients may only read the synced
+// table, without subqueries, so that they can't reach past the grant.
+type Grant struct {
+	Token  string            `yaml:"token"`
+	Tables []string          `yaml:"tables"`
+	Where  map[string]string `yaml:"where"`
+}
+
+// LoadGrants reads the grants of a Server from a YAML file laid out as:
+//
+//	grants:
+//	  - token: reporting-secret
+//	    tables: [orders, "report_*"]
+//	    where:
+//	      orders: region = 'eu'
+//	  - token: billing-secret
+//	    tables: [invoices]
+func LoadGrants(path string) ([]Grant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading grants: %w", err)
+	}
+	var file struct {
+		Grants []Grant `yaml:"grants"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing grants %s: %w", path, err)
+	}
+	if err := checkGrants(file.Grants); err != nil {
+		return nil, fmt.Errorf("parsing grants %s: %w", path, err)
+	}
+	return file.Grants, nil
+}
+
+// checkGrants fails on grants without a token, sharing one, or with an
+// invalid table pattern.
+func checkGrants(grants []Grant) error {
+	tokens := make(map[string]bool, len(grants))
+	for i, g := range grants {
+		if g.Token == "" {
+			return fmt.Errorf("grant %d has no token", i+1)
+		}
+		if tokens[g.Token] {
+			return fmt.Errorf("grant %d has the token of another one", i+1)
+		}
+		tokens[g.Token] = true
+		for _, p := range g.Tables {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("grant %d: invalid table pattern %q", i+1, p)
+			}
+		}
+		for table := range g.Where {
+			if !g.allows(table) {
+				return fmt.Errorf("grant %d has a condition on table %s it doesn't grant", i+1, table)
+			}
+		}
+	}
+	return nil
+}
+
+// allows reports whether g grants reading a table.
+func (g *Grant) allows(table string) bool {
+	return len(g.Tables) == 0 || matchTable(g.Tables, table)
+}
+
+// where returns the condition of g selecting the rows of a table, if any.
+func (g *Grant) where(table string) string {
+	for t, cond := range g.Where {
+		if strings.EqualFold(t, table) {
+			return cond
+		}
+	}
+	return ""
+}
+
+// conditionChecker checks the conditions sent by the clients of a grant
+// by preparing them on a connection of its own, whose authorizer only lets
+// them read their table.
+type conditionChecker struct {
+	mu      gosync.Mutex
+	conn    *sql.Conn
+	table   string
+	selects int
+	denied  string
+}
+
+func newConditionChecker(ctx context.Context, db *sql.DB) (*conditionChecker, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &conditionChecker{conn: conn}
+	err = conn.Raw(func(dc interface{}) error {
+		sc, ok := dc.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected connection type %T", dc)
+		}
+		sc.RegisterAuthorizer(c.authorize)
+		return nil
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// authorize is the authorizer of the connection, called while a condition
+// is prepared.
+func (c *conditionChecker) authorize(op int, arg1, arg2, _ string) int {
+	switch op {
+	case sqlite3.SQLITE_SELECT:
+		if c.selects++; c.selects > 1 {
+			c.denied = "subqueries"
+			return sqlite3.SQLITE_DENY
+		}
+	case sqlite3.SQLITE_READ:
+		if !strings.EqualFold(arg1, c.table) {
+			c.denied = "reading table " + arg1
+			return sqlite3.SQLITE_DENY
+		}
+	case sqlite3.SQLITE_FUNCTION:
+	default:
+		c.denied = fmt.Sprintf("operation %d", op)
+		return sqlite3.SQLITE_DENY
+	}
+	return sqlite3.SQLITE_OK
+}
+
+// check fails on a condition on table that reads another table, has
+// subqueries, or more than one statement.
+func (c *conditionChecker) check(ctx context.Context, table, cond string) error {
+	if strings.Contains(cond, ";") {
+		return errors.New("conditions of a grant can't hold semicolons")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.table, c.selects, c.denied = table, 0, ""
+	stmt, err := c.conn.PrepareContext(ctx, fmt.Sprintf("SELECT 1 FROM %s WHERE %s", quoteIdent(table), cond))
+	if err != nil {
+		if c.denied != "" {
+			return fmt.Errorf("condition not allowed by the grant: %s", c.denied)
+		}
+		return err
+	}
+	return stmt.Close()
+}
+
+func (c *conditionChecker) Close() error {
+	return c.conn.Close()
+}
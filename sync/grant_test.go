@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerGrants(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, region TEXT)`,
+			srcData: [][]interface{}{{1, "eu"}, {2, "us"}, {3, "eu"}},
+		},
+		{
+			name:    "secrets",
+			schema:  `CREATE TABLE secrets (id INTEGER PRIMARY KEY, value TEXT)`,
+			srcData: [][]interface{}{{1, "hunter2"}},
+		},
+	})
+	grants := []Grant{{Token: "eu", Tables: []string{"ord*"}, Where: map[string]string{"orders": "region = 'eu'"}}}
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "admin", grants)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	url := "rslite://" + strings.TrimPrefix(ts.URL, "http://")
+
+	// Only the granted tables and rows
+	stats, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "eu"})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Table != "orders" {
+		t.Errorf("synced tables %+v, want orders only", stats.Tables)
+	}
+	got, err := getTableData(tgtDB, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "eu"}, {3, "eu"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Along with the conditions of the client, which can't escape them
+	if _, err := tgtDB.Exec(`DELETE FROM orders`); err != nil {
+		t.Fatal(err)
+	}
+	for _, where := range []string{"id > 1", "1) OR (1"} {
+		cfg := Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "eu", Where: where}
+		if _, err := Sync(cfg); err != nil {
+			t.Fatalf("Sync() with condition %q error = %v", where, err)
+		}
+	}
+	got, err = getTableData(tgtDB, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "eu"}, {3, "eu"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct{ name, where, table string }{
+		{"another table", "", "secrets"},
+		{"a subquery", "id IN (SELECT id FROM secrets)", "orders"},
+		{"a second statement", "1; SELECT * FROM secrets", "orders"},
+	} {
+		cfg := Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "eu", Where: tc.where, Tables: []string{tc.table}}
+		if _, err := Sync(cfg); err == nil {
+			t.Errorf("Sync() reading past the grant with %s succeeded", tc.name)
+		}
+	}
+
+	// The server token reads every table
+	if _, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "admin", Tables: []string{"secrets"}}); err != nil {
+		t.Errorf("Sync() with the server token error = %v", err)
+	}
+	if _, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "other"}); err == nil {
+		t.Error("Sync() with an unknown token succeeded")
+	}
+}
+
+func TestLoadGrants(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, data, wantErr string
+		want                int
+	}{
+		{"valid", "grants:\n  - token: a\n    tables: [orders]\n    where:\n      orders: id > 1\n  - token: b\n", "", 2},
+		{"no token", "grants:\n  - tables: [orders]\n", "no token", 0},
+		{"shared token", "grants:\n  - token: a\n  - token: a\n", "token of another", 0},
+		{"bad pattern", "grants:\n  - token: a\n    tables: ['[']\n", "invalid table pattern", 0},
+		{"condition not granted", "grants:\n  - token: a\n    tables: [orders]\n    where:\n      users: id > 1\n", "doesn't grant", 0},
+	} {
+		path := filepath.Join(dir, tc.name+".yaml")
+		if err := os.WriteFile(path, []byte(tc.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		grants, err := LoadGrants(path)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: LoadGrants() error = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(grants) != tc.want {
+			t.Errorf("%s: LoadGrants() = %+v, %v", tc.name, grants, err)
+		}
+	}
+}
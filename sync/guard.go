@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policies for text values holding invalid UTF-8, see Config.FixEncoding.
+const (
+	EncodingRepair = "repair" // replace invalid sequences with U+FFFD
+	EncodingBlob   = "blob"   // write the raw bytes as a BLOB
+	EncodingReject = "reject" // don't write the row
+)
+
+// maxReportedIssues caps the issues kept per table, so a table full of bad
+// rows doesn't hold all of them in memory. TableStats.Flagged keeps the count.
+const maxReportedIssues = 100
+
+// RowIssue describes a source row flagged by the data guards.
+type RowIssue struct {
+	PK     interface{}
+	Reason string
+}
+
+// checkRow applies the data guards enabled in cfg to a row laid out as the pk
+// column followed by table.columns. It returns an empty string for rows that
+// pass.
+func checkRow(table Table, values []interface{}, cfg Config) string {
+	if cfg.MaxRowSize > 0 {
+		if size := rowSize(values[1:]); size > cfg.MaxRowSize {
+			return fmt.Sprintf("row is %d bytes, exceeding the %d bytes limit", size, cfg.MaxRowSize)
+		}
+	}
+	if cfg.CheckUTF8 {
+		for i, v := range values[1:] {
+			if s, ok := v.(string); ok && !utf8.ValidString(s) {
+				return fmt.Sprintf("column %s holds invalid UTF-8 text", table.columns[i])
+			}
+		}
+	}
+	return ""
+}
+
+// fixEncoding applies the FixEncoding policy to the text values of a row in
+// place. It returns a description of what it changed, or an empty string when
+// every value was valid, and whether the row must be rejected.
+func fixEncoding(table Table, values []interface{}, policy string) (reason string, reject bool) {
+	var fixed []string
+	for i, v := range values[1:] {
+		s, ok := v.(string)
+		if !ok || utf8.ValidString(s) {
+			continue
+		}
+		fixed = append(fixed, table.columns[i])
+		switch policy {
+		case EncodingRepair:
+			values[i+1] = strings.ToValidUTF8(s, "\uFFFD")
+		case EncodingBlob:
+			values[i+1] = []byte(s)
+		case EncodingReject:
+			return fmt.Sprintf("rejected: column %s holds invalid UTF-8 text", table.columns[i]), true
+		}
+	}
+	if len(fixed) == 0 {
+		return "", false
+	}
+	action := "repaired"
+	if policy == EncodingBlob {
+		action = "stored as blob"
+	}
+	return fmt.Sprintf("invalid UTF-8 %s in %s", action, strings.Join(fixed, ", ")), false
+}
+
+// rowSize approximates the storage size of a row: the length of text and blob
+// values plus 8 bytes for every other non-NULL value.
+func rowSize(values []interface{}) int64 {
+	var size int64
+	for _, v := range values {
+		switch x := v.(type) {
+		case nil:
+		case string:
+			size += int64(len(x))
+		case []byte:
+			size += int64(len(x))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
@@ -0,0 +1,129 @@
+package sync
+
+import "testing"
+
+func TestSyncGuards(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		wantFlagged int64
+		wantSkipped int64
+		expected    [][]interface{}
+	}{
+		{
+			name:        "Oversized rows are flagged and written",
+			config:      Config{MaxRowSize: 20},
+			wantFlagged: 1,
+			expected: [][]interface{}{
+				{1, "short"},
+				{2, "a much longer value than allowed"},
+				{3, "bad \xff text"},
+			},
+		},
+		{
+			name:        "Flagged rows are skipped",
+			config:      Config{MaxRowSize: 20, CheckUTF8: true, SkipFlagged: true},
+			wantFlagged: 2,
+			wantSkipped: 2,
+			expected: [][]interface{}{
+				{1, "short"},
+				{2, "old 2"},
+				{3, "old 3"},
+			},
+		},
+		{
+			name:        "Invalid UTF-8 is repaired",
+			config:      Config{FixEncoding: EncodingRepair},
+			wantFlagged: 1,
+			expected: [][]interface{}{
+				{1, "short"},
+				{2, "a much longer value than allowed"},
+				{3, "bad \uFFFD text"},
+			},
+		},
+		{
+			name:        "Invalid UTF-8 is stored as blob",
+			config:      Config{FixEncoding: EncodingBlob, CheckUTF8: true},
+			wantFlagged: 1,
+			expected: [][]interface{}{
+				{1, "short"},
+				{2, "a much longer value than allowed"},
+				{3, []byte("bad \xff text")},
+			},
+		},
+		{
+			name:        "Rows with invalid UTF-8 are rejected",
+			config:      Config{FixEncoding: EncodingReject},
+			wantFlagged: 1,
+			wantSkipped: 1,
+			expected: [][]interface{}{
+				{1, "short"},
+				{2, "a much longer value than allowed"},
+				{3, "old 3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := []testTable{{
+				name:   "notes",
+				schema: `CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)`,
+				srcData: [][]interface{}{
+					{1, "short"},
+					{2, "a much longer value than allowed"},
+					{3, "bad \xff text"},
+				},
+				tgtData: [][]interface{}{
+					{2, "old 2"},
+					{3, "old 3"},
+				},
+			}}
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+			cfg := tt.config
+			cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+			stats, err := Sync(cfg)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			got := stats.Tables[0]
+			if got.Flagged != tt.wantFlagged || got.Skipped != tt.wantSkipped {
+				t.Errorf("flagged/skipped = %d/%d, want %d/%d", got.Flagged, got.Skipped, tt.wantFlagged, tt.wantSkipped)
+			}
+			if len(got.Issues) != int(tt.wantFlagged) {
+				t.Errorf("got %d issues, want %d: %+v", len(got.Issues), tt.wantFlagged, got.Issues)
+			}
+
+			var blobs int
+			if err := tgtDB.QueryRow(`SELECT COUNT(*) FROM notes WHERE typeof(body) = 'blob'`).Scan(&blobs); err != nil {
+				t.Fatal(err)
+			}
+			if wantBlobs := btoi(tt.config.FixEncoding == EncodingBlob); blobs != wantBlobs {
+				t.Errorf("got %d blob values, want %d", blobs, wantBlobs)
+			}
+
+			data, err := getTableData(tgtDB, "notes")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !compareData(data, tt.expected) {
+				t.Errorf("got data %v, want %v", data, tt.expected)
+			}
+		})
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestSyncRejectsUnknownEncodingPolicy(t *testing.T) {
+	if _, err := Sync(Config{FixEncoding: "ignore"}); err == nil {
+		t.Fatal("Sync() accepted an unknown encoding policy")
+	}
+}
@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// Checksum algorithms of Config.Checksum. XXH64, the default, and FNV-1a
+// are fast but easy to collide on purpose, SHA-256 is for rows an attacker
+// may control.
+const (
+	ChecksumXXHash64 = "xxhash64"
+	ChecksumFNV      = "fnv"
+	ChecksumSHA256   = "sha256"
+)
+
+// checksumName returns the name of a Config.Checksum algorithm, the default
+// one when empty, as recorded along with its checksums.
+func checksumName(alg string) string {
+	if alg == "" {
+		return ChecksumXXHash64
+	}
+	return alg
+}
+
+// checkChecksum fails on an unknown Config.Checksum algorithm.
+func checkChecksum(alg string) error {
+	switch alg {
+	case "", ChecksumXXHash64, ChecksumFNV, ChecksumSHA256:
+		return nil
+	}
+	return fmt.Errorf("unknown checksum algorithm %q: want %s, %s or %s", alg, ChecksumXXHash64, ChecksumFNV, ChecksumSHA256)
+}
+
+// newChecksum returns the hash of a Config.Checksum algorithm, XXH64 when
+// empty.
+func newChecksum(alg string) hash.Hash {
+	switch alg {
+	case ChecksumSHA256:
+		return sha256.New()
+	case ChecksumFNV:
+		return fnv.New64a()
+	}
+	return newXXHash64()
+}
+
+// Storage class tags of the row encoding fed to the hash.
+const (
+	tagNull byte = iota
+	tagInt
+	tagFloat
+	tagText
+	tagBlob
+	tagTime
+)
+
+// hashRow feeds a row to h. Every value is tagged with its storage class and
+// text and blobs are length-prefixed, so rows only hash alike when they hold
+// the same values: 1 and '1', or ('ab', 'c') and ('a', 'bc'), differ.
+func hashRow(h hash.Hash, values []interface{}) {
+	var buf [9]byte
+	for _, v := range values {
+		switch x := v.(type) {
+		case nil:
+			h.Write([]byte{tagNull})
+		case int64:
+			buf[0] = tagInt
+			binary.BigEndian.PutUint64(buf[1:], uint64(x))
+			h.Write(buf[:])
+		case bool:
+			buf[0] = tagInt
+			binary.BigEndian.PutUint64(buf[1:], uint64(btoi64(x)))
+			h.Write(buf[:])
+		case float64:
+			buf[0] = tagFloat
+			binary.BigEndian.PutUint64(buf[1:], math.Float64bits(x))
+			h.Write(buf[:])
+		case string:
+			writeTagged(h, tagText, []byte(x))
+		case []byte:
+			writeTagged(h, tagBlob, x)
+		case time.Time:
+			writeTagged(h, tagTime, []byte(x.Format(time.RFC3339Nano)))
+		}
+	}
+}
+
+func writeTagged(h hash.Hash, tag byte, b []byte) {
+	var buf [9]byte
+	buf[0] = tag
+	binary.BigEndian.PutUint64(buf[1:], uint64(len(b)))
+	h.Write(buf[:])
+	h.Write(b)
+}
+
+// rowChecksum returns the hash of a row with a Config.Checksum algorithm, see
+// hashRow.
+func rowChecksum(alg string, values []interface{}) []byte {
+	h := newChecksum(alg)
+	hashRow(h, values)
+	return h.Sum(nil)
+}
+
+func btoi64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
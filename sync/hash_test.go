@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRowChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []interface{}
+		same bool
+	}{
+		{"Equal rows", []interface{}{int64(1), "a", nil}, []interface{}{int64(1), "a", nil}, true},
+		{"Integer and text", []interface{}{int64(1)}, []interface{}{"1"}, false},
+		{"Text and blob", []interface{}{"ab"}, []interface{}{[]byte("ab")}, false},
+		{"Shifted boundary", []interface{}{"ab", "c"}, []interface{}{"a", "bc"}, false},
+		{"Null and empty text", []interface{}{nil}, []interface{}{""}, false},
+	}
+	for _, alg := range []string{ChecksumXXHash64, ChecksumFNV, ChecksumSHA256} {
+		for _, tt := range tests {
+			t.Run(alg+"/"+tt.name, func(t *testing.T) {
+				if same := bytes.Equal(rowChecksum(alg, tt.a), rowChecksum(alg, tt.b)); same != tt.same {
+					t.Errorf("checksums equal = %v, want %v", same, tt.same)
+				}
+			})
+		}
+	}
+}
+
+func TestSyncSkipUnchanged(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL)`,
+		srcData: [][]interface{}{{1, "a", 1.5}, {2, "b", 2.0}, {3, "c", nil}},
+		tgtData: [][]interface{}{{1, "a", 1.5}, {2, "old", 2.0}, {3, "c", nil}},
+	}})
+
+	// Count the rows the sync actually writes
+	_, err := tgtDB.Exec(`
+		CREATE TABLE writes (n INTEGER);
+		INSERT INTO writes VALUES (0);
+		CREATE TRIGGER count_inserts AFTER INSERT ON items BEGIN
+			UPDATE writes SET n = n + 1;
+		END;
+		CREATE TRIGGER count_updates AFTER UPDATE ON items BEGIN
+			UPDATE writes SET n = n + 1;
+		END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"items"}, SkipUnchanged: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got := stats.Tables[0]
+	if got.Replaced != 1 || got.Inserted != 0 || got.Skipped != 2 || got.Unchanged != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	var writes int
+	if err := tgtDB.QueryRow(`SELECT n FROM writes`).Scan(&writes); err != nil {
+		t.Fatal(err)
+	}
+	if writes != 1 {
+		t.Errorf("got %d writes to the target, want 1", writes)
+	}
+
+	data, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "a", 1.5}, {2, "b", 2.0}, {3, "c", nil}}; !compareData(data, want) {
+		t.Errorf("got %v, want %v", data, want)
+	}
+}
@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyTable is the table of a target recording its runs, see
+// Config.History. It is never synced.
+const historyTable = "_rslite_meta"
+
+// Run is a past run recorded in the history of a target.
+type Run struct {
+	ID int64 `json:"id"`
+	Report
+}
+
+// saveRun appends the Report of a run to the historyTable of the target,
+// creating it if needed. Targets that don't exist, because the run failed
+// before creating them, are left alone.
+func saveRun(ctx context.Context, cfg Config, r *Report) error {
+	if isRemote(cfg.DstDbPath) {
+		return nil
+	}
+	if _, err := os.Stat(dbFile(cfg.DstDbPath)); os.IsNotExist(err) {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	db, err := openDB(cfg.driver(), dsn(cfg.DstDbPath, params))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY,
+		started TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		success INTEGER NOT NULL,
+		report TEXT NOT NULL
+	)`, historyTable)); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (started, duration_ms, success, report) VALUES (?, ?, ?, ?)", historyTable),
+		r.Started.UTC().Format(time.RFC3339Nano), r.DurationMS, r.Success, string(data))
+	return err
+}
+
+// History returns the runs recorded in the history of the target of cfg,
+// latest first, at most limit of them when limit is positive. A target
+// without history has no runs.
+func History(ctx context.Context, cfg Config, limit int) ([]Run, error) {
+	db, err := openReadOnly(cfg.driver(), cfg.DstDbPath, nil)
+	if err != nil {
+		return nil, classify(ErrOpenTarget, fmt.Errorf("opening db: %w", err))
+	}
+	defer db.Close()
+	if exists, err := tableExists(ctx, db, historyTable); err != nil {
+		return nil, classify(ErrOpenTarget, err)
+	} else if !exists {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT id, report FROM %s ORDER BY id DESC LIMIT ?", historyTable), limit)
+	if err != nil {
+		return nil, classify(ErrOpenTarget, fmt.Errorf("reading history: %w", err))
+	}
+	defer rows.Close()
+	var runs []Run
+	for rows.Next() {
+		var (
+			run  Run
+			data sql.RawBytes
+		)
+		if err := rows.Scan(&run.ID, &data); err != nil {
+			return nil, classify(ErrOpenTarget, fmt.Errorf("reading history: %w", err))
+		}
+		if err := json.Unmarshal(data, &run.Report); err != nil {
+			return nil, classify(ErrOpenTarget, fmt.Errorf("decoding run %d: %w", run.ID, err))
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(ErrOpenTarget, fmt.Errorf("reading history: %w", err))
+	}
+	return runs, nil
+}
+
+// HistorySummary sums up the runs of a target, see Summarize.
+type HistorySummary struct {
+	Runs        int            `json:"runs"`
+	Succeeded   int            `json:"succeeded"`
+	SuccessRate float64        `json:"success_rate"` // between 0 and 1
+	AverageMS   int64          `json:"average_duration_ms"`
+	Since       time.Time      `json:"since"` // start of the earliest run
+	Tables      []TableSummary `json:"tables"`
+}
+
+// TableSummary sums up the runs of a table in a HistorySummary.
+type TableSummary struct {
+	Table     string `json:"table"`
+	Runs      int    `json:"runs"`
+	Failures  int    `json:"failures"`
+	Written   int64  `json:"written"` // rows inserted, updated or deleted
+	AverageMS int64  `json:"average_duration_ms"`
+}
+
+// Summarize sums up runs read with History, offline: how many succeeded,
+// how long they took on average, and for every table the rows they wrote
+// and how long they took, the tables writing the most rows first.
+func Summarize(runs []Run) HistorySummary {
+	s := HistorySummary{Runs: len(runs), Tables: []TableSummary{}}
+	if len(runs) == 0 {
+		return s
+	}
+	var total int64
+	tables := make(map[string]*TableSummary)
+	tableMS := make(map[string]int64)
+	for _, r := range runs {
+		if r.Success {
+			s.Succeeded++
+		}
+		total += r.DurationMS
+		if s.Since.IsZero() || r.Started.Before(s.Since) {
+			s.Since = r.Started
+		}
+		for _, tr := range r.Tables {
+			t, ok := tables[tr.Table]
+			if !ok {
+				t = &TableSummary{Table: tr.Table}
+				tables[tr.Table] = t
+			}
+			t.Runs++
+			if tr.Error != "" {
+				t.Failures++
+			}
+			t.Written += tr.Inserted + tr.Updated + tr.Deleted
+			tableMS[tr.Table] += tr.DurationMS
+		}
+	}
+	s.SuccessRate = float64(s.Succeeded) / float64(s.Runs)
+	s.AverageMS = total / int64(s.Runs)
+	for name, t := range tables {
+		t.AverageMS = tableMS[name] / int64(t.Runs)
+		s.Tables = append(s.Tables, *t)
+	}
+	slices.SortFunc(s.Tables, func(a, b TableSummary) int {
+		if c := cmp.Compare(b.Written, a.Written); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Table, b.Table)
+	})
+	return s
+}
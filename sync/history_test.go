@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSyncHistory(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+	}})
+	ctx := context.Background()
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if runs, err := History(ctx, Config{DstDbPath: tgtPath}, 0); err != nil || len(runs) != 0 {
+		t.Fatalf("History() without recording = %+v, %v, want no runs", runs, err)
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, History: true, Mirror: true}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	failing := cfg
+	failing.Where = "missing = 1"
+	if _, err := Sync(failing); err == nil {
+		t.Fatal("Sync() with an invalid condition succeeded")
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	runs, err := History(ctx, Config{DstDbPath: tgtPath}, 0)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("History() = %d runs, want 3", len(runs))
+	}
+	// Latest first, the history table left alone by the mirroring
+	if runs[0].ID <= runs[1].ID || !runs[0].Success || runs[1].Success || !runs[2].Success {
+		t.Errorf("unexpected runs %+v", runs)
+	}
+	if len(runs[1].Errors) == 0 || runs[1].Config.Where != "missing = 1" {
+		t.Errorf("failed run recorded as %+v", runs[1].Report)
+	}
+	if got := runs[2].Total.Unchanged + runs[2].Total.Updated + runs[2].Total.Inserted; got != 2 {
+		t.Errorf("first recorded run wrote %+v, want 2 rows", runs[2].Total)
+	}
+	if runs, err := History(ctx, Config{DstDbPath: tgtPath}, 1); err != nil || len(runs) != 1 || runs[0].ID != 3 {
+		t.Errorf("History() limited to 1 = %+v, %v, want the latest run", runs, err)
+	}
+	if s := Summarize(runs); s.Runs != 3 || s.Succeeded != 2 || len(s.Tables) != 1 || s.Tables[0].Table != "users" || !s.Since.Equal(runs[2].Started) {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if n := countTestRows(t, tgtDB, historyTable); n != 3 {
+		t.Errorf("history table holds %d rows, want 3", n)
+	}
+
+	// The history of a target isn't synced when it is used as a source
+	other := filepath.Join(t.TempDir(), "other.db")
+	stats, err := Sync(Config{SrcDbPath: tgtPath, DstDbPath: other})
+	if err != nil {
+		t.Fatalf("Sync() from the target error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Table != "users" {
+		t.Errorf("synced tables %+v, want users only", stats.Tables)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runs := []Run{
+		{ID: 3, Report: Report{Started: start.Add(2 * time.Hour), DurationMS: 300, Tables: []TableReport{
+			{Table: "orders", Inserted: 10, DurationMS: 100},
+			{Table: "users", Error: "locked"},
+		}}},
+		{ID: 2, Report: Report{Started: start.Add(time.Hour), DurationMS: 200, Success: true, Tables: []TableReport{
+			{Table: "orders", Updated: 5, Deleted: 1, DurationMS: 50},
+			{Table: "users", Inserted: 20, DurationMS: 80},
+		}}},
+		{ID: 1, Report: Report{Started: start, DurationMS: 100, Success: true, Tables: []TableReport{
+			{Table: "users", Inserted: 2, DurationMS: 20},
+		}}},
+	}
+	s := Summarize(runs)
+	if s.Runs != 3 || s.Succeeded != 2 || s.SuccessRate != 2.0/3 || s.AverageMS != 200 || !s.Since.Equal(start) {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	want := []TableSummary{
+		{Table: "users", Runs: 3, Failures: 1, Written: 22, AverageMS: 33},
+		{Table: "orders", Runs: 2, Written: 16, AverageMS: 75},
+	}
+	if !slices.Equal(s.Tables, want) {
+		t.Errorf("tables %+v, want %+v", s.Tables, want)
+	}
+	if s := Summarize(nil); s.Runs != 0 || s.Tables == nil {
+		t.Errorf("summary of no runs %+v", s)
+	}
+}
@@ -0,0 +1,44 @@
+package sync
+
+import "context"
+
+// Hooks are called at fixed points of a run, see Config.Hooks. Any of them
+// may be nil.
+type Hooks struct {
+	// BeforeSync is called with the tables to sync once the databases are
+	// open, before anything is written. An error aborts the run.
+	BeforeSync func(ctx context.Context, tables []string) error
+
+	// BeforeTable is called before a table is synced, and AfterTable once
+	// it is committed, with its statistics. With Atomic, the run
+	// transaction is only committed at the end. An error fails the table,
+	// even though AfterTable is called after the commit. With Jobs, they
+	// are called concurrently for different tables.
+	BeforeTable func(ctx context.Context, table string) error
+	AfterTable  func(ctx context.Context, stats TableStats) error
+
+	// AfterSync is called at the end of every run, with its statistics and
+	// error, nil if it succeeded. Its error is returned when the run
+	// succeeded.
+	AfterSync func(ctx context.Context, stats *Stats, err error) error
+}
+
+// tableHooks reports whether per-table hooks are set.
+func (h Hooks) tableHooks() bool {
+	return h.BeforeTable != nil || h.AfterTable != nil
+}
+
+// syncTableWithHooks syncs a table with withRetries, calling the per-table
+// hooks around it.
+func syncTableWithHooks(ctx context.Context, cfg Config, table Table, sync func() (TableStats, error)) (TableStats, error) {
+	if h := cfg.Hooks.BeforeTable; h != nil {
+		if err := h(ctx, table.name); err != nil {
+			return TableStats{Table: table.name}, err
+		}
+	}
+	stats, err := withRetries(ctx, cfg, sync)
+	if err == nil && cfg.Hooks.AfterTable != nil {
+		err = cfg.Hooks.AfterTable(ctx, stats)
+	}
+	return stats, err
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSyncHooks(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "a",
+			schema:  `CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+		{
+			name:    "b",
+			schema:  `CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Bob"}, {2, "Carol"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+	var calls []string
+	hooks := Hooks{
+		BeforeSync: func(ctx context.Context, tables []string) error {
+			calls = append(calls, fmt.Sprintf("before sync %v", tables))
+			return nil
+		},
+		BeforeTable: func(ctx context.Context, table string) error {
+			calls = append(calls, "before "+table)
+			return nil
+		},
+		AfterTable: func(ctx context.Context, stats TableStats) error {
+			calls = append(calls, fmt.Sprintf("after %s %d", stats.Table, stats.Inserted))
+			return nil
+		},
+		AfterSync: func(ctx context.Context, stats *Stats, err error) error {
+			calls = append(calls, fmt.Sprintf("after sync %d %v", len(stats.Tables), err))
+			return nil
+		},
+	}
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Hooks: hooks}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	want := []string{
+		"before sync [a b]",
+		"before a", "after a 1",
+		"before b", "after b 2",
+		"after sync 2 <nil>",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hook calls = %q, want %q", calls, want)
+	}
+
+	// A failing hook fails the table and reaches AfterSync
+	calls = nil
+	failed := errors.New("refresh failed")
+	hooks.AfterTable = func(ctx context.Context, stats TableStats) error {
+		if stats.Table == "a" {
+			return failed
+		}
+		return nil
+	}
+	_, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Hooks: hooks, KeepGoing: true})
+	var tableErr *TableError
+	if !errors.Is(err, failed) || !errors.As(err, &tableErr) || tableErr.Table != "a" {
+		t.Errorf("Sync() error = %v, want the hook error of a", err)
+	}
+	if last := calls[len(calls)-1]; last != "after sync 1 syncing table a: refresh failed" {
+		t.Errorf("last hook call = %q, want AfterSync with the error", last)
+	}
+}
@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isHTTP reports whether a database path is an http or https URL.
+func isHTTP(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// httpCache describes a database downloaded over HTTP or from object
+// storage, or the part of it downloaded so far, in a JSON file next to it.
+type httpCache struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the value of an If-Range header resuming the download,
+// empty if it can't be resumed: If-Range takes a strong ETag or a date.
+func (c httpCache) validator() string {
+	if c.ETag != "" && !strings.HasPrefix(c.ETag, "W/") {
+		return c.ETag
+	}
+	return c.LastModified
+}
+
+// cacheDir returns Config.CacheDir, or its default.
+func (cfg Config) cacheDir() (string, error) {
+	if cfg.CacheDir != "" {
+		return cfg.CacheDir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rslite"), nil
+}
+
+// cachePath returns the path of the cached copy of the database at url,
+// creating the cache directory.
+func cachePath(cfg Config, url string) (string, error) {
+	dir, err := cfg.cacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".db"), nil
+}
+
+// fetchHTTP downloads the database at url into the cache directory, unless
+// the copy there is still current, and returns the path of the copy. A
+// download interrupted halfway is resumed by the next one when the server
+// supports range requests and the database didn't change in between.
+func fetchHTTP(ctx context.Context, cfg Config, url string) (string, error) {
+	path, err := cachePath(cfg, url)
+	if err != nil {
+		return "", err
+	}
+	part := path + ".part"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	var offset int64
+	cached, cachedErr := readHTTPCache(path, url)
+	partial, partialErr := readHTTPCache(part, url)
+	info, statErr := os.Stat(part)
+	switch {
+	case partialErr == nil && statErr == nil && partial.validator() != "":
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", partial.validator())
+	case cachedErr == nil:
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cachedErr != nil {
+			return "", fmt.Errorf("%s: not modified, but not in the cache", url)
+		}
+		return path, nil
+	case http.StatusPartialContent:
+		if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			return "", fmt.Errorf("%s: unexpected range %q", url, resp.Header.Get("Content-Range"))
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	case http.StatusOK:
+		// The whole database, changed since the partial download if any
+		partial = httpCache{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+		if err := writeJSONFile(part+".json", partial); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %w", url, err)
+	}
+	if err := os.Rename(part, path); err != nil {
+		return "", err
+	}
+	if err := writeJSONFile(path+".json", partial); err != nil {
+		return "", err
+	}
+	os.Remove(part + ".json")
+	return path, nil
+}
+
+// readHTTPCache reads the description of the download at path, failing when
+// it is missing or from another url.
+func readHTTPCache(path, url string) (httpCache, error) {
+	var c httpCache
+	if _, err := os.Stat(path); err != nil {
+		return c, err
+	}
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.URL != url {
+		return c, fmt.Errorf("cached download of %s, not %s", c.URL, url)
+	}
+	return c, nil
+}
+
+// rangeStart returns the first byte of a Content-Range header value.
+func rangeStart(contentRange string) (int64, bool) {
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &start, &end); err != nil {
+		return 0, false
+	}
+	return start, true
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSyncHTTP(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		},
+	}
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+	srcDB.Close()
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "src.db", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	url := server.URL + "/src.db"
+	cfg := Config{SrcDbPath: url, DstDbPath: tgtPath, CacheDir: t.TempDir()}
+
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("target has %d users, want 2", n)
+	}
+
+	// The cached copy is revalidated, not downloaded again
+	path, err := fetchHTTP(context.Background(), cfg, url)
+	if err != nil {
+		t.Fatalf("fetchHTTP() error = %v", err)
+	}
+	if got := headers[len(headers)-1].Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the cached ETag", got)
+	}
+
+	// An interrupted download is resumed
+	if err := os.WriteFile(path+".part", data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(path+".part.json", httpCache{URL: url, ETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchHTTP(context.Background(), cfg, url); err != nil {
+		t.Fatalf("fetchHTTP() resuming error = %v", err)
+	}
+	if got := headers[len(headers)-1].Get("Range"); got == "" {
+		t.Error("the download was not resumed with a range request")
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("resumed download differs from the source database (error %v)", err)
+	}
+
+	cfg.SrcDbPath, cfg.DstDbPath = tgtPath, url
+	if _, err := Sync(cfg); err == nil {
+		t.Error("HTTP target: error = nil")
+	}
+}
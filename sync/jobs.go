@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	gosync "sync"
+)
+
+// syncTablesParallel syncs up to cfg.Jobs tables at a time. A table is only
+// started once the tables it references through foreign keys are done; when
+// the references form a cycle the remaining tables are started in order.
+// The first error cancels the tables still running, unless Config.KeepGoing
+// is set: the tables referencing a failed one are still synced then.
+func syncTablesParallel(ctx context.Context, srcs []*sql.DB, dst *sql.DB, tables []Table, cfg Config, stats *Stats) error {
+	parents, err := foreignKeyParents(ctx, srcs[0], tables)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index int
+		stats TableStats
+		err   error
+	}
+	var (
+		lock     gosync.Mutex // target write lock
+		results  = make(chan result)
+		started  = make([]bool, len(tables))
+		done     = make(map[string]bool, len(tables))
+		finished = make([]*TableStats, len(tables))
+		running  int
+		firstErr error
+		errs     []error
+	)
+
+	ready := func(i int) bool {
+		for _, parent := range parents[tables[i].name] {
+			if !done[parent] {
+				return false
+			}
+		}
+		return true
+	}
+	start := func(i int) {
+		started[i] = true
+		running++
+		go func() {
+			tableStats, err := syncTableWithHooks(ctx, cfg, tables[i], func() (TableStats, error) {
+				return syncTable(ctx, srcs, dst, &lock, tables[i], cfg, nil)
+			})
+			if err != nil {
+				err = &TableError{Table: tables[i].name, Err: err}
+			}
+			results <- result{i, tableStats, err}
+		}()
+	}
+
+	for {
+		if firstErr == nil {
+			for i := range tables {
+				if running < cfg.Jobs && !started[i] && ready(i) {
+					start(i)
+				}
+			}
+			if running == 0 {
+				// Nothing runnable is left: either everything was started
+				// or the remaining tables reference each other.
+				for i := range tables {
+					if !started[i] {
+						start(i)
+						break
+					}
+				}
+			}
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil && cfg.KeepGoing && ctx.Err() == nil {
+			errs = append(errs, r.err)
+			done[tables[r.index].name] = true
+			continue
+		}
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+				cancel()
+			}
+			continue
+		}
+		done[tables[r.index].name] = true
+		finished[r.index] = &r.stats
+	}
+
+	// Report in the order the tables were listed, like a serial run
+	for _, s := range finished {
+		if s != nil {
+			stats.Tables = append(stats.Tables, *s)
+		}
+	}
+	if firstErr != nil {
+		errs = append(errs, firstErr)
+	}
+	return errors.Join(errs...)
+}
+
+// foreignKeyParents maps each table to the other tables of the list it
+// references through foreign keys.
+func foreignKeyParents(ctx context.Context, db *sql.DB, tables []Table) (map[string][]string, error) {
+	listed := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		listed[t.name] = true
+	}
+
+	parents := make(map[string][]string)
+	for _, t := range tables {
+		rows, err := db.QueryContext(ctx, `SELECT DISTINCT "table" FROM pragma_foreign_key_list(?)`, t.name)
+		if err != nil {
+			return nil, fmt.Errorf("reading foreign keys of %s: %w", t.name, err)
+		}
+		for rows.Next() {
+			var parent string
+			if err := rows.Scan(&parent); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("reading foreign keys of %s: %w", t.name, err)
+			}
+			if parent != t.name && listed[parent] {
+				parents[t.name] = append(parents[t.name], parent)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading foreign keys of %s: %w", t.name, err)
+		}
+	}
+	return parents, nil
+}
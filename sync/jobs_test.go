@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncParallel(t *testing.T) {
+	var tables []testTable
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("t%d", i)
+		schema := fmt.Sprintf(`CREATE TABLE %s (id INTEGER PRIMARY KEY, v TEXT)`, name)
+		if i > 0 {
+			// Each table references the previous one
+			schema = fmt.Sprintf(`CREATE TABLE %s (id INTEGER PRIMARY KEY, v TEXT REFERENCES t%d(id))`, name, i-1)
+		}
+		tables = append(tables, testTable{
+			name:    name,
+			schema:  schema,
+			srcData: [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}},
+			tgtData: [][]interface{}{{1, "old"}, {4, "d"}},
+		})
+	}
+	// t0 also references t5, closing a cycle
+	tables[0].schema = `CREATE TABLE t0 (id INTEGER PRIMARY KEY, v TEXT REFERENCES t5(id))`
+
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+
+	src := []Table{}
+	for _, tt := range tables {
+		table, err := getTableInfo(context.Background(), srcDB, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src = append(src, table)
+	}
+	parents, err := foreignKeyParents(context.Background(), srcDB, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"t1"}; !reflect.DeepEqual(parents["t2"], want) {
+		t.Errorf("parents of t2 = %v, want %v", parents["t2"], want)
+	}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Jobs: 3, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != len(tables) {
+		t.Fatalf("got stats for %d tables, want %d", len(stats.Tables), len(tables))
+	}
+	for i, ts := range stats.Tables {
+		if ts.Table != tables[i].name {
+			t.Errorf("stats[%d] is for %s, want %s", i, ts.Table, tables[i].name)
+		}
+		if ts.Inserted != 2 || ts.Replaced != 1 || ts.Deleted != 1 {
+			t.Errorf("unexpected stats %+v", ts)
+		}
+		got, err := getTableData(tgtDB, ts.Table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareData(got, tables[i].srcData) {
+			t.Errorf("table %s: got %v, want %v", ts.Table, got, tables[i].srcData)
+		}
+	}
+}
+
+func TestSyncKeepGoing(t *testing.T) {
+	for _, jobs := range []int{1, 2} {
+		srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+			{name: "a", schema: `CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)`, srcData: [][]interface{}{{1, "x"}}},
+			{name: "b", schema: `CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT)`, srcData: [][]interface{}{{1, nil}}},
+			{name: "c", schema: `CREATE TABLE c (id INTEGER PRIMARY KEY, name TEXT)`, srcData: [][]interface{}{{1, "z"}}},
+		})
+		if _, err := tgtDB.Exec(`DROP TABLE b; CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"a", "b", "c"}, KeepGoing: true, Jobs: jobs})
+		if err == nil || !strings.Contains(err.Error(), "syncing table b") {
+			t.Fatalf("jobs %d: Sync() error = %v, want table b failing", jobs, err)
+		}
+		if len(stats.Tables) != 2 {
+			t.Errorf("jobs %d: stats for %d tables, want 2", jobs, len(stats.Tables))
+		}
+		for _, table := range []string{"a", "c"} {
+			if n := countTestRows(t, tgtDB, table); n != 1 {
+				t.Errorf("jobs %d: table %s has %d rows, want 1", jobs, table, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Ways of matching the rows of the tables without a primary key, see
+// Config.KeylessMatch.
+const (
+	KeylessRowid   = "rowid"
+	KeylessColumns = "columns"
+)
+
+// Temporary tables of the writer's connection staging the source rows of a
+// table matched on its columns, and numbering the duplicate rows of both
+// sides.
+const (
+	stagedRows   = "temp.rslite_rows"
+	numberedRows = "temp.rslite_src"
+	numberedDst  = "temp.rslite_dst"
+)
+
+// keyless reports whether the table has no primary key, nor a Key option,
+// so that its rows are told apart by rowid only.
+func (t Table) keyless() bool {
+	return t.pkCol == "rowid"
+}
+
+// matchKeyless marks the keyless tables matched on all their columns, which
+// are synced whole.
+func matchKeyless(tables []Table, cfg Config) error {
+	if cfg.KeylessMatch != KeylessColumns {
+		return nil
+	}
+	for i, t := range tables {
+		if !t.keyless() {
+			continue
+		}
+		if where, _ := buildFilter(t, cfg); where != "" || cfg.StatePath != "" || cfg.RecordPath != "" || cfg.Tracked {
+			return fmt.Errorf("table %s has no primary key and is matched on its columns: it can't be filtered, incremental, tracked nor recorded", t.name)
+		}
+		tables[i].byContent = true
+	}
+	return nil
+}
+
+// stageRows creates the staging table the writer of a table matched on its
+// columns writes the source rows to, in place of the target.
+func (w *tableWriter) stageRows(ctx context.Context) error {
+	exec := w.conn.ExecContext
+	if w.shared != nil {
+		exec = w.shared.tx.ExecContext
+	}
+	for _, t := range []string{stagedRows, numberedRows, numberedDst} {
+		if _, err := exec(ctx, "DROP TABLE IF EXISTS "+t); err != nil {
+			return err
+		}
+	}
+	cols := quoteIdents(append([]string{"rslite_rid"}, w.table.columns...))
+	if _, err := exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s)", strings.TrimPrefix(stagedRows, "temp."), cols)); err != nil {
+		return fmt.Errorf("staging source rows: %w", err)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(w.table.columns)+1), ", ")
+	w.query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", stagedRows, cols, placeholders)
+	w.conflict = ""
+	return nil
+}
+
+// applyStaged turns the target table into the staged source rows: the
+// target rows without an identical source row are deleted, unless del is
+// false, and the source rows without an identical target row inserted. A
+// row held n times by one side matches the first n identical rows of the
+// other.
+func (w *tableWriter) applyStaged(ctx context.Context, del bool) error {
+	cols := quoteIdents(w.table.columns)
+	number := fmt.Sprintf("row_number() OVER (PARTITION BY %s) AS rslite_n", cols)
+	match := make([]string, 0, len(w.table.columns)+1)
+	for _, c := range w.table.columns {
+		match = append(match, fmt.Sprintf("s.%[1]s IS d.%[1]s", quoteIdent(c)))
+	}
+	match = append(match, "s.rslite_n = d.rslite_n")
+	same := strings.Join(match, " AND ")
+
+	for _, query := range []string{
+		fmt.Sprintf("CREATE TEMP TABLE %s AS SELECT %s, %s FROM %s", strings.TrimPrefix(numberedRows, "temp."), cols, number, stagedRows),
+		fmt.Sprintf("CREATE INDEX temp.rslite_src_row ON %s (%s, rslite_n)", strings.TrimPrefix(numberedRows, "temp."), cols),
+		fmt.Sprintf("CREATE TEMP TABLE %s AS SELECT rowid AS rslite_rid, %s, %s FROM %s", strings.TrimPrefix(numberedDst, "temp."), cols, number, quoteIdent(w.table.targetName())),
+		fmt.Sprintf("CREATE INDEX temp.rslite_dst_row ON %s (%s, rslite_n)", strings.TrimPrefix(numberedDst, "temp."), cols),
+	} {
+		if _, err := w.exec(ctx, query); err != nil {
+			return fmt.Errorf("comparing staged rows: %w", err)
+		}
+	}
+	if del {
+		query := fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rslite_rid FROM %s d WHERE NOT EXISTS (SELECT 1 FROM %s s WHERE %s))",
+			quoteIdent(w.table.targetName()), numberedDst, numberedRows, same)
+		if err := w.deleteKept(ctx, query); err != nil {
+			return err
+		}
+	}
+	res, err := w.exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s s WHERE NOT EXISTS (SELECT 1 FROM %s d WHERE %s)",
+		quoteIdent(w.table.targetName()), cols, cols, numberedRows, numberedDst, same))
+	if err != nil {
+		return fmt.Errorf("inserting staged rows: %w", err)
+	}
+	// Only the inserted rows were written to the target
+	if w.written, err = res.RowsAffected(); err != nil {
+		return err
+	}
+	for _, t := range []string{stagedRows, numberedRows, numberedDst} {
+		if _, err := w.exec(ctx, "DROP TABLE "+t); err != nil {
+			return fmt.Errorf("dropping staged rows: %w", err)
+		}
+	}
+	return nil
+}
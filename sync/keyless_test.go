@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSyncKeylessColumns(t *testing.T) {
+	// The target rows were written independently, under other rowids
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "logs",
+		schema:  `CREATE TABLE logs (level TEXT, msg TEXT)`,
+		srcData: [][]interface{}{{"info", "started"}, {"warn", "slow"}, {"warn", "slow"}, {"info", nil}},
+		tgtData: [][]interface{}{{"debug", "gone"}, {"info", "started"}, {"warn", "slow"}, {"info", nil}, {"info", nil}},
+	}})
+	if _, err := tgtDB.Exec(`UPDATE logs SET rowid = rowid + 100`); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, KeylessMatch: KeylessColumns}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	// The missing duplicate is inserted, the extra one and the gone row deleted
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 0 || got.Deleted != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	var got [][]interface{}
+	rows, err := tgtDB.Query(`SELECT level, msg FROM logs ORDER BY level, msg`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var level, msg interface{}
+		if err := rows.Scan(&level, &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []interface{}{level, msg})
+	}
+	want := [][]interface{}{{"info", nil}, {"info", "started"}, {"warn", "slow"}, {"warn", "slow"}}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Nothing left to do
+	if stats, err = Sync(cfg); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 0 || got.Deleted != 0 {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+
+	cfg.Where = "level = 'info'"
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("filtered keyless table: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestSyncKeylessRowidWarning(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{
+		{
+			name:    "logs",
+			schema:  `CREATE TABLE logs (msg TEXT)`,
+			srcData: [][]interface{}{{"started"}},
+		},
+		{
+			name:   "users",
+			schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		},
+	})
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Logger: logger}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "table=logs") || strings.Contains(logs.String(), "table=users") {
+		t.Errorf("want a warning for logs only, got:\n%s", logs.String())
+	}
+}
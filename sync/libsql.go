@@ -0,0 +1,533 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarolm/rslite/diff"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// The libsql:// databases are reached by default with hranaDriver, a
+// database/sql driver of the Hrana over HTTP protocol, version 2, rather
+// than one of the libSQL Go clients: go-libsql links a SQLite of its own
+// through cgo, next to the one of go-sqlite3, and libsql-client-go brings a
+// WebSocket stack for what a few JSON requests do. The driver covers what
+// syncs need: positional arguments, transactions kept on a stream by its
+// baton, and the base_url servers move streams to. Config.LibSQLDriver
+// swaps in another one, like the driver of libsql-client-go.
+
+// isLibSQL reports whether a database path is a libsql:// URL, of a libSQL
+// server or a Turso database.
+func isLibSQL(path string) bool {
+	return strings.HasPrefix(path, "libsql://")
+}
+
+// libSQLDSN returns a libsql:// URL with its authToken parameter set to
+// Config.LibSQLToken, unless it has one.
+func libSQLDSN(cfg Config, path string) (string, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%s: no host", path)
+	}
+	q := u.Query()
+	if q.Get("authToken") == "" && cfg.LibSQLToken != "" {
+		q.Set("authToken", cfg.LibSQLToken)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// libSQLEndpoint returns the HTTP endpoint and auth token of a libsql://
+// URL. The token is the authToken parameter, and the tls=0 parameter
+// selects plain HTTP, for local servers.
+func libSQLEndpoint(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	scheme := "https"
+	if u.Query().Get("tls") == "0" {
+		scheme = "http"
+	}
+	return scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/"), u.Query().Get("authToken"), nil
+}
+
+// openLibSQL opens the database of a libsql:// URL with the driver of
+// Config.LibSQLDriver, or hranaDriver.
+func openLibSQL(cfg Config, path string) (*sql.DB, error) {
+	dsn, err := libSQLDSN(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	return openDB(cfg.libSQLDriver(), dsn)
+}
+
+// hranaDriver opens libsql:// URLs over the Hrana over HTTP protocol of
+// libSQL servers. Every connection is a Hrana stream, keeping its
+// transaction and temporary tables between statements.
+type hranaDriver struct{}
+
+func (hranaDriver) Open(dsn string) (driver.Conn, error) {
+	endpoint, token, err := libSQLEndpoint(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &libSQLConn{base: endpoint, token: token}, nil
+}
+
+// libSQLConn is a Hrana stream. The baton returned by every response
+// identifies it in the next request.
+type libSQLConn struct {
+	base  string
+	token string
+	baton string
+}
+
+// hranaValue is a value of the Hrana protocol. Integers are sent as strings
+// to keep their 64 bits in JSON.
+type hranaValue struct {
+	Type   string          `json:"type"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Base64 string          `json:"base64,omitempty"`
+}
+
+type hranaStmt struct {
+	SQL      string       `json:"sql"`
+	Args     []hranaValue `json:"args,omitempty"`
+	WantRows bool         `json:"want_rows"`
+}
+
+type hranaRequest struct {
+	Type string     `json:"type"`
+	Stmt *hranaStmt `json:"stmt,omitempty"`
+}
+
+type hranaResult struct {
+	Cols []struct {
+		Name string `json:"name"`
+	} `json:"cols"`
+	Rows             [][]hranaValue `json:"rows"`
+	AffectedRowCount int64          `json:"affected_row_count"`
+	LastInsertRowid  *string        `json:"last_insert_rowid"`
+}
+
+type hranaPipelineResult struct {
+	Type     string `json:"type"`
+	Response *struct {
+		Result *hranaResult `json:"result"`
+	} `json:"response"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// pipeline sends requests on the stream of c, and returns their results.
+func (c *libSQLConn) pipeline(ctx context.Context, requests []hranaRequest) ([]hranaPipelineResult, error) {
+	body := struct {
+		Baton    *string        `json:"baton"`
+		Requests []hranaRequest `json:"requests"`
+	}{Requests: requests}
+	if c.baton != "" {
+		body.Baton = &c.baton
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/v2/pipeline", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// The stream is gone
+		c.baton = ""
+		return nil, fmt.Errorf("libsql: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		Baton   *string               `json:"baton"`
+		BaseURL *string               `json:"base_url"`
+		Results []hranaPipelineResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("libsql: reading response: %w", err)
+	}
+	c.baton = ""
+	if out.Baton != nil {
+		c.baton = *out.Baton
+	}
+	if out.BaseURL != nil && *out.BaseURL != "" {
+		c.base = strings.TrimSuffix(*out.BaseURL, "/")
+	}
+	if len(out.Results) != len(requests) {
+		return nil, fmt.Errorf("libsql: got %d results for %d requests", len(out.Results), len(requests))
+	}
+	return out.Results, nil
+}
+
+// execute runs a statement on the stream of c.
+func (c *libSQLConn) execute(ctx context.Context, query string, args []driver.NamedValue, wantRows bool) (*hranaResult, error) {
+	stmt := &hranaStmt{SQL: query, WantRows: wantRows}
+	for _, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("libsql: named parameters are not supported")
+		}
+		v, err := newHranaValue(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Args = append(stmt.Args, v)
+	}
+	results, err := c.pipeline(ctx, []hranaRequest{{Type: "execute", Stmt: stmt}})
+	if err != nil {
+		return nil, err
+	}
+	r := results[0]
+	if r.Type == "error" && r.Error != nil {
+		return nil, errors.New(r.Error.Message)
+	}
+	if r.Type != "ok" || r.Response == nil || r.Response.Result == nil {
+		return nil, fmt.Errorf("libsql: unexpected %s result", r.Type)
+	}
+	return r.Response.Result, nil
+}
+
+func (c *libSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.execute(ctx, query, args, false)
+	if err != nil {
+		return nil, err
+	}
+	result := libSQLResult{affected: res.AffectedRowCount}
+	if res.LastInsertRowid != nil {
+		result.lastID, _ = strconv.ParseInt(*res.LastInsertRowid, 10, 64)
+	}
+	return result, nil
+}
+
+func (c *libSQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.execute(ctx, query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	rows := &libSQLRows{rows: res.Rows}
+	for _, col := range res.Cols {
+		rows.cols = append(rows.cols, col.Name)
+	}
+	return rows, nil
+}
+
+func (c *libSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &libSQLStmt{conn: c, query: query}, nil
+}
+
+func (c *libSQLConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *libSQLConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.execute(ctx, "BEGIN", nil, false); err != nil {
+		return nil, err
+	}
+	return libSQLTx{conn: c}, nil
+}
+
+// Close closes the stream, if the server still keeps it.
+func (c *libSQLConn) Close() error {
+	if c.baton == "" {
+		return nil
+	}
+	_, err := c.pipeline(context.Background(), []hranaRequest{{Type: "close"}})
+	return err
+}
+
+type libSQLTx struct {
+	conn *libSQLConn
+}
+
+func (tx libSQLTx) Commit() error {
+	_, err := tx.conn.execute(context.Background(), "COMMIT", nil, false)
+	return err
+}
+
+func (tx libSQLTx) Rollback() error {
+	_, err := tx.conn.execute(context.Background(), "ROLLBACK", nil, false)
+	return err
+}
+
+// libSQLStmt runs its query at every execution, Hrana taking statements
+// whole.
+type libSQLStmt struct {
+	conn  *libSQLConn
+	query string
+}
+
+func (s *libSQLStmt) Close() error  { return nil }
+func (s *libSQLStmt) NumInput() int { return -1 }
+
+func (s *libSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *libSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *libSQLStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *libSQLStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+type libSQLResult struct {
+	lastID, affected int64
+}
+
+func (r libSQLResult) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r libSQLResult) RowsAffected() (int64, error) { return r.affected, nil }
+
+// libSQLRows holds the rows of a result, Hrana returning them at once.
+type libSQLRows struct {
+	cols []string
+	rows [][]hranaValue
+}
+
+func (r *libSQLRows) Columns() []string { return r.cols }
+func (r *libSQLRows) Close() error      { return nil }
+
+func (r *libSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	for i := range dest {
+		v, err := row[i].value()
+		if err != nil {
+			return err
+		}
+		dest[i] = v
+	}
+	return nil
+}
+
+func newHranaValue(v interface{}) (hranaValue, error) {
+	switch x := v.(type) {
+	case nil:
+		return hranaValue{Type: "null"}, nil
+	case int64:
+		return hranaValue{Type: "integer", Value: json.RawMessage(strconv.Quote(strconv.FormatInt(x, 10)))}, nil
+	case bool:
+		return newHranaValue(btoi64(x))
+	case float64:
+		data, err := json.Marshal(x)
+		return hranaValue{Type: "float", Value: data}, err
+	case string:
+		data, err := json.Marshal(x)
+		return hranaValue{Type: "text", Value: data}, err
+	case []byte:
+		return hranaValue{Type: "blob", Base64: base64.StdEncoding.EncodeToString(x)}, nil
+	case time.Time:
+		// As the SQLite driver writes it
+		return newHranaValue(x.Format(sqlite3.SQLiteTimestampFormats[0]))
+	default:
+		return hranaValue{}, fmt.Errorf("libsql: unsupported value %T", v)
+	}
+}
+
+func (v hranaValue) value() (driver.Value, error) {
+	switch v.Type {
+	case "null":
+		return nil, nil
+	case "integer":
+		var s string
+		if err := json.Unmarshal(v.Value, &s); err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		var f float64
+		err := json.Unmarshal(v.Value, &f)
+		return f, err
+	case "text":
+		var s string
+		err := json.Unmarshal(v.Value, &s)
+		return s, err
+	case "blob":
+		return base64.StdEncoding.DecodeString(v.Base64)
+	default:
+		return nil, fmt.Errorf("libsql: unknown value type %q", v.Type)
+	}
+}
+
+// downloadLibSQL copies the tables and indexes of the libSQL database at
+// path into a new database at local, preserving the rowids. The triggers are
+// left out: the rows they write are copied, and they would fire again on the
+// changes pushed back by pushLibSQL.
+func downloadLibSQL(ctx context.Context, cfg Config, path, local string) error {
+	remote, err := openLibSQL(cfg, path)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	tables, _, err := getTables(ctx, remote)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg.driver(), dsn(local, nil))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		var ddl string
+		if err := remote.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table.name).Scan(&ddl); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, ddl); err != nil {
+			return fmt.Errorf("creating table %s: %w", table.name, err)
+		}
+		cols := table.columns
+		if rowid := rowidAlias(table.columns); len(table.pageKey) == 1 && table.pageKey[0] == rowid {
+			cols = append([]string{rowid}, cols...)
+		}
+		stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(table.name),
+			quoteIdents(cols), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+		if err != nil {
+			return err
+		}
+		err = scanPages(ctx, remote, table, cols, "", nil, cfg.PageSize, func(values []interface{}) error {
+			_, err := stmt.ExecContext(ctx, values...)
+			return err
+		})
+		stmt.Close()
+		if err != nil {
+			return fmt.Errorf("copying table %s: %w", table.name, err)
+		}
+	}
+
+	rows, err := remote.QueryContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ddl string
+		if err := rows.Scan(&ddl); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, ddl); err != nil {
+			return fmt.Errorf("creating index: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// libSQLBase returns the path of the copy of a libSQL target kept as
+// downloaded, which pushLibSQL compares the synced copy with.
+func libSQLBase(local string) string {
+	return local + ".base"
+}
+
+// downloadLibSQLTarget downloads the libSQL target at path to local, and
+// keeps a copy for pushLibSQL.
+func downloadLibSQLTarget(ctx context.Context, cfg Config, path, local string) error {
+	if err := downloadLibSQL(ctx, cfg, path, local); err != nil {
+		return err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(libSQLBase(local), f)
+}
+
+// pushLibSQL writes the changes made to local since downloadLibSQLTarget to
+// the libSQL target at path, in a single transaction: the rows of the
+// tables that differ from the downloaded copy are inserted, updated or
+// deleted by key.
+func pushLibSQL(ctx context.Context, cfg Config, local, path string) error {
+	remote, err := openLibSQL(cfg, path)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	tx, err := remote.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	db, err := openReadOnly(cfg.driver(), local, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tables, _, err := getTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	specs := make(map[string]Table, len(tables))
+	for _, t := range tables {
+		specs[t.name] = t
+	}
+	compare := Config{SrcDbPath: local, DstDbPath: libSQLBase(local), Extensions: cfg.Extensions, ConnectHook: cfg.ConnectHook}
+	_, err = Diff(ctx, compare, func(row diff.Row) error {
+		var args []interface{}
+		query := changeStatement(row, specs[row.Table], func(v interface{}) string {
+			args = append(args, v)
+			return "?"
+		})
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
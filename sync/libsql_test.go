@@ -0,0 +1,253 @@
+package sync
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	gosync "sync"
+	"testing"
+)
+
+// fakeLibSQL is a Hrana over HTTP server backed by a local database, each
+// stream being a connection of it.
+type fakeLibSQL struct {
+	db      *sql.DB
+	token   string
+	mu      gosync.Mutex
+	streams map[string]*sql.Conn
+	next    int
+}
+
+func (s *fakeLibSQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v2/pipeline" || r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Baton    *string        `json:"baton"`
+		Requests []hranaRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var baton string
+	var conn *sql.Conn
+	if body.Baton != nil {
+		baton, conn = *body.Baton, s.streams[*body.Baton]
+		delete(s.streams, baton)
+		if conn == nil {
+			http.Error(w, "no such stream", http.StatusBadRequest)
+			return
+		}
+	} else {
+		var err error
+		if conn, err = s.db.Conn(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var results []interface{}
+	for _, req := range body.Requests {
+		if req.Type == "close" {
+			conn.Close()
+			conn = nil
+			results = append(results, map[string]interface{}{"type": "ok", "response": map[string]string{"type": "close"}})
+			continue
+		}
+		res, err := s.execute(r, conn, req.Stmt)
+		if err != nil {
+			results = append(results, map[string]interface{}{"type": "error", "error": map[string]string{"message": err.Error()}})
+			continue
+		}
+		results = append(results, map[string]interface{}{"type": "ok", "response": map[string]interface{}{"type": "execute", "result": res}})
+	}
+	out := map[string]interface{}{"baton": nil, "base_url": nil, "results": results}
+	if conn != nil {
+		s.next++
+		baton = fmt.Sprintf("baton-%d", s.next)
+		s.streams[baton] = conn
+		out["baton"] = baton
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *fakeLibSQL) execute(r *http.Request, conn *sql.Conn, stmt *hranaStmt) (*hranaResult, error) {
+	args := make([]interface{}, len(stmt.Args))
+	for i, a := range stmt.Args {
+		v, err := a.value()
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	res := &hranaResult{}
+	if !stmt.WantRows {
+		result, err := conn.ExecContext(r.Context(), stmt.SQL, args...)
+		if err != nil {
+			return nil, err
+		}
+		res.AffectedRowCount, _ = result.RowsAffected()
+		return res, nil
+	}
+	rows, err := conn.QueryContext(r.Context(), stmt.SQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	for _, c := range cols {
+		res.Cols = append(res.Cols, struct {
+			Name string `json:"name"`
+		}{c})
+	}
+	values, ptrs := scanValues(len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make([]hranaValue, len(values))
+		for i, v := range values {
+			if row[i], err = newHranaValue(v); err != nil {
+				return nil, err
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, rows.Err()
+}
+
+func TestSyncLibSQL(t *testing.T) {
+	schema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, avatar BLOB)`
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  schema,
+		srcData: [][]interface{}{{1, "Alice", []byte{1, 2}}, {2, "Bob", nil}},
+	}})
+	_, remotePath, _, remoteDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  schema,
+		tgtData: [][]interface{}{{2, "Robert", nil}, {3, "Carol", nil}},
+	}})
+	if _, err := remoteDB.Exec(`CREATE INDEX users_name ON users (name)`); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&fakeLibSQL{db: openTestDB(t, remotePath), token: "tok", streams: map[string]*sql.Conn{}})
+	defer server.Close()
+	url := "libsql://" + strings.TrimPrefix(server.URL, "http://") + "?tls=0"
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: url, LibSQLToken: "tok"})
+	if err != nil {
+		t.Fatalf("Sync() to libSQL error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 1 || got.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	want := [][]interface{}{{1, "Alice", []byte{1, 2}}, {2, "Bob", nil}}
+	got, err := getTableData(remoteDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("libSQL target: got %v, want %v", got, want)
+	}
+
+	// And back, with the token in the URL
+	if _, err := Sync(Config{SrcDbPath: url + "&authToken=tok", DstDbPath: tgtPath}); err != nil {
+		t.Fatalf("Sync() from libSQL error = %v", err)
+	}
+	if got, err = getTableData(tgtDB, "users"); err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("local target: got %v, want %v", got, want)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, LibSQLToken: "wrong"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrong token: error = %v, want a 401", err)
+	}
+
+	// Through the driver of the config, given the token in the URL
+	var mu gosync.Mutex
+	var dsns []string
+	cfg := Config{SrcDbPath: url, DstDbPath: tgtPath, LibSQLToken: "tok", LibSQLDriver: driverFunc(func(dsn string) (driver.Conn, error) {
+		mu.Lock()
+		dsns = append(dsns, dsn)
+		mu.Unlock()
+		return hranaDriver{}.Open(dsn)
+	})}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() with another driver error = %v", err)
+	}
+	if len(dsns) == 0 || !strings.Contains(dsns[0], "authToken=tok") {
+		t.Errorf("the driver of the config opened %q", dsns)
+	}
+}
+
+type driverFunc func(dsn string) (driver.Conn, error)
+
+func (f driverFunc) Open(dsn string) (driver.Conn, error) { return f(dsn) }
+
+// TestSyncSqld syncs with the libSQL server at RSLITE_SQLD_URL, like
+// libsql://127.0.0.1:8080?tls=0 for a local sqld, which CI runs.
+func TestSyncSqld(t *testing.T) {
+	url := os.Getenv("RSLITE_SQLD_URL")
+	if url == "" {
+		t.Skip("RSLITE_SQLD_URL is not set")
+	}
+	remote, err := openLibSQL(Config{}, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	schema := `CREATE TABLE rslite_sqld (id INTEGER PRIMARY KEY, name TEXT, avatar BLOB, score REAL)`
+	for _, stmt := range []string{
+		`DROP TABLE IF EXISTS rslite_sqld`,
+		schema,
+		`INSERT INTO rslite_sqld VALUES (2, 'Robert', NULL, NULL), (3, 'Carol', NULL, NULL)`,
+	} {
+		if _, err := remote.Exec(stmt); err != nil {
+			t.Fatalf("preparing sqld: %v", err)
+		}
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "rslite_sqld",
+		schema:  schema,
+		srcData: [][]interface{}{{1, "Alice", []byte{1, 2}, 1.5}, {2, "Bob", nil, nil}},
+	}})
+	tables := []string{"rslite_sqld"}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: url, Tables: tables})
+	if err != nil {
+		t.Fatalf("Sync() to sqld error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 1 || got.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	want := [][]interface{}{{1, "Alice", []byte{1, 2}, 1.5}, {2, "Bob", nil, nil}}
+	got, err := getTableData(remote, "rslite_sqld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("sqld target: got %v, want %v", got, want)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, Tables: tables}); err != nil {
+		t.Fatalf("Sync() from sqld error = %v", err)
+	}
+	if got, err = getTableData(tgtDB, "rslite_sqld"); err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("local target: got %v, want %v", got, want)
+	}
+}
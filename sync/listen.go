@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// Listen returns the listener of a server address: host:port for TCP, the
+// IPv6 hosts in brackets like [::1]:8080, unix:PATH for a unix socket, so
+// that a local reverse proxy can front the server, or systemd for the
+// socket passed by systemd socket activation, systemd:NAME picking the
+// socket of that FileDescriptorName when the unit passes several.
+//
+// A unix socket nothing listens on anymore, left by a process that
+// crashed, is replaced.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return nil, errors.New("unix socket address without a path")
+		}
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	if addr == "systemd" || strings.HasPrefix(addr, "systemd:") {
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// removeStaleSocket removes the unix socket at path unless a server still
+// answers on it. Other files are left for the listener to fail on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server listens on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// listenFdsStart is the first file descriptor passed by systemd.
+var listenFdsStart = 3
+
+// systemdSockets holds the sockets passed by systemd, read from the
+// environment once since it is cleared for the commands the process runs.
+var systemdSockets struct {
+	once  gosync.Once
+	mu    gosync.Mutex
+	files []*os.File // nil once used
+	names []string
+	err   error
+}
+
+// systemdListener returns a listener on the socket systemd passed under
+// name, or the first one not used yet when name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	s := &systemdSockets
+	s.once.Do(func() {
+		s.files, s.names, s.err = systemdFiles()
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.files {
+		if f == nil || (name != "" && s.names[i] != name) {
+			continue
+		}
+		s.files[i] = nil
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %w", s.names[i], err)
+		}
+		return ln, nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("no systemd socket %s left to listen on", name)
+	}
+	return nil, errors.New("no systemd socket left to listen on")
+}
+
+// systemdFiles returns the sockets passed by systemd to the process and
+// their names, following sd_listen_fds(3).
+func systemdFiles() ([]*os.File, []string, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	listed := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil, errors.New("no sockets passed by systemd: the process isn't socket activated")
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	files, names := make([]*os.File, n), make([]string, n)
+	for i := range files {
+		// The name systemd gives the sockets without one
+		names[i] = "unknown"
+		if i < len(listed) && listed[i] != "" {
+			names[i] = listed[i]
+		}
+		files[i] = os.NewFile(uintptr(listenFdsStart+i), names[i])
+	}
+	return files, names, nil
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	srcPath, _, _, _ := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	// A short directory, unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "rslite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "s.sock")
+
+	// Left by a crashed server
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- server.ListenAndServe(ctx, "unix:"+sock, "", "") }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; ; i++ {
+		if resp, err = client.Get("http://localhost/v1/tables"); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("the server never answered on the socket: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var tables []serverTable
+	json.NewDecoder(resp.Body).Decode(&tables)
+	resp.Body.Close()
+	if len(tables) != 1 || tables[0].Name != "users" {
+		t.Errorf("tables served on the socket %+v", tables)
+	}
+
+	if _, err := Listen("unix:" + sock); err == nil || !strings.Contains(err.Error(), "another server") {
+		t.Errorf("Listen() on a socket in use error = %v", err)
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("ListenAndServe() error = %v", err)
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A descriptor no os.File owns, passed as systemd does
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(start int) { listenFdsStart = start }(listenFdsStart)
+	listenFdsStart = fd
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "api")
+
+	if _, err := Listen("systemd:metrics"); err == nil {
+		t.Error("Listen() of a socket systemd didn't pass succeeded")
+	}
+	got, err := Listen("systemd:api")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer got.Close()
+	if got.Addr().String() != ln.Addr().String() {
+		t.Errorf("listening on %s, want the passed socket %s", got.Addr(), ln.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("the environment still passes the sockets to child processes")
+	}
+	if _, err := Listen("systemd"); err == nil {
+		t.Error("Listen() of a used socket succeeded")
+	}
+}
@@ -0,0 +1,34 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// discardLogger is used when Config.Logger is nil.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+
+// logger returns Config.Logger, or a logger discarding everything.
+func (cfg Config) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return discardLogger
+	}
+	return cfg.Logger
+}
+
+// logTable logs the outcome of a table sync.
+func logTable(ctx context.Context, log *slog.Logger, stats TableStats, start time.Time, err error) {
+	if err != nil {
+		log.ErrorContext(ctx, "table failed", "duration", time.Since(start), "error", err)
+		return
+	}
+	log.InfoContext(ctx, "table finished",
+		"inserted", stats.Inserted,
+		"replaced", stats.Replaced,
+		"deleted", stats.Deleted,
+		"skipped", stats.Skipped,
+		"flagged", stats.Flagged,
+		"duration", stats.Duration)
+}
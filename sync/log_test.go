@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSyncLogger(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{3, "Carol"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+	var buf bytes.Buffer
+	cfg := Config{
+		SrcDbPath: srcPath,
+		DstDbPath: tgtPath,
+		Logger:    slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	for _, want := range []string{
+		`msg="sync started"`,
+		`msg="table started" table=users`,
+		`msg="statement executed" table=users query="DELETE FROM`,
+		`msg="table finished" table=users inserted=2 replaced=0 deleted=1`,
+		`msg="sync finished" tables=1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log lacks %s:\n%s", want, buf.String())
+		}
+	}
+}
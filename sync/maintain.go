@@ -0,0 +1,32 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// maintainTarget runs the upkeep of the target asked for by Config.Analyze
+// and Vacuum once the rows are synced: ANALYZE of the synced tables, then
+// VACUUM of the whole database.
+func maintainTarget(ctx context.Context, dst *sql.DB, tables []Table, cfg Config) error {
+	log := cfg.logger()
+	if cfg.Analyze {
+		start := time.Now()
+		for _, t := range tables {
+			if _, err := dst.ExecContext(ctx, "ANALYZE "+quoteIdent(t.targetName())); err != nil {
+				return fmt.Errorf("analyzing table %s: %w", t.targetName(), err)
+			}
+		}
+		log.InfoContext(ctx, "target analyzed", "tables", len(tables), "duration", time.Since(start))
+	}
+	if cfg.Vacuum {
+		start := time.Now()
+		if _, err := dst.ExecContext(ctx, "VACUUM"); err != nil {
+			return fmt.Errorf("vacuuming target: %w", err)
+		}
+		log.InfoContext(ctx, "target vacuumed", "duration", time.Since(start))
+	}
+	return nil
+}
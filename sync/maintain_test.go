@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSyncVacuumAnalyze(t *testing.T) {
+	var tgtRows [][]interface{}
+	for i := 1; i <= 2000; i++ {
+		tgtRows = append(tgtRows, []interface{}{i, strings.Repeat("x", 200)})
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+		tgtData: tgtRows,
+	}})
+	if _, err := tgtDB.Exec(`CREATE INDEX users_name ON users (name)`); err != nil {
+		t.Fatal(err)
+	}
+	pages := func() (n int64) {
+		t.Helper()
+		if err := tgtDB.QueryRow(`PRAGMA page_count`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	before := pages()
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Analyze: true, Vacuum: true}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if after := pages(); after*10 > before {
+		t.Errorf("target holds %d pages, %d before, want it vacuumed", after, before)
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT count(*) FROM sqlite_stat1 WHERE tbl = 'users'`).Scan(&n); err != nil || n == 0 {
+		t.Errorf("users not analyzed: %v", err)
+	}
+}
@@ -0,0 +1,523 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// jobHistoryRuns is the number of past runs a JobManager keeps in memory
+// for each job.
+const jobHistoryRuns = 50
+
+// jobLogLines is the number of log records a JobManager keeps for each job,
+// sent first to the clients following its logs.
+const jobLogLines = 200
+
+// JobManager runs scheduled jobs like RunScheduledJobs, and serves an HTTP
+// API managing them while they run:
+//
+//	GET    /v1/jobs                the status of every job
+//	POST   /v1/jobs                create a job from a JobSpec with a name
+//	GET    /v1/jobs/{name}         the status of a job
+//	DELETE /v1/jobs/{name}         stop a job and forget it
+//	POST   /v1/jobs/{name}/run     run a job now, besides its schedule
+//	GET    /v1/jobs/{name}/history the reports of its last runs, latest first
+//	GET    /v1/jobs/{name}/logs    its log records, as JSON lines
+//
+// The history of a job comes from its target when it records its runs, see
+// Config.History, and from memory otherwise. Its logs are followed until
+// the client disconnects, unless the follow parameter is false; a client
+// too slow to read them misses records. Clients must send the token of
+// the JobManager as a bearer token when it is not empty.
+type JobManager struct {
+	token string
+	log   *slog.Logger
+	fn    func(job string, stats *Stats, err error)
+	mux   *http.ServeMux
+
+	mu   gosync.Mutex
+	ctx  context.Context // of Run, nil before
+	jobs map[string]*managedJob
+	wg   gosync.WaitGroup
+}
+
+// managedJob is a job run by a JobManager.
+type managedJob struct {
+	ScheduledJob
+	cancel  context.CancelFunc
+	trigger chan struct{}
+	done    chan struct{} // closed once the job stopped
+	logs    *logStream
+
+	// Guarded by the mutex of the JobManager
+	running bool
+	started time.Time // of the current run
+	next    time.Time
+	runs    int64
+	history []Run // latest last
+	err     error // that stopped the job
+}
+
+// JobStatus is the state of a job of a JobManager.
+type JobStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Source   string     `json:"source"`
+	Target   string     `json:"target"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	Runs     int64      `json:"runs"`
+	LastRun  *Report    `json:"last_run,omitempty"`
+	Error    string     `json:"error,omitempty"` // that stopped the job
+}
+
+// NewJobManager returns a JobManager without jobs, serving its API with
+// token. The jobs without a logger log to log, with their name. fn is
+// called with the outcome of every run, concurrently for different jobs.
+func NewJobManager(log *slog.Logger, token string, fn func(job string, stats *Stats, err error)) *JobManager {
+	if log == nil {
+		log = discardLogger
+	}
+	m := &JobManager{token: token, log: log, fn: fn, jobs: make(map[string]*managedJob)}
+	m.mux = http.NewServeMux()
+	m.mux.HandleFunc("GET /v1/jobs", m.serveJobs)
+	m.mux.HandleFunc("POST /v1/jobs", m.serveCreate)
+	m.mux.HandleFunc("GET /v1/jobs/{name}", m.serveJob)
+	m.mux.HandleFunc("DELETE /v1/jobs/{name}", m.serveDelete)
+	m.mux.HandleFunc("POST /v1/jobs/{name}/run", m.serveRun)
+	m.mux.HandleFunc("GET /v1/jobs/{name}/history", m.serveHistory)
+	m.mux.HandleFunc("GET /v1/jobs/{name}/logs", m.serveLogs)
+	return m
+}
+
+// Run starts jobs, then runs them and those created through the API until
+// ctx is done. It fails if one of jobs can't be started, stopping the
+// others. A job failing later, like a Watch, is stopped alone, its error
+// logged and kept in its status.
+func (m *JobManager) Run(ctx context.Context, jobs []ScheduledJob) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer m.wg.Wait()
+	defer cancel()
+
+	m.mu.Lock()
+	m.ctx = ctx
+	for _, job := range jobs {
+		if err := m.start(job); err != nil {
+			m.mu.Unlock()
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+	}
+	m.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+// errJobExists is returned when starting a job with the name of another.
+var errJobExists = errors.New("a job with this name exists")
+
+// start checks and starts a job, with the mutex held.
+func (m *JobManager) start(job ScheduledJob) error {
+	if _, ok := m.jobs[job.Name]; ok {
+		return errJobExists
+	}
+	sched, err := cron.ParseStandard(job.Schedule)
+	if err != nil {
+		return classify(ErrInvalidConfig, fmt.Errorf("invalid schedule %q: %w", job.Schedule, err))
+	}
+	if err := job.Config.validate(); err != nil {
+		return classify(ErrInvalidConfig, err)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &managedJob{
+		ScheduledJob: job,
+		cancel:       cancel,
+		trigger:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		logs:         newLogStream(jobLogLines),
+		next:         sched.Next(time.Now()),
+	}
+	log := job.Config.Logger
+	if log == nil {
+		log = m.log.With("job", job.Name)
+	}
+	j.Config.Logger = slog.New(teeHandler{log.Handler(), slog.NewJSONHandler(j.logs, nil)})
+	m.jobs[job.Name] = j
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(j.done)
+		err := watch(ctx, j.Config, false, func(stats *Stats, err error) {
+			m.finish(j, stats, err)
+		}, func(ctx context.Context) error {
+			return m.wait(ctx, j, sched)
+		})
+		m.mu.Lock()
+		j.running, j.next, j.err = false, time.Time{}, err
+		m.mu.Unlock()
+		if err != nil {
+			j.Config.logger().Error("job stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// wait waits for the next scheduled run of j, or one triggered through the
+// API, before watch runs it.
+func (m *JobManager) wait(ctx context.Context, j *managedJob, sched cron.Schedule) error {
+	next := sched.Next(time.Now())
+	m.mu.Lock()
+	j.next = next
+	m.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	case <-j.trigger:
+	}
+	m.mu.Lock()
+	j.running, j.started, j.next = true, time.Now(), time.Time{}
+	m.mu.Unlock()
+	return nil
+}
+
+// finish records the outcome of a run of j.
+func (m *JobManager) finish(j *managedJob, stats *Stats, err error) {
+	m.mu.Lock()
+	j.running = false
+	j.runs++
+	j.history = append(j.history, Run{ID: j.runs, Report: *newReport(j.Config, j.started, stats, err)})
+	if len(j.history) > jobHistoryRuns {
+		j.history = slices.Delete(j.history, 0, len(j.history)-jobHistoryRuns)
+	}
+	m.mu.Unlock()
+	if m.fn != nil {
+		m.fn(j.Name, stats, err)
+	}
+}
+
+// status returns the JobStatus of j, with the mutex held.
+func (j *managedJob) status() JobStatus {
+	s := JobStatus{
+		Name:     j.Name,
+		Schedule: j.Schedule,
+		Source:   j.Config.SrcDbPath,
+		Target:   j.Config.DstDbPath,
+		Running:  j.running,
+		Runs:     j.runs,
+	}
+	if !j.next.IsZero() {
+		next := j.next
+		s.NextRun = &next
+	}
+	if len(j.history) > 0 {
+		last := j.history[len(j.history)-1].Report
+		s.LastRun = &last
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	return s
+}
+
+func (m *JobManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.token != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	} else if !localRequest(r) {
+		http.Error(w, "requests from other sites need a token", http.StatusForbidden)
+		return
+	}
+	m.mux.ServeHTTP(w, r)
+}
+
+// job returns the job named in the path of r, or answers with 404.
+func (m *JobManager) job(w http.ResponseWriter, r *http.Request) (*managedJob, bool) {
+	m.mu.Lock()
+	j, ok := m.jobs[r.PathValue("name")]
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+	}
+	return j, ok
+}
+
+// serveJobs lists the status of every job, sorted by name.
+func (m *JobManager) serveJobs(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	list := make([]JobStatus, 0, len(m.jobs))
+	for _, name := range sortedKeys(m.jobs) {
+		list = append(list, m.jobs[name].status())
+	}
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveCreate starts a job from a JobSpec with a name.
+func (m *JobManager) serveCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		JobSpec
+	}
+	// Unlike a form or text/plain, which a web page can send anywhere
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		http.Error(w, "the job must be sent as application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "the job needs a name", http.StatusBadRequest)
+		return
+	}
+	job, err := req.JobSpec.Job(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx == nil || m.ctx.Err() != nil {
+		http.Error(w, "the jobs are not running", http.StatusServiceUnavailable)
+		return
+	}
+	switch err := m.start(job); {
+	case errors.Is(err, errJobExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		m.log.Info("job created", "job", job.Name)
+		writeJSON(w, http.StatusCreated, m.jobs[job.Name].status())
+	}
+}
+
+// serveJob answers with the status of a job.
+func (m *JobManager) serveJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.job(w, r)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	status := j.status()
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+// serveDelete stops a job, once its current run is done, and forgets it.
+func (m *JobManager) serveDelete(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.job(w, r)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	if m.jobs[j.Name] == j {
+		delete(m.jobs, j.Name)
+	}
+	m.mu.Unlock()
+	j.cancel()
+	<-j.done
+	m.log.Info("job deleted", "job", j.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRun makes a job run now, or right after its current run.
+func (m *JobManager) serveRun(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.job(w, r)
+	if !ok {
+		return
+	}
+	select {
+	case <-j.done:
+		http.Error(w, "the job stopped", http.StatusConflict)
+		return
+	default:
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// A run is already pending
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveHistory answers with the last runs of a job, latest first, at most
+// the number of the limit parameter when positive.
+func (m *JobManager) serveHistory(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.job(w, r)
+	if !ok {
+		return
+	}
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if j.Config.History {
+		runs, err := History(r.Context(), j.Config, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if runs == nil {
+			runs = []Run{}
+		}
+		writeJSON(w, http.StatusOK, runs)
+		return
+	}
+	m.mu.Lock()
+	runs := slices.Clone(j.history)
+	m.mu.Unlock()
+	slices.Reverse(runs)
+	if limit > 0 && limit < len(runs) {
+		runs = runs[:limit]
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// serveLogs streams the log records of a job.
+func (m *JobManager) serveLogs(w http.ResponseWriter, r *http.Request) {
+	j, ok := m.job(w, r)
+	if !ok {
+		return
+	}
+	follow := true
+	if s := r.URL.Query().Get("follow"); s != "" {
+		var err error
+		if follow, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, "invalid follow", http.StatusBadRequest)
+			return
+		}
+	}
+	backlog, lines, unsubscribe := j.logs.subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, line := range backlog {
+		w.Write(line)
+	}
+	if !follow {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-j.done:
+			return
+		case line := <-lines:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// logStream keeps the last log records written to it, one per Write as
+// slog handlers do, and passes the new ones to its subscribers.
+type logStream struct {
+	mu    gosync.Mutex
+	max   int
+	lines [][]byte
+	subs  map[chan []byte]bool
+}
+
+func newLogStream(max int) *logStream {
+	return &logStream{max: max, subs: make(map[chan []byte]bool)}
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	line := bytes.Clone(p)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, line)
+	if len(s.lines) > s.max {
+		s.lines = slices.Delete(s.lines, 0, len(s.lines)-s.max)
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- line:
+		default:
+			// Logging doesn't wait for slow readers
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe returns the records kept so far, and the channel receiving the
+// next ones until unsubscribe is called.
+func (s *logStream) subscribe() (backlog [][]byte, lines <-chan []byte, unsubscribe func()) {
+	ch := make(chan []byte, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[ch] = true
+	return slices.Clone(s.lines), ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// teeHandler passes the log records to two handlers.
+type teeHandler struct {
+	a, b slog.Handler
+}
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.a.Enabled(ctx, level) || h.b.Enabled(ctx, level)
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range []slog.Handler{h.a, h.b} {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{h.a.WithAttrs(attrs), h.b.WithAttrs(attrs)}
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{h.a.WithGroup(name), h.b.WithGroup(name)}
+}
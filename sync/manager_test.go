@@ -0,0 +1,191 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobManager(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m := NewJobManager(nil, "secret", nil)
+	server := httptest.NewServer(m)
+	defer server.Close()
+	// Once a year, so that the jobs only run when triggered
+	jobs := []ScheduledJob{{Name: "replica", Schedule: "0 0 1 1 *", Config: Config{SrcDbPath: srcPath, DstDbPath: tgtPath}}}
+	runErr := make(chan error, 1)
+	go func() { runErr <- m.Run(ctx, jobs) }()
+
+	call := func(method, path, body string, v interface{}) int {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode/100 == 2 {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+	// waitRuns waits for a job to have run n times.
+	waitRuns := func(name string, n int64) JobStatus {
+		t.Helper()
+		for {
+			var status JobStatus
+			if code := call("GET", "/v1/jobs/"+name, "", &status); code != http.StatusOK {
+				t.Fatalf("GET job %s = %d", name, code)
+			}
+			if status.Runs >= n {
+				return status
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("job %s ran %d times, want %d", name, status.Runs, n)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	// The job file jobs start with Run
+	for {
+		var list []JobStatus
+		if call("GET", "/v1/jobs", "", &list); len(list) == 1 {
+			if list[0].Name != "replica" || list[0].Running || list[0].Runs != 0 {
+				t.Errorf("unexpected jobs %+v", list)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := call("POST", "/v1/jobs/replica/run", "", nil); code != http.StatusAccepted {
+		t.Errorf("triggering a run = %d, want 202", code)
+	}
+	status := waitRuns("replica", 1)
+	if status.LastRun == nil || !status.LastRun.Success || status.NextRun == nil {
+		t.Errorf("unexpected status after a run %+v", status)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("users has %d rows, want Alice synced", n)
+	}
+
+	// Jobs created through the API
+	spec := `{"name": "other", "source": "` + srcPath + `", "target": "` + otherPath + `", "schedule": "0 0 1 1 *", "history": true}`
+	if code := call("POST", "/v1/jobs", spec, &status); code != http.StatusCreated || status.Name != "other" {
+		t.Fatalf("creating a job = %d, %+v", code, status)
+	}
+	if code := call("POST", "/v1/jobs", spec, nil); code != http.StatusConflict {
+		t.Errorf("creating it again = %d, want 409", code)
+	}
+	if code := call("POST", "/v1/jobs", `{"name": "bad", "source": "a.db", "target": "b.db", "schedule": "often"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("creating a job with an invalid schedule = %d, want 400", code)
+	}
+	// As a web page can send, without preflight
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/v1/jobs", strings.NewReader(`{"name": "plain", "source": "a.db", "target": "b.db", "schedule": "0 0 1 1 *"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("creating a job sent as text/plain = %d, want 415", resp.StatusCode)
+	}
+	for i := 0; i < 2; i++ {
+		call("POST", "/v1/jobs/other/run", "", nil)
+		waitRuns("other", int64(i+1))
+	}
+	var runs []Run
+	if code := call("GET", "/v1/jobs/other/history?limit=1", "", &runs); code != http.StatusOK || len(runs) != 1 || !runs[0].Success {
+		t.Errorf("history of other = %d, %+v", code, runs)
+	}
+	// Recorded in its target
+	if got, err := History(ctx, Config{DstDbPath: otherPath}, 0); err != nil || len(got) != 2 {
+		t.Errorf("target history = %d runs, %v, want 2", len(got), err)
+	}
+	if code := call("GET", "/v1/jobs/replica/history", "", &runs); code != http.StatusOK || len(runs) != 1 || runs[0].ID != 1 {
+		t.Errorf("history of replica = %d, %+v", code, runs)
+	}
+
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL+"/v1/jobs/other/logs?follow=false", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Count(string(logs), `"msg":"sync finished"`) != 2 {
+		t.Errorf("logs of other lack its runs:\n%s", logs)
+	}
+
+	if code := call("DELETE", "/v1/jobs/other", "", nil); code != http.StatusNoContent {
+		t.Errorf("deleting a job = %d, want 204", code)
+	}
+	if code := call("GET", "/v1/jobs/other", "", nil); code != http.StatusNotFound {
+		t.Errorf("deleted job = %d, want 404", code)
+	}
+
+	resp, err = http.Get(server.URL + "/v1/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without the token = %d, want 401", resp.StatusCode)
+	}
+
+	cancel()
+	if err := <-runErr; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestJobManagerWithoutToken(t *testing.T) {
+	m := NewJobManager(nil, "", nil)
+	for _, tc := range []struct {
+		name, host, origin string
+		want               int
+	}{
+		{"a local client", "127.0.0.1:8081", "", http.StatusOK},
+		{"a page of the API", "localhost:8081", "http://localhost:8081", http.StatusOK},
+		{"a page of another site", "127.0.0.1:8081", "https://example.com", http.StatusForbidden},
+		{"a host name rebound to a loopback address", "example.com:8081", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/v1/jobs", nil)
+		req.Host = tc.host
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("request of %s = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
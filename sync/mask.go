@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Masking rules of TableOptions.Mask.
+const (
+	MaskNull  = "null"  // write NULL
+	MaskEmpty = "empty" // write an empty string, keeping NULLs
+	// MaskHash writes the hex SHA-256 of the value, keeping NULLs. Equal
+	// values get equal hashes across tables and runs, so joins still work,
+	// but the hash is unsalted: values from a small set can be guessed.
+	MaskHash = "hash"
+)
+
+// checkMask fails on an unknown masking rule.
+func checkMask(rule string) error {
+	switch rule {
+	case MaskNull, MaskEmpty, MaskHash:
+		return nil
+	}
+	return fmt.Errorf("unknown masking rule %q: want %s, %s or %s", rule, MaskNull, MaskEmpty, MaskHash)
+}
+
+// maskRow applies the masking rules of a table, by column name, to a row
+// laid out as the key column followed by table.columns.
+func maskRow(table Table, values []interface{}, masks map[string]string) {
+	for column, rule := range masks {
+		for i, c := range table.columns {
+			if strings.EqualFold(c, column) {
+				values[i+1] = maskValue(rule, values[i+1])
+			}
+		}
+	}
+}
+
+func maskValue(rule string, v interface{}) interface{} {
+	if rule == MaskNull || v == nil {
+		return nil
+	}
+	if rule == MaskEmpty {
+		return ""
+	}
+	var data []byte
+	switch x := v.(type) {
+	case []byte:
+		data = x
+	case string:
+		data = []byte(x)
+	default:
+		data = []byte(fmt.Sprint(x))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTableOptionsMask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	data := `tables:
+  users:
+    mask:
+      name: empty
+mask:
+  users.email: hash
+  users.ssn: null
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTableOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]TableOptions{"users": {Mask: map[string]string{"name": MaskEmpty, "email": MaskHash, "ssn": MaskNull}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadTableOptions() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSyncMask(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, ssn TEXT, note TEXT)`,
+		srcData: [][]interface{}{{1, "Alice", "alice@example.com", "123-45-6789", nil}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	masks := map[string]string{"Name": MaskEmpty, "email": MaskHash, "ssn": MaskNull, "note": MaskHash}
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, TableOptions: map[string]TableOptions{"users": {Mask: masks}}}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{1, "", "ff8d9819fc0e12bf0d24892e45987e249a28dce836a85cad60e28eaaa8c6d976", nil, nil}}
+	if !compareData(got, want) {
+		t.Errorf("users = %v, want %v", got, want)
+	}
+
+	for name, mask := range map[string]map[string]string{
+		"unknown rule": {"email": "scramble"},
+		"key column":   {"id": MaskNull},
+		"no column":    {"phone": MaskNull},
+	} {
+		cfg.TableOptions = map[string]TableOptions{"users": {Mask: mask}}
+		if _, err := Sync(cfg); err == nil {
+			t.Errorf("%s: Sync() error = nil, want the mask rejected", name)
+		}
+	}
+}
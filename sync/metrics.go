@@ -0,0 +1,213 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// metricBuckets are the upper bounds, in seconds, of the buckets of the
+// duration histograms of Metrics.
+var metricBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+// Metrics collects the outcome of the runs of long-running syncs, like
+// Watch and RunScheduledJobs, by job and table, and serves it at /metrics
+// in the Prometheus text exposition format. A Metrics is safe for
+// concurrent use.
+type Metrics struct {
+	mu   gosync.Mutex
+	jobs map[string]*jobMetrics
+}
+
+// jobMetrics are the Metrics of a job.
+type jobMetrics struct {
+	runs        int64
+	failures    int64
+	lastSuccess time.Time
+	duration    histogram
+	tables      map[string]*tableMetrics
+}
+
+// tableMetrics are the Metrics of a table of a job.
+type tableMetrics struct {
+	inserted    int64
+	updated     int64
+	deleted     int64
+	failures    int64
+	lastSuccess time.Time
+	duration    histogram
+}
+
+// histogram counts observations in the metricBuckets.
+type histogram struct {
+	counts []int64 // by bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(metricBuckets))
+	}
+	s := d.Seconds()
+	if i, _ := slices.BinarySearch(metricBuckets, s); i < len(metricBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += s
+}
+
+// NewMetrics returns a Metrics without any run.
+func NewMetrics() *Metrics {
+	return &Metrics{jobs: make(map[string]*jobMetrics)}
+}
+
+// Observe records a run of job, with the stats and error the sync returned.
+func (m *Metrics) Observe(job string, stats *Stats, err error) {
+	if stats == nil {
+		stats = &Stats{}
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.jobs[job]
+	if j == nil {
+		j = &jobMetrics{tables: make(map[string]*tableMetrics)}
+		m.jobs[job] = j
+	}
+	table := func(name string) *tableMetrics {
+		t := j.tables[name]
+		if t == nil {
+			t = &tableMetrics{}
+			j.tables[name] = t
+		}
+		return t
+	}
+
+	j.runs++
+	j.duration.observe(stats.Duration)
+	if err != nil {
+		j.failures++
+	} else {
+		j.lastSuccess = now
+	}
+	for _, ts := range stats.Tables {
+		t := table(ts.Table)
+		t.inserted += ts.Inserted
+		t.updated += ts.Replaced
+		t.deleted += ts.Deleted
+		t.lastSuccess = now
+		t.duration.observe(ts.Duration)
+	}
+	for _, e := range splitErrors(err) {
+		var te *TableError
+		if errors.As(e, &te) {
+			table(te.Table).failures++
+		}
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes the metrics in the Prometheus text exposition format, by
+// job and table name.
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := sortedKeys(m.jobs)
+
+	family := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	sample := func(name, labels string, v float64) {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	histo := func(name, labels string, h histogram) {
+		var cumulative int64
+		for i, bound := range metricBuckets {
+			if h.counts != nil {
+				cumulative += h.counts[i]
+			}
+			sample(name+"_bucket", labels+`,le="`+strconv.FormatFloat(bound, 'g', -1, 64)+`"`, float64(cumulative))
+		}
+		sample(name+"_bucket", labels+`,le="+Inf"`, float64(h.count))
+		sample(name+"_sum", labels, h.sum)
+		sample(name+"_count", labels, float64(h.count))
+	}
+	// Each family over the jobs, then over their tables
+	perJob := func(f func(labels string, j *jobMetrics)) {
+		for _, name := range jobs {
+			f(label("sync_job", name), m.jobs[name])
+		}
+	}
+	perTable := func(f func(labels string, t *tableMetrics)) {
+		for _, job := range jobs {
+			tables := m.jobs[job].tables
+			for _, name := range sortedKeys(tables) {
+				f(label("sync_job", job)+","+label("table", name), tables[name])
+			}
+		}
+	}
+
+	family("rslite_runs_total", "counter", "Sync runs.")
+	perJob(func(l string, j *jobMetrics) { sample("rslite_runs_total", l, float64(j.runs)) })
+	family("rslite_run_failures_total", "counter", "Sync runs that failed.")
+	perJob(func(l string, j *jobMetrics) { sample("rslite_run_failures_total", l, float64(j.failures)) })
+	family("rslite_run_duration_seconds", "histogram", "Duration of the sync runs.")
+	perJob(func(l string, j *jobMetrics) { histo("rslite_run_duration_seconds", l, j.duration) })
+	family("rslite_last_success_timestamp_seconds", "gauge", "Unix time of the last successful run.")
+	perJob(func(l string, j *jobMetrics) {
+		if !j.lastSuccess.IsZero() {
+			sample("rslite_last_success_timestamp_seconds", l, unixSeconds(j.lastSuccess))
+		}
+	})
+
+	family("rslite_rows_written_total", "counter", "Rows inserted or updated in the target.")
+	perTable(func(l string, t *tableMetrics) {
+		sample("rslite_rows_written_total", l+`,op="insert"`, float64(t.inserted))
+		sample("rslite_rows_written_total", l+`,op="update"`, float64(t.updated))
+	})
+	family("rslite_rows_deleted_total", "counter", "Rows deleted from the target.")
+	perTable(func(l string, t *tableMetrics) { sample("rslite_rows_deleted_total", l, float64(t.deleted)) })
+	family("rslite_table_failures_total", "counter", "Table syncs that failed.")
+	perTable(func(l string, t *tableMetrics) { sample("rslite_table_failures_total", l, float64(t.failures)) })
+	family("rslite_table_duration_seconds", "histogram", "Duration of the successful table syncs.")
+	perTable(func(l string, t *tableMetrics) { histo("rslite_table_duration_seconds", l, t.duration) })
+	family("rslite_table_last_success_timestamp_seconds", "gauge", "Unix time of the last successful sync of the table.")
+	perTable(func(l string, t *tableMetrics) {
+		if !t.lastSuccess.IsZero() {
+			sample("rslite_table_last_success_timestamp_seconds", l, unixSeconds(t.lastSuccess))
+		}
+	})
+}
+
+// label returns a Prometheus label pair, its value escaped.
+func label(name, value string) string {
+	return name + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	srcPath, tgtPath, srcDB, _ := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+	}})
+	m := NewMetrics()
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	m.Observe("replica", stats, err)
+	if _, err := srcDB.Exec(`DELETE FROM users WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	m.Observe("replica", stats, err)
+	m.Observe("archive", &Stats{Duration: 2 * time.Second}, errors.Join(&TableError{Table: "orders", Err: errors.New("locked")}))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`rslite_runs_total{sync_job="archive"} 1`,
+		`rslite_runs_total{sync_job="replica"} 2`,
+		`rslite_run_failures_total{sync_job="archive"} 1`,
+		`rslite_run_failures_total{sync_job="replica"} 0`,
+		`rslite_run_duration_seconds_bucket{sync_job="archive",le="1"} 0`,
+		`rslite_run_duration_seconds_bucket{sync_job="archive",le="5"} 1`,
+		`rslite_run_duration_seconds_count{sync_job="replica"} 2`,
+		`rslite_last_success_timestamp_seconds{sync_job="replica"} `,
+		`rslite_rows_written_total{sync_job="replica",table="users",op="insert"} 2`,
+		`rslite_rows_deleted_total{sync_job="replica",table="users"} 1`,
+		`rslite_table_failures_total{sync_job="archive",table="orders"} 1`,
+		`# TYPE rslite_table_duration_seconds histogram`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), `rslite_last_success_timestamp_seconds{sync_job="archive"}`) {
+		t.Error("a job that never succeeded has a last success time")
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 404 {
+		t.Errorf("GET / = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,207 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// MigrationStats counts the changes made to the target schema, see
+// Config.MigrateSchema.
+type MigrationStats struct {
+	Columns    int64 // added to the target tables
+	Indexes    int64 // created on the target tables
+	Backfilled int64 // target rows whose added columns were filled
+}
+
+// migrateSchema adds to the target tables the synced columns and the indexes
+// of the source they lack, in one transaction, and backfills the added
+// columns. Virtual tables and those missing from the target are left alone.
+func migrateSchema(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config, stats *MigrationStats) error {
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	log := cfg.logger()
+
+	for _, table := range tables {
+		if table.module != "" {
+			continue
+		}
+		have, err := targetColumns(ctx, tx, table.targetName())
+		if err != nil {
+			return fmt.Errorf("reading target table %s: %w", table.targetName(), err)
+		}
+		if len(have) == 0 {
+			continue
+		}
+		var added []string
+		for _, c := range table.schema {
+			if !containsFold(table.rowColumns(), c.name) || containsFold(have, c.name) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdent(table.targetName()), addedColumn(c))); err != nil {
+				return fmt.Errorf("adding column %s to %s: %w", c.name, table.targetName(), err)
+			}
+			log.InfoContext(ctx, "column added", "table", table.targetName(), "column", c.name)
+			stats.Columns++
+			added = append(added, c.name)
+		}
+		if len(added) == 0 {
+			continue
+		}
+		n, err := backfill(ctx, src, tx, table, added, cfg)
+		if err != nil {
+			return fmt.Errorf("backfilling %s: %w", table.targetName(), err)
+		}
+		if n > 0 {
+			log.InfoContext(ctx, "columns backfilled", "table", table.targetName(), "rows", n)
+		}
+		stats.Backfilled += n
+	}
+
+	want, err := listObjects(ctx, src, tables, cfg)
+	if err != nil {
+		return fmt.Errorf("listing source indexes: %w", err)
+	}
+	have, err := listObjects(ctx, tx, tables, cfg)
+	if err != nil {
+		return fmt.Errorf("listing target indexes: %w", err)
+	}
+	for _, o := range want {
+		if o.typ != "index" || containsObject(have, o.name) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, o.ddl); err != nil {
+			return fmt.Errorf("creating index %s: %w", o.name, err)
+		}
+		log.InfoContext(ctx, "index created", "table", o.table, "index", o.name)
+		stats.Indexes++
+	}
+	return tx.Commit()
+}
+
+// backfill fills the columns just added to a target table in its existing
+// rows, with the TableOptions.Backfill expressions and then, with
+// Config.Backfill, the values of the source rows with the same key. It
+// returns the number of rows filled.
+func backfill(ctx context.Context, src *sql.DB, tx *sql.Tx, table Table, added []string, cfg Config) (int64, error) {
+	opts := cfg.TableOptions[table.name]
+	target := quoteIdent(table.targetName())
+	var filled int64
+	var sets []string
+	for _, c := range added {
+		for column, expr := range opts.Backfill {
+			if strings.EqualFold(column, c) {
+				sets = append(sets, fmt.Sprintf("%s = (%s)", quoteIdent(c), expr))
+			}
+		}
+	}
+	if len(sets) > 0 {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s", target, strings.Join(sets, ", ")))
+		if err != nil {
+			return 0, err
+		}
+		// Every row, which the source values then only overwrite
+		if filled, err = res.RowsAffected(); err != nil {
+			return 0, err
+		}
+	}
+	if !cfg.Backfill || table.keyless() {
+		return filled, nil
+	}
+
+	sets, conds := sets[:0], make([]string, len(table.keyCols))
+	columns := make([]string, 0, len(table.keyCols)+len(added))
+	for i, k := range table.keyCols {
+		conds[i] = quoteIdent(k) + " = ?"
+		columns = append(columns, quoteIdent(k))
+	}
+	for _, c := range added {
+		sets = append(sets, quoteIdent(c)+" = ?")
+		columns = append(columns, quoteIdent(c))
+	}
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE %s", target, strings.Join(sets, ", "), strings.Join(conds, " AND ")))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	rows, err := src.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), quoteIdent(table.name)))
+	if err != nil {
+		return 0, fmt.Errorf("reading source table %s: %w", table.name, err)
+	}
+	defer rows.Close()
+	masks := opts.Mask
+	var updated int64
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		keys, vals := values[:len(table.keyCols)], values[len(table.keyCols):]
+		for i, c := range added {
+			for column, rule := range masks {
+				if strings.EqualFold(column, c) {
+					vals[i] = maskValue(rule, vals[i])
+				}
+			}
+		}
+		res, err := stmt.ExecContext(ctx, append(vals, keys...)...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		updated += n
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return max(filled, updated), nil
+}
+
+// targetColumns returns the names of the columns of a table, hidden ones
+// included, or none when db lacks it.
+func targetColumns(ctx context.Context, db queryer, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_xinfo(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// addedColumn returns the definition of c in ALTER TABLE ADD COLUMN. The
+// column can't have a default value that isn't constant there, so it is
+// added without it and then without its NOT NULL constraint, which needs
+// one: the synced rows still hold the source values.
+func addedColumn(c columnDef) string {
+	def := quoteIdent(c.name)
+	if c.typ != "" {
+		def += " " + c.typ
+	}
+	dflt := strings.ToUpper(strings.TrimSpace(c.dflt.String))
+	if !c.dflt.Valid || dflt == "NULL" || strings.HasPrefix(dflt, "(") || strings.HasPrefix(dflt, "CURRENT_") {
+		return def
+	}
+	if c.notNull {
+		def += " NOT NULL"
+	}
+	return def + " DEFAULT " + c.dflt.String
+}
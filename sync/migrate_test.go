@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncMigrateSchema(t *testing.T) {
+	dir := t.TempDir()
+	srcPath, tgtPath := filepath.Join(dir, "src.db"), filepath.Join(dir, "tgt.db")
+	srcDB, err := createTestDB(srcPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT NOT NULL DEFAULT '', score INT, seen TEXT DEFAULT CURRENT_TIMESTAMP)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDB.Close()
+	if _, err := srcDB.Exec(`CREATE INDEX users_email ON users (email)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := insertTestData(srcDB, "users", [][]interface{}{{1, "Alice", "alice@example.com", 3, "2024-01-01"}}); err != nil {
+		t.Fatal(err)
+	}
+	// An older version of the schema, with types of the same affinity
+	tgtDB, err := createTestDB(tgtPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name VARCHAR(20), score INTEGER)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tgtDB.Close()
+	if err := insertTestData(tgtDB, "users", [][]interface{}{{2, "Bob", 5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, NoDelete: true}); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("Sync() without MigrateSchema error = %v, want ErrSchemaMismatch", err)
+	}
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, NoDelete: true, MigrateSchema: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Migration; got.Columns != 2 || got.Indexes != 1 {
+		t.Errorf("unexpected migration stats %+v", got)
+	}
+	var email, seen string
+	if err := tgtDB.QueryRow(`SELECT email, seen FROM users WHERE id = 1`).Scan(&email, &seen); err != nil {
+		t.Fatal(err)
+	}
+	if email != "alice@example.com" || seen != "2024-01-01" {
+		t.Errorf("synced row holds %q, %q", email, seen)
+	}
+	// The target row gets the default of the added column
+	if err := tgtDB.QueryRow(`SELECT email FROM users WHERE id = 2`).Scan(&email); err != nil || email != "" {
+		t.Errorf("target row email = %q, %v, want the default", email, err)
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'users_email'`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("index not created in the target: %v", err)
+	}
+
+	// Nothing left to migrate
+	if stats, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, NoDelete: true, MigrateSchema: true}); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Migration; got.Columns != 0 || got.Indexes != 0 {
+		t.Errorf("unexpected second run migration stats %+v", got)
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	for typ, want := range map[string]string{
+		"INTEGER": "INTEGER", "bigint": "INTEGER", "VARCHAR(20)": "TEXT", "CLOB": "TEXT",
+		"": "BLOB", "DOUBLE PRECISION": "REAL", "DECIMAL(10,5)": "NUMERIC", "BOOLEAN": "NUMERIC",
+	} {
+		if got := affinity(typ); got != want {
+			t.Errorf("affinity(%q) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestSyncMigrateBackfill(t *testing.T) {
+	dir := t.TempDir()
+	srcPath, tgtPath := filepath.Join(dir, "src.db"), filepath.Join(dir, "tgt.db")
+	srcDB, err := createTestDB(srcPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, region TEXT, email TEXT)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDB.Close()
+	if err := insertTestData(srcDB, "users", [][]interface{}{{1, "Alice", "us", "alice@example.com"}, {3, "Carol", "eu", "carol@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	tgtDB, err := createTestDB(tgtPath, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tgtDB.Close()
+	if err := insertTestData(tgtDB, "users", [][]interface{}{{1, "Alice"}, {2, "Bob"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		SrcDbPath: srcPath,
+		DstDbPath: tgtPath,
+		NoDelete:  true,
+		Backfill:  true,
+		// Alice is left out of the sync, only the backfill fills her row
+		TableWhere: map[string]string{"users": "id > 1"},
+		TableOptions: map[string]TableOptions{"users": {
+			Mask:     map[string]string{"email": MaskEmpty},
+			Backfill: map[string]string{"region": "'n/a'", "email": "lower(name) || '@local'"},
+		}},
+	}
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "migrated") {
+		t.Fatalf("Sync() backfilling without MigrateSchema error = %v", err)
+	}
+	cfg.MigrateSchema = true
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Migration; got.Columns != 2 || got.Backfilled != 2 {
+		t.Errorf("unexpected migration stats %+v", got)
+	}
+	want := [][]interface{}{
+		{int64(1), "Alice", "us", ""}, // masked
+		{int64(2), "Bob", "n/a", "bob@local"},
+		{int64(3), "Carol", "eu", ""},
+	}
+	// A new connection, as SELECT * lists the columns of the cached schema
+	got, err := getTableData(openTestDB(t, tgtPath), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("target rows %v, want %v", got, want)
+	}
+
+	bad := cfg
+	bad.TableOptions = map[string]TableOptions{"users": {Backfill: map[string]string{"missing": "1"}}}
+	if _, err := Sync(bad); err == nil || !strings.Contains(err.Error(), "no synced column missing") {
+		t.Errorf("Sync() backfilling a missing column error = %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// dropExtraTables drops the target tables the source lacks, see Config.Mirror,
+// in one transaction, and returns their names. The tables renamed in the
+// target, the shadow tables of virtual tables, those of rslite and those
+// matching Config.Protect are kept.
+func dropExtraTables(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config) ([]string, error) {
+	keep := make(map[string]bool)
+	names, err := tableNames(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("listing source tables: %w", err)
+	}
+	for _, name := range names {
+		keep[strings.ToLower(name)] = true
+	}
+	for _, t := range tables {
+		keep[strings.ToLower(t.targetName())] = true
+	}
+	shadows, err := shadowTables(ctx, dst)
+	if err != nil {
+		return nil, fmt.Errorf("listing target shadow tables: %w", err)
+	}
+	if names, err = tableNames(ctx, dst); err != nil {
+		return nil, fmt.Errorf("listing target tables: %w", err)
+	}
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Tables referencing each other are dropped in any order
+	if _, err := tx.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON"); err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, name := range names {
+		switch {
+		case keep[strings.ToLower(name)], shadows[name], name == changeLog, name == historyTable, name == appliedTable,
+			name == sequenceTable, !cfg.selects(name), matchTable(cfg.Protect, name):
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DROP TABLE "+quoteIdent(name)); err != nil {
+			return nil, fmt.Errorf("dropping table %s: %w", name, err)
+		}
+		cfg.logger().InfoContext(ctx, "table dropped", "table", name)
+		dropped = append(dropped, name)
+	}
+	return dropped, tx.Commit()
+}
+
+// tableNames returns the names of the tables of db, in creation order.
+func tableNames(ctx context.Context, db queryer) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
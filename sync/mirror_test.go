@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncMirror(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	for _, stmt := range []string{
+		`CREATE TABLE old_users (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users_archive (id))`,
+		`CREATE TABLE users_archive (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE local_notes (id INTEGER PRIMARY KEY, note TEXT)`,
+		`CREATE VIRTUAL TABLE docs USING fts4 (body)`,
+	} {
+		if _, err := tgtDB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tableCount := func() int {
+		t.Helper()
+		var n int
+		if err := tgtDB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	before := tableCount()
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Mirror: true, Protect: []string{"local_*"}})
+	if err != nil {
+		t.Fatalf("Sync() with Mirror error = %v", err)
+	}
+	if got := stats.DroppedTables; len(got) != 3 || got[0] != "old_users" || got[1] != "users_archive" || got[2] != "docs" {
+		t.Errorf("DroppedTables = %v, want old_users, users_archive and docs", got)
+	}
+	// docs went with its shadow tables
+	if n := tableCount(); n != 2 || before < 7 {
+		t.Errorf("target holds %d tables, %d before, want users and local_notes", n, before)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("users holds %d rows, want 1", n)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Mirror: true, NoDelete: true}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("mirroring without deletes: error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds each notification of a run, which is still sent
+// when the run was interrupted.
+const notifyTimeout = 30 * time.Second
+
+// notify sends the Report of a run to Config.NotifyURL and NotifyCmd.
+func notify(ctx context.Context, cfg Config, r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+
+	var errs []error
+	if cfg.NotifyURL != "" {
+		if err := notifyURL(ctx, cfg.NotifyURL, data); err != nil {
+			errs = append(errs, fmt.Errorf("notifying %s: %w", cfg.NotifyURL, err))
+		}
+	}
+	if cfg.NotifyCmd != "" {
+		if err := notifyCmd(ctx, cfg.NotifyCmd, r, data); err != nil {
+			errs = append(errs, fmt.Errorf("running notify command: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifyURL posts a JSON report to url.
+func notifyURL(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyCmd runs command with the shell, the JSON report on its standard
+// input and the outcome of the run in its environment.
+func notifyCmd(ctx context.Context, command string, r *Report, data []byte) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"RSLITE_SUCCESS="+strconv.FormatBool(r.Success),
+		"RSLITE_SOURCE="+r.Source,
+		"RSLITE_TARGET="+r.Target,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
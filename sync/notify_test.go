@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncNotify(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	var posted []Report
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("decoding the notification: %v", err)
+		}
+		posted = append(posted, report)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	out := filepath.Join(t.TempDir(), "notified")
+
+	cfg := Config{
+		SrcDbPath: srcPath,
+		DstDbPath: tgtPath,
+		NotifyURL: server.URL,
+		NotifyCmd: `printf '%s ' "$RSLITE_SUCCESS" >> ` + out + ` && cat >> ` + out,
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	failing := cfg
+	failing.Where = "missing = 1"
+	if _, err := Sync(failing); err == nil {
+		t.Fatal("Sync() with an invalid condition succeeded")
+	}
+
+	if len(posted) != 2 || !posted[0].Success || posted[1].Success || len(posted[1].Errors) == 0 {
+		t.Errorf("posted reports %+v, want a success then a failure", posted)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.HasPrefix(got, `true {"source":`) || !strings.Contains(got, `false {"source":`) {
+		t.Errorf("the command was given %q", got)
+	}
+
+	status = http.StatusBadGateway
+	cfg.NotifyCmd = ""
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Sync() with a failing notification error = %v, want the status", err)
+	}
+}
@@ -0,0 +1,250 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Server-side encryption of the targets uploaded to object storage, see
+// Config.ObjectEncryption.
+const (
+	EncryptionAES256 = "AES256"
+	EncryptionKMS    = "aws:kms"
+)
+
+// gcsEndpoint is the S3-compatible endpoint of Cloud Storage, reached for
+// gs:// URLs.
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// objectPartSize is the size of the parts of multipart uploads. Smaller
+// targets are uploaded with a single request.
+var objectPartSize int64 = 16 << 20
+
+// objectURL splits an s3:// or gs:// URL into its scheme, bucket and key.
+func objectURL(path string) (scheme, bucket, key string, ok bool) {
+	scheme, rest, ok := strings.Cut(path, "://")
+	if !ok || (scheme != "s3" && scheme != "gs") {
+		return "", "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", "", false
+	}
+	return scheme, bucket, key, true
+}
+
+// isObject reports whether a database path is an object storage URL.
+func isObject(path string) bool {
+	_, _, _, ok := objectURL(path)
+	return ok
+}
+
+// objectClient returns the client of the object store of scheme. The
+// credentials and region come from the AWS environment variables, config
+// files or instance role.
+func (cfg Config) objectClient(ctx context.Context, scheme string) (*s3.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := cfg.S3Endpoint
+	if scheme == "gs" {
+		endpoint = gcsEndpoint
+		awsCfg.Region = "auto"
+	}
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint == "" {
+			return
+		}
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = scheme == "s3"
+		if o.Region == "" {
+			o.Region = "us-east-1"
+		}
+		// Other stores often reject the checksums sent by default
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}), nil
+}
+
+// fetchObject downloads the source database at url into the cache
+// directory, unless the copy there still has the ETag of the object, and
+// returns the path of the copy.
+func fetchObject(ctx context.Context, cfg Config, url string) (string, error) {
+	path, err := cachePath(cfg, url)
+	if err != nil {
+		return "", err
+	}
+	cached, cachedErr := readHTTPCache(path, url)
+	scheme, bucket, key, _ := objectURL(url)
+	client, err := cfg.objectClient(ctx, scheme)
+	if err != nil {
+		return "", err
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if cachedErr == nil && cached.ETag != "" {
+		input.IfNoneMatch = aws.String(cached.ETag)
+	}
+	out, err := client.GetObject(ctx, input)
+	if httpStatus(err) == http.StatusNotModified {
+		return path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+
+	part := path + ".part"
+	if err := writeFile(part, out.Body); err != nil {
+		return "", fmt.Errorf("downloading %s: %w", url, err)
+	}
+	if err := os.Rename(part, path); err != nil {
+		return "", err
+	}
+	if err := writeJSONFile(path+".json", httpCache{URL: url, ETag: aws.ToString(out.ETag)}); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// downloadObject downloads the target at url to local, and reports whether
+// it exists. Nothing is left at local when it doesn't.
+func downloadObject(ctx context.Context, cfg Config, url, local string) (bool, error) {
+	scheme, bucket, key, _ := objectURL(url)
+	client, err := cfg.objectClient(ctx, scheme)
+	if err != nil {
+		return false, err
+	}
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) || httpStatus(err) == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer out.Body.Close()
+	if err := writeFile(local, out.Body); err != nil {
+		return false, fmt.Errorf("downloading %s: %w", url, err)
+	}
+	return true, nil
+}
+
+// uploadObject uploads local to url, in parts of objectPartSize when it is
+// larger, with the server-side encryption of cfg. The object is only
+// replaced once the upload is complete.
+func uploadObject(ctx context.Context, cfg Config, local, url string) error {
+	scheme, bucket, key, _ := objectURL(url)
+	client, err := cfg.objectClient(ctx, scheme)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	sse := types.ServerSideEncryption(cfg.ObjectEncryption)
+	var kmsKey *string
+	if cfg.ObjectKMSKey != "" {
+		kmsKey = aws.String(cfg.ObjectKMSKey)
+	}
+
+	if info.Size() <= objectPartSize {
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			Body:                 f,
+			ContentLength:        aws.Int64(info.Size()),
+			ServerSideEncryption: sse,
+			SSEKMSKeyId:          kmsKey,
+		})
+		return err
+	}
+
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKey,
+	})
+	if err != nil {
+		return err
+	}
+	var parts []types.CompletedPart
+	for offset, n := int64(0), int32(1); offset < info.Size(); offset, n = offset+objectPartSize, n+1 {
+		size := min(objectPartSize, info.Size()-offset)
+		out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int32(n),
+			Body:          io.NewSectionReader(f, offset, size),
+			ContentLength: aws.Int64(size),
+		})
+		if err != nil {
+			abortUpload(client, bucket, key, upload.UploadId)
+			return fmt.Errorf("uploading part %d: %w", n, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(n)})
+	}
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abortUpload(client, bucket, key, upload.UploadId)
+	}
+	return err
+}
+
+// abortUpload drops the parts of a failed multipart upload, which would be
+// billed otherwise. It doesn't take a context: the upload has to be aborted
+// even when the run was cancelled.
+func abortUpload(client *s3.Client, bucket, key string, id *string) {
+	client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: id,
+	})
+}
+
+// httpStatus returns the HTTP status of the response an object storage
+// request failed with, 0 if none.
+func httpStatus(err error) int {
+	var resp *awshttp.ResponseError
+	if errors.As(err, &resp) {
+		return resp.HTTPStatusCode()
+	}
+	return 0
+}
+
+// writeFile writes the content of r to a new file at path.
+func writeFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory S3 store, path-style, for the requests rslite
+// makes.
+type fakeS3 struct {
+	mu      gosync.Mutex
+	objects map[string][]byte
+	parts   map[int][]byte
+	sse     []string // server-side encryption of the uploads
+	get     []string // If-None-Match of the downloads
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, q := r.URL.Path, r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodGet:
+		s.get = append(s.get, r.Header.Get("If-None-Match"))
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, md5.Sum(data))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.sse = append(s.sse, r.Header.Get("X-Amz-Server-Side-Encryption"))
+		s.parts = make(map[int][]byte)
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		s.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		var numbers []int
+		for n := range s.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, s.parts[n]...)
+		}
+		s.objects[key] = data
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodPut:
+		s.sse = append(s.sse, r.Header.Get("X-Amz-Server-Side-Encryption"))
+		s.objects[key] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestSyncObjects(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		},
+	}
+	srcPath, tgtPath, srcDB, _ := setupTestDBs(t, tables)
+	srcDB.Close()
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeS3{objects: map[string][]byte{"/bucket/src.db": data}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	cfg := Config{
+		SrcDbPath:        "s3://bucket/src.db",
+		DstDbPath:        "s3://bucket/backup/app.db",
+		S3Endpoint:       server.URL,
+		CacheDir:         t.TempDir(),
+		ObjectEncryption: EncryptionAES256,
+	}
+
+	// The missing target is created and uploaded
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	uploaded := store.objects["/bucket/backup/app.db"]
+	if len(uploaded) == 0 {
+		t.Fatal("the target was not uploaded")
+	}
+	if len(store.sse) != 1 || store.sse[0] != EncryptionAES256 {
+		t.Errorf("server-side encryption = %v, want %s", store.sse, EncryptionAES256)
+	}
+	check := func() {
+		t.Helper()
+		path := tgtPath + ".downloaded"
+		if err := os.WriteFile(path, store.objects["/bucket/backup/app.db"], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if n := countTestRows(t, openTestDB(t, path), "users"); n != 2 {
+			t.Errorf("uploaded target has %d users, want 2", n)
+		}
+	}
+	check()
+
+	// The cached source is revalidated, and large targets uploaded in parts
+	defer func(size int64) { objectPartSize = size }(objectPartSize)
+	objectPartSize = int64(len(uploaded)/2 + 1)
+	store.sse, store.get = nil, nil
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() again error = %v", err)
+	}
+	if !slices.Contains(store.get, fmt.Sprintf(`"%x"`, md5.Sum(data))) {
+		t.Errorf("source downloads sent If-None-Match %q, want its ETag", store.get)
+	}
+	if len(store.parts) != 2 {
+		t.Errorf("target uploaded in %d parts, want 2", len(store.parts))
+	}
+	check()
+	if !bytes.Equal(store.objects["/bucket/src.db"], data) {
+		t.Error("the source object was modified")
+	}
+
+	cfg.SrcDbPath = "s3://bucket/missing.db"
+	if _, err := Sync(cfg); !errors.Is(err, ErrOpenSource) {
+		t.Errorf("missing source: error = %v, want ErrOpenSource", err)
+	}
+	cfg.ObjectEncryption = "rot13"
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("unknown encryption: error = %v, want ErrInvalidConfig", err)
+	}
+}
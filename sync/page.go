@@ -0,0 +1,204 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// defaultPageSize is the number of rows read per query when Config.PageSize
+// is not set.
+const defaultPageSize = 1000
+
+// pageKeyFor returns the columns that uniquely order the rows of a table for
+// keyset pagination: the rowid for ordinary tables, and the whole primary key
+// for WITHOUT ROWID tables. pkCols holds the primary key columns in key order.
+func pageKeyFor(ctx context.Context, db *sql.DB, table Table, pkCols []string) ([]string, error) {
+	rowid := rowidAlias(table.columns)
+	if rowid == "" {
+		// Every alias is shadowed by a real column, only the key is left
+		return pkCols, nil
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT 0", rowid, quoteIdent(table.name)))
+	if err == nil {
+		rows.Close()
+		return []string{rowid}, nil
+	}
+	if len(pkCols) == 0 || !strings.Contains(err.Error(), "no such column") {
+		return nil, fmt.Errorf("detecting page key: %w", err)
+	}
+	// WITHOUT ROWID table
+	return pkCols, nil
+}
+
+// rowidAlias returns a name for the rowid not shadowed by a declared column.
+func rowidAlias(columns []string) string {
+	for _, alias := range []string{"rowid", "_rowid_", "oid"} {
+		shadowed := false
+		for _, c := range columns {
+			if strings.EqualFold(c, alias) {
+				shadowed = true
+				break
+			}
+		}
+		if !shadowed {
+			return alias
+		}
+	}
+	return ""
+}
+
+// scanPages reads cols from a table in pages of pageSize rows ordered by the
+// table's page key, so only one page is held by the driver at a time. where
+// and args restrict the rows read; fn is called for every row and must not
+// retain values.
+func scanPages(ctx context.Context, db queryer, table Table, cols []string, where string, args []interface{}, pageSize int, fn func(values []interface{}) error) error {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	key := table.pageKey
+	selectCols := append(append([]string{}, cols...), key...)
+	keyList := quoteIdents(key)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(key)), ", ")
+
+	row := newRowScanner(selectCols)
+	values, cursor := row.values[:len(cols)], row.values[len(cols):]
+
+	for first := true; ; first = false {
+		var conds []string
+		queryArgs := append([]interface{}{}, args...)
+		if where != "" {
+			conds = append(conds, "("+where+")")
+		}
+		if !first {
+			conds = append(conds, fmt.Sprintf("(%s) > (%s)", keyList, placeholders))
+			queryArgs = append(queryArgs, cursor...)
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s", selectList(selectCols), quoteIdent(table.name))
+		if len(conds) > 0 {
+			query += " WHERE " + strings.Join(conds, " AND ")
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", keyList, pageSize)
+
+		n, err := scanPage(ctx, db, query, queryArgs, row, func() error { return fn(values) })
+		if err != nil {
+			return err
+		}
+		if n < pageSize {
+			return nil
+		}
+	}
+}
+
+func scanPage(ctx context.Context, db queryer, query string, args []interface{}, row *rowScanner, fn func() error) (int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		if err := row.scan(rows); err != nil {
+			return n, err
+		}
+		if err := fn(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// rowScanner reads the rows of a query selecting a list of columns in
+// which a column may be repeated, as a declared pk column in rowColumns:
+// each column is selected once, see selectList, and copied to its places.
+type rowScanner struct {
+	values  []interface{} // the current row, laid out as the list
+	scanned []interface{}
+	ptrs    []interface{}
+	index   []int // index in scanned of each value
+}
+
+// newRowScanner returns a rowScanner of the rows of a query selecting the
+// selectList of cols.
+func newRowScanner(cols []string) *rowScanner {
+	distinct, index := distinctColumns(cols)
+	s := &rowScanner{values: make([]interface{}, len(cols)), index: index}
+	s.scanned, s.ptrs = scanValues(len(distinct))
+	return s
+}
+
+// scan reads the current row of rows into s.values.
+func (s *rowScanner) scan(rows *sql.Rows) error {
+	if err := rows.Scan(s.ptrs...); err != nil {
+		return err
+	}
+	for i, j := range s.index {
+		s.values[i] = s.scanned[j]
+	}
+	return nil
+}
+
+// selectList returns the select list of the distinct columns of cols.
+func selectList(cols []string) string {
+	distinct, _ := distinctColumns(cols)
+	return quoteIdents(distinct)
+}
+
+// distinctColumns returns cols without the columns already listed, compared
+// as SQLite does regardless of case, and the index in it of each of cols.
+func distinctColumns(cols []string) ([]string, []int) {
+	var distinct []string
+	index := make([]int, len(cols))
+	for i, c := range cols {
+		j := slices.IndexFunc(distinct, func(d string) bool { return strings.EqualFold(d, c) })
+		if j < 0 {
+			j = len(distinct)
+			distinct = append(distinct, c)
+		}
+		index[i] = j
+	}
+	return distinct, index
+}
+
+// pipeRows runs scan in a goroutine and hands the rows it reads to fn
+// through a channel of size rows, so reading the source overlaps with
+// writing the target while a slow target holds the reader back instead of
+// rows piling up in memory. scan must stop once ctx is done; values are
+// copied, so its callback may reuse them.
+func pipeRows(ctx context.Context, size int, scan func(ctx context.Context, fn func(values []interface{}) error) error, fn func(values []interface{}) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rows := make(chan []interface{}, size)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(rows)
+		scanErr <- scan(ctx, func(values []interface{}) error {
+			select {
+			case rows <- append([]interface{}(nil), values...):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	for values := range rows {
+		if err := fn(values); err != nil {
+			// Let the reader stop before returning
+			cancel()
+			for range rows {
+			}
+			<-scanErr
+			return err
+		}
+	}
+	return <-scanErr
+}
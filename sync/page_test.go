@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSyncPaged(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    testTable
+		config   Config
+		expected [][]interface{}
+	}{
+		{
+			name: "Rowid table across pages",
+			table: testTable{
+				name:    "words",
+				schema:  `CREATE TABLE words (word TEXT PRIMARY KEY, n INTEGER)`,
+				srcData: [][]interface{}{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 5}},
+				tgtData: [][]interface{}{{"a", 0}, {"z", 26}},
+			},
+			config:   Config{PageSize: 2},
+			expected: [][]interface{}{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 5}},
+		},
+		{
+			name: "Composite key without rowid across pages",
+			table: testTable{
+				name: "scores",
+				schema: `CREATE TABLE scores (
+					player TEXT,
+					round INTEGER,
+					points INTEGER,
+					PRIMARY KEY (player, round)
+				) WITHOUT ROWID`,
+				srcData: [][]interface{}{{"ann", 1, 10}, {"ann", 2, 20}, {"bob", 1, 5}, {"bob", 2, 7}, {"cid", 1, 1}},
+				tgtData: [][]interface{}{{"ann", 1, 0}},
+			},
+			config:   Config{PageSize: 2, NoDelete: true},
+			expected: [][]interface{}{{"ann", 1, 10}, {"ann", 2, 20}, {"bob", 1, 5}, {"bob", 2, 7}, {"cid", 1, 1}},
+		},
+		{
+			name: "Filter combined with pages",
+			table: testTable{
+				name:    "nums",
+				schema:  `CREATE TABLE nums (id INTEGER PRIMARY KEY, v TEXT)`,
+				srcData: [][]interface{}{{1, "one"}, {2, "two"}, {3, "three"}, {4, "four"}, {5, "five"}},
+				tgtData: [][]interface{}{{1, "old"}, {2, "old"}, {6, "six"}},
+			},
+			config:   Config{PageSize: 2, Filter: "gt", Value: "1"},
+			expected: [][]interface{}{{1, "old"}, {2, "two"}, {3, "three"}, {4, "four"}, {5, "five"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{tt.table})
+
+			cfg := tt.config
+			cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+			if _, err := Sync(cfg); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			got, err := getTableData(tgtDB, tt.table.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !compareData(sortRows(got), tt.expected) {
+				t.Errorf("got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// sortRows orders rows by their printed form, for tables whose rowid order
+// differs between source and target.
+func sortRows(rows [][]interface{}) [][]interface{} {
+	sorted := append([][]interface{}{}, rows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+	return sorted
+}
+
+func TestPipeRows(t *testing.T) {
+	scan := func(ctx context.Context, fn func(values []interface{}) error) error {
+		values := make([]interface{}, 1)
+		for i := int64(0); i < 100; i++ {
+			values[0] = i
+			if err := fn(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var got []interface{}
+	err := pipeRows(context.Background(), 2, scan, func(values []interface{}) error {
+		got = append(got, values[0])
+		return nil
+	})
+	if err != nil || len(got) != 100 || got[99] != int64(99) {
+		t.Fatalf("pipeRows() = %v with %d rows, want 100 rows in order", err, len(got))
+	}
+
+	// A writer failure stops the reader
+	stop := errors.New("stop")
+	err = pipeRows(context.Background(), 2, scan, func(values []interface{}) error {
+		if values[0] == int64(10) {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("pipeRows() error = %v, want %v", err, stop)
+	}
+}
+
+func TestDistinctColumns(t *testing.T) {
+	cols, index := distinctColumns([]string{"id", "ID", "name", "rowid", "id"})
+	if fmt.Sprint(cols) != "[id name rowid]" || fmt.Sprint(index) != "[0 0 1 2 0]" {
+		t.Errorf("distinctColumns() = %v, %v", cols, index)
+	}
+	if got := selectList([]string{"id", "id", "name"}); got != `"id", "name"` {
+		t.Errorf("selectList() = %q, want %q", got, `"id", "name"`)
+	}
+}
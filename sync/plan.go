@@ -0,0 +1,447 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alvarolm/rslite/diff"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Plan writes to w the SQL statements turning the target of cfg into what
+// Sync would make of it, without writing to the target: an INSERT, UPDATE
+// or DELETE by key for every row the sync would change, between BEGIN and
+// COMMIT, so the file can be reviewed and then run on the target, by the
+// sqlite3 shell, or by Apply, which first checks the fingerprint of the
+// planned tables written at the top of the plan, along with its ID. It
+// returns the Stats of the sync.
+//
+// The sync runs on a copy of the target, with the whole behavior of cfg,
+// masks, transforms and merges included, and the copy is then compared with
+// the target. Neither the state file, the recording nor the report are
+// written. The plan only holds row changes: options changing the schema or
+// the pragmas of the target, like Mirror, and those writing outside the
+// target, like AttachmentDirs, are rejected. The target must be a local
+// database.
+func Plan(ctx context.Context, cfg Config, w io.Writer) (*Stats, error) {
+	stats := &Stats{}
+	if err := cfg.validate(); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	switch {
+	case isRemote(cfg.DstDbPath):
+		return stats, classify(ErrInvalidConfig, errors.New("plans need a local target"))
+	case cfg.Simulate:
+		return stats, classify(ErrInvalidConfig, errors.New("plans already leave the target untouched"))
+	case len(cfg.AttachmentDirs) > 0:
+		return stats, classify(ErrInvalidConfig, errors.New("plans can't copy attachments"))
+	case cfg.ExternalizeBlobs > 0:
+		return stats, classify(ErrInvalidConfig, errors.New("plans can't externalize blobs"))
+	}
+	if flag := schemaFlag(cfg); flag != "" {
+		return stats, classify(ErrInvalidConfig, fmt.Errorf("plans only hold row changes, not those of %s", flag))
+	}
+
+	dir, err := os.MkdirTemp("", "rslite-plan-")
+	if err != nil {
+		return stats, err
+	}
+	defer os.RemoveAll(dir)
+	target, err := openReadOnly(cfg.driver(), cfg.DstDbPath, nil)
+	if err != nil {
+		return stats, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	// The plan goes from a snapshot of the target, the one its fingerprint
+	// is taken of, to a synced copy of it
+	base := filepath.Join(dir, "base.db")
+	_, err = target.ExecContext(ctx, "VACUUM INTO ?", base)
+	target.Close()
+	if err != nil {
+		return stats, fmt.Errorf("copying target db: %w", err)
+	}
+	snapshot, err := openReadOnly(cfg.driver(), base, nil)
+	if err != nil {
+		return stats, err
+	}
+	planned := filepath.Join(dir, "target.db")
+	_, err = snapshot.ExecContext(ctx, "VACUUM INTO ?", planned)
+	snapshot.Close()
+	if err != nil {
+		return stats, fmt.Errorf("copying target db: %w", err)
+	}
+
+	run := cfg
+	run.DstDbPath = planned
+	run.RecordPath, run.ReportPath = "", ""
+	if cfg.StatePath != "" {
+		// Read, but saved to the copy
+		if run.state, err = loadState(cfg.StatePath, cfg.Checksum); err != nil {
+			return stats, err
+		}
+		run.StatePath = filepath.Join(dir, "sync.state")
+	}
+	stats, err = SyncContext(ctx, run)
+	if err != nil {
+		return stats, err
+	}
+
+	tables := make([]string, len(stats.Tables))
+	for i, t := range stats.Tables {
+		tables[i] = t.Table
+		if target := cfg.TableOptions[t.Table].Target; target != "" {
+			tables[i] = target
+		}
+	}
+	if err := writePlan(ctx, cfg, planned, base, tables, w); err != nil {
+		return stats, fmt.Errorf("writing plan: %w", err)
+	}
+	return stats, nil
+}
+
+// schemaFlag returns the flag of the first option of cfg changing the
+// target beyond its rows, or "" when none is set.
+func schemaFlag(cfg Config) string {
+	for _, o := range []struct {
+		set  bool
+		flag string
+	}{
+		{cfg.Mirror, "--mirror"},
+		{cfg.MigrateSchema, "--migrate-schema"},
+		{cfg.SchemaObjects, "--schema-objects"},
+		{cfg.DropSchemaObjects, "--drop-schema-objects"},
+		{cfg.CopyVersion, "--copy-version"},
+		{cfg.Sequences != "", "--sequences"},
+		{cfg.RebuildFTS, "--rebuild-fts"},
+	} {
+		if o.set {
+			return o.flag
+		}
+	}
+	return ""
+}
+
+// writePlan writes to w the statements turning the tables of the database
+// at to into those of the database at from, after the fingerprint of the
+// tables at to.
+func writePlan(ctx context.Context, cfg Config, from, to string, tables []string, w io.Writer) error {
+	db, err := openReadOnly(cfg.driver(), from, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	specs := make(map[string]Table, len(tables))
+	for _, name := range tables {
+		if specs[name], err = getTableInfo(ctx, db, name); err != nil {
+			return err
+		}
+	}
+	old, err := openReadOnly(cfg.driver(), to, nil)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	sum, err := fingerprint(ctx, old, tables)
+	if err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "-- rslite plan of %s to %s, %s\n", cfg.SrcDbPath, cfg.DstDbPath, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(bw, "%s%s\n", planID, hex.EncodeToString(id))
+	fmt.Fprintf(bw, "%s%s\n", planTables, strings.Join(tables, ", "))
+	fmt.Fprintf(bw, "%s%s\n", planFingerprint, sum)
+	fmt.Fprintln(bw, "BEGIN;")
+	if len(tables) > 0 {
+		compare := Config{SrcDbPath: from, DstDbPath: to, Tables: tables, Extensions: cfg.Extensions, ConnectHook: cfg.ConnectHook}
+		_, err = Diff(ctx, compare, func(row diff.Row) error {
+			_, err := fmt.Fprintf(bw, "%s;\n", changeStatement(row, specs[row.Table], sqlLiteral))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(bw, "COMMIT;")
+	return bw.Flush()
+}
+
+// Header lines of a plan, see Apply.
+const (
+	planID          = "-- id: "
+	planTables      = "-- tables: "
+	planFingerprint = "-- fingerprint: "
+)
+
+// appliedTable is the table of a target recording the IDs of the plans
+// applied to it. It is never synced.
+const appliedTable = "_rslite_applied"
+
+// Apply runs on the target of cfg the statements of a plan written by Plan,
+// in a single transaction, and returns the number of rows they changed. It
+// fails with ErrTargetChanged, without writing anything, when the schema or
+// the rows of the planned tables changed since the plan was made.
+//
+// The ID of the plan, or the SHA-256 of a plan without one, is recorded in
+// the appliedTable of the target along with its changes. Applying the plan
+// again fails with ErrAlreadyApplied and leaves the target alone.
+func Apply(ctx context.Context, cfg Config, plan io.Reader) (int64, error) {
+	if cfg.DstDbPath == "" || isRemote(cfg.DstDbPath) {
+		return 0, classify(ErrInvalidConfig, errors.New("plans apply to a local target"))
+	}
+	text, err := io.ReadAll(plan)
+	if err != nil {
+		return 0, fmt.Errorf("reading plan: %w", err)
+	}
+	var (
+		id     string
+		tables []string
+		sum    string
+		body   strings.Builder
+	)
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		switch trimmed := strings.TrimSpace(line); {
+		case strings.HasPrefix(trimmed, planID):
+			id = strings.TrimPrefix(trimmed, planID)
+		case strings.HasPrefix(trimmed, planTables):
+			if names := strings.TrimPrefix(trimmed, planTables); names != "" {
+				tables = strings.Split(names, ", ")
+			}
+		case strings.HasPrefix(trimmed, planFingerprint):
+			sum = strings.TrimPrefix(trimmed, planFingerprint)
+		case trimmed == "BEGIN;" || trimmed == "COMMIT;":
+			// The transaction is Apply's own
+		default:
+			body.WriteString(line)
+		}
+	}
+	if sum == "" {
+		return 0, classify(ErrInvalidConfig, errors.New("plan has no fingerprint"))
+	}
+	if id == "" {
+		// Written before plans had IDs
+		h := sha256.Sum256(text)
+		id = hex.EncodeToString(h[:])
+	}
+
+	params := url.Values{}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	if _, err := os.Stat(dbFile(cfg.DstDbPath)); err != nil {
+		return 0, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	db, err := openDB(cfg.driver(), dsn(cfg.DstDbPath, params))
+	if err != nil {
+		return 0, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer conn.Close()
+
+	// The write lock keeps the target as fingerprinted until the commit,
+	// while the fingerprint is read on another connection
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return 0, fmt.Errorf("locking target db: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		}
+	}()
+	if applied, err := appliedAt(ctx, conn, id); err != nil {
+		return 0, fmt.Errorf("reading applied plans: %w", err)
+	} else if applied != "" {
+		return 0, fmt.Errorf("%w: %s on %s", ErrAlreadyApplied, id, applied)
+	}
+	got, err := fingerprint(ctx, db, tables)
+	if err != nil {
+		return 0, fmt.Errorf("fingerprinting target db: %w", err)
+	}
+	if got != sum {
+		return 0, classify(ErrTargetChanged, errors.New("the target changed since the plan was made"))
+	}
+	var before, after int64
+	if err := conn.QueryRowContext(ctx, "SELECT total_changes()").Scan(&before); err != nil {
+		return 0, err
+	}
+	if _, err := conn.ExecContext(ctx, body.String()); err != nil {
+		return 0, fmt.Errorf("applying plan: %w", err)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT total_changes()").Scan(&after); err != nil {
+		return 0, err
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id TEXT PRIMARY KEY,
+		applied TEXT NOT NULL,
+		changes INTEGER NOT NULL
+	)`, appliedTable)); err != nil {
+		return 0, fmt.Errorf("recording plan: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, applied, changes) VALUES (?, ?, ?)", appliedTable),
+		id, time.Now().UTC().Format(time.RFC3339), after-before); err != nil {
+		return 0, fmt.Errorf("recording plan: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return 0, fmt.Errorf("committing plan: %w", err)
+	}
+	committed = true
+	return after - before, nil
+}
+
+// appliedAt returns when the plan of an ID was applied to the target of
+// conn, or nothing if it wasn't.
+func appliedAt(ctx context.Context, conn *sql.Conn, id string) (string, error) {
+	if exists, err := tableExists(ctx, conn, appliedTable); err != nil || !exists {
+		return "", err
+	}
+	var applied string
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT applied FROM %s WHERE id = ?", appliedTable), id).Scan(&applied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return applied, err
+}
+
+// fingerprint returns the SHA-256 of the schema and the rows of tables in
+// db, in key order.
+func fingerprint(ctx context.Context, db *sql.DB, tables []string) (string, error) {
+	h := newChecksum(ChecksumSHA256)
+	for _, name := range tables {
+		// The table with its indexes and triggers
+		rows, err := db.QueryContext(ctx, `SELECT type, name, sql FROM sqlite_master WHERE tbl_name = ? ORDER BY type, name`, name)
+		if err != nil {
+			return "", err
+		}
+		values, ptrs := scanValues(3)
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return "", err
+			}
+			hashRow(h, values)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+
+		table, err := getTableInfo(ctx, db, name)
+		if err != nil {
+			return "", err
+		}
+		query, _ := buildDiffQuery(table, name, Config{})
+		if rows, err = db.QueryContext(ctx, query); err != nil {
+			return "", err
+		}
+		row := newRowScanner(diffColumns(table))
+		for rows.Next() {
+			if err := row.scan(rows); err != nil {
+				rows.Close()
+				return "", err
+			}
+			hashRow(h, row.values)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// changeStatement returns the statement applying a row of a diff of two
+// versions of table, from the source version: an INSERT of a source-only
+// row, a DELETE of a target-only one, or an UPDATE of the changed columns.
+// bind renders every value, as a literal or a placeholder.
+func changeStatement(row diff.Row, table Table, bind func(v interface{}) string) string {
+	var conds []string
+	key := func() string {
+		for i, c := range table.keyCols {
+			conds = append(conds, quoteIdent(c)+" = "+bind(row.Key[i]))
+		}
+		return strings.Join(conds, " AND ")
+	}
+	switch row.Kind {
+	case diff.SourceOnly:
+		// The key too, which may be the rowid
+		var cols, values []string
+		for i, c := range table.keyCols {
+			cols = append(cols, c)
+			values = append(values, bind(row.Key[i]))
+		}
+		for _, c := range table.columns {
+			if v, ok := row.Source[c]; ok && !containsFold(cols, c) {
+				cols = append(cols, c)
+				values = append(values, bind(v))
+			}
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(row.Table), quoteIdents(cols), strings.Join(values, ", "))
+	case diff.TargetOnly:
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(row.Table), key())
+	default:
+		sets := make([]string, len(row.Changed))
+		for i, c := range row.Changed {
+			sets[i] = quoteIdent(c) + " = " + bind(row.Source[c])
+		}
+		return fmt.Sprintf("UPDATE %s SET %s WHERE %s", quoteIdent(row.Table), strings.Join(sets, ", "), key())
+	}
+}
+
+// sqlLiteral renders a value as an SQL literal of the same storage class.
+func sqlLiteral(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case bool:
+		return strconv.FormatInt(btoi64(x), 10)
+	case float64:
+		switch {
+		case math.IsInf(x, 1):
+			return "9e999"
+		case math.IsInf(x, -1):
+			return "-9e999"
+		case math.IsNaN(x):
+			return "NULL"
+		}
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			// Still a REAL
+			s += ".0"
+		}
+		return s
+	case string:
+		return "'" + strings.ReplaceAll(x, "'", "''") + "'"
+	case []byte:
+		return fmt.Sprintf("x'%x'", x)
+	case time.Time:
+		// As the SQLite driver writes it
+		return sqlLiteral(x.Format(sqlite3.SQLiteTimestampFormats[0]))
+	default:
+		return sqlLiteral(fmt.Sprint(v))
+	}
+}
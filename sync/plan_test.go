@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, avatar BLOB, score REAL)`,
+			srcData: [][]interface{}{{1, "Alice", []byte{0, 1}, 2.0}, {2, "O'Hara", nil, nil}},
+			tgtData: [][]interface{}{{2, "Robert", nil, nil}, {4, "Dan", nil, nil}},
+		},
+		{
+			name:    "logs",
+			schema:  `CREATE TABLE logs (msg TEXT)`,
+			srcData: [][]interface{}{{"started"}, {"stopped"}},
+		},
+	})
+
+	var plan strings.Builder
+	stats, err := Plan(context.Background(), Config{SrcDbPath: srcPath, DstDbPath: tgtPath}, &plan)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if total := stats.Total(); total.Inserted != 3 || total.Replaced != 1 || total.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", total)
+	}
+	for _, want := range []string{
+		`INSERT INTO "users" ("id", "name", "avatar", "score") VALUES (1, 'Alice', x'0001', 2.0);`,
+		`UPDATE "users" SET "name" = 'O''Hara' WHERE "id" = 2;`,
+		`DELETE FROM "users" WHERE "id" = 4;`,
+		`INSERT INTO "logs" ("rowid", "msg") VALUES (1, 'started');`,
+	} {
+		if !strings.Contains(plan.String(), want) {
+			t.Errorf("plan lacks %q:\n%s", want, plan.String())
+		}
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("the plan changed the target")
+	}
+
+	// Running the plan syncs the target
+	if _, err := tgtDB.Exec(plan.String()); err != nil {
+		t.Fatalf("running the plan: %v", err)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice", []byte{0, 1}, 2.0}, {2, "O'Hara", nil, nil}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := countTestRows(t, tgtDB, "logs"); n != 2 {
+		t.Errorf("target has %d logs, want 2", n)
+	}
+
+	// Nothing left to do
+	plan.Reset()
+	if _, err := Plan(context.Background(), Config{SrcDbPath: srcPath, DstDbPath: tgtPath}, &plan); err != nil {
+		t.Fatalf("second Plan() error = %v", err)
+	}
+	if strings.Contains(plan.String(), "INSERT") || strings.Contains(plan.String(), "DELETE") || strings.Contains(plan.String(), "UPDATE") {
+		t.Errorf("second plan is not empty:\n%s", plan.String())
+	}
+
+	// Changes the plan couldn't hold
+	for _, cfg := range []Config{{Mirror: true}, {SchemaObjects: true}, {CopyVersion: true}, {Sequences: "copy"}, {RebuildFTS: true}} {
+		cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+		if _, err := Plan(context.Background(), cfg, &plan); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "row changes") {
+			t.Errorf("Plan() with %+v error = %v, want ErrInvalidConfig", cfg, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{2, "Robert"}, {3, "Carol"}},
+	}})
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath}
+
+	var plan strings.Builder
+	if _, err := Plan(context.Background(), cfg, &plan); err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if !strings.Contains(plan.String(), "-- fingerprint: ") {
+		t.Fatalf("plan has no fingerprint:\n%s", plan.String())
+	}
+
+	// A drifted target is left alone
+	if _, err := tgtDB.Exec(`UPDATE users SET name = 'Caroline' WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(context.Background(), cfg, strings.NewReader(plan.String())); !errors.Is(err, ErrTargetChanged) || !errors.Is(err, ErrVerification) {
+		t.Fatalf("drifted target: error = %v, want ErrTargetChanged", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("the drifted target was written to")
+	}
+	if _, err := tgtDB.Exec(`UPDATE users SET name = 'Carol' WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := Apply(context.Background(), cfg, strings.NewReader(plan.String()))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if changed != 3 {
+		t.Errorf("Apply() changed %d rows, want 3", changed)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice"}, {2, "Bob"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Applying it again does nothing
+	if _, err := Apply(context.Background(), cfg, strings.NewReader(plan.String())); !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("second Apply() error = %v, want ErrAlreadyApplied", err)
+	}
+	// Another plan made before the first was applied no longer matches
+	other := strings.Replace(plan.String(), "-- id: ", "-- id: 0", 1)
+	if _, err := Apply(context.Background(), cfg, strings.NewReader(other)); !errors.Is(err, ErrTargetChanged) {
+		t.Errorf("Apply() of another plan error = %v, want ErrTargetChanged", err)
+	}
+	// Plans without an ID are told apart by their text
+	noID := regexp.MustCompile(`(?m)^-- id: .*\n`).ReplaceAllString(plan.String(), "")
+	if _, err := tgtDB.Exec(`DELETE FROM users WHERE id = 1; UPDATE users SET name = 'Robert' WHERE id = 2; INSERT INTO users VALUES (3, 'Carol')`); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []error{nil, ErrAlreadyApplied} {
+		if _, err := Apply(context.Background(), cfg, strings.NewReader(noID)); !errors.Is(err, want) {
+			t.Errorf("Apply() %d of a plan without ID error = %v, want %v", i+1, err, want)
+		}
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT count(*) FROM ` + appliedTable).Scan(&n); err != nil || n != 2 {
+		t.Errorf("%d plans recorded as applied, want 2: %v", n, err)
+	}
+	if _, err := Apply(context.Background(), cfg, strings.NewReader("DELETE FROM users;")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("no fingerprint: error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// pragmaRE matches a Config.DstPragmas setting.
+var pragmaRE = regexp.MustCompile(`^([A-Za-z_]+)\s*=\s*([-\w.]+)$`)
+
+// checkPragma fails on a Config.DstPragmas setting that isn't NAME=VALUE.
+func checkPragma(setting string) error {
+	if !pragmaRE.MatchString(setting) {
+		return fmt.Errorf("invalid target pragma %q: want NAME=VALUE", setting)
+	}
+	return nil
+}
+
+// dstPragma returns the value Config.DstPragmas sets a pragma to.
+func (cfg Config) dstPragma(name string) (string, bool) {
+	for _, setting := range cfg.DstPragmas {
+		if m := pragmaRE.FindStringSubmatch(setting); m != nil && strings.EqualFold(m[1], name) {
+			return m[2], true
+		}
+	}
+	return "", false
+}
+
+// targetDriver returns the driver opening the connections to the target,
+// which sets Config.DstPragmas on each of them after Config.ConnectHook.
+func (cfg Config) targetDriver() driver.Driver {
+	if len(cfg.DstPragmas) == 0 {
+		return cfg.driver()
+	}
+	hook := cfg.ConnectHook
+	return cfg.sqliteDriver(func(conn *sqlite3.SQLiteConn) error {
+		if hook != nil {
+			if err := hook(conn); err != nil {
+				return err
+			}
+		}
+		for _, setting := range cfg.DstPragmas {
+			if _, err := conn.Exec("PRAGMA "+setting, nil); err != nil {
+				return fmt.Errorf("setting target pragma %s: %w", setting, err)
+			}
+		}
+		return nil
+	})
+}
+
+// saveJournalMode returns a function setting the journal mode of the target
+// back to its current one, that of a new database when it doesn't exist,
+// when Config.DstPragmas changes it: unlike the other pragmas, it is kept
+// in the database file. It returns nil when there is nothing to restore.
+func saveJournalMode(ctx context.Context, cfg Config, params url.Values) (func(context.Context) error, error) {
+	want, ok := cfg.dstPragma("journal_mode")
+	if !ok {
+		return nil, nil
+	}
+	mode := "delete"
+	if _, err := os.Stat(dbFile(cfg.DstDbPath)); err == nil {
+		db, err := openDB(cfg.driver(), dsn(cfg.DstDbPath, params))
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+			return nil, fmt.Errorf("reading target journal mode: %w", err)
+		}
+	}
+	if strings.EqualFold(mode, want) {
+		return nil, nil
+	}
+	return func(ctx context.Context) error {
+		db, err := openDB(cfg.driver(), dsn(cfg.DstDbPath, params))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		var got string
+		if err := db.QueryRowContext(ctx, "PRAGMA journal_mode = "+mode).Scan(&got); err != nil {
+			return fmt.Errorf("restoring target journal mode %s: %w", mode, err)
+		}
+		if !strings.EqualFold(got, mode) {
+			return fmt.Errorf("restoring target journal mode %s: still %s", mode, got)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncDstPragmas(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+	}})
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, DstPragmas: []string{"journal_mode=WAL", "synchronous=OFF", "cache_size = -20000"}}
+
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("users holds %d rows, want 2", n)
+	}
+	var mode string
+	if err := tgtDB.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "delete" {
+		t.Errorf("target journal mode = %q, %v, want it restored to delete", mode, err)
+	}
+
+	// The other settings only last as long as the connections
+	db, err := openDB(cfg.targetDriver(), tgtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sync, cache int64
+	if err := db.QueryRow(`PRAGMA synchronous`).Scan(&sync); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`PRAGMA cache_size`).Scan(&cache); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if sync != 0 || cache != -20000 {
+		t.Errorf("target connection has synchronous = %d, cache_size = %d", sync, cache)
+	}
+
+	cfg.DstPragmas = []string{"synchronous=OFF; DROP TABLE users"}
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("invalid pragma: error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Progress reports how far the sync of a table went, see Config.Progress.
+type Progress struct {
+	Table   string
+	Rows    int64 // source rows processed so far
+	Total   int64 // source rows to process, counted when the table starts
+	Elapsed time.Duration
+	Done    bool // the table was committed
+}
+
+// progressInterval is the minimum time between two reports of a table.
+const progressInterval = 200 * time.Millisecond
+
+// progressReporter calls Config.Progress for a table, at most every
+// progressInterval. A nil reporter reports nothing.
+type progressReporter struct {
+	fn    func(Progress)
+	p     Progress
+	start time.Time
+	last  time.Time
+}
+
+// newProgressReporter reports the start of a table, with total rows to
+// process, and returns nil when cfg has no Progress callback.
+func newProgressReporter(cfg Config, table Table, total int64) *progressReporter {
+	if cfg.Progress == nil {
+		return nil
+	}
+	r := &progressReporter{fn: cfg.Progress, p: Progress{Table: table.name, Total: total}, start: time.Now()}
+	r.report()
+	return r
+}
+
+func (r *progressReporter) report() {
+	r.last = time.Now()
+	r.p.Elapsed = r.last.Sub(r.start)
+	r.fn(r.p)
+}
+
+// add counts n processed rows.
+func (r *progressReporter) add(n int64) {
+	if r == nil {
+		return
+	}
+	r.p.Rows += n
+	if time.Since(r.last) >= progressInterval {
+		r.report()
+	}
+}
+
+// done reports that the table was committed.
+func (r *progressReporter) done() {
+	if r == nil {
+		return
+	}
+	r.p.Done = true
+	r.report()
+}
+
+// countSourceRows counts the rows of a source table matching where, for
+// Config.Progress. from is the table name, qualified with its schema if
+// needed.
+func countSourceRows(ctx context.Context, db queryer, from, where string, args []interface{}) (int64, error) {
+	query := "SELECT COUNT(*) FROM " + from
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("counting source rows: %w", err)
+	}
+	defer rows.Close()
+	var n int64
+	if rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			return 0, fmt.Errorf("counting source rows: %w", err)
+		}
+	}
+	return n, rows.Err()
+}
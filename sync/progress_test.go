@@ -0,0 +1,48 @@
+package sync
+
+import (
+	gosync "sync"
+	"testing"
+)
+
+func TestSyncProgress(t *testing.T) {
+	for _, noAttach := range []bool{false, true} {
+		tables := []testTable{
+			{
+				name:    "users",
+				schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+				srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}, {3, "Carol"}},
+			},
+		}
+		srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+		var (
+			mu      gosync.Mutex
+			reports []Progress
+		)
+		cfg := Config{
+			SrcDbPath: srcPath,
+			DstDbPath: tgtPath,
+			Where:     "id > 1",
+			NoAttach:  noAttach,
+			Progress: func(p Progress) {
+				mu.Lock()
+				defer mu.Unlock()
+				reports = append(reports, p)
+			},
+		}
+		if _, err := Sync(cfg); err != nil {
+			t.Fatalf("NoAttach=%v: Sync() error = %v", noAttach, err)
+		}
+		if len(reports) < 2 {
+			t.Fatalf("NoAttach=%v: got %d reports, want the start and the end", noAttach, len(reports))
+		}
+		first, last := reports[0], reports[len(reports)-1]
+		if first.Table != "users" || first.Rows != 0 || first.Total != 2 || first.Done {
+			t.Errorf("NoAttach=%v: first report = %+v, want 0 of 2 users rows", noAttach, first)
+		}
+		if last.Rows != 2 || last.Total != 2 || !last.Done {
+			t.Errorf("NoAttach=%v: last report = %+v, want 2 of 2 rows done", noAttach, last)
+		}
+	}
+}
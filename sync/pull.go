@@ -0,0 +1,351 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// serverURL returns the base HTTP URL of an rslite://host:port or
+// rslite+https://host:port source, served by a Server.
+func serverURL(path string) (string, bool) {
+	for scheme, base := range map[string]string{"rslite://": "http://", "rslite+https://": "https://"} {
+		if rest, ok := strings.CutPrefix(path, scheme); ok {
+			return base + strings.TrimSuffix(rest, "/"), true
+		}
+	}
+	return "", false
+}
+
+// isServer reports whether a database path is the URL of a Server.
+func isServer(path string) bool {
+	_, ok := serverURL(path)
+	return ok
+}
+
+// pullServer creates at path a database with the tables of the Server at
+// url that cfg syncs, holding only the rows selected by the filters and
+// watermarks of cfg, so that syncing it instead of the served database
+// writes the same rows. The orphan delete is left to deleteServerOrphans.
+//
+// With RangeDiff, the tables are compared with the target of cfg by key
+// range instead, and only the rows of the differing ranges are pulled. They
+// are returned by table, for deleteServerOrphans to only delete from them.
+// The tables missing from the target, or with a key of several columns, are
+// pulled whole and left out, as are all tables when the target doesn't exist.
+func pullServer(ctx context.Context, cfg Config, url, path string) (map[string][]keyRange, error) {
+	req, err := serverRequest(ctx, cfg, http.MethodGet, url+"/v1/tables", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := serverDo(req)
+	if err != nil {
+		return nil, err
+	}
+	var tables []serverTable
+	err = json.NewDecoder(resp.Body).Decode(&tables)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading tables: %w", err)
+	}
+
+	db, err := openDB(cfg.driver(), dsn(path, nil))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if cfg.StatePath != "" && cfg.state == nil {
+		if cfg.state, err = loadState(cfg.StatePath, cfg.Checksum); err != nil {
+			return nil, err
+		}
+	}
+	var dst *sql.DB
+	if cfg.RangeDiff {
+		// A new target is pulled whole
+		if dst, err = openReadOnly(cfg.driver(), cfg.DstDbPath, nil); err == nil {
+			defer dst.Close()
+		}
+	}
+	ranges := make(map[string][]keyRange)
+	for _, t := range tables {
+		if !cfg.selects(t.Name) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, t.SQL); err != nil {
+			return nil, fmt.Errorf("creating table %s: %w", t.Name, err)
+		}
+		table, err := getTableInfo(ctx, db, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if dst != nil {
+			diffed, err := pullDiff(ctx, cfg, url, db, dst, table)
+			if err != nil {
+				return nil, fmt.Errorf("pulling table %s: %w", t.Name, err)
+			}
+			if diffed != nil {
+				ranges[table.name] = diffed
+				continue
+			}
+		}
+		if err := pullTable(ctx, cfg, url, db, table); err != nil {
+			return nil, fmt.Errorf("pulling table %s: %w", t.Name, err)
+		}
+	}
+	return ranges, nil
+}
+
+// pullDiff copies the rows of the key ranges of table differing between the
+// Server at url and the target dst to db, and returns the ranges, nil when
+// the table can't be compared by range.
+func pullDiff(ctx context.Context, cfg Config, url string, db, dst *sql.DB, table Table) ([]keyRange, error) {
+	// The ranges are compared on the rows as synced, but copied whole
+	compared, err := applyTableOptions(table, cfg.TableOptions[table.name])
+	if err != nil {
+		return nil, err
+	}
+	if len(compared.keyCols) > 1 {
+		return nil, nil
+	}
+	exists, err := tableExists(ctx, dst, compared.targetName())
+	if err != nil || !exists {
+		return nil, err
+	}
+	ranges, err := diffRanges(ctx, cfg, url, dst, compared)
+	if err != nil {
+		return nil, err
+	}
+	cfg.logger().InfoContext(ctx, "key ranges compared", "table", table.name, "differing", len(ranges))
+	return ranges, pullRanges(ctx, cfg, url, db, table, compared.pkCol, ranges)
+}
+
+// pullTable copies the rows of a table selected by cfg from the Server at
+// url to db.
+func pullTable(ctx context.Context, cfg Config, url string, db *sql.DB, table Table) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, buildInsertQuery(table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// The rows are selected as the sync would, with the key of the table
+	// options, but copied whole
+	selected, err := applyTableOptions(table, cfg.TableOptions[table.name])
+	if err != nil {
+		return err
+	}
+	where, args := buildFilter(selected, cfg)
+	err = pullRows(ctx, cfg, url, table.name, table.writtenColumns(), where, args, func(values []interface{}) error {
+		_, err := stmt.ExecContext(ctx, values...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pullRows streams the given columns of the rows of a table matching where
+// from the Server at url, calling fn with each of them.
+func pullRows(ctx context.Context, cfg Config, url, table string, cols []string, where string, args []interface{}, fn func(values []interface{}) error) error {
+	body := rowsRequest{Table: table, Columns: cols, Where: where, Args: make([]wireValue, len(args))}
+	for i, a := range args {
+		body.Args[i] = newWireValue(a)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(body); err != nil {
+		return err
+	}
+	req, err := serverRequest(ctx, cfg, http.MethodPost, url+"/v1/rows", &buf)
+	if err != nil {
+		return err
+	}
+	resp, err := serverDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := gob.NewDecoder(resp.Body)
+	values := make([]interface{}, len(cols))
+	for {
+		var frame rowsFrame
+		if err := dec.Decode(&frame); err != nil {
+			return fmt.Errorf("reading rows: %w", err)
+		}
+		if frame.Done {
+			if frame.Err != "" {
+				return fmt.Errorf("server: %s", frame.Err)
+			}
+			return nil
+		}
+		if len(frame.Row) != len(cols) {
+			return fmt.Errorf("reading rows: got %d columns, want %d", len(frame.Row), len(cols))
+		}
+		for i, v := range frame.Row {
+			values[i] = v.value()
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+}
+
+// pulledConfig returns cfg syncing the database pulled by pullServer. Its
+// rows are already filtered, and applying the conditions again could drop
+// rows whose condition reads other tables, only pulled in part. The
+// watermarks still apply, to be tracked.
+func pulledConfig(cfg Config) Config {
+	cfg.Filter, cfg.Value, cfg.Since, cfg.Where, cfg.TableWhere = "", "", "", "", nil
+	if len(cfg.TableOptions) > 0 {
+		opts := make(map[string]TableOptions, len(cfg.TableOptions))
+		for name, o := range cfg.TableOptions {
+			o.Where = ""
+			opts[name] = o
+		}
+		cfg.TableOptions = opts
+	}
+	// See deleteServerOrphans
+	cfg.NoDelete, cfg.RangeDiff = true, false
+	return cfg
+}
+
+// deleteServerOrphans deletes the rows of the target at path whose key is
+// no longer on the Server at url, for the tables of stats synced from the
+// database pulled at pulled. The keys are streamed from the server, without
+// the rows, except for the tables of ranges compared by pullServer: their
+// orphans are the target rows of the differing ranges that weren't pulled.
+func deleteServerOrphans(ctx context.Context, cfg Config, url, pulled, path string, ranges map[string][]keyRange, stats *Stats) error {
+	src, err := openReadOnly(cfg.driver(), pulled, nil)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := openDB(cfg.driver(), dsn(path, nil))
+	if err != nil {
+		return classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer dst.Close()
+
+	cfg.state = nil // the keys are read unfiltered
+	for i, ts := range stats.Tables {
+		table, err := getTableInfo(ctx, src, ts.Table)
+		if err != nil {
+			return err
+		}
+		if table, err = applyTableOptions(table, cfg.TableOptions[table.name]); err != nil {
+			return err
+		}
+		if !cfg.deletes(table) {
+			continue
+		}
+		var deleted int64
+		if diffed, ok := ranges[table.name]; ok {
+			deleted, err = deleteRangeOrphans(ctx, cfg, src, dst, table, diffed)
+		} else {
+			deleted, err = deleteTableOrphans(ctx, cfg, url, dst, table)
+		}
+		if err != nil {
+			return &TableError{Table: table.name, Err: err}
+		}
+		stats.Tables[i].Deleted += deleted
+	}
+	return nil
+}
+
+// deleteTableOrphans deletes the target rows of table whose key is no
+// longer on the Server at url, and returns their number.
+func deleteTableOrphans(ctx context.Context, cfg Config, url string, dst *sql.DB, table Table) (int64, error) {
+	w, err := newTableWriter(ctx, dst, nil, table, cfg, "")
+	if err != nil {
+		return 0, err
+	}
+	defer w.close()
+	err = pullRows(ctx, cfg, url, table.name, table.keyCols, "", nil, func(values []interface{}) error {
+		return w.keep(ctx, values)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("staging source IDs: %w", err)
+	}
+	if err := w.deleteOrphans(ctx); err != nil {
+		return 0, err
+	}
+	stats, err := w.commit(ctx)
+	return stats.Deleted, err
+}
+
+// deleteRangeOrphans deletes the target rows of the key ranges of table
+// whose key is not in the pulled database src, and returns their number.
+func deleteRangeOrphans(ctx context.Context, cfg Config, src, dst *sql.DB, table Table, ranges []keyRange) (int64, error) {
+	if len(ranges) == 0 {
+		return 0, nil
+	}
+	w, err := newTableWriter(ctx, dst, nil, table, cfg, "")
+	if err != nil {
+		return 0, err
+	}
+	defer w.close()
+	rows, err := src.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", selectList(table.keyCols), quoteIdent(table.name)))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		key := make([]interface{}, len(table.keyCols))
+		ptrs := make([]interface{}, len(key))
+		for i := range key {
+			ptrs[i] = &key[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		if err := w.keep(ctx, key); err != nil {
+			return 0, fmt.Errorf("staging source IDs: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := w.deleteOrphansIn(ctx, ranges); err != nil {
+		return 0, err
+	}
+	stats, err := w.commit(ctx)
+	return stats.Deleted, err
+}
+
+// serverRequest returns a request to a Server, with the token of cfg.
+func serverRequest(ctx context.Context, cfg Config, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.ServerToken)
+	}
+	return req, nil
+}
+
+// serverDo sends a request to a Server, failing on an error status.
+func serverDo(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s: %s", req.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
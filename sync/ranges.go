@@ -0,0 +1,232 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// rangeParts is the number of subranges a Server splits a differing key
+// range into. Ranges of at most rangeLeafRows rows are split into single
+// rows, compared one by one.
+var (
+	rangeParts    = 16
+	rangeLeafRows = 256
+)
+
+// rangePullBatch is the number of differing key ranges whose rows are pulled
+// by a single request.
+const rangePullBatch = 100
+
+// keyRange is the range of the keys after After, up to Upto included. A nil
+// bound leaves the range open on that side.
+type keyRange struct {
+	After, Upto interface{}
+}
+
+// where returns the condition selecting the rows of the range by the key
+// column.
+func (r keyRange) where(key string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	key = quoteIdent(key)
+	if r.After != nil {
+		conds = append(conds, key+" > ?")
+		args = append(args, r.After)
+	}
+	if r.Upto != nil {
+		conds = append(conds, key+" <= ?")
+		args = append(args, r.Upto)
+	}
+	if len(conds) == 0 {
+		return "1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// rangesRequest asks a Server for the checksums of the subranges of a key
+// range of a table, hashing the given columns, the key first, with the
+// Checksum algorithm: FNV-1a when empty, as sent by the clients predating
+// the other algorithms.
+type rangesRequest struct {
+	Table       string
+	Columns     []string
+	After, Upto *wireValue
+	Parts       int
+	Checksum    string
+}
+
+// rangeHash is the checksum of a subrange answering a rangesRequest. It
+// starts after the previous subrange, or the requested range, and ends at
+// Upto, nil for the end of the requested range. Checksum is the algorithm
+// of Hash, empty from the servers predating the other algorithms than
+// FNV-1a.
+type rangeHash struct {
+	Upto     *wireValue
+	Count    int64
+	Hash     []byte
+	Checksum string
+}
+
+// wireBound returns the wire value of a range bound.
+func wireBound(v interface{}) *wireValue {
+	if v == nil {
+		return nil
+	}
+	w := newWireValue(v)
+	return &w
+}
+
+// boundValue returns the range bound of a wire value.
+func boundValue(w *wireValue) interface{} {
+	if w == nil {
+		return nil
+	}
+	return w.value()
+}
+
+// hashRange returns the number of rows of a key range of db and the checksum
+// of their columns read in key order, cols starting with the key.
+func hashRange(ctx context.Context, db queryer, table string, cols []string, r keyRange, alg string) (int64, []byte, error) {
+	where, args := r.where(cols[0])
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s",
+		selectList(cols), quoteIdent(table), where, quoteIdent(cols[0])), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	h := newChecksum(alg)
+	row := newRowScanner(cols)
+	var n int64
+	for rows.Next() {
+		if err := row.scan(rows); err != nil {
+			return 0, nil, err
+		}
+		hashRow(h, row.values)
+		n++
+	}
+	return n, h.Sum(nil), rows.Err()
+}
+
+// scanValues returns a row of n values and the pointers scanning it.
+func scanValues(n int) ([]interface{}, []interface{}) {
+	values := make([]interface{}, n)
+	ptrs := make([]interface{}, n)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	return values, ptrs
+}
+
+// diffRanges compares the checksums of the key ranges of table on the Server
+// at url with those of the target db, splitting the differing ones until
+// they hold a single row, and returns the ranges still differing.
+func diffRanges(ctx context.Context, cfg Config, url string, dst *sql.DB, table Table) ([]keyRange, error) {
+	cols := table.rowColumns()
+	leaves := []keyRange{}
+	type pending struct {
+		r     keyRange
+		parts int
+	}
+	queue := []pending{{parts: rangeParts}}
+	for len(queue) > 0 {
+		p := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		hashes, err := serverRanges(ctx, cfg, url, rangesRequest{
+			Table:    table.name,
+			Columns:  cols,
+			After:    wireBound(p.r.After),
+			Upto:     wireBound(p.r.Upto),
+			Parts:    p.parts,
+			Checksum: checksumName(cfg.Checksum),
+		})
+		if err != nil {
+			return nil, err
+		}
+		after := p.r.After
+		for _, rh := range hashes {
+			if rh.Checksum != checksumName(cfg.Checksum) {
+				return nil, fmt.Errorf("server hashed table %s with the %q checksum, not %s: upgrade it", table.name, rh.Checksum, checksumName(cfg.Checksum))
+			}
+			r := keyRange{After: after, Upto: boundValue(rh.Upto)}
+			if rh.Upto == nil {
+				r.Upto = p.r.Upto
+			}
+			after = r.Upto
+			n, sum, err := hashRange(ctx, dst, table.targetName(), cols, r, cfg.Checksum)
+			if err != nil {
+				return nil, fmt.Errorf("hashing target rows: %w", err)
+			}
+			switch {
+			case n == rh.Count && bytes.Equal(sum, rh.Hash):
+			case rh.Count <= 1:
+				leaves = append(leaves, r)
+			case rh.Count <= int64(rangeLeafRows):
+				queue = append(queue, pending{r: r, parts: int(rh.Count)})
+			default:
+				queue = append(queue, pending{r: r, parts: rangeParts})
+			}
+		}
+	}
+	return leaves, nil
+}
+
+// serverRanges asks the Server at url for the checksums of req.
+func serverRanges(ctx context.Context, cfg Config, url string, req rangesRequest) ([]rangeHash, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(req); err != nil {
+		return nil, err
+	}
+	httpReq, err := serverRequest(ctx, cfg, http.MethodPost, url+"/v1/ranges", &buf)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := serverDo(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var hashes []rangeHash
+	if err := gob.NewDecoder(resp.Body).Decode(&hashes); err != nil {
+		return nil, fmt.Errorf("reading range checksums: %w", err)
+	}
+	return hashes, nil
+}
+
+// pullRanges copies the rows of the ranges of the key column of table from
+// the Server at url to db, a batch of ranges per request.
+func pullRanges(ctx context.Context, cfg Config, url string, db *sql.DB, table Table, key string, ranges []keyRange) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, buildInsertQuery(table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	cols := table.writtenColumns()
+	for start := 0; start < len(ranges); start += rangePullBatch {
+		var conds []string
+		var args []interface{}
+		for _, r := range ranges[start:min(start+rangePullBatch, len(ranges))] {
+			where, rangeArgs := r.where(key)
+			conds = append(conds, "("+where+")")
+			args = append(args, rangeArgs...)
+		}
+		err := pullRows(ctx, cfg, url, table.name, cols, strings.Join(conds, " OR "), args, func(values []interface{}) error {
+			_, err := stmt.ExecContext(ctx, values...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSyncRangeDiff(t *testing.T) {
+	defer func(parts, leaf int) { rangeParts, rangeLeafRows = parts, leaf }(rangeParts, rangeLeafRows)
+	rangeParts, rangeLeafRows = 4, 8
+
+	var srcData, tgtData [][]interface{}
+	for i := 1; i <= 100; i++ {
+		srcData = append(srcData, []interface{}{i, fmt.Sprintf("row %d", i)})
+		switch i {
+		case 10:
+			tgtData = append(tgtData, []interface{}{i, "changed"})
+		case 50:
+		default:
+			tgtData = append(tgtData, []interface{}{i, fmt.Sprintf("row %d", i)})
+		}
+	}
+	tgtData = append(tgtData, []interface{}{200, "orphan"})
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: srcData,
+		tgtData: tgtData,
+	}})
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "", nil)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	url := "rslite://" + strings.TrimPrefix(ts.URL, "http://")
+
+	stats, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, RangeDiff: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	// Only the differing rows were pulled and written: the changed one, and
+	// the last one, sharing its range with the orphan
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 2 || got.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	got, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, srcData) {
+		t.Errorf("target differs from the source: got %v", got)
+	}
+
+	// Nothing differs anymore
+	stats, err = Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, RangeDiff: true})
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 0 || got.Replaced != 0 || got.Deleted != 0 {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+	// The server hashes with the algorithm of the client
+	stats, err = Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, RangeDiff: true, Checksum: ChecksumSHA256})
+	if err != nil {
+		t.Fatalf("Sync() with SHA-256 error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 0 || got.Replaced != 0 || got.Deleted != 0 {
+		t.Errorf("unexpected SHA-256 run stats %+v", got)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, RangeDiff: true}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("local source: error = %v, want ErrInvalidConfig", err)
+	}
+	if _, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, RangeDiff: true, Where: "id > 1"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("filter: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestServerHashRanges(t *testing.T) {
+	srcPath, _, _, _ := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}, {5, "e"}},
+	}})
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	db := openTestDB(t, srcPath)
+	table, err := getTableInfo(t.Context(), db, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cols := []string{"id", "name"}
+	hashes, err := server.hashRanges(t.Context(), table, rangesRequest{Table: "items", Columns: cols, After: wireBound(int64(1)), Parts: 2, Checksum: ChecksumFNV}, "")
+	if err != nil {
+		t.Fatalf("hashRanges() error = %v", err)
+	}
+	if len(hashes) != 2 || hashes[0].Count != 2 || boundValue(hashes[0].Upto) != int64(3) || hashes[1].Count != 2 || hashes[1].Upto != nil {
+		t.Fatalf("unexpected ranges %+v", hashes)
+	}
+	if hashes[0].Checksum != ChecksumFNV || hashes[1].Checksum != ChecksumFNV {
+		t.Errorf("ranges hashed with %q, %q, want %s", hashes[0].Checksum, hashes[1].Checksum, ChecksumFNV)
+	}
+	// The target side hashes the same rows alike
+	n, sum, err := hashRange(t.Context(), db, "items", cols, keyRange{After: int64(3)}, ChecksumFNV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || string(sum) != string(hashes[1].Hash) {
+		t.Errorf("hashRange() = %d, %x, want 2, %x", n, sum, hashes[1].Hash)
+	}
+}
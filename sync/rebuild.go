@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Rebuild replaces the target with a fresh copy of the whole source. The
+// copy is made with VACUUM INTO into a shadow file next to the target,
+// checked with PRAGMA integrity_check and renamed over the target, so readers
+// never see a partial database: connections opened before the rename keep
+// reading the old file. The target keeps its journal mode, and its WAL is
+// checkpointed and truncated before the rename so that it can't be replayed
+// onto the new file. Nothing else may write to the target meanwhile, and in
+// WAL mode the connections left on the old file share its -wal and -shm
+// files with the new one, so they should be reopened.
+//
+// Only SrcDbPath, DstDbPath, SrcImmutable and BusyTimeout are used.
+func Rebuild(ctx context.Context, cfg Config) (*Stats, error) {
+	start := time.Now()
+	stats := &Stats{}
+	defer func() { stats.Duration = time.Since(start) }()
+
+	src, err := openReadOnly(cfg.driver(), cfg.SrcDbPath, sourceParams(cfg))
+	if err != nil {
+		return stats, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	defer src.Close()
+	tables, _, err := getTables(ctx, src)
+	if err != nil {
+		return stats, err
+	}
+
+	target := dbFile(cfg.DstDbPath)
+	shadow := target + ".rebuild"
+	if err := os.Remove(shadow); err != nil && !os.IsNotExist(err) {
+		return stats, fmt.Errorf("removing leftover shadow db: %w", err)
+	}
+	defer os.Remove(shadow) // after a failure, the rename moved it otherwise
+
+	shadowCfg := cfg
+	shadowCfg.DstDbPath = shadow
+	if err := buildShadow(ctx, src, tables, shadowCfg, stats); err != nil {
+		return stats, err
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		mode, err := journalMode(ctx, cfg)
+		if err != nil {
+			return stats, err
+		}
+		if mode == "wal" {
+			if err := setWAL(ctx, cfg.driver(), shadow); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := os.Rename(shadow, target); err != nil {
+		return stats, fmt.Errorf("replacing target db: %w", err)
+	}
+	return stats, nil
+}
+
+// buildShadow copies the source into the shadow file of cfg.DstDbPath and
+// checks its integrity.
+func buildShadow(ctx context.Context, src *sql.DB, tables []Table, cfg Config, stats *Stats) error {
+	db, err := openDB(cfg.driver(), cfg.DstDbPath)
+	if err != nil {
+		return fmt.Errorf("opening shadow db: %w", err)
+	}
+	defer db.Close()
+
+	if err := seedTarget(ctx, src, db, tables, cfg, stats); err != nil {
+		return err
+	}
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check(1)").Scan(&result); err != nil {
+		return fmt.Errorf("checking shadow db: %w", err)
+	}
+	if result != "ok" {
+		return classify(ErrVerification, fmt.Errorf("shadow db failed the integrity check: %s", result))
+	}
+	return nil
+}
+
+// journalMode returns the journal mode of the target, checkpointing and
+// truncating its WAL when it has one.
+func journalMode(ctx context.Context, cfg Config) (string, error) {
+	params := url.Values{}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	db, err := openDB(cfg.driver(), dsn(cfg.DstDbPath, params))
+	if err != nil {
+		return "", classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer db.Close()
+
+	var mode string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+		return "", fmt.Errorf("reading target journal mode: %w", err)
+	}
+	if mode == "wal" {
+		if _, err := db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return "", fmt.Errorf("checkpointing target db: %w", err)
+		}
+	}
+	return mode, nil
+}
+
+func setWAL(ctx context.Context, d driver.Driver, path string) error {
+	db, err := openDB(d, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=WAL"); err != nil {
+		return fmt.Errorf("setting shadow journal mode: %w", err)
+	}
+	return nil
+}
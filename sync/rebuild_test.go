@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestRebuild(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{1, "Drifted"}, {3, "Carol"}},
+	}})
+	if _, err := tgtDB.Exec(`PRAGMA journal_mode=WAL; CREATE TABLE extra (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	tgtDB.Close()
+
+	stats, err := Rebuild(context.Background(), Config{SrcDbPath: srcPath, DstDbPath: tgtPath})
+	if err != nil {
+		t.Fatalf("Rebuild() error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Inserted != 2 {
+		t.Errorf("unexpected stats %+v", stats.Tables)
+	}
+	if _, err := os.Stat(tgtPath + ".rebuild"); !os.IsNotExist(err) {
+		t.Errorf("shadow db left behind: %v", err)
+	}
+
+	db := openTestDB(t, tgtPath)
+	got, err := getTableData(db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice"}, {2, "Bob"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if exists, err := tableExists(context.Background(), db, "extra"); err != nil || exists {
+		t.Errorf("table only the old target had still exists: %v", err)
+	}
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal mode = %q, %v, want wal", mode, err)
+	}
+}
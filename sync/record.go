@@ -0,0 +1,353 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A recording is a JSON lines file with one entry per decision taken during a
+// sync: the run header, then for every table the rows written, the source
+// primary keys kept by the orphan delete, the keys deleted one by one and
+// whether the table was committed.
+// Replaying it against a copy of the target reproduces the run without the
+// source database.
+const (
+	recordRun    = "run"
+	recordTable  = "table"
+	recordRow    = "row"
+	recordKeep   = "keep"
+	recordFlag   = "flag"
+	recordSkip   = "skip"
+	recordSame   = "unchanged"
+	recordDelete = "delete"
+	recordRemove = "remove"
+	recordCommit = "commit"
+	recordError  = "error"
+)
+
+type recordEntry struct {
+	Kind    string        `json:"kind"`
+	Time    *time.Time    `json:"time,omitempty"`
+	Config  *Config       `json:"config,omitempty"`
+	Table   string        `json:"table,omitempty"`
+	PK      string        `json:"pk,omitempty"`
+	Key     []string      `json:"key,omitempty"`
+	Columns []string      `json:"columns,omitempty"`
+	Values  []recordValue `json:"values,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// recordValue keeps the SQLite storage class of a value across the JSON
+// round trip.
+type recordValue struct {
+	V interface{}
+}
+
+type taggedValue struct {
+	Int   *int64     `json:"i,omitempty"`
+	Float *float64   `json:"f,omitempty"`
+	Text  *string    `json:"s,omitempty"`
+	Blob  *[]byte    `json:"b,omitempty"`
+	Time  *time.Time `json:"t,omitempty"`
+}
+
+func (v recordValue) MarshalJSON() ([]byte, error) {
+	var t taggedValue
+	switch x := v.V.(type) {
+	case nil:
+		return []byte("null"), nil
+	case int64:
+		t.Int = &x
+	case float64:
+		t.Float = &x
+	case string:
+		t.Text = &x
+	case []byte:
+		if x == nil {
+			x = []byte{}
+		}
+		t.Blob = &x
+	case bool:
+		n := int64(0)
+		if x {
+			n = 1
+		}
+		t.Int = &n
+	case time.Time:
+		t.Time = &x
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v.V)
+	}
+	return json.Marshal(t)
+}
+
+func (v *recordValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		v.V = nil
+		return nil
+	}
+	var t taggedValue
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	switch {
+	case t.Int != nil:
+		v.V = *t.Int
+	case t.Float != nil:
+		v.V = *t.Float
+	case t.Text != nil:
+		v.V = *t.Text
+	case t.Time != nil:
+		v.V = *t.Time
+	case t.Blob != nil:
+		v.V = *t.Blob
+	default:
+		return fmt.Errorf("unrecognized recorded value %s", data)
+	}
+	return nil
+}
+
+// recorder writes a recording. A nil recorder records nothing, so callers
+// don't have to check whether recording is enabled.
+type recorder struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func newRecorder(path string, cfg Config) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+	r := &recorder{f: f, w: bufio.NewWriter(f)}
+	r.enc = json.NewEncoder(r.w)
+
+	now := time.Now().UTC()
+	r.write(recordEntry{Kind: recordRun, Time: &now, Config: &cfg})
+	return r, r.err
+}
+
+func (r *recorder) write(e recordEntry) {
+	if r == nil || r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(e); err != nil {
+		r.err = fmt.Errorf("writing recording: %w", err)
+	}
+}
+
+func (r *recorder) table(table Table) {
+	r.write(recordEntry{Kind: recordTable, Table: table.name, PK: table.pkCol, Key: table.keyCols, Columns: table.columns})
+}
+
+func (r *recorder) row(table string, values []interface{}) {
+	if r == nil {
+		return
+	}
+	rv := make([]recordValue, len(values))
+	for i, v := range values {
+		rv[i] = recordValue{v}
+	}
+	r.write(recordEntry{Kind: recordRow, Table: table, Values: rv})
+}
+
+func (r *recorder) flag(table string, issue RowIssue) {
+	r.write(recordEntry{Kind: recordFlag, Table: table, Values: []recordValue{{issue.PK}}, Error: issue.Reason})
+}
+
+func (r *recorder) skip(table string) {
+	r.write(recordEntry{Kind: recordSkip, Table: table})
+}
+
+func (r *recorder) unchanged(table string) {
+	r.write(recordEntry{Kind: recordSame, Table: table})
+}
+
+func (r *recorder) keep(table string, key []interface{}) {
+	values := make([]recordValue, len(key))
+	for i, v := range key {
+		values[i] = recordValue{v}
+	}
+	r.write(recordEntry{Kind: recordKeep, Table: table, Values: values})
+}
+
+func (r *recorder) deleteOrphans(table string) {
+	r.write(recordEntry{Kind: recordDelete, Table: table})
+}
+
+func (r *recorder) remove(table string, key []interface{}) {
+	values := make([]recordValue, len(key))
+	for i, v := range key {
+		values[i] = recordValue{v}
+	}
+	r.write(recordEntry{Kind: recordRemove, Table: table, Values: values})
+}
+
+// done records how the table ended: committed, or the error that aborted it.
+func (r *recorder) done(table string, err error) {
+	if err != nil {
+		r.write(recordEntry{Kind: recordError, Table: table, Error: err.Error()})
+		return
+	}
+	r.write(recordEntry{Kind: recordCommit, Table: table})
+}
+
+// close flushes the recording and returns the first error seen while writing.
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("writing recording: %w", err)
+	}
+	if err := r.f.Close(); err != nil && r.err == nil {
+		r.err = fmt.Errorf("writing recording: %w", err)
+	}
+	return r.err
+}
+
+// Replay applies a recording made with Config.RecordPath to the database at
+// dstPath, taking the same decisions the recorded run took. It is meant to be
+// run against a copy of the original target to debug a problematic sync.
+//
+// Tables the recorded run failed on are replayed up to the failure point and
+// rolled back, and Replay reports the recorded error.
+func Replay(ctx context.Context, recordingPath, dstPath string) (*Stats, error) {
+	start := time.Now()
+	stats := &Stats{}
+	defer func() { stats.Duration = time.Since(start) }()
+
+	f, err := os.Open(recordingPath)
+	if err != nil {
+		return stats, fmt.Errorf("opening recording: %w", err)
+	}
+	defer f.Close()
+
+	dst, err := sql.Open("sqlite3", dstPath)
+	if err != nil {
+		return stats, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer func() { dst.Close() }()
+
+	var (
+		cfg Config // of the recorded run
+		w   *tableWriter
+	)
+	defer func() {
+		if w != nil {
+			w.close()
+		}
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		var e recordEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("reading recording entry %d: %w", line, err)
+		}
+
+		if e.Kind != recordRun && e.Kind != recordTable && (w == nil || w.table.name != e.Table) {
+			return stats, fmt.Errorf("recording entry %d: %s for table %q outside of its table", line, e.Kind, e.Table)
+		}
+
+		switch e.Kind {
+		case recordRun:
+			if e.Config != nil {
+				cfg = *e.Config
+			}
+			// Externalized BLOBs go next to the replayed target
+			cfg.DstDbPath = dstPath
+			if len(cfg.Extensions) > 0 {
+				dst.Close()
+				if dst, err = openDB(cfg.driver(), dstPath); err != nil {
+					return stats, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+				}
+			}
+		case recordTable:
+			if w != nil {
+				return stats, fmt.Errorf("recording entry %d: table %s starts before %s ended", line, e.Table, w.table.name)
+			}
+			table := Table{name: e.Table, pkCol: e.PK, keyCols: e.Key, columns: e.Columns, target: cfg.TableOptions[e.Table].Target}
+			if len(table.keyCols) == 0 {
+				table.keyCols = []string{e.PK}
+			}
+			if w, err = newTableWriter(ctx, dst, nil, table, cfg, ""); err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+		case recordRow:
+			values := make([]interface{}, len(e.Values))
+			for i, v := range e.Values {
+				values[i] = v.V
+			}
+			if err := w.upsert(ctx, values); err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+		case recordFlag:
+			if len(e.Values) != 1 {
+				return stats, fmt.Errorf("recording entry %d: flag needs exactly one key", line)
+			}
+			w.flag(RowIssue{PK: e.Values[0].V, Reason: e.Error})
+		case recordSkip:
+			w.skip()
+		case recordSame:
+			w.skipUnchangedRow()
+		case recordKeep:
+			if len(e.Values) != len(w.table.keyCols) {
+				return stats, fmt.Errorf("recording entry %d: keep needs a value for each key column", line)
+			}
+			key := make([]interface{}, len(e.Values))
+			for i, v := range e.Values {
+				key[i] = v.V
+			}
+			if err := w.keep(ctx, key); err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+		case recordDelete:
+			if err := w.deleteOrphans(ctx); err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+		case recordRemove:
+			if len(e.Values) != len(w.table.keyCols) {
+				return stats, fmt.Errorf("recording entry %d: remove needs a value for each key column", line)
+			}
+			key := make([]interface{}, len(e.Values))
+			for i, v := range e.Values {
+				key[i] = v.V
+			}
+			if err := w.deleteKey(ctx, key); err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+		case recordCommit:
+			tableStats, err := w.commit(ctx)
+			if err != nil {
+				return stats, fmt.Errorf("replaying table %s: %w", e.Table, err)
+			}
+			w.close()
+			w = nil
+			stats.Tables = append(stats.Tables, tableStats)
+		case recordError:
+			return stats, fmt.Errorf("recorded run failed on table %s: %s", e.Table, e.Error)
+		default:
+			return stats, fmt.Errorf("recording entry %d: unknown kind %q", line, e.Kind)
+		}
+	}
+
+	if w != nil {
+		return stats, fmt.Errorf("recording ends before table %s was committed", w.table.name)
+	}
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	tables := []testTable{{
+		name:   "items",
+		schema: `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL, data BLOB)`,
+		srcData: [][]interface{}{
+			{1, "Item 1", 10.5, []byte{0x00, 0xff}},
+			{2, "Item 2", nil, []byte{}},
+			{4, "Item 4", 40.0, nil},
+		},
+		tgtData: [][]interface{}{
+			{1, "Old Item 1", 9.0, nil},
+			{3, "Item 3", 30.0, nil},
+		},
+	}}
+
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	// Keep a pristine copy of the target to replay against
+	copyPath := filepath.Join(t.TempDir(), "copy.db")
+	if err := copyFile(tgtPath, copyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	recPath := filepath.Join(t.TempDir(), "run.rec")
+	want, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, RecordPath: recPath})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	got, err := Replay(context.Background(), recPath, copyPath)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(got.Tables) != 1 || len(want.Tables) != 1 {
+		t.Fatalf("got %d replayed tables, want 1", len(got.Tables))
+	}
+	g, w := got.Tables[0], want.Tables[0]
+	if g.Inserted != w.Inserted || g.Replaced != w.Replaced || g.Deleted != w.Deleted {
+		t.Errorf("replay stats %+v differ from recorded run %+v", g, w)
+	}
+
+	synced, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := openTestDB(t, copyPath)
+	replayedData, err := getTableData(replayed, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(replayedData, synced) {
+		t.Errorf("replayed data %v differs from synced data %v", replayedData, synced)
+	}
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,260 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remotePath splits a remote path like ssh://user@host:2222/data/app.db or
+// user@host:/data/app.db into its host, as given to ssh, and the path on the
+// host. Like with scp, a path without the ssh:// scheme is remote when a
+// colon comes before any slash, other than the one of a file: URI or a
+// Windows drive, and no local file has that name: backup:2024.db is local
+// when it exists, and ./backup:2024.db always is.
+func remotePath(path string) (host, file string, ok bool) {
+	if rest, ok := strings.CutPrefix(path, "ssh://"); ok {
+		host, file, _ = strings.Cut(rest, "/")
+		if host == "" || file == "" {
+			return "", "", false
+		}
+		return "ssh://" + host, "/" + file, true
+	}
+	host, file, ok = strings.Cut(path, ":")
+	if !ok || len(host) < 2 || host == "file" || strings.ContainsAny(host, `/\`) || file == "" || strings.HasPrefix(file, "//") {
+		return "", "", false
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", "", false
+	}
+	return host, file, true
+}
+
+// isRemote reports whether a database path is an SSH path or a URL.
+func isRemote(path string) bool {
+	_, _, ok := remotePath(path)
+	return ok || isHTTP(path) || isObject(path) || isServer(path) || isLibSQL(path)
+}
+
+// hasRemotePaths reports whether a database of cfg is remote.
+func hasRemotePaths(cfg Config) bool {
+	for _, path := range append([]string{cfg.SrcDbPath, cfg.DstDbPath}, cfg.UnionSources...) {
+		if isRemote(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncRemote runs syncLocal on local copies of the remote databases of cfg,
+// and uploads the target back when it was changed.
+func syncRemote(ctx context.Context, cfg Config) (*Stats, error) {
+	start := time.Now()
+	stats := &Stats{}
+	remoteTarget := isRemote(cfg.DstDbPath)
+	if remoteTarget && cfg.ExternalizeBlobs > 0 && cfg.BlobDir == "" {
+		return stats, classify(ErrInvalidConfig, errors.New("externalized BLOBs of a remote target need a blob directory"))
+	}
+
+	dir, err := os.MkdirTemp("", "rslite-remote-")
+	if err != nil {
+		return stats, err
+	}
+	defer os.RemoveAll(dir)
+
+	local := cfg
+	// The target first, for RangeDiff to compare the source with it
+	if remoteTarget {
+		local.DstDbPath = filepath.Join(dir, "target.db")
+		if err := downloadTarget(ctx, cfg, cfg.DstDbPath, local.DstDbPath); err != nil {
+			return stats, classify(ErrOpenTarget, fmt.Errorf("downloading target db: %w", err))
+		}
+	}
+	// SyncTargets already read the sources
+	if len(cfg.snapshots) == 0 {
+		if local, err = fetchSources(ctx, local, dir); err != nil {
+			return stats, err
+		}
+	}
+	url, server := serverURL(cfg.SrcDbPath)
+	if server {
+		local = pulledConfig(local)
+	}
+
+	stats, err = syncLocal(ctx, local)
+	if server && err == nil && !cfg.NoDelete && !cfg.Simulate {
+		err = deleteServerOrphans(ctx, cfg, url, local.sourcePath(), local.DstDbPath, local.pulled, stats)
+	}
+	// Upload the tables committed before a failure too, as a local target
+	// keeps them
+	if remoteTarget && !cfg.Simulate && (err == nil || len(stats.Tables) > 0) {
+		if uploadErr := uploadTarget(ctx, cfg, local.DstDbPath, cfg.DstDbPath); uploadErr != nil {
+			err = errors.Join(err, fmt.Errorf("uploading target db: %w", uploadErr))
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, err
+}
+
+// fetchSources downloads the remote databases among cfg.SrcDbPath and
+// UnionSources into dir, or the cache directory for URLs, and returns cfg
+// reading the copies instead.
+func fetchSources(ctx context.Context, cfg Config, dir string) (Config, error) {
+	paths := append([]string{cfg.SrcDbPath}, cfg.UnionSources...)
+	for i, path := range paths {
+		if url, ok := serverURL(path); ok {
+			// validate took servers as the only source
+			paths[i] = filepath.Join(dir, "server.db")
+			var err error
+			if cfg.pulled, err = pullServer(ctx, cfg, url, paths[i]); err != nil {
+				return cfg, classify(ErrOpenSource, fmt.Errorf("pulling source db: %w", err))
+			}
+			continue
+		}
+		if isLibSQL(path) {
+			paths[i] = filepath.Join(dir, fmt.Sprintf("libsql-%d.db", i))
+			if err := downloadLibSQL(ctx, cfg, path, paths[i]); err != nil {
+				return cfg, classify(ErrOpenSource, fmt.Errorf("downloading source db: %w", err))
+			}
+			continue
+		}
+		if isHTTP(path) || isObject(path) {
+			fetch := fetchHTTP
+			if isObject(path) {
+				fetch = fetchObject
+			}
+			var err error
+			if paths[i], err = fetch(ctx, cfg, path); err != nil {
+				return cfg, classify(ErrOpenSource, fmt.Errorf("downloading source db: %w", err))
+			}
+			continue
+		}
+		host, file, ok := remotePath(path)
+		if !ok {
+			continue
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("remote-%d.db", i))
+		exists, err := download(ctx, cfg, host, file, paths[i])
+		if err == nil && !exists {
+			err = fmt.Errorf("%s: no such file", path)
+		}
+		if err != nil {
+			return cfg, classify(ErrOpenSource, fmt.Errorf("downloading source db: %w", err))
+		}
+	}
+	cfg.SrcDbPath, cfg.UnionSources = paths[0], paths[1:]
+	return cfg, nil
+}
+
+// downloadTarget downloads the remote target at path to local, leaving
+// nothing there when it doesn't exist yet.
+func downloadTarget(ctx context.Context, cfg Config, path, local string) error {
+	if isLibSQL(path) {
+		return downloadLibSQLTarget(ctx, cfg, path, local)
+	}
+	if isObject(path) {
+		_, err := downloadObject(ctx, cfg, path, local)
+		return err
+	}
+	host, file, _ := remotePath(path)
+	_, err := download(ctx, cfg, host, file, local)
+	return err
+}
+
+// uploadTarget uploads local in place of the remote target at path.
+func uploadTarget(ctx context.Context, cfg Config, local, path string) error {
+	if isLibSQL(path) {
+		return pushLibSQL(ctx, cfg, local, path)
+	}
+	if isObject(path) {
+		return uploadObject(ctx, cfg, local, path)
+	}
+	host, file, _ := remotePath(path)
+	return upload(ctx, cfg, local, host, file)
+}
+
+// remoteMissing is the exit status of the download script when the remote
+// file doesn't exist, ssh itself exiting with 255 on failure.
+const remoteMissing = 66
+
+// download copies file from host to local, and reports whether it exists.
+// Nothing is left at local when it doesn't.
+func download(ctx context.Context, cfg Config, host, file, local string) (bool, error) {
+	q := shellQuote(file)
+	cmd, err := cfg.ssh(ctx, host, fmt.Sprintf("[ -e %s ] || exit %d; exec cat -- %s", q, remoteMissing, q))
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Create(local)
+	if err != nil {
+		return false, err
+	}
+	cmd.Stdout = f
+	err = runSSH(cmd)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == remoteMissing {
+		return false, os.Remove(local)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s:%s: %w", host, file, err)
+	}
+	return true, nil
+}
+
+// upload copies local to file on host, writing a temporary file next to it
+// first, renamed over it once complete.
+func upload(ctx context.Context, cfg Config, local, host, file string) error {
+	q, tmp := shellQuote(file), shellQuote(file+".rslite-upload")
+	cmd, err := cfg.ssh(ctx, host, fmt.Sprintf("cat > %s && mv -f %s %s", tmp, tmp, q))
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd.Stdin = f
+	if err := runSSH(cmd); err != nil {
+		return fmt.Errorf("%s:%s: %w", host, file, err)
+	}
+	return nil
+}
+
+// ssh returns the command running script on host with Config.SSHCommand,
+// failing on a host ssh could take for an option.
+func (cfg Config) ssh(ctx context.Context, host, script string) (*exec.Cmd, error) {
+	if strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+	args := strings.Fields(cfg.SSHCommand)
+	if len(args) == 0 {
+		args = []string{"ssh"}
+	}
+	return exec.CommandContext(ctx, args[0], append(args[1:], "--", host, script)...), nil
+}
+
+// runSSH runs cmd, adding what it wrote to stderr to its error.
+func runSSH(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// shellQuote quotes s for the POSIX shell running the remote commands.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
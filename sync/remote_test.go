@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemotePath(t *testing.T) {
+	for path, want := range map[string][2]string{
+		"user@host:/data/app.db":           {"user@host", "/data/app.db"},
+		"host:app.db":                      {"host", "app.db"},
+		"app.db":                           {},
+		"./dir:1/app.db":                   {},
+		"file:app.db?mode=ro":              {},
+		`C:\data\app.db`:                   {},
+		":memory:":                         {},
+		"host:":                            {},
+		"s3://bucket/app.db":               {},
+		"ssh://user@host:2222/data/app.db": {"ssh://user@host:2222", "/data/app.db"},
+		"ssh://host":                       {},
+	} {
+		host, file, ok := remotePath(path)
+		if ok != (want[0] != "") || host != want[0] || file != want[1] {
+			t.Errorf("remotePath(%q) = %q, %q, %v, want %q, %q", path, host, file, ok, want[0], want[1])
+		}
+	}
+
+	// A local file named like a remote path
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("backup:2024.db", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if host, file, ok := remotePath("backup:2024.db"); ok {
+		t.Errorf("remotePath() of a local file = %q, %q", host, file)
+	}
+}
+
+// fakeSSH writes a script standing for ssh, running the remote commands
+// locally, and returns its path.
+func fakeSSH(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n[ \"$1\" = -- ] && shift 2 || exit 255\nexec sh -c \"$1\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSyncRemote(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{3, "Carol"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	ssh := fakeSSH(t)
+
+	stats, err := Sync(Config{SrcDbPath: "edge:" + srcPath, DstDbPath: "backup:" + tgtPath, SSHCommand: ssh})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if total := stats.Total(); total.Inserted != 2 || total.Deleted != 1 {
+		t.Errorf("stats = %+v, want 2 inserted and 1 deleted", total)
+	}
+	if n := countTestRows(t, openTestDB(t, tgtPath), "users"); n != 2 {
+		t.Errorf("uploaded target has %d users, want 2", n)
+	}
+	if _, err := os.Stat(tgtPath + ".rslite-upload"); !os.IsNotExist(err) {
+		t.Errorf("upload left its temporary file: %v", err)
+	}
+
+	// A missing remote target is created, given as an ssh:// URL
+	newPath := filepath.Join(t.TempDir(), "new.db")
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: "ssh://backup" + newPath, SSHCommand: ssh}); err != nil {
+		t.Fatalf("Sync() to a new target error = %v", err)
+	}
+	if n := countTestRows(t, openTestDB(t, newPath), "users"); n != 2 {
+		t.Errorf("new target has %d users, want 2", n)
+	}
+
+	_, err = Sync(Config{SrcDbPath: "edge:" + filepath.Join(t.TempDir(), "missing.db"), DstDbPath: tgtPath, SSHCommand: ssh})
+	if !errors.Is(err, ErrOpenSource) {
+		t.Errorf("missing remote source: error = %v, want ErrOpenSource", err)
+	}
+	_, err = Sync(Config{SrcDbPath: "edge:" + srcPath, DstDbPath: tgtPath, SSHCommand: "false"})
+	if !errors.Is(err, ErrOpenSource) {
+		t.Errorf("failing ssh: error = %v, want ErrOpenSource", err)
+	}
+	// Never passed to ssh as an option
+	_, err = Sync(Config{SrcDbPath: "-oProxyCommand=touch pwned:" + srcPath, DstDbPath: tgtPath, SSHCommand: ssh})
+	if err == nil || !strings.Contains(err.Error(), "invalid host") {
+		t.Errorf("host starting with a dash: error = %v, want an invalid host", err)
+	}
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Report is the document written to Config.ReportPath at the end of a run,
+// for tools that need the outcome of a sync without parsing its output.
+type Report struct {
+	Source     string        `json:"source"`
+	Target     string        `json:"target"`
+	Started    time.Time     `json:"started"`
+	DurationMS int64         `json:"duration_ms"`
+	Success    bool          `json:"success"`
+	Errors     []string      `json:"errors,omitempty"`
+	Tables     []TableReport `json:"tables"`
+	Total      TableReport   `json:"total"`
+	Files      struct {
+		Copied  int64 `json:"copied"`
+		Deleted int64 `json:"deleted"`
+	} `json:"files"`
+	Schema struct {
+		Created  int64 `json:"created"`
+		Replaced int64 `json:"replaced"`
+		Dropped  int64 `json:"dropped"`
+	} `json:"schema"`
+	Migration struct {
+		Columns    int64 `json:"columns"`
+		Indexes    int64 `json:"indexes"`
+		Backfilled int64 `json:"backfilled"`
+	} `json:"migration"`
+	DroppedTables []string              `json:"dropped_tables,omitempty"`
+	Corruption    []IntegrityIssue      `json:"corruption,omitempty"`
+	Verified      []Verification        `json:"verified,omitempty"`
+	Violations    []ForeignKeyViolation `json:"violations,omitempty"`
+	Unsupported   []Unsupported         `json:"unsupported,omitempty"`
+
+	// Config is the configuration the run was given.
+	Config Config `json:"config"`
+}
+
+// TableReport holds the outcome of a single table in a Report. Tables that
+// failed only have Table and Error set.
+type TableReport struct {
+	Table      string `json:"table"`
+	Inserted   int64  `json:"inserted"`
+	Updated    int64  `json:"updated"`
+	Deleted    int64  `json:"deleted"`
+	Skipped    int64  `json:"skipped"`
+	Flagged    int64  `json:"flagged"`
+	Unchanged  int64  `json:"unchanged"`
+	Retries    int    `json:"retries"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newReport builds the Report of a run.
+func newReport(cfg Config, start time.Time, stats *Stats, err error) *Report {
+	r := &Report{
+		Source:        cfg.SrcDbPath,
+		Target:        cfg.DstDbPath,
+		Started:       start,
+		DurationMS:    stats.Duration.Milliseconds(),
+		Success:       err == nil,
+		Tables:        []TableReport{},
+		Total:         tableReport(stats.Total()),
+		DroppedTables: stats.DroppedTables,
+		Corruption:    stats.Corruption,
+		Verified:      stats.Verified,
+		Violations:    stats.Violations,
+		Unsupported:   stats.Unsupported,
+		Config:        cfg,
+	}
+	r.Files.Copied = stats.Files.Copied
+	r.Files.Deleted = stats.Files.Deleted
+	r.Schema.Created = stats.Schema.Created
+	r.Schema.Replaced = stats.Schema.Replaced
+	r.Schema.Dropped = stats.Schema.Dropped
+	r.Migration.Columns = stats.Migration.Columns
+	r.Migration.Indexes = stats.Migration.Indexes
+	r.Migration.Backfilled = stats.Migration.Backfilled
+	for _, t := range stats.Tables {
+		r.Tables = append(r.Tables, tableReport(t))
+	}
+	for _, e := range splitErrors(err) {
+		r.Errors = append(r.Errors, e.Error())
+		var te *TableError
+		if errors.As(e, &te) {
+			r.Tables = append(r.Tables, TableReport{Table: te.Table, Error: te.Err.Error()})
+		}
+	}
+	return r
+}
+
+func tableReport(t TableStats) TableReport {
+	return TableReport{
+		Table:      t.Table,
+		Inserted:   t.Inserted,
+		Updated:    t.Replaced,
+		Deleted:    t.Deleted,
+		Skipped:    t.Skipped,
+		Flagged:    t.Flagged,
+		Unchanged:  t.Unchanged,
+		Retries:    t.Retries,
+		DurationMS: t.Duration.Milliseconds(),
+	}
+}
+
+// splitErrors returns the errors joined by errors.Join in err, or err alone.
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, splitErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+// writeJSONFile replaces the file at path with v encoded as indented JSON,
+// through a rename so an interrupted write doesn't leave a truncated file
+// behind.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
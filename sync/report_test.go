@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncReport(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "a",
+			schema:  `CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{2, "Bobby"}, {3, "Carol"}},
+		},
+		{
+			name:    "b",
+			schema:  `CREATE TABLE b (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+
+	cfg := Config{
+		SrcDbPath:  srcPath,
+		DstDbPath:  tgtPath,
+		TableWhere: map[string]string{"b": "no_such_column = 1"},
+		KeepGoing:  true,
+		ReportPath: reportPath,
+	}
+	if _, err := Sync(cfg); err == nil {
+		t.Fatal("Sync() error = nil, want table b failing")
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, data)
+	}
+	if report.Success || len(report.Errors) != 1 {
+		t.Errorf("Success = %v, Errors = %q, want the failure of b", report.Success, report.Errors)
+	}
+	if report.Config.ReportPath != reportPath || report.Source != srcPath {
+		t.Errorf("report lacks the configuration: %s", data)
+	}
+	if len(report.Tables) != 2 {
+		t.Fatalf("Tables = %+v, want a and b", report.Tables)
+	}
+	a, b := report.Tables[0], report.Tables[1]
+	if a.Table != "a" || a.Inserted != 1 || a.Updated != 1 || a.Deleted != 1 || a.Error != "" {
+		t.Errorf("table a = %+v, want 1 inserted, 1 updated and 1 deleted", a)
+	}
+	if b.Table != "b" || b.Error == "" {
+		t.Errorf("table b = %+v, want its error", b)
+	}
+	if report.Total.Inserted != 1 || report.Total.Deleted != 1 {
+		t.Errorf("Total = %+v, want the sum of the tables", report.Total)
+	}
+}
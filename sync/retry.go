@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// retryBackoff is the wait before the first retry of a table, doubled for
+// every following one up to maxRetryBackoff.
+const (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+// isBusy reports whether err comes from a database locked by another
+// connection, which may succeed when tried again.
+func isBusy(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+// withRetries calls sync until it succeeds, fails with an error other than
+// isBusy, or Config.Retries retries were made, waiting longer before each
+// retry. A table synced again starts over, rows committed by earlier batches
+// are written again.
+func withRetries(ctx context.Context, cfg Config, sync func() (TableStats, error)) (TableStats, error) {
+	wait := retryBackoff
+	for retries := 0; ; retries++ {
+		stats, err := sync()
+		stats.Retries = retries
+		if err == nil || retries >= cfg.Retries || !isBusy(err) {
+			return stats, err
+		}
+
+		cfg.logger().WarnContext(ctx, "retrying table on a locked database",
+			"table", stats.Table, "retry", retries+1, "wait", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return stats, err
+		case <-timer.C:
+		}
+		wait = min(wait*2, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// WatchSchedule is like Watch, but syncs at the times of a cron schedule,
+// five fields in the local time zone like "*/5 * * * *", and not right away.
+func WatchSchedule(ctx context.Context, cfg Config, schedule string, fn func(*Stats, error)) error {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return classify(ErrInvalidConfig, fmt.Errorf("invalid schedule %q: %w", schedule, err))
+	}
+	return watch(ctx, cfg, false, fn, func(ctx context.Context) error {
+		timer := time.NewTimer(time.Until(sched.Next(time.Now())))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	})
+}
+
+// ScheduledJob is a sync run on a cron schedule, see LoadScheduledJobs.
+type ScheduledJob struct {
+	Name     string
+	Schedule string
+	Config   Config
+}
+
+// LoadScheduledJobs reads the sync jobs of a YAML configuration file laid
+// out as:
+//
+//	jobs:
+//	  replica:
+//	    source: app.db
+//	    target: replica.db
+//	    schedule: "*/5 * * * *"
+//	    max_delete_fraction: 0.2
+//	  archive:
+//	    source: app.db
+//	    target: archive.db
+//	    schedule: "0 3 * * *"
+//	    tables: [orders, invoices]
+//	    exclude_tables: ["*_tmp"]
+//	    where: created_at < date('now', '-1 year')
+//	    no_delete: true
+//	    state: archive.state
+//	    config: archive-tables.yaml
+//	    history: true
+//
+// config names a file of per-table settings, see LoadTableOptions, and
+// history records the runs in the target, see Config.History. The jobs are
+// returned sorted by name.
+func LoadScheduledJobs(path string) ([]ScheduledJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var file struct {
+		Jobs map[string]JobSpec `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("parsing config %s: no jobs", path)
+	}
+
+	var jobs []ScheduledJob
+	for name, spec := range file.Jobs {
+		job, err := spec.Job(name)
+		if err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+// JobSpec is a job of a job file, see LoadScheduledJobs, or of a request
+// creating one through a JobManager.
+type JobSpec struct {
+	Source        string   `yaml:"source" json:"source"`
+	Target        string   `yaml:"target" json:"target"`
+	Schedule      string   `yaml:"schedule" json:"schedule"`
+	Tables        []string `yaml:"tables" json:"tables,omitempty"`
+	ExcludeTables []string `yaml:"exclude_tables" json:"exclude_tables,omitempty"`
+	Where         string   `yaml:"where" json:"where,omitempty"`
+	NoDelete      bool     `yaml:"no_delete" json:"no_delete,omitempty"`
+	MaxDeletes    float64  `yaml:"max_delete_fraction" json:"max_delete_fraction,omitempty"`
+	State         string   `yaml:"state" json:"state,omitempty"`
+	Config        string   `yaml:"config" json:"config,omitempty"`
+	History       bool     `yaml:"history" json:"history,omitempty"`
+}
+
+// Job returns the ScheduledJob of spec called name, checking its schedule
+// and reading its file of per-table settings.
+func (spec JobSpec) Job(name string) (ScheduledJob, error) {
+	if spec.Source == "" || spec.Target == "" || spec.Schedule == "" {
+		return ScheduledJob{}, fmt.Errorf("job %s needs a source, a target and a schedule", name)
+	}
+	if _, err := cron.ParseStandard(spec.Schedule); err != nil {
+		return ScheduledJob{}, fmt.Errorf("job %s: invalid schedule %q: %w", name, spec.Schedule, err)
+	}
+	job := ScheduledJob{
+		Name:     name,
+		Schedule: spec.Schedule,
+		Config: Config{
+			SrcDbPath:         spec.Source,
+			DstDbPath:         spec.Target,
+			Tables:            spec.Tables,
+			ExcludeTables:     spec.ExcludeTables,
+			Where:             spec.Where,
+			NoDelete:          spec.NoDelete,
+			MaxDeleteFraction: spec.MaxDeletes,
+			StatePath:         spec.State,
+			History:           spec.History,
+		},
+	}
+	if spec.Config != "" {
+		var err error
+		if job.Config.TableOptions, err = LoadTableOptions(spec.Config); err != nil {
+			return ScheduledJob{}, fmt.Errorf("job %s: %w", name, err)
+		}
+	}
+	return job, nil
+}
+
+// RunScheduledJobs runs every job on its schedule with WatchSchedule, until
+// ctx is done or one of them fails to start, which stops the others. fn is
+// called with the outcome of every run, concurrently for different jobs.
+func RunScheduledJobs(ctx context.Context, jobs []ScheduledJob, fn func(job string, stats *Stats, err error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(jobs))
+	for _, job := range jobs {
+		go func() {
+			err := WatchSchedule(ctx, job.Config, job.Schedule, func(stats *Stats, err error) {
+				fn(job.Name, stats, err)
+			})
+			if err != nil {
+				err = fmt.Errorf("job %s: %w", job.Name, err)
+			}
+			errs <- err
+		}()
+	}
+
+	var first error
+	for range jobs {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
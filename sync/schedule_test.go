@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestLoadScheduledJobs(t *testing.T) {
+	dir := t.TempDir()
+	tablesPath := filepath.Join(dir, "tables.yaml")
+	if err := os.WriteFile(tablesPath, []byte("tables:\n  users:\n    where: active = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "jobs.yaml")
+	writeJobs := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeJobs(`
+jobs:
+  replica:
+    source: app.db
+    target: replica.db
+    schedule: "*/5 * * * *"
+    max_delete_fraction: 0.2
+  archive:
+    source: app.db
+    target: archive.db
+    schedule: "0 3 * * *"
+    tables: [users]
+    no_delete: true
+    config: ` + tablesPath + `
+`)
+	jobs, err := LoadScheduledJobs(path)
+	if err != nil {
+		t.Fatalf("LoadScheduledJobs() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Name != "archive" || jobs[1].Name != "replica" {
+		t.Fatalf("jobs = %+v, want archive and replica", jobs)
+	}
+	archive := jobs[0]
+	if archive.Schedule != "0 3 * * *" || archive.Config.SrcDbPath != "app.db" || archive.Config.DstDbPath != "archive.db" ||
+		!archive.Config.NoDelete || archive.Config.TableOptions["users"].Where != "active = 1" {
+		t.Errorf("archive = %+v, want its settings", archive)
+	}
+	if got := jobs[1].Config.MaxDeleteFraction; got != 0.2 {
+		t.Errorf("replica delete fraction = %v, want 0.2", got)
+	}
+
+	writeJobs("jobs:\n  bad:\n    source: a.db\n    target: b.db\n    schedule: every minute\n")
+	if _, err := LoadScheduledJobs(path); err == nil {
+		t.Error("invalid schedule: error = nil")
+	}
+	writeJobs("jobs:\n  bad:\n    source: a.db\n    schedule: '* * * * *'\n")
+	if _, err := LoadScheduledJobs(path); err == nil {
+		t.Error("job without a target: error = nil")
+	}
+}
+
+func TestRunScheduledJobs(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	jobs := []ScheduledJob{
+		{Name: "a", Schedule: "@every 1s", Config: Config{SrcDbPath: srcPath, DstDbPath: tgtPath}},
+		{Name: "b", Schedule: "@every 1s", Config: Config{SrcDbPath: srcPath, DstDbPath: otherPath}},
+	}
+	var (
+		mu   gosync.Mutex
+		runs = make(map[string]int)
+	)
+	err := RunScheduledJobs(ctx, jobs, func(job string, stats *Stats, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			t.Errorf("job %s: error = %v", job, err)
+		}
+		if runs[job]++; runs["a"] > 0 && runs["b"] > 0 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("RunScheduledJobs() error = %v", err)
+	}
+	if runs["a"] == 0 || runs["b"] == 0 {
+		t.Errorf("runs = %v, want both jobs run", runs)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("users has %d rows, want Alice synced", n)
+	}
+
+	// Jobs sharing a target take turns
+	runs = make(map[string]int)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := range jobs {
+		jobs[i].Config.DstDbPath = tgtPath
+		jobs[i].Config.WaitLock = 5 * time.Second
+	}
+	err = RunScheduledJobs(ctx, jobs, func(job string, stats *Stats, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			t.Errorf("job %s on a shared target: error = %v", job, err)
+		}
+		if runs[job]++; runs["a"] > 0 && runs["b"] > 0 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("RunScheduledJobs() on a shared target: error = %v", err)
+	}
+	if runs["a"] == 0 || runs["b"] == 0 {
+		t.Errorf("runs on a shared target = %v, want both jobs run", runs)
+	}
+}
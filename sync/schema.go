@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaStats counts the views, indexes and triggers written to the target,
+// see Config.SchemaObjects.
+type SchemaStats struct {
+	Created  int64
+	Replaced int64 // dropped and created again with the source definition
+	Dropped  int64
+}
+
+// schemaObject is a view, index or trigger of a database.
+type schemaObject struct {
+	typ   string
+	name  string
+	table string // the table or view of an index or trigger
+	ddl   string
+}
+
+// listObjects returns the views, indexes and triggers of db synced along
+// tables, in creation order: the views selected by Config.Tables, and the
+// indexes and triggers of those views and of the tables not renamed in the
+// target, whose definitions name the source table. The automatic indexes
+// of the constraints and the change tracking triggers are left out.
+func listObjects(ctx context.Context, db queryer, tables []Table, cfg Config) ([]schemaObject, error) {
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE type IN ('view', 'index', 'trigger') AND sql IS NOT NULL AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []schemaObject
+	for rows.Next() {
+		var o schemaObject
+		if err := rows.Scan(&o.typ, &o.name, &o.table, &o.ddl); err != nil {
+			return nil, err
+		}
+		all = append(all, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tables and views whose indexes and triggers are synced
+	owners := make(map[string]bool)
+	for _, t := range tables {
+		if t.targetName() == t.name {
+			owners[strings.ToLower(t.name)] = true
+		}
+	}
+	for _, o := range all {
+		if o.typ == "view" && cfg.selects(o.name) {
+			owners[strings.ToLower(o.name)] = true
+		}
+	}
+	var objects []schemaObject
+	for _, o := range all {
+		if owners[strings.ToLower(o.table)] && !strings.HasPrefix(o.name, trackPrefix) {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+// syncSchemaObjects creates the views, indexes and triggers of the source
+// missing from the target, or defined differently there, and with
+// Config.DropSchemaObjects drops the target ones the source lacks, in one
+// transaction.
+func syncSchemaObjects(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config, stats *SchemaStats) error {
+	want, err := listObjects(ctx, src, tables, cfg)
+	if err != nil {
+		return fmt.Errorf("listing source schema objects: %w", err)
+	}
+	have, err := listObjects(ctx, dst, tables, cfg)
+	if err != nil {
+		return fmt.Errorf("listing target schema objects: %w", err)
+	}
+	wanted := make(map[string]schemaObject, len(want))
+	for _, o := range want {
+		wanted[strings.ToLower(o.name)] = o
+	}
+	held := make(map[string]bool, len(have))
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	log := cfg.logger()
+	// Dependents first, the reverse of their creation
+	for i := len(have) - 1; i >= 0; i-- {
+		o := have[i]
+		w, ok := wanted[strings.ToLower(o.name)]
+		switch {
+		case ok && w.typ == o.typ && w.ddl == o.ddl:
+			held[strings.ToLower(o.name)] = true
+			continue
+		case !ok && !cfg.DropSchemaObjects:
+			continue
+		case !ok:
+			stats.Dropped++
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP %s %s", strings.ToUpper(o.typ), quoteIdent(o.name))); err != nil {
+			return fmt.Errorf("dropping %s %s: %w", o.typ, o.name, err)
+		}
+		log.InfoContext(ctx, "schema object dropped", "type", o.typ, "name", o.name)
+	}
+	for _, o := range want {
+		if held[strings.ToLower(o.name)] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, o.ddl); err != nil {
+			return fmt.Errorf("creating %s %s: %w", o.typ, o.name, err)
+		}
+		log.InfoContext(ctx, "schema object created", "type", o.typ, "name", o.name)
+		if containsObject(have, o.name) {
+			stats.Replaced++
+		} else {
+			stats.Created++
+		}
+	}
+	return tx.Commit()
+}
+
+// containsObject reports whether objects holds one called name.
+func containsObject(objects []schemaObject, name string) bool {
+	for _, o := range objects {
+		if strings.EqualFold(o.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionPragmas are the header fields of a database that applications set
+// to identify it and its schema, see Config.CopyVersion.
+var versionPragmas = []string{"user_version", "application_id"}
+
+// copyVersion sets the versionPragmas of the target to those of the source.
+func copyVersion(ctx context.Context, src, dst *sql.DB) error {
+	for _, pragma := range versionPragmas {
+		var v int64
+		if err := src.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&v); err != nil {
+			return fmt.Errorf("reading source %s: %w", pragma, err)
+		}
+		if _, err := dst.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %d", pragma, v)); err != nil {
+			return fmt.Errorf("writing target %s: %w", pragma, err)
+		}
+	}
+	return nil
+}
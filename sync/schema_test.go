@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncSchemaObjects(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score INTEGER)`,
+			srcData: [][]interface{}{{1, "Alice", 3}, {2, "Bob", 5}},
+		},
+		{
+			name:   "audit",
+			schema: `CREATE TABLE audit (msg TEXT)`,
+		},
+	})
+	for _, stmt := range []string{
+		`CREATE INDEX users_name ON users (name)`,
+		`CREATE INDEX users_score ON users (score DESC)`,
+		`CREATE VIEW top_users AS SELECT name FROM users WHERE score > 4`,
+		`CREATE TRIGGER users_audit AFTER UPDATE ON users BEGIN INSERT INTO audit VALUES (NEW.name); END`,
+	} {
+		if _, err := srcDB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stmt := range []string{
+		`CREATE INDEX users_score ON users (score)`,
+		`CREATE INDEX users_extra ON users (name, score)`,
+	} {
+		if _, err := tgtDB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, SchemaObjects: true}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Schema; got.Created != 3 || got.Replaced != 1 || got.Dropped != 0 {
+		t.Errorf("unexpected schema stats %+v", got)
+	}
+	if len(stats.Unsupported) != 0 {
+		t.Errorf("unexpected unsupported objects %+v", stats.Unsupported)
+	}
+	// The trigger was created after the rows were written
+	if n := countTestRows(t, tgtDB, "audit"); n != 0 {
+		t.Errorf("the trigger fired %d times during the sync", n)
+	}
+	if n := countTestRows(t, tgtDB, "top_users"); n != 1 {
+		t.Errorf("view holds %d rows, want 1", n)
+	}
+	if _, err := tgtDB.Exec(`UPDATE users SET name = 'Robert' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	if n := countTestRows(t, tgtDB, "audit"); n != 1 {
+		t.Errorf("the synced trigger fired %d times, want 1", n)
+	}
+
+	cfg.DropSchemaObjects = true
+	if stats, err = Sync(cfg); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Schema; got.Created != 0 || got.Replaced != 0 || got.Dropped != 1 {
+		t.Errorf("unexpected second run schema stats %+v", got)
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'users_extra'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("extra index left in the target: %v", err)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, DropSchemaObjects: true}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("dropping without syncing schema objects: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestSyncCopyVersion(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+	}})
+	if _, err := srcDB.Exec(`PRAGMA user_version = 42; PRAGMA application_id = 1920233327`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tgtDB.Exec(`PRAGMA user_version = 41`); err != nil {
+		t.Fatal(err)
+	}
+	version := func() (v, id int64) {
+		t.Helper()
+		if err := tgtDB.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if err := tgtDB.QueryRow(`PRAGMA application_id`).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return v, id
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if v, id := version(); v != 41 || id != 0 {
+		t.Errorf("target version changed without CopyVersion: %d, %d", v, id)
+	}
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, CopyVersion: true}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if v, id := version(); v != 42 || id != 1920233327 {
+		t.Errorf("target version = %d, %d, want 42, 1920233327", v, id)
+	}
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// canSeed reports whether the target can be created as a copy of the whole
+// source with VACUUM INTO, which also brings the indexes, triggers and views
+// along: the target file is missing or empty and the sync would copy every
+// row of every table as is.
+func canSeed(cfg Config) bool {
+	if cfg.Simulate || readsRows(cfg) || len(cfg.Tables) > 0 || len(cfg.ExcludeTables) > 0 ||
+		cfg.Filter != "" || cfg.Where != "" || len(cfg.TableWhere) > 0 || cfg.Since != "" ||
+		len(cfg.TableOptions) > 0 || cfg.Hooks.tableHooks() {
+		return false
+	}
+	info, err := os.Stat(dbFile(cfg.DstDbPath))
+	if os.IsNotExist(err) {
+		return true
+	}
+	return err == nil && info.Mode().IsRegular() && info.Size() == 0
+}
+
+// seedTarget creates the target with VACUUM INTO from a single read
+// transaction on the source, and counts the rows of every table as inserted.
+func seedTarget(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config, stats *Stats) error {
+	start := time.Now()
+	if _, err := src.ExecContext(ctx, "VACUUM INTO ?", dbFile(cfg.DstDbPath)); err != nil {
+		return fmt.Errorf("copying source db: %w", err)
+	}
+	duration := time.Since(start)
+
+	for _, table := range tables {
+		var n int64
+		if err := dst.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdent(table.name))).Scan(&n); err != nil {
+			return fmt.Errorf("counting rows of %s: %w", table.name, err)
+		}
+		stats.Tables = append(stats.Tables, TableStats{Table: table.name, Inserted: n, Duration: duration})
+		duration = 0
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package sync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncSeedsMissingTarget(t *testing.T) {
+	srcPath, _, srcDB, _ := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+	}})
+	if _, err := srcDB.Exec(`CREATE INDEX users_name ON users (name)`); err != nil {
+		t.Fatal(err)
+	}
+	tgtPath := filepath.Join(t.TempDir(), "new.db")
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath}
+	if !canSeed(cfg) {
+		t.Fatal("canSeed() = false for a missing target")
+	}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Inserted != 2 {
+		t.Errorf("unexpected stats %+v", stats.Tables)
+	}
+
+	tgtDB := openTestDB(t, tgtPath)
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice"}, {2, "Bob"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var indexes int
+	if err := tgtDB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'users_name'`).Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 1 {
+		t.Error("index users_name was not copied")
+	}
+
+	// The target exists now, the next run syncs rows
+	if canSeed(cfg) {
+		t.Error("canSeed() = true for an existing target")
+	}
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Ways of syncing the AUTOINCREMENT counters of the synced tables, see
+// Config.Sequences.
+const (
+	SequencesCopy = "copy"
+	SequencesBump = "bump"
+)
+
+// sequenceTable holds the AUTOINCREMENT counters of a database. It is never
+// synced as a table: its rows are matched by table name, see syncSequences.
+const sequenceTable = "sqlite_sequence"
+
+// syncSequences writes the AUTOINCREMENT counters of the synced tables from
+// the source to the target, in one transaction: SequencesCopy sets them to
+// the source values, SequencesBump only raises those below. A target
+// without AUTOINCREMENT tables has no counters and is left alone.
+func syncSequences(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config) error {
+	for _, db := range []*sql.DB{src, dst} {
+		exists, err := tableExists(ctx, db, sequenceTable)
+		if err != nil || !exists {
+			return err
+		}
+	}
+	rows, err := src.QueryContext(ctx, "SELECT name, seq FROM "+sequenceTable)
+	if err != nil {
+		return fmt.Errorf("reading source sequences: %w", err)
+	}
+	defer rows.Close()
+	seqs := make(map[string]int64)
+	for rows.Next() {
+		var (
+			name string
+			seq  int64
+		)
+		if err := rows.Scan(&name, &seq); err != nil {
+			return fmt.Errorf("reading source sequences: %w", err)
+		}
+		seqs[name] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading source sequences: %w", err)
+	}
+	rows.Close()
+
+	update := "UPDATE " + sequenceTable + " SET seq = ? WHERE name = ?"
+	if cfg.Sequences == SequencesBump {
+		update = "UPDATE " + sequenceTable + " SET seq = max(seq, ?) WHERE name = ?"
+	}
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range tables {
+		seq, ok := seqs[t.name]
+		if !ok {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, update, seq, t.targetName())
+		if err != nil {
+			return fmt.Errorf("writing sequence of %s: %w", t.name, err)
+		}
+		// A table the target never inserted into has no counter yet
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO "+sequenceTable+" (name, seq) VALUES (?, ?)", t.targetName(), seq); err != nil {
+				return fmt.Errorf("writing sequence of %s: %w", t.name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncSequences(t *testing.T) {
+	tests := []struct {
+		name      string
+		sequences string
+		tgtSeq    int64
+		want      int64
+	}{
+		{"Not synced", "", 7, 7},
+		{"Copied", SequencesCopy, 7, 3},
+		{"Bumped up", SequencesBump, 1, 3},
+		{"Kept when higher", SequencesBump, 7, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+				name:    "users",
+				schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)`,
+				srcData: [][]interface{}{{1, "Alice"}},
+			}})
+			// The source allocated keys it deleted since
+			if _, err := srcDB.Exec(`UPDATE sqlite_sequence SET seq = 3 WHERE name = 'users'`); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tgtSeq > 0 {
+				if _, err := tgtDB.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES ('users', ?)`, tt.tgtSeq); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Sequences: tt.sequences})
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if len(stats.Tables) != 1 {
+				t.Errorf("Tables = %+v, want users only", stats.Tables)
+			}
+			var seq int64
+			var n int
+			if err := tgtDB.QueryRow(`SELECT max(seq), count(*) FROM sqlite_sequence WHERE name = 'users'`).Scan(&seq, &n); err != nil {
+				t.Fatal(err)
+			}
+			if seq != tt.want || n != 1 {
+				t.Errorf("target sequence = %d in %d rows, want %d in one", seq, n, tt.want)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	cfg := Config{SrcDbPath: filepath.Join(dir, "src.db"), DstDbPath: filepath.Join(dir, "tgt.db"), Sequences: "reset"}
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("unknown sequences: error = %v, want ErrInvalidConfig", err)
+	}
+}
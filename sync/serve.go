@@ -0,0 +1,492 @@
+package sync
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Server serves the rows of a source database over HTTP to the syncs
+// reading it through an rslite:// URL, see Config.SrcDbPath. Clients only
+// pull the rows selected by their filters and watermarks, compressed with
+// gzip when they accept it, and the keys of the tables they delete from, or
+// the checksums of key ranges with Config.RangeDiff.
+type Server struct {
+	db      *sql.DB
+	token   string
+	grants  []Grant
+	checker *conditionChecker // of the conditions of grants
+	log     *slog.Logger
+}
+
+// NewServer opens the source database of cfg read-only to serve it, with
+// the extensions, immutable mode and logger of cfg. Clients must send token
+// as a bearer token when it is not empty, which lets them read every table,
+// or the token of one of grants, which restricts what they read.
+func NewServer(cfg Config, token string, grants []Grant) (*Server, error) {
+	if err := checkGrants(grants); err != nil {
+		return nil, classify(ErrInvalidConfig, err)
+	}
+	db, err := openReadOnly(cfg.driver(), cfg.SrcDbPath, sourceParams(cfg))
+	if err != nil {
+		return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	s := &Server{db: db, token: token, grants: grants, log: cfg.logger()}
+	if len(grants) > 0 {
+		if s.checker, err = newConditionChecker(context.Background(), db); err != nil {
+			db.Close()
+			return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+		}
+	}
+	return s, nil
+}
+
+// Close closes the served database.
+func (s *Server) Close() error {
+	if s.checker != nil {
+		s.checker.Close()
+	}
+	return s.db.Close()
+}
+
+// serverTable describes a served table, for the client to create it.
+type serverTable struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// rowsRequest asks a Server for the given columns of the rows of a table
+// matching a condition, like the SELECT of a local sync.
+type rowsRequest struct {
+	Table   string
+	Columns []string
+	Where   string
+	Args    []wireValue
+}
+
+// rowsFrame is an element of the stream answering a rowsRequest: a row, or
+// the last frame, with the error that ended the stream if any.
+type rowsFrame struct {
+	Row  []wireValue
+	Done bool
+	Err  string
+}
+
+// wireValue is a column value sent by a Server, gob not encoding nil
+// interface values.
+type wireValue struct {
+	Type  byte // 0 for NULL, 'i', 'f', 't' or 'b'
+	Int   int64
+	Float float64
+	Bytes []byte
+}
+
+// serverFlushRows is the number of rows sent between two flushes of the
+// response.
+const serverFlushRows = 1000
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	grant, ok := s.authorize(r)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/tables":
+		s.serveTables(w, r, grant)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/rows":
+		s.serveRows(w, r, grant)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/ranges":
+		s.serveRanges(w, r, grant)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// localRequest reports whether a request may be served without a token:
+// neither sent by a web page of another site, nor to a host name other than
+// localhost, like a DNS name an attacker rebound to a loopback address.
+func localRequest(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host != "" && !strings.EqualFold(host, "localhost") && net.ParseIP(host) == nil {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize returns the Grant of the token of a request, nil when it may
+// read every table, and whether the token is accepted. A Server without a
+// token nor grants only accepts local requests, see localRequest.
+func (s *Server) authorize(r *http.Request) (*Grant, bool) {
+	if s.token == "" && len(s.grants) == 0 {
+		return nil, localRequest(r)
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	full := s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+	var grant *Grant
+	for i := range s.grants {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.grants[i].Token)) == 1 {
+			grant = &s.grants[i]
+		}
+	}
+	if full {
+		return nil, true
+	}
+	return grant, grant != nil
+}
+
+// serveTables lists the tables a client can sync.
+func (s *Server) serveTables(w http.ResponseWriter, r *http.Request, grant *Grant) {
+	tables, _, err := getTables(r.Context(), s.db)
+	if err != nil {
+		s.fail(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	list := make([]serverTable, 0, len(tables))
+	for _, t := range tables {
+		if grant != nil && !grant.allows(t.name) {
+			continue
+		}
+		st := serverTable{Name: t.name}
+		if err := s.db.QueryRowContext(r.Context(), "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", t.name).Scan(&st.SQL); err != nil {
+			s.fail(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, st)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// serveRows streams the rows asked by a rowsRequest as gob encoded
+// rowsFrames.
+func (s *Server) serveRows(w http.ResponseWriter, r *http.Request, grant *Grant) {
+	ctx := r.Context()
+	var req rowsRequest
+	if err := gob.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("reading request: %w", err))
+		return
+	}
+	table, err := s.table(ctx, req.Table, req.Columns, grant)
+	if err != nil {
+		s.fail(w, r, tableStatus(err), err)
+		return
+	}
+	where := req.Where
+	if grant != nil {
+		if where != "" {
+			if err := s.checker.check(ctx, table.name, where); err != nil {
+				s.fail(w, r, http.StatusForbidden, err)
+				return
+			}
+		}
+		where = joinConditions(grant.where(table.name), where)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(req.Columns), quoteIdent(table.name))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	args := make([]interface{}, len(req.Args))
+	for i, v := range req.Args {
+		args[i] = v.value()
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, err)
+		return
+	}
+	defer rows.Close()
+
+	start := time.Now()
+	out := io.Writer(w)
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	flush := func() {
+		if gz, ok := out.(*gzip.Writer); ok {
+			gz.Flush()
+		}
+		http.NewResponseController(w).Flush()
+	}
+	enc := gob.NewEncoder(out)
+
+	row := newRowScanner(req.Columns)
+	var n int
+	for rows.Next() {
+		if err = row.scan(rows); err != nil {
+			break
+		}
+		frame := rowsFrame{Row: make([]wireValue, len(row.values))}
+		for i, v := range row.values {
+			frame.Row[i] = newWireValue(v)
+		}
+		if err = enc.Encode(frame); err != nil {
+			// The client went away
+			s.log.WarnContext(ctx, "sending rows failed", "table", table.name, "rows", n, "error", err)
+			return
+		}
+		if n++; n%serverFlushRows == 0 {
+			flush()
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	last := rowsFrame{Done: true}
+	if err != nil {
+		last.Err = err.Error()
+		s.log.ErrorContext(ctx, "reading rows failed", "table", table.name, "rows", n, "error", err)
+	} else {
+		s.log.InfoContext(ctx, "rows sent", "table", table.name, "rows", n, "duration", time.Since(start), "client", r.RemoteAddr)
+	}
+	enc.Encode(last)
+}
+
+// serveRanges answers a rangesRequest, splitting the key range into
+// subranges of as many rows.
+func (s *Server) serveRanges(w http.ResponseWriter, r *http.Request, grant *Grant) {
+	ctx := r.Context()
+	var req rangesRequest
+	if err := gob.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("reading request: %w", err))
+		return
+	}
+	if len(req.Columns) == 0 || req.Parts < 1 {
+		s.fail(w, r, http.StatusBadRequest, errors.New("no columns or parts"))
+		return
+	}
+	if req.Checksum == "" {
+		req.Checksum = ChecksumFNV
+	}
+	if err := checkChecksum(req.Checksum); err != nil {
+		s.fail(w, r, http.StatusBadRequest, err)
+		return
+	}
+	table, err := s.table(ctx, req.Table, req.Columns, grant)
+	if err != nil {
+		s.fail(w, r, tableStatus(err), err)
+		return
+	}
+	var filter string
+	if grant != nil {
+		filter = grant.where(table.name)
+	}
+	hashes, err := s.hashRanges(ctx, table, req, filter)
+	if err != nil {
+		s.fail(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	gob.NewEncoder(w).Encode(hashes)
+}
+
+// hashRanges splits the key range of req into req.Parts subranges holding
+// as many rows, the last one ending with the requested range, and returns
+// their checksums, of the rows matching filter if not empty. The rows are
+// counted and read in the same transaction.
+func (s *Server) hashRanges(ctx context.Context, table Table, req rangesRequest, filter string) ([]rangeHash, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	key := req.Columns[0]
+	where, args := keyRange{After: boundValue(req.After), Upto: boundValue(req.Upto)}.where(key)
+	where = joinConditions(filter, where)
+	var count int64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", quoteIdent(table.name), where), args...).Scan(&count); err != nil {
+		return nil, err
+	}
+	size := max((count+int64(req.Parts)-1)/int64(req.Parts), 1)
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s",
+		selectList(req.Columns), quoteIdent(table.name), where, quoteIdent(key)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []rangeHash
+	h := newChecksum(req.Checksum)
+	row := newRowScanner(req.Columns)
+	values := row.values
+	var n, total int64
+	for rows.Next() {
+		if err := row.scan(rows); err != nil {
+			return nil, err
+		}
+		hashRow(h, values)
+		n++
+		total++
+		// The last subrange ends with the requested range instead
+		if n == size && total < count {
+			hashes = append(hashes, rangeHash{Upto: wireBound(values[0]), Count: n, Hash: h.Sum(nil), Checksum: req.Checksum})
+			h, n = newChecksum(req.Checksum), 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return append(hashes, rangeHash{Count: n, Hash: h.Sum(nil), Checksum: req.Checksum}), nil
+}
+
+// errNotGranted fails the requests for a table the grant of the client
+// doesn't let it read.
+var errNotGranted = errors.New("table not granted to the token")
+
+// table returns the served table name, after checking that grant lets the
+// client read it and that it has the given columns, which are part of the
+// queries unlike the condition arguments.
+func (s *Server) table(ctx context.Context, name string, columns []string, grant *Grant) (Table, error) {
+	exists, err := tableExists(ctx, s.db, name)
+	if err != nil {
+		return Table{}, err
+	}
+	// Alike for the tables that don't exist, not to reveal them
+	if grant != nil && !grant.allows(name) {
+		return Table{}, fmt.Errorf("%s: %w", name, errNotGranted)
+	}
+	if !exists {
+		return Table{}, fmt.Errorf("no table %s", name)
+	}
+	table, err := getTableInfo(ctx, s.db, name)
+	if err != nil {
+		return Table{}, err
+	}
+	for _, c := range columns {
+		if !strings.EqualFold(c, table.pkCol) && !strings.EqualFold(c, "rowid") && !containsFold(table.columns, c) {
+			return Table{}, fmt.Errorf("table %s has no column %s", table.name, c)
+		}
+	}
+	return table, nil
+}
+
+// tableStatus returns the status of a request failing on its table.
+func tableStatus(err error) int {
+	if errors.Is(err, errNotGranted) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// joinConditions returns the conjunction of two SQL conditions, either of
+// which may be empty.
+func joinConditions(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return "(" + a + ") AND (" + b + ")"
+}
+
+// fail answers a request with an error.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, status int, err error) {
+	s.log.WarnContext(r.Context(), "request failed", "path", r.URL.Path, "status", status, "error", err)
+	http.Error(w, err.Error(), status)
+}
+
+func newWireValue(v interface{}) wireValue {
+	switch v := v.(type) {
+	case int64:
+		return wireValue{Type: 'i', Int: v}
+	case float64:
+		return wireValue{Type: 'f', Float: v}
+	case string:
+		return wireValue{Type: 't', Bytes: []byte(v)}
+	case []byte:
+		return wireValue{Type: 'b', Bytes: v}
+	case bool:
+		if v {
+			return wireValue{Type: 'i', Int: 1}
+		}
+		return wireValue{Type: 'i'}
+	case time.Time:
+		// As the driver writes it
+		return wireValue{Type: 't', Bytes: []byte(v.Format(sqlite3.SQLiteTimestampFormats[0]))}
+	case nil:
+		return wireValue{}
+	default:
+		return wireValue{Type: 't', Bytes: []byte(fmt.Sprint(v))}
+	}
+}
+
+// value returns the value as read from the database.
+func (v wireValue) value() interface{} {
+	switch v.Type {
+	case 'i':
+		return v.Int
+	case 'f':
+		return v.Float
+	case 't':
+		return string(v.Bytes)
+	case 'b':
+		if v.Bytes == nil {
+			// gob decodes an empty slice as nil, which would be NULL
+			return []byte{}
+		}
+		return v.Bytes
+	default:
+		return nil
+	}
+}
+
+// ListenAndServe serves s on addr, see Listen, until ctx is done, then
+// shuts down gracefully. It serves HTTPS with the certificate and key of
+// certFile and keyFile when not empty.
+func (s *Server) ListenAndServe(ctx context.Context, addr, certFile, keyFile string) error {
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln, certFile, keyFile)
+}
+
+// Serve is ListenAndServe on a listener, which it closes.
+func (s *Server) Serve(ctx context.Context, ln net.Listener, certFile, keyFile string) error {
+	srv := &http.Server{Handler: s}
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdown)
+	}
+}
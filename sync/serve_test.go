@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncServer(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, avatar BLOB, score REAL)`,
+			srcData: [][]interface{}{{1, "Alice", []byte{1, 2}, 1.5}, {2, "Bob", nil, nil}},
+			tgtData: [][]interface{}{{3, "Carol", nil, nil}},
+		},
+		{
+			name:    "events",
+			schema:  `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "a"}, {2, "b"}},
+		},
+	})
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "secret", nil)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	url := "rslite://" + strings.TrimPrefix(ts.URL, "http://")
+
+	stats, err := Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "secret", Tables: []string{"users"}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 2 || got.Deleted != 1 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "Alice", []byte{1, 2}, 1.5}, {2, "Bob", nil, nil}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only the rows above the watermark are pulled
+	cfg := Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "secret", Tables: []string{"events"},
+		StatePath: filepath.Join(t.TempDir(), "sync.state")}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("first incremental Sync() error = %v", err)
+	}
+	if _, err := srcDB.Exec(`UPDATE events SET name = 'changed' WHERE id = 1; INSERT INTO events VALUES (3, 'c')`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Sync(cfg)
+	if err != nil {
+		t.Fatalf("second incremental Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 0 || got.Deleted != 0 || got.Watermark != int64(3) {
+		t.Errorf("unexpected incremental stats %+v", got)
+	}
+	if n := countTestRows(t, tgtDB, "events"); n != 3 {
+		t.Errorf("target has %d events, want 3", n)
+	}
+
+	_, err = Sync(Config{SrcDbPath: url, DstDbPath: tgtPath, ServerToken: "wrong"})
+	if !errors.Is(err, ErrOpenSource) || !strings.Contains(err.Error(), "401") {
+		t.Errorf("wrong token: error = %v, want ErrOpenSource and a 401", err)
+	}
+	if _, err := Sync(Config{SrcDbPath: tgtPath, DstDbPath: url}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("server target: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestServerWithoutToken(t *testing.T) {
+	srcPath, _, _, _ := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	server, err := NewServer(Config{SrcDbPath: srcPath}, "", nil)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	defer server.Close()
+	for _, tc := range []struct {
+		name, host, origin string
+		want               int
+	}{
+		{"a local client", "127.0.0.1:8080", "", http.StatusOK},
+		{"a client of an IPv6 address", "[::1]:8080", "", http.StatusOK},
+		{"a page of another site", "localhost:8080", "https://example.com", http.StatusUnauthorized},
+		{"a host name rebound to a loopback address", "example.com:8080", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/v1/tables", nil)
+		req.Host = tc.host
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("request of %s = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// openSimulation creates the private in-memory database standing in for the
+// target with Config.Simulate, holding the schema of the target at path,
+// which is only read. The database lives as long as the returned connection
+// is open.
+func openSimulation(ctx context.Context, d driver.Driver, path string, params url.Values) (*sql.DB, *sql.Conn, error) {
+	target, err := openReadOnly(d, path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer target.Close()
+
+	// Virtual tables create their shadow tables themselves
+	shadows, err := shadowTables(ctx, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading target schema: %w", err)
+	}
+
+	// Tables first, the other objects depend on them
+	rows, err := target.QueryContext(ctx, `SELECT name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading target schema: %w", err)
+	}
+	var schema []string
+	for rows.Next() {
+		var name, ddl string
+		if err := rows.Scan(&name, &ddl); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("reading target schema: %w", err)
+		}
+		if !shadows[name] {
+			schema = append(schema, ddl)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading target schema: %w", err)
+	}
+
+	// The shared cache lets every connection of the pool see the same
+	// database
+	name := fmt.Sprintf("file:rslite-simulate-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := openDB(d, dsn(name, params))
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	for _, ddl := range schema {
+		if _, err := conn.ExecContext(ctx, ddl); err != nil {
+			conn.Close()
+			db.Close()
+			return nil, nil, fmt.Errorf("copying target schema: %w", err)
+		}
+	}
+	return db, conn, nil
+}
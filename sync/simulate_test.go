@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSyncSimulate(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+		tgtData: [][]interface{}{{3, "Carol"}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+	before, err := os.ReadFile(tgtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Simulate: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 2 || got.Deleted != 0 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	after, err := os.ReadFile(tgtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("simulation modified the target file")
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("target has %d rows, want 1", n)
+	}
+
+	// Rows the target schema rejects fail the simulation
+	if _, err := tgtDB.Exec(`DROP TABLE users; CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT CHECK (name != 'Bob'))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Simulate: true}); err == nil {
+		t.Error("Sync() error = nil, want the CHECK constraint violation")
+	}
+}
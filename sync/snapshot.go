@@ -0,0 +1,29 @@
+package sync
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// snapshotSources copies the databases at paths into dir with VACUUM INTO,
+// each from a single read transaction, and returns the paths of the copies in
+// the same order.
+func snapshotSources(ctx context.Context, paths []string, dir string, d driver.Driver, params url.Values) ([]string, error) {
+	copies := make([]string, len(paths))
+	for i, path := range paths {
+		db, err := openReadOnly(d, path, params)
+		if err != nil {
+			return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+		}
+		copies[i] = filepath.Join(dir, fmt.Sprintf("source-%d.db", i))
+		_, err = db.ExecContext(ctx, "VACUUM INTO ?", copies[i])
+		db.Close()
+		if err != nil {
+			return nil, fmt.Errorf("snapshotting source %s: %w", path, err)
+		}
+	}
+	return copies, nil
+}
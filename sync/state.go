@@ -0,0 +1,129 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+// syncState is the content of the state file of incremental syncs: for every
+// table, the highest value of the watermark column synced so far. The next
+// run only reads the rows beyond it. Checksum is the Config.Checksum
+// algorithm of the runs, empty in the files written before it was recorded.
+type syncState struct {
+	Checksum string                `json:"checksum,omitempty"`
+	Tables   map[string]tableState `json:"tables"`
+}
+
+type tableState struct {
+	Column    string      `json:"column"`
+	Watermark recordValue `json:"watermark"`
+}
+
+// loadState reads a state file, failing when written by runs with another
+// checksum algorithm than alg. A missing file is an empty state, every table
+// is read in full.
+func loadState(path, alg string) (*syncState, error) {
+	state := &syncState{Checksum: checksumName(alg), Tables: map[string]tableState{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("reading state %s: %w", path, err)
+	}
+	if state.Tables == nil {
+		state.Tables = map[string]tableState{}
+	}
+	switch state.Checksum {
+	case checksumName(alg):
+	case "":
+		state.Checksum = checksumName(alg)
+	default:
+		return nil, classify(ErrInvalidConfig, fmt.Errorf("state %s was written with the %s checksum, not %s", path, state.Checksum, checksumName(alg)))
+	}
+	return state, nil
+}
+
+// save replaces the state file, through a rename so an interrupted write
+// doesn't leave a truncated file behind.
+func (s *syncState) save(path string) error {
+	if err := writeJSONFile(path, s); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	return nil
+}
+
+// since returns the watermark of a table, if one was recorded for the same
+// column.
+func (s *syncState) since(table, column string) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	t, ok := s.Tables[table]
+	if !ok || t.Column != column || t.Watermark.V == nil {
+		return nil, false
+	}
+	return t.Watermark.V, true
+}
+
+// update records the watermarks reached by the committed tables.
+func (s *syncState) update(stats *Stats, cfg Config, tables []Table) {
+	for _, ts := range stats.Tables {
+		if ts.Watermark == nil {
+			continue
+		}
+		for _, table := range tables {
+			if table.name == ts.Table {
+				s.Tables[table.name] = tableState{Column: watermarkColumn(table, cfg), Watermark: recordValue{ts.Watermark}}
+			}
+		}
+	}
+}
+
+// watermarkColumn returns the column tracked by incremental syncs of a table.
+func watermarkColumn(table Table, cfg Config) string {
+	if cfg.WatermarkColumn != "" {
+		return cfg.WatermarkColumn
+	}
+	if cfg.UpdatedColumn != "" {
+		return cfg.UpdatedColumn
+	}
+	return table.pkCol
+}
+
+// watermarkIndex returns the position of the watermark column in rows laid
+// out as the pk column followed by table.columns.
+func watermarkIndex(table Table, cfg Config) (int, error) {
+	col := watermarkColumn(table, cfg)
+	if col == table.pkCol {
+		if col != cfg.WatermarkColumn && col != cfg.UpdatedColumn && len(table.keyCols) > 1 {
+			return 0, fmt.Errorf("table %s has a composite primary key, set a watermark column", table.name)
+		}
+		return 0, nil
+	}
+	for i, c := range table.columns {
+		if c == col {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("table %s has no watermark column %s", table.name, col)
+}
+
+// maxWatermark returns the greater of two watermark values, ordered as
+// SQLite orders them.
+func maxWatermark(current, v interface{}) interface{} {
+	if v == nil {
+		return current
+	}
+	if current == nil || diff.CompareValues(v, current) > 0 {
+		return v
+	}
+	return current
+}
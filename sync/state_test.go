@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncIncremental(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "events",
+		schema:  `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "a"}, {2, "b"}},
+	}})
+	statePath := filepath.Join(t.TempDir(), "sync.state")
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, NoDelete: true, StatePath: statePath}
+
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 2 || got.Watermark != int64(2) {
+		t.Errorf("unexpected first run stats %+v", got)
+	}
+
+	// Rows below the watermark are not read again
+	if _, err := srcDB.Exec(`UPDATE events SET name = 'changed' WHERE id = 1; INSERT INTO events VALUES (3, 'c')`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Sync(cfg)
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 1 || got.Replaced != 0 || got.Watermark != int64(3) {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+
+	// Nothing new keeps the watermark
+	if _, err = Sync(cfg); err != nil {
+		t.Fatalf("third Sync() error = %v", err)
+	}
+	state, err := loadState(statePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since, ok := state.since("events", "id"); !ok || since != int64(3) {
+		t.Errorf("state watermark = %v, %v, want 3", since, ok)
+	}
+	if state.Checksum != ChecksumXXHash64 {
+		t.Errorf("state checksum = %q, want %s", state.Checksum, ChecksumXXHash64)
+	}
+	// Refused by runs with another checksum
+	other := cfg
+	other.Checksum = ChecksumSHA256
+	if _, err := Sync(other); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "xxhash64 checksum") {
+		t.Errorf("Sync() with another checksum: error = %v, want the state refused", err)
+	}
+
+	got, err := getTableData(tgtDB, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncIncrementalColumn(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id TEXT PRIMARY KEY, name TEXT, updated_at TEXT)`,
+		srcData: [][]interface{}{{"x", "a", "2024-01-01"}, {"y", "b", "2024-01-02"}},
+	}})
+	cfg := Config{
+		SrcDbPath:       srcPath,
+		DstDbPath:       tgtPath,
+		StatePath:       filepath.Join(t.TempDir(), "sync.state"),
+		WatermarkColumn: "updated_at",
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+
+	if _, err := srcDB.Exec(`UPDATE items SET name = 'A', updated_at = '2024-02-01' WHERE id = 'x'`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Replaced != 1 || got.Inserted != 0 || got.Deleted != 0 {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+	got, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{"x", "A", "2024-02-01"}, {"y", "b", "2024-01-02"}}; !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSyncIncrementalCompositeKey(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{{
+		name:   "pairs",
+		schema: `CREATE TABLE pairs (a INTEGER, b INTEGER, PRIMARY KEY (a, b))`,
+	}})
+	_, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, StatePath: filepath.Join(t.TempDir(), "sync.state")})
+	if err == nil || !strings.Contains(err.Error(), "composite primary key") {
+		t.Errorf("Sync() error = %v, want a composite key error", err)
+	}
+}
+
+func TestSyncIncrementalSoftDelete(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, updated_at TEXT, deleted_at TEXT)`,
+		srcData: [][]interface{}{{1, "a", "2024-01-01", nil}, {2, "b", "2024-01-02", nil}, {3, "c", "2024-01-03", nil}},
+	}})
+	dir := t.TempDir()
+	cfg := Config{
+		SrcDbPath:        srcPath,
+		DstDbPath:        tgtPath,
+		NoDelete:         true,
+		StatePath:        filepath.Join(dir, "sync.state"),
+		WatermarkColumn:  "updated_at",
+		SoftDeleteColumn: "deleted_at",
+		RecordPath:       filepath.Join(dir, "run.jsonl"),
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+	replayed := filepath.Join(dir, "replayed.db")
+	if err := copyFile(tgtPath, replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tombstone within the increment deletes its row, a hard delete stays
+	if _, err := srcDB.Exec(`UPDATE items SET deleted_at = '2024-02-01', updated_at = '2024-02-01' WHERE id = 2;
+		DELETE FROM items WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Deleted != 1 || got.Inserted != 0 || got.Replaced != 0 {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+	got, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{1, "a", "2024-01-01", nil}, {3, "c", "2024-01-03", nil}}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The recording deletes the tombstone too
+	if _, err := Replay(context.Background(), cfg.RecordPath, replayed); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if got, err = getTableData(openTestDB(t, replayed), "items"); err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("replayed: got %v, want %v", got, want)
+	}
+}
+
+func TestSyncSoftDeleteCompositeKey(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "pairs",
+		schema:  `CREATE TABLE pairs (a INTEGER, b INTEGER, deleted_at TEXT, PRIMARY KEY (a, b))`,
+		srcData: [][]interface{}{{1, 1, nil}, {2, 1, "2024-02-01"}},
+		tgtData: [][]interface{}{{1, 1, nil}, {2, 1, nil}},
+	}})
+	dir := t.TempDir()
+	cfg := Config{
+		SrcDbPath:        srcPath,
+		DstDbPath:        tgtPath,
+		NoDelete:         true,
+		SoftDeleteColumn: "deleted_at",
+		RecordPath:       filepath.Join(dir, "run.jsonl"),
+	}
+	replayed := filepath.Join(dir, "replayed.db")
+	if err := copyFile(tgtPath, replayed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	// Only the tombstoned row is deleted, by its whole key
+	want := [][]interface{}{{1, 1, nil}}
+	got, err := getTableData(tgtDB, "pairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := Replay(context.Background(), cfg.RecordPath, replayed); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if got, err = getTableData(openTestDB(t, replayed), "pairs"); err != nil {
+		t.Fatal(err)
+	}
+	if !compareData(got, want) {
+		t.Errorf("replayed: got %v, want %v", got, want)
+	}
+}
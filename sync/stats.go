@@ -0,0 +1,86 @@
+package sync
+
+import "time"
+
+// Stats summarizes a sync run.
+type Stats struct {
+	Tables   []TableStats
+	Duration time.Duration
+
+	// Violations lists the first foreign key violations found in the target
+	// after a run with Config.DeferConstraints.
+	Violations []ForeignKeyViolation
+
+	// Files counts the attachment files copied and removed.
+	Files FileStats
+
+	// Schema counts the views, indexes and triggers written with
+	// Config.SchemaObjects.
+	Schema SchemaStats
+
+	// Corruption lists the problems found by the quick checks of
+	// Config.CheckSource and CheckTarget.
+	Corruption []IntegrityIssue
+
+	// Verified holds the comparison of every synced table with the source
+	// made with Config.Verify.
+	Verified []Verification
+
+	// DroppedTables lists the target tables dropped with Config.Mirror.
+	DroppedTables []string
+
+	// Migration counts the columns and indexes added to the target with
+	// Config.MigrateSchema.
+	Migration MigrationStats
+
+	// Unsupported lists the source objects the run didn't cover: virtual
+	// tables whose module isn't loaded, full-text tables indexing another
+	// table not rebuilt, views and triggers on the synced tables unless
+	// Config.SchemaObjects is set, and generated columns.
+	Unsupported []Unsupported
+}
+
+// TableStats holds the row counts for a single synced table.
+//
+// Inserted and Replaced are derived from the target row count before and
+// after the write phase, so rows removed as a side effect of REPLACE on a
+// secondary UNIQUE constraint are not accounted for separately.
+type TableStats struct {
+	Table    string
+	Inserted int64
+	Replaced int64
+	Deleted  int64
+	Skipped  int64 // rows read from the source but not written to the target
+	Flagged  int64 // rows reported by the data guards, written or not
+	// Unchanged counts the skipped rows the target already held, see
+	// Config.SkipUnchanged
+	Unchanged int64
+	Duration  time.Duration
+	// Retries is the number of times the table was synced again after
+	// failing on a locked database, see Config.Retries.
+	Retries int
+
+	// Watermark is the highest value of the watermark column read by an
+	// incremental sync, nil when nothing was read or Config.StatePath is
+	// not set.
+	Watermark interface{}
+
+	// Issues lists the first flagged rows and why they were flagged.
+	Issues []RowIssue
+
+	lastChange int64 // last change log entry replayed, see Config.Tracked
+}
+
+// Total returns the sum of the per-table counts, labelled "total".
+func (s *Stats) Total() TableStats {
+	total := TableStats{Table: "total", Duration: s.Duration}
+	for _, t := range s.Tables {
+		total.Inserted += t.Inserted
+		total.Replaced += t.Replaced
+		total.Deleted += t.Deleted
+		total.Skipped += t.Skipped
+		total.Flagged += t.Flagged
+		total.Unchanged += t.Unchanged
+	}
+	return total
+}
@@ -1,11 +1,23 @@
 package sync
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	gosync "sync"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
 // Modify the existing Config struct to add arg tags
@@ -16,85 +28,1166 @@ type Config struct {
 	Tables    []string `arg:"-t,--tables,separate" help:"tables to sync (if not specified, syncs all tables)"`
 	SrcDbPath string   `arg:"positional,required" help:"source database path"`
 	DstDbPath string   `arg:"positional,required" help:"target database path"`
+
+	// Tables and ExcludeTables hold table names or glob patterns, as
+	// matched by path.Match: the tables matching Tables, or every table
+	// when it's empty, are synced unless they match ExcludeTables.
+	ExcludeTables []string `arg:"--exclude-tables,separate" help:"tables not to sync, by name or glob pattern"`
+
+	// FilterColumn is the column Filter compares with Value, the primary key
+	// when empty. Every synced table must have it.
+	FilterColumn string `arg:"--filter-column" help:"column compared by the filter (default: primary key)"`
+
+	// UpdatedColumn holds the modification time of the rows, and Since
+	// selects the rows modified after it. Text values are read with the
+	// SQLite date functions, numbers as unix seconds. UpdatedColumn is also
+	// the default watermark column of incremental syncs.
+	UpdatedColumn string `arg:"--updated-column" help:"column holding the modification time of the rows"`
+	Since         string `arg:"--since" help:"only sync rows modified after this time (RFC 3339 or YYYY-MM-DD)"`
+
+	// SoftDeleteColumn marks the deleted rows of the source: a row whose
+	// column is set, not NULL, 0 nor empty, is a tombstone, and instead of
+	// being written its key is deleted from the target. Unlike the orphan
+	// delete it works with filters and incremental syncs, which read the
+	// tombstones like any modified row, and NoDelete doesn't turn it off.
+	// Tables without the column are synced as usual.
+	SoftDeleteColumn string `arg:"--soft-delete-column" help:"column set on the source rows to delete from the target"`
+
+	// KeylessMatch is how the rows of the tables without a primary key are
+	// matched: KeylessRowid, the default, copies and matches their rowid,
+	// which only holds when the target rows were all written by rslite and
+	// the source was never vacuumed, or duplicates rows otherwise; such tables
+	// are logged with a warning. KeylessColumns matches them on all their
+	// columns, duplicate rows included: the target rows without an identical
+	// source row are deleted, and the source rows without an identical target
+	// row inserted. Those tables are synced whole. TableOptions.Key names a
+	// unique column to match on instead.
+	KeylessMatch string `arg:"--keyless" help:"matching of the rows of tables without a primary key: rowid or columns (default rowid)"`
+
+	// MaxDeleteFraction guards against syncing from an empty or truncated
+	// source: a table whose sync would delete more than this fraction of
+	// its target rows fails with ErrTooManyDeletes, and nothing of its
+	// current transaction is kept. Zero disables it, TableOptions can set
+	// it per table, and Force lifts it for a run.
+	MaxDeleteFraction float64 `arg:"--max-delete-fraction" help:"fail a table whose sync would delete more than this fraction of its target rows (0 disables)"`
+	Force             bool    `arg:"--force" help:"sync past --max-delete-fraction"`
+
+	// Where is an SQL condition selecting the source rows to sync, on top of
+	// Filter. TableWhere holds conditions for single tables, applied along
+	// with Where; tables it names that are not synced are ignored. Source
+	// rows left out are still kept by the orphan delete.
+	Where      string            `arg:"--where" help:"SQL condition selecting the source rows to sync"`
+	TableWhere map[string]string `arg:"--table-where" help:"SQL condition for a single table, as TABLE=CONDITION"`
+
+	// TableOptions holds per-table settings by source table name, usually
+	// read from a file with LoadTableOptions. Tables it names that are not
+	// synced are ignored.
+	TableOptions map[string]TableOptions `arg:"-"`
+
+	RecordPath string `arg:"--record" help:"record the rows and decisions of the run to this file for replay"`
+
+	// ReportPath is where the Report of the run is written as JSON, whether
+	// it succeeded or not.
+	ReportPath string `arg:"--report" help:"write a JSON report of the run to this file"`
+	// History records the Report of every run in a table of the target,
+	// listed by the History function, whether it succeeded or not.
+	History bool `arg:"--history" help:"record the run in a history table of the target"`
+	// NotifyURL receives the Report of every run, whether it succeeded or
+	// not, as JSON in a POST request, and NotifyCmd, run by the shell, on
+	// its standard input, with RSLITE_SUCCESS set to true or false and
+	// RSLITE_SOURCE and RSLITE_TARGET to the database paths. A failed
+	// notification fails a run that succeeded.
+	NotifyURL string `arg:"--notify-url" help:"POST the JSON report of every run to this URL"`
+	NotifyCmd string `arg:"--notify-cmd" help:"run this shell command with the JSON report of every run on its standard input"`
+
+	PageSize  int  `arg:"--page-size" help:"rows read from the source per query (default 1000)"`
+	BatchSize int  `arg:"--batch-size" help:"commit the target every N rows (0 commits once per table)"`
+	SingleTx  bool `arg:"--single-tx" help:"write each table in a single transaction, ignoring BatchSize"`
+
+	// Jobs is the number of tables synced concurrently. Tables wait for the
+	// tables they reference through foreign keys, and writes to the target
+	// are serialized per transaction, so it pays off most with BatchSize.
+	// Recorded runs always sync one table at a time.
+	Jobs int `arg:"-j,--jobs" help:"number of tables to sync concurrently"`
+
+	// The source databases are opened read-only, so a sync can't modify
+	// them. SrcImmutable also disables locking and change detection, for
+	// sources on read-only media or filesystems, like a WAL database whose
+	// -shm file can't be created. Nothing may write to an immutable source
+	// during the sync.
+	SrcImmutable bool `arg:"--src-immutable" help:"open the source without locking, for read-only media nothing writes to"`
+
+	// SrcDbPath, UnionSources and DstDbPath may be remote paths, like
+	// ssh://user@host:2222/data/app.db or, unless a local file has that
+	// name, user@host:/data/app.db, read and written over SSH: the remote
+	// databases are downloaded to the temporary directory, synced there, and
+	// the target is uploaded back in place of the remote file, with a
+	// rename. The remote files must not be written to during the sync, nor
+	// have changes left in a -wal file. SSHCommand is the command reaching
+	// the hosts, with its arguments, "ssh" when empty.
+	SSHCommand string `arg:"--ssh" help:"command reaching the hosts of remote databases (default ssh)"`
+
+	// SrcDbPath and UnionSources may also be http or https URLs of
+	// databases, downloaded into CacheDir and read from there. A cached
+	// database is only downloaded again once changed, as told by its ETag
+	// or modification time, and an interrupted download is resumed. CacheDir
+	// defaults to the rslite directory of the user cache directory.
+	CacheDir string `arg:"--cache-dir" help:"directory of the databases downloaded over HTTP"`
+
+	// SrcDbPath, UnionSources and DstDbPath may also be s3://bucket/key or
+	// gs://bucket/key object URLs. Sources are cached in CacheDir like
+	// http URLs, and only downloaded again once their ETag changed; targets
+	// are downloaded to the temporary directory and uploaded back, in parts
+	// when large. The credentials and region come from the AWS environment
+	// variables, config files or instance role. gs:// objects are reached
+	// through the S3-compatible API of Cloud Storage, with HMAC keys as
+	// credentials. S3Endpoint points s3:// URLs to another S3-compatible
+	// store. ObjectEncryption is the server-side encryption of the uploaded
+	// targets, EncryptionAES256 or EncryptionKMS with the ObjectKMSKey key,
+	// the default of the bucket when empty.
+	S3Endpoint       string `arg:"--s3-endpoint" help:"endpoint of the S3-compatible store of s3:// URLs"`
+	ObjectEncryption string `arg:"--sse" help:"server-side encryption of uploaded targets: AES256 or aws:kms"`
+	ObjectKMSKey     string `arg:"--sse-kms-key" help:"KMS key encrypting uploaded targets with aws:kms"`
+
+	// SrcDbPath may also be the URL of a Server, rslite://host:port or
+	// rslite+https://host:port, serving the source database: only the rows
+	// selected by the filters and watermarks are pulled from it, then
+	// synced, and only the keys of the tables the orphans are deleted from,
+	// in a second transaction. ServerToken is the token the server expects.
+	ServerToken string `arg:"--token" json:"-" help:"token of the rslite server of an rslite:// source"`
+
+	// SrcDbPath, UnionSources and DstDbPath may also be libsql:// URLs of
+	// libSQL servers or Turso databases, reached over HTTP, and authenticated
+	// with the authToken parameter of the URL or LibSQLToken. A libSQL
+	// source is copied first; a libSQL target is copied, synced, and the rows
+	// that changed written back in a single transaction.
+	LibSQLToken string `arg:"--libsql-token" json:"-" help:"auth token of libsql:// databases"`
+
+	// LibSQLDriver opens the libsql:// databases in place of the built-in
+	// driver of the Hrana over HTTP protocol, given their URL with its
+	// authToken parameter set, like the driver of libsql-client-go:
+	//
+	//	db, _ := sql.Open("libsql", "")
+	//	cfg.LibSQLDriver = db.Driver()
+	LibSQLDriver driver.Driver `arg:"-" json:"-"`
+
+	// RangeDiff compares the tables of an rslite:// source with the target by
+	// key range instead of pulling them: the server splits the differing
+	// ranges into smaller ones, hashed on both sides with Checksum, until
+	// they hold a single row, and only the rows of the ranges still
+	// differing are pulled and synced, or deleted. The whole tables are
+	// compared, without filters; tables with a key of several columns are
+	// pulled whole, and options writing other values than the source's, like
+	// masks, make every row differ.
+	RangeDiff bool `arg:"--range-diff" help:"only pull the key ranges differing from the target, from an rslite:// source"`
+
+	// Tracked only syncs the rows logged by the change tracking of the
+	// source, see EnableTracking: the logged rows still in the source are
+	// written and the others deleted, then the replayed changes are trimmed
+	// from the log, unless Simulate is set: the only write of a sync to its
+	// source, which must be a single local database, neither immutable nor
+	// remote, and every synced table tracked.
+	Tracked bool `arg:"--tracked" help:"only sync the rows changed since the last run, as logged by rslite track enable"`
+
+	// BusyTimeout is how long a statement waits for a database locked by
+	// another connection before failing, 5 seconds when zero. A table whose
+	// sync still fails on a locked database is synced again up to Retries
+	// times, waiting longer each time: SQLite returns some lock conflicts
+	// at once, without waiting.
+	BusyTimeout time.Duration `arg:"--busy-timeout" help:"how long to wait for a locked database (default 5s)"`
+	Retries     int           `arg:"--retries" help:"times a table failing on a locked database is synced again"`
+
+	// Options of the per-table transactions. Every table is read inside one
+	// source transaction, read-only unless SrcTxOptions says otherwise, so
+	// all of its pages come from the same snapshot. The SQLite driver
+	// ignores the isolation level: transactions are always serializable.
+	SrcTxOptions *sql.TxOptions
+	DstTxOptions *sql.TxOptions
+	// DstTxLock is when target transactions take the write lock: "deferred"
+	// (the default) on the first write, "immediate" or "exclusive" at BEGIN.
+	// Use immediate when other processes write the target concurrently, a
+	// deferred transaction that has already read fails instead of waiting.
+	DstTxLock string `arg:"--tx-lock" help:"target transaction locking: deferred, immediate or exclusive"`
+	// DstPragmas are PRAGMA settings, as NAME=VALUE, run on every target
+	// connection, like journal_mode=WAL, synchronous=NORMAL or
+	// cache_size=-200000 to speed up bulk loads. Those only last as long as
+	// the connections; the journal mode, kept in the database file, is set
+	// back to the previous one once the run is done.
+	DstPragmas []string `arg:"--dst-pragmas,separate" help:"PRAGMA settings of the target connections, as NAME=VALUE"`
+
+	// Data guards, see RowIssue
+	MaxRowSize  int64  `arg:"--max-row-size" help:"flag rows larger than this many bytes (0 disables)"`
+	CheckUTF8   bool   `arg:"--check-utf8" help:"flag rows with invalid UTF-8 in text values"`
+	SkipFlagged bool   `arg:"--skip-flagged" help:"don't write rows flagged by the data guards"`
+	FixEncoding string `arg:"--fix-encoding" help:"policy for text with invalid UTF-8: repair, blob or reject"`
+
+	// SkipUnchanged compares the checksum of every source row with the one of
+	// the target row with the same key, and only writes the rows that differ,
+	// sparing the target WAL and triggers.
+	SkipUnchanged bool `arg:"--skip-unchanged" help:"only write rows that differ from the target"`
+	// Checksum is the hash algorithm of the row checksums, ChecksumXXHash64
+	// (the default), ChecksumFNV or ChecksumSHA256. It is recorded in the
+	// state file, which a run with another algorithm refuses, and checked
+	// against the one of the server with RangeDiff.
+	Checksum string `arg:"--checksum" help:"row checksum algorithm: xxhash64, fnv or sha256"`
+
+	// Analyze runs ANALYZE on the synced tables of the target after a
+	// successful run, so the query planner knows their new contents, and
+	// Vacuum then runs VACUUM on it, giving back the space of the deleted
+	// rows. VACUUM rewrites the whole database and needs as much free
+	// disk space, and no other connection writing it.
+	Analyze bool `arg:"--analyze" help:"run ANALYZE on the synced target tables after a successful sync"`
+	Vacuum  bool `arg:"--vacuum" help:"run VACUUM on the target after a successful sync"`
+
+	// CheckSource runs PRAGMA quick_check on the sources before reading
+	// them, failing with ErrCorruptSource on a corrupt one, so its damage
+	// isn't copied to the target, and CheckTarget on the target once the
+	// rows are written, failing with ErrVerification. FlagCorruption only
+	// reports the problems found, in Stats.Corruption, and goes on.
+	CheckSource    bool `arg:"--check-source" help:"run PRAGMA quick_check on the source before reading it"`
+	CheckTarget    bool `arg:"--check-target" help:"run PRAGMA quick_check on the target after writing it"`
+	FlagCorruption bool `arg:"--flag-corruption" help:"report the problems the quick checks find without failing"`
+
+	// Verify compares every synced table with the source once the run is
+	// done, counting and hashing the rows selected by the filters on both
+	// sides with the Checksum algorithm, and fails with ErrVerification on
+	// the tables that differ. The outcome is in Stats.Verified. A source
+	// written to during the run differs too, unless Snapshot is set.
+	Verify bool `arg:"--verify" help:"compare the row counts and checksums of the synced tables with the source at the end"`
+	// VerifySample verifies the tables on this many of their source rows,
+	// picked at random among those selected by the filters, compared with
+	// the target rows with the same keys, rather than on all their rows.
+	// The target rows the source lacks aren't looked for. It turns Verify on.
+	VerifySample int `arg:"--verify-sample" help:"verify the synced tables on this many random rows each"`
+
+	// Replace writes rows with INSERT OR REPLACE instead of an UPSERT on the
+	// primary key. REPLACE deletes the target row before inserting the new
+	// one, firing delete triggers and ON DELETE CASCADE, but also clears
+	// conflicts on secondary UNIQUE constraints, on which an UPSERT fails.
+	// Virtual tables don't support UPSERT and always use REPLACE.
+	Replace bool `arg:"--replace" help:"write rows with INSERT OR REPLACE instead of updating them in place"`
+
+	// JSONColumns lists the text columns holding JSON objects, as
+	// TABLE.COLUMN or COLUMN for every table. Their source documents are
+	// merged into the target ones with json_patch (RFC 7396) instead of
+	// overwriting them: members only the target has are kept, and members
+	// the source sets to null are removed. Values that are not valid JSON on
+	// either side are overwritten. Diff compares them member by member.
+	// Ignored with Replace.
+	JSONColumns []string `arg:"--json-column" help:"JSON columns merged member by member, as TABLE.COLUMN or COLUMN"`
+
+	// ExternalizeBlobs moves the BLOBs larger than this many bytes out of
+	// the target: each is written to a file under BlobDir named after its
+	// SHA-256, and the column holds "rslite-blob:sha256:" and the hash
+	// instead. BlobDir defaults to the target path followed by ".blobs".
+	// Files no longer referenced are not removed.
+	ExternalizeBlobs int64  `arg:"--externalize-blobs" help:"store BLOBs larger than this many bytes as files next to the target (0 disables)"`
+	BlobDir          string `arg:"--blob-dir" help:"directory of the externalized BLOBs (default: target path + .blobs)"`
+
+	// StatePath enables incremental syncs: the highest value of the
+	// watermark column synced per table is kept in this file, and the next
+	// run only reads the rows beyond it. The watermark column is
+	// WatermarkColumn, else UpdatedColumn, else the primary key; it must grow
+	// with every change to sync, like an autoincrement key or an updated_at
+	// column. The orphan delete still scans every source key, set NoDelete
+	// to skip it, and SoftDeleteColumn or Tracked to propagate deletes.
+	StatePath       string `arg:"--state" help:"file keeping the per-table watermarks of incremental syncs"`
+	WatermarkColumn string `arg:"--watermark-column" help:"column tracked by incremental syncs (default: updated column or primary key)"`
+
+	// UnionSources are more source databases holding the same tables, like
+	// per-day files, synced as one logical source: every table is read from
+	// SrcDbPath, then from each of them in order, so later databases win on
+	// key conflicts. Tables are listed from SrcDbPath, databases lacking one
+	// are skipped for it.
+	UnionSources []string `arg:"--union,separate" help:"more source databases read after the first, later ones win on key conflicts"`
+
+	// DeferConstraints turns foreign key enforcement off on the target for
+	// the run, so tables can be written in any order and rows may reference
+	// rows synced later. Once every table is done the whole target is
+	// checked with PRAGMA foreign_key_check: violations are listed in
+	// Stats.Violations and fail the run, the synced rows are kept.
+	// DisableTriggers drops the target triggers on the synced tables before
+	// writing and creates them again at the end, a process killed in between
+	// leaves them dropped.
+	DeferConstraints bool `arg:"--defer-constraints" help:"don't enforce foreign keys while syncing, check them at the end"`
+	DisableTriggers  bool `arg:"--disable-triggers" help:"drop the target triggers of the synced tables while syncing"`
+
+	// SchemaObjects also syncs the views, indexes and triggers once the rows
+	// are, so the triggers don't fire on them: those missing from the
+	// target, or defined differently there, are created as in the source.
+	// The views are selected by Tables like the tables, the indexes and
+	// triggers are those of the synced tables and views; tables renamed in
+	// the target keep theirs. DropSchemaObjects also drops the target ones
+	// the source lacks.
+	SchemaObjects     bool `arg:"--schema-objects" help:"also sync the views, indexes and triggers"`
+	DropSchemaObjects bool `arg:"--drop-schema-objects" help:"drop the target views, indexes and triggers missing from the source"`
+
+	// CopyVersion sets PRAGMA user_version and application_id of the target
+	// to those of the source once the rows are synced, so that tools
+	// reading the schema version of the target see that of its rows.
+	CopyVersion bool `arg:"--copy-version" help:"copy the user_version and application_id pragmas to the target"`
+
+	// IgnoreSchemaDiff syncs the tables whose columns differ in the target
+	// in order, declared types or extra columns, writing the columns by
+	// name. Otherwise they fail with ErrSchemaMismatch and a diff of the
+	// column lists; a synced column missing from the target always fails.
+	IgnoreSchemaDiff bool `arg:"--ignore-schema-diff" help:"sync tables whose columns differ in the target, mapping them by name"`
+
+	// Mirror also drops the target tables missing from the source once every
+	// table is synced, unless they match Protect, which holds table names or
+	// glob patterns like Tables. Only the tables Tables and ExcludeTables
+	// select are dropped. With SchemaObjects and DropSchemaObjects the
+	// target ends up with the tables, views, indexes and triggers of the
+	// source.
+	Mirror  bool     `arg:"--mirror" help:"drop the target tables missing from the source"`
+	Protect []string `arg:"--protect,separate" help:"target tables --mirror never drops, by name or glob pattern"`
+
+	// MigrateSchema applies the additive changes of the source schema to
+	// the target before syncing: the synced columns missing from the target
+	// tables are added, with their type and default value, and the indexes
+	// of the synced tables missing from the target are created. The declared
+	// types it can't alter are compared by affinity, and the columns by name
+	// rather than position; tables missing from the target still fail.
+	MigrateSchema bool `arg:"--migrate-schema" help:"add the missing columns and indexes to the target tables before syncing"`
+
+	// Backfill fills the columns MigrateSchema adds in the existing target
+	// rows, in the same transaction, with the values of the source rows
+	// with the same key, masked like the synced rows. Incremental syncs
+	// would otherwise leave them empty in the rows that don't change. The
+	// TableOptions.Backfill expressions are applied to every row before,
+	// so that they fill the rows the source lacks.
+	Backfill bool `arg:"--backfill" help:"fill the columns --migrate-schema adds with the values of the source rows"`
+
+	// Sequences syncs the AUTOINCREMENT counters of the synced tables, kept
+	// in sqlite_sequence, once the rows are: SequencesCopy sets them to the
+	// source values, SequencesBump raises the target ones below them, so
+	// rows inserted on either side never reuse a key the other allocated.
+	// sqlite_sequence is never synced as a table.
+	Sequences string `arg:"--sequences" help:"sync the AUTOINCREMENT counters: copy or bump"`
+
+	// RebuildFTS rebuilds the full-text indexes (FTS3, FTS4 and FTS5) of the
+	// synced tables once the rows are. The full-text tables indexing the
+	// rows of another table, their content option, are never synced: their
+	// content table is, and they are only rebuilt from it with RebuildFTS.
+	RebuildFTS bool `arg:"--rebuild-fts" help:"rebuild the full-text indexes of the target after syncing"`
+
+	// AttachmentDirs maps directories of files referenced by the rows, like
+	// uploads, from their source to their target path. New and changed files
+	// are copied before any row is written, and files missing from the
+	// source are removed once every table is synced unless NoDelete is set,
+	// so the target never holds a row whose file has not arrived yet. With
+	// ExternalizeBlobs, the BLOB directory of the source, if it has one, is
+	// copied into BlobDir the same way, without removing anything.
+	AttachmentDirs map[string]string `arg:"--attachments" help:"directory of files referenced by the rows, as SOURCE=TARGET"`
+
+	// Simulate runs the sync against an in-memory copy of the schema of
+	// the target, which is only read, and reports what it would do: rows
+	// the target schema rejects fail the run, and with DeferConstraints the
+	// foreign keys are checked. The target rows are not copied, so every
+	// written row counts as inserted and nothing as deleted. State files,
+	// attachments and externalized BLOBs are left alone.
+	Simulate bool `arg:"--simulate" help:"sync into an in-memory copy of the target schema, leaving the target untouched"`
+
+	// NoAttach always copies the rows one by one. Otherwise, when the
+	// source is a file and no option needs to look at single rows, every
+	// table is synced with one INSERT ... SELECT and one DELETE on the
+	// source attached to the target connection, which is much faster on
+	// large tables. SrcTxOptions don't apply then, the source is read in
+	// the target transaction.
+	NoAttach bool `arg:"--no-attach" help:"copy rows one by one instead of attaching the source to the target"`
+
+	// Snapshot copies the source databases to the temporary directory with
+	// VACUUM INTO before syncing, and reads the copies instead, so every
+	// table comes from the same point in time even while the source is
+	// written to. Without it each table is read in its own transaction.
+	// The copies take as much space as the sources.
+	Snapshot bool `arg:"--snapshot" help:"sync every table from a point-in-time copy of the source"`
+
+	// KeepGoing carries on with the next tables when one fails instead of
+	// stopping the run, and returns the errors of every failed table
+	// joined. Failed tables are rolled back and left out of Stats. With
+	// Atomic, the run is still rolled back as a whole.
+	KeepGoing bool `arg:"--keep-going" help:"sync the remaining tables when one fails"`
+
+	// Extensions are SQLite extensions loaded on every connection to the
+	// source and target, for databases relying on the collations, functions
+	// or virtual tables they provide.
+	Extensions []string `arg:"--load-extension,separate" help:"SQLite extension loaded on every connection"`
+
+	// ConnectHook is called on every connection opened to the source and
+	// target, to register the collations and functions of the application
+	// with RegisterCollation and RegisterFunc. Without them, tables and
+	// indexes using a custom collation can't be read nor written. Rows are
+	// still matched on the binary order of their keys.
+	ConnectHook func(*sqlite3.SQLiteConn) error `arg:"-" json:"-"`
+
+	// RowTransform is called with every source row before it is written,
+	// keyed by column name, and returns the row to write instead, or false
+	// to skip it. Columns missing from the returned row are written as
+	// NULL. Rows keep the source key for the orphan delete, so changing the
+	// key columns deletes the rewritten rows again.
+	RowTransform func(table string, row map[string]interface{}) (map[string]interface{}, bool, error) `arg:"-" json:"-"`
+
+	// Progress is called with the progress of every table: when it starts,
+	// then at most every 200ms while rows are processed, and once committed.
+	// Rows are counted upfront, which takes a scan of each table. With Jobs,
+	// it is called concurrently for different tables.
+	Progress func(Progress) `arg:"-" json:"-"`
+
+	// Logger receives the events of the run: tables started and finished
+	// at info level, retries at warn level, and the set-based statements
+	// with their duration at debug level. Nothing is logged when nil.
+	Logger *slog.Logger `arg:"-" json:"-"`
+
+	// Hooks are called before and after the run and every table.
+	Hooks Hooks `arg:"-" json:"-"`
+
+	// WaitLock is how long a run waits for another one syncing the same
+	// target to finish, failing with ErrTargetLocked after it; zero fails
+	// at once. Runs lock a file next to the target, see lockTarget, which
+	// the system releases when a process is killed. The runs of tables
+	// named in Tables only wait for those syncing the same tables, or the
+	// whole target.
+	WaitLock time.Duration `arg:"--wait-lock" help:"how long to wait for another sync of the same target to finish"`
+
+	// Atomic writes every table in a single target transaction, so a run
+	// either syncs them all or changes nothing. The stats of the tables
+	// synced before a failure are still returned, but were rolled back.
+	// Tables are synced one at a time and BatchSize is ignored; the target
+	// stays locked for writing during the whole run.
+	Atomic bool `arg:"--atomic" help:"sync all tables in a single target transaction"`
+
+	// AllOrNothing makes SyncTargets change every target or none of them,
+	// syncing copies of the targets renamed over them once all succeeded.
+	AllOrNothing bool `arg:"--all-or-nothing" help:"with several targets, change them all or none"`
+
+	state     *syncState            // loaded from StatePath
+	snapshots []string              // copies of SrcDbPath and UnionSources read instead of them, see Snapshot
+	locked    bool                  // the target lock is held for the run, see lockTarget
+	pulled    map[string][]keyRange // key ranges pulled from a server by table, see RangeDiff
+	shared    *sharedTx             // run transaction of an Atomic sync
+}
+
+// sourcePath returns the path of the source database to read.
+func (cfg Config) sourcePath() string {
+	if len(cfg.snapshots) > 0 {
+		return cfg.snapshots[0]
+	}
+	return cfg.SrcDbPath
 }
 
 func (Config) Description() string {
 	return "Syncs data between two SQLite databases with filtering options"
 }
 
-func Sync(cfg Config) error {
-	src, err := sql.Open("sqlite3", cfg.SrcDbPath)
+func (cfg Config) validate() error {
+	switch cfg.Filter {
+	case "", "gt", "lt", "gte", "lte":
+	default:
+		return classify(ErrUnsupportedFilter, fmt.Errorf("unknown filter %q: want gt, lt, gte or lte", cfg.Filter))
+	}
+	switch cfg.DstTxLock {
+	case "", "deferred", "immediate", "exclusive":
+	default:
+		return fmt.Errorf("unknown transaction lock %q: want deferred, immediate or exclusive", cfg.DstTxLock)
+	}
+	if cfg.Since != "" {
+		if cfg.UpdatedColumn == "" {
+			return fmt.Errorf("a since time needs an updated column")
+		}
+		if _, err := parseSince(cfg.Since); err != nil {
+			return err
+		}
+	}
+	for _, setting := range cfg.DstPragmas {
+		if err := checkPragma(setting); err != nil {
+			return err
+		}
+	}
+	if cfg.VerifySample < 0 {
+		return fmt.Errorf("invalid verify sample %d", cfg.VerifySample)
+	}
+	if (cfg.Verify || cfg.VerifySample > 0) && len(cfg.UnionSources) > 0 {
+		return fmt.Errorf("verify does not support union sources")
+	}
+	if cfg.Mirror && cfg.NoDelete {
+		return fmt.Errorf("mirroring the source needs the target rows deleted")
+	}
+	for _, pattern := range append(append(append([]string{}, cfg.Tables...), cfg.ExcludeTables...), cfg.Protect...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q: %w", pattern, err)
+		}
+	}
+	switch cfg.ObjectEncryption {
+	case "", EncryptionAES256, EncryptionKMS:
+	default:
+		return fmt.Errorf("unknown server-side encryption %q: want %s or %s", cfg.ObjectEncryption, EncryptionAES256, EncryptionKMS)
+	}
+	if cfg.ObjectKMSKey != "" && cfg.ObjectEncryption != EncryptionKMS {
+		return fmt.Errorf("a KMS key needs %s server-side encryption", EncryptionKMS)
+	}
+	for _, path := range append([]string{cfg.DstDbPath}, cfg.UnionSources...) {
+		if isServer(path) {
+			return fmt.Errorf("%s: an rslite server can only be the source", path)
+		}
+	}
+	if cfg.RangeDiff {
+		if !isServer(cfg.SrcDbPath) {
+			return errors.New("range diffing needs an rslite:// source")
+		}
+		if cfg.Filter != "" || cfg.Since != "" || cfg.Where != "" || len(cfg.TableWhere) > 0 || cfg.StatePath != "" {
+			return errors.New("range diffing compares whole tables, without filters or watermarks")
+		}
+		for name, o := range cfg.TableOptions {
+			if o.Where != "" {
+				return fmt.Errorf("range diffing compares whole tables, without the condition of table %s", name)
+			}
+		}
+	}
+	for _, name := range []string{cfg.FilterColumn, cfg.UpdatedColumn, cfg.SoftDeleteColumn, cfg.WatermarkColumn} {
+		if err := checkIdent(name); err != nil {
+			return err
+		}
+	}
+	for name, o := range cfg.TableOptions {
+		if err := checkIdent(o.Target); err != nil {
+			return fmt.Errorf("target of table %s: %w", name, err)
+		}
+	}
+	switch cfg.Sequences {
+	case "", SequencesCopy, SequencesBump:
+	default:
+		return fmt.Errorf("unknown sequence syncing %q: want %s or %s", cfg.Sequences, SequencesCopy, SequencesBump)
+	}
+	switch cfg.KeylessMatch {
+	case "", KeylessRowid, KeylessColumns:
+	default:
+		return fmt.Errorf("unknown keyless matching %q: want %s or %s", cfg.KeylessMatch, KeylessRowid, KeylessColumns)
+	}
+	if cfg.MaxDeleteFraction < 0 || cfg.MaxDeleteFraction > 1 {
+		return fmt.Errorf("invalid delete fraction %g: want a value between 0 and 1", cfg.MaxDeleteFraction)
+	}
+	for name, o := range cfg.TableOptions {
+		if o.MaxDeleteFraction < 0 || o.MaxDeleteFraction > 1 {
+			return fmt.Errorf("invalid delete fraction %g of table %s: want a value between 0 and 1", o.MaxDeleteFraction, name)
+		}
+	}
+	if cfg.Tracked {
+		switch {
+		case isRemote(cfg.SrcDbPath):
+			return errors.New("tracked syncs need a local source, whose change log they trim")
+		case len(cfg.UnionSources) > 0 || cfg.SrcImmutable:
+			return errors.New("tracked syncs read and trim the change log of a single writable source")
+		case cfg.StatePath != "" || cfg.RangeDiff:
+			return errors.New("tracked syncs follow the change log, without watermarks nor range diffing")
+		}
+	}
+	if isHTTP(cfg.DstDbPath) {
+		return fmt.Errorf("can't write the target %s over HTTP", cfg.DstDbPath)
+	}
+	if cfg.History && isRemote(cfg.DstDbPath) {
+		return errors.New("the run history is only recorded in local targets")
+	}
+	if cfg.DropSchemaObjects && !cfg.SchemaObjects {
+		return errors.New("dropping schema objects needs schema objects synced")
+	}
+	if cfg.WatermarkColumn != "" && cfg.StatePath == "" {
+		return fmt.Errorf("a watermark column needs a state file")
+	}
+	for table, opts := range cfg.TableOptions {
+		for column, rule := range opts.Mask {
+			if err := checkMask(rule); err != nil {
+				return fmt.Errorf("masking %s.%s: %w", table, column, err)
+			}
+		}
+		if len(opts.Backfill) > 0 && !cfg.MigrateSchema {
+			return fmt.Errorf("backfilling the columns of %s needs the schema migrated", table)
+		}
+	}
+	if cfg.Backfill && !cfg.MigrateSchema {
+		return errors.New("backfilling columns needs the schema migrated")
+	}
+	if err := checkChecksum(cfg.Checksum); err != nil {
+		return err
+	}
+	switch cfg.FixEncoding {
+	case "", EncodingRepair, EncodingBlob, EncodingReject:
+	default:
+		return fmt.Errorf("unknown encoding policy %q: want %s, %s or %s",
+			cfg.FixEncoding, EncodingRepair, EncodingBlob, EncodingReject)
+	}
+	return nil
+}
+
+// Sync copies the rows of the selected tables from the source to the target
+// database and reports what was changed. The returned Stats cover every table
+// processed before an error, if any. It is the same as Syncer.Sync with a
+// Syncer holding cfg.
+func Sync(cfg Config) (*Stats, error) {
+	return SyncContext(context.Background(), cfg)
+}
+
+// SyncContext is like Sync but stops as soon as ctx is done.
+func SyncContext(ctx context.Context, cfg Config) (*Stats, error) {
+	return (&Syncer{cfg: cfg}).Sync(ctx)
+}
+
+// Sync runs the sync, see the package Sync function. It stops as soon as ctx
+// is done: the transaction of the table being synced at that point is rolled
+// back, tables committed before it are kept.
+func (s *Syncer) Sync(ctx context.Context) (*Stats, error) {
+	cfg := s.cfg
+	log := cfg.logger()
+	log.InfoContext(ctx, "sync started", "source", cfg.SrcDbPath, "target", cfg.DstDbPath)
+	start := time.Now()
+	stats, err := syncRun(ctx, cfg)
+	if h := cfg.Hooks.AfterSync; h != nil {
+		if hookErr := h(ctx, stats, err); err == nil {
+			err = hookErr
+		}
+	}
+	report := newReport(cfg, start, stats, err)
+	if cfg.ReportPath != "" {
+		if reportErr := writeJSONFile(cfg.ReportPath, report); reportErr != nil && err == nil {
+			err = fmt.Errorf("writing report: %w", reportErr)
+		}
+	}
+	if cfg.History && !cfg.Simulate {
+		if historyErr := saveRun(ctx, cfg, report); historyErr != nil && err == nil {
+			err = fmt.Errorf("recording run: %w", historyErr)
+		}
+	}
+	if cfg.NotifyURL != "" || cfg.NotifyCmd != "" {
+		if notifyErr := notify(ctx, cfg, report); notifyErr != nil && err == nil {
+			err = notifyErr
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("opening source db: %w", err)
+		log.ErrorContext(ctx, "sync failed", "tables", len(stats.Tables), "duration", stats.Duration, "error", err)
+	} else {
+		log.InfoContext(ctx, "sync finished", "tables", len(stats.Tables), "duration", stats.Duration)
+	}
+	return stats, err
+}
+
+// syncRun does the work of SyncContext.
+func syncRun(ctx context.Context, cfg Config) (*Stats, error) {
+	// An invalid run doesn't leave a lock file behind
+	if err := cfg.validate(); err != nil {
+		return &Stats{}, classify(ErrInvalidConfig, err)
+	}
+	// A simulation doesn't write the target
+	if !cfg.locked && !cfg.Simulate && cfg.DstDbPath != "" {
+		unlock, err := waitLock(ctx, cfg)
+		if err != nil {
+			return &Stats{}, err
+		}
+		defer unlock()
+		cfg.locked = true
+	}
+	if hasRemotePaths(cfg) {
+		return syncRemote(ctx, cfg)
+	}
+	return syncLocal(ctx, cfg)
+}
+
+// syncLocal syncs databases on the local filesystem, for a Config syncRun
+// validated.
+func syncLocal(ctx context.Context, cfg Config) (stats *Stats, err error) {
+	start := time.Now()
+	stats = &Stats{}
+	defer func() { stats.Duration = time.Since(start) }()
+
+	srcPaths := append([]string{cfg.SrcDbPath}, cfg.UnionSources...)
+	switch {
+	case len(cfg.snapshots) > 0:
+		// SyncTargets took the snapshot for every target
+		srcPaths = cfg.snapshots
+	case cfg.Snapshot:
+		dir, err := os.MkdirTemp("", "rslite-snapshot-")
+		if err != nil {
+			return stats, err
+		}
+		defer os.RemoveAll(dir)
+		if srcPaths, err = snapshotSources(ctx, srcPaths, dir, cfg.driver(), sourceParams(cfg)); err != nil {
+			return stats, err
+		}
+		cfg.snapshots = srcPaths
+	}
+
+	src, err := openReadOnly(cfg.driver(), srcPaths[0], sourceParams(cfg))
+	if err != nil {
+		return stats, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
 	}
 	defer src.Close()
+	srcs := []*sql.DB{src}
+	for _, path := range srcPaths[1:] {
+		db, err := openReadOnly(cfg.driver(), path, sourceParams(cfg))
+		if err != nil {
+			return stats, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+		}
+		defer db.Close()
+		srcs = append(srcs, db)
+	}
+
+	if cfg.CheckSource {
+		for i, db := range srcs {
+			if err := checkIntegrity(ctx, db, srcPaths[i], ErrCorruptSource, cfg, stats); err != nil {
+				return stats, err
+			}
+		}
+	}
 
-	dst, err := sql.Open("sqlite3", cfg.DstDbPath)
+	dstParams := url.Values{}
+	if cfg.DstTxLock != "" {
+		dstParams.Set("_txlock", cfg.DstTxLock)
+	}
+	if cfg.DeferConstraints {
+		dstParams.Set("_foreign_keys", "0")
+	}
+	if cfg.BusyTimeout > 0 {
+		dstParams.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	var (
+		dst  *sql.DB
+		keep *sql.Conn // holding the simulated target
+	)
+	if cfg.Simulate {
+		// Nothing is written next to the target either, and the in-memory
+		// database takes a single writer
+		cfg.AttachmentDirs, cfg.ExternalizeBlobs, cfg.Jobs = nil, 0, 1
+		dst, keep, err = openSimulation(ctx, cfg.targetDriver(), cfg.DstDbPath, dstParams)
+	} else {
+		var restore func(context.Context) error
+		if restore, err = saveJournalMode(ctx, cfg, dstParams); err != nil {
+			return stats, classify(ErrOpenTarget, err)
+		}
+		if restore != nil {
+			// Once every target connection is closed
+			defer func() {
+				if restoreErr := restore(context.WithoutCancel(ctx)); err == nil {
+					err = restoreErr
+				}
+			}()
+		}
+		dst, err = openDB(cfg.targetDriver(), dsn(cfg.DstDbPath, dstParams))
+	}
 	if err != nil {
-		return fmt.Errorf("opening target db: %w", err)
+		return stats, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
 	}
 	defer dst.Close()
+	if keep != nil {
+		defer keep.Close()
+	}
 
-	tables, err := getTables(src)
+	tables, unsupported, err := getTables(ctx, src)
 	if err != nil {
-		return err
+		return stats, classify(ErrOpenSource, err)
 	}
 
-	// Add this block to filter tables if specified
-	if len(cfg.Tables) > 0 {
-		tableMap := make(map[string]bool)
-		for _, t := range cfg.Tables {
-			tableMap[t] = true
+	if tables, err = selectTables(tables, cfg); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	tables, indexes := indexTables(tables)
+	for _, t := range indexes {
+		switch {
+		case t.content == "":
+			unsupported = append(unsupported, Unsupported{Type: "table", Name: t.name, Reason: "contentless full-text table, its rows can't be read"})
+		case !cfg.RebuildFTS:
+			unsupported = append(unsupported, Unsupported{Type: "table", Name: t.name, Reason: fmt.Sprintf("full-text index of table %s, not rebuilt", t.content)})
+		}
+	}
+	if err := matchKeyless(tables, cfg); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	if err := checkColumns(tables, cfg); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	if cfg.StatePath != "" {
+		// Watch keeps the state loaded between runs
+		if cfg.state == nil {
+			if cfg.state, err = loadState(cfg.StatePath, cfg.Checksum); err != nil {
+				return stats, err
+			}
 		}
-
-		filteredTables := make([]Table, 0)
 		for _, table := range tables {
-			if tableMap[table.name] {
-				filteredTables = append(filteredTables, table)
+			if _, err := watermarkIndex(table, cfg); err != nil {
+				return stats, classify(ErrInvalidConfig, err)
 			}
 		}
-		tables = filteredTables
 	}
 
+	var rec *recorder
+	if cfg.RecordPath != "" {
+		if rec, err = newRecorder(cfg.RecordPath, cfg); err != nil {
+			return stats, err
+		}
+	}
+
+	if h := cfg.Hooks.BeforeSync; h != nil {
+		names := make([]string, len(tables))
+		for i, t := range tables {
+			names[i] = t.name
+		}
+		if err := h(ctx, names); err != nil {
+			rec.close()
+			return stats, err
+		}
+	}
+
+	attachments, err := syncAttachments(ctx, cfg, stats)
+	if err != nil {
+		rec.close()
+		return stats, err
+	}
+
+	// Checked before anything opens the target and creates its file
+	seeding := canSeed(cfg)
+
+	// A seeded target is a copy of the whole source
+	if !seeding {
+		for _, u := range unsupported {
+			if cfg.selects(u.Name) {
+				stats.Unsupported = append(stats.Unsupported, u)
+			}
+		}
+		objects, err := findUnsupported(ctx, src, tables, cfg)
+		if err != nil {
+			rec.close()
+			return stats, fmt.Errorf("listing unsupported objects: %w", err)
+		}
+		stats.Unsupported = append(stats.Unsupported, objects...)
+	}
+
+	if cfg.MigrateSchema && !seeding {
+		if err := migrateSchema(ctx, src, dst, tables, cfg, &stats.Migration); err != nil {
+			rec.close()
+			return stats, fmt.Errorf("migrating target schema: %w", err)
+		}
+	}
+
+	var triggers []trigger
+	if cfg.DisableTriggers && !seeding {
+		if triggers, err = dropTriggers(ctx, dst, tables); err != nil {
+			rec.close()
+			return stats, err
+		}
+	}
+
+	switch {
+	case seeding:
+		cfg.logger().InfoContext(ctx, "seeding target with a copy of the source")
+		err = seedTarget(ctx, src, dst, tables, cfg, stats)
+	case cfg.Atomic:
+		err = syncAtomic(ctx, srcs, dst, tables, cfg, rec, stats)
+	default:
+		err = syncTables(ctx, srcs, dst, tables, cfg, rec, stats)
+	}
+	if restoreErr := restoreTriggers(dst, triggers); err == nil {
+		err = restoreErr
+	}
+	if err == nil && cfg.Mirror && !seeding {
+		stats.DroppedTables, err = dropExtraTables(ctx, src, dst, tables, cfg)
+	}
+	if err == nil && cfg.SchemaObjects && !seeding {
+		err = syncSchemaObjects(ctx, src, dst, tables, cfg, &stats.Schema)
+	}
+	if err == nil && cfg.CopyVersion {
+		err = copyVersion(ctx, src, dst)
+	}
+	if err == nil && cfg.Sequences != "" && !seeding {
+		err = syncSequences(ctx, src, dst, tables, cfg)
+	}
+	if err == nil && cfg.RebuildFTS && !seeding {
+		err = rebuildIndexes(ctx, dst, append(tables, indexes...), cfg)
+	}
+	if err == nil && !cfg.NoDelete {
+		for _, dirs := range attachments {
+			if err = pruneFiles(ctx, dirs[0], dirs[1], &stats.Files); err != nil {
+				err = fmt.Errorf("pruning attachments: %w", err)
+				break
+			}
+		}
+	}
+	if err == nil && cfg.DeferConstraints {
+		var n int
+		if stats.Violations, n, err = checkForeignKeys(ctx, dst); err == nil && n > 0 {
+			err = classify(ErrVerification, fmt.Errorf("%d foreign key violations in the target", n))
+		}
+	}
+	// Whatever was written before a failure
+	if cfg.CheckTarget {
+		if checkErr := checkIntegrity(ctx, dst, cfg.DstDbPath, ErrVerification, cfg, stats); err == nil {
+			err = checkErr
+		}
+	}
+	if err == nil && (cfg.Verify || cfg.VerifySample > 0) {
+		stats.Verified, err = verifyTables(ctx, src, dst, tables, cfg)
+	}
+	if err == nil && (cfg.Analyze || cfg.Vacuum) && !cfg.Simulate {
+		err = maintainTarget(ctx, dst, tables, cfg)
+	}
+	// Changes replayed to a committed table are done with
+	if cfg.Tracked && !cfg.Simulate && (err == nil || !cfg.Atomic) {
+		if trimErr := trimChanges(ctx, cfg, stats); err == nil {
+			err = trimErr
+		}
+	}
+	// Keep the progress of the tables committed before a failure
+	if cfg.state != nil && !cfg.Simulate {
+		cfg.state.update(stats, cfg, tables)
+		if saveErr := cfg.state.save(cfg.StatePath); err == nil {
+			err = saveErr
+		}
+	}
+	if closeErr := rec.close(); err == nil {
+		err = closeErr
+	}
+	return stats, err
+}
+
+// syncAttachments copies the files of Config.AttachmentDirs and of the
+// source BLOB directory to the target ahead of the rows. It returns the
+// attachment directories as source and target pairs, sorted.
+func syncAttachments(ctx context.Context, cfg Config, stats *Stats) ([][2]string, error) {
+	var dirs [][2]string
+	for src, dst := range cfg.AttachmentDirs {
+		dirs = append(dirs, [2]string{src, dst})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i][0] < dirs[j][0] })
+	for _, d := range dirs {
+		if err := copyFiles(ctx, d[0], d[1], &stats.Files); err != nil {
+			return nil, fmt.Errorf("copying attachments: %w", err)
+		}
+	}
+
+	if blobs := newBlobStore(cfg); blobs != nil {
+		srcDir := dbFile(cfg.SrcDbPath) + ".blobs"
+		if info, err := os.Stat(srcDir); err == nil && info.IsDir() {
+			if err := copyFiles(ctx, srcDir, blobs.dir, &stats.Files); err != nil {
+				return nil, fmt.Errorf("copying blobs: %w", err)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// syncTables syncs the tables in order, or concurrently with Config.Jobs.
+// srcs holds the source database followed by the UnionSources.
+func syncTables(ctx context.Context, srcs []*sql.DB, dst *sql.DB, tables []Table, cfg Config, rec *recorder, stats *Stats) error {
+	// A recording has to replay table by table
+	if cfg.Jobs > 1 && rec == nil {
+		return syncTablesParallel(ctx, srcs, dst, tables, cfg, stats)
+	}
+
+	var errs []error
 	for _, table := range tables {
-		if err := syncTable(src, dst, table, cfg); err != nil {
-			return fmt.Errorf("syncing table %s: %w", table.name, err)
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		tableStats, err := syncTableWithHooks(ctx, cfg, table, func() (TableStats, error) {
+			return syncTable(ctx, srcs, dst, nil, table, cfg, rec)
+		})
+		if err != nil {
+			errs = append(errs, &TableError{Table: table.name, Err: err})
+			if !cfg.KeepGoing {
+				break
+			}
+			continue
+		}
+		stats.Tables = append(stats.Tables, tableStats)
+	}
+	return errors.Join(errs...)
+}
+
+// checkColumns fails when a table lacks Config.FilterColumn or
+// UpdatedColumn, rather than letting its query fail halfway through the run.
+func checkColumns(tables []Table, cfg Config) error {
+	for _, table := range tables {
+		if cfg.FilterColumn != "" && !containsFold(table.columns, cfg.FilterColumn) {
+			return fmt.Errorf("table %s has no filter column %s", table.name, cfg.FilterColumn)
+		}
+		if cfg.UpdatedColumn != "" && !containsFold(table.columns, cfg.UpdatedColumn) {
+			return fmt.Errorf("table %s has no updated column %s", table.name, cfg.UpdatedColumn)
 		}
 	}
 	return nil
 }
 
+// parseSince parses Config.Since.
+func parseSince(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid since time %q: want RFC 3339 or YYYY-MM-DD", s)
+}
+
+// dsn appends driver parameters to a database path.
+func dsn(path string, params url.Values) string {
+	if len(params) == 0 {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + params.Encode()
+}
+
+// queryer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 type Table struct {
 	name    string
 	columns []string
 	pkCol   string
+	keyCols []string // primary key columns in key order, or the rowid
+	pageKey []string // unique ordering used to read the table in pages
+	target  string   // name in the target when it differs, see TableOptions
+	// byContent matches the rows on all their columns, see KeylessMatch
+	byContent bool
+	// module is the module of a virtual table; external marks a full-text
+	// table indexing the rows of its content table, see ftsContent
+	module   string
+	content  string
+	external bool
+	// schema holds the columns declared in the source, whatever the synced
+	// ones, see IgnoreSchemaDiff
+	schema []columnDef
+}
+
+// targetName returns the name of the table in the target database.
+func (t Table) targetName() string {
+	if t.target != "" {
+		return t.target
+	}
+	return t.name
+}
+
+// rowColumns returns the columns of the rows read from the table: the pk
+// column followed by table.columns, so that a declared pk column is listed
+// twice while the rowid of a table without one is read along.
+func (t Table) rowColumns() []string {
+	return append([]string{t.pkCol}, t.columns...)
 }
 
-func getTables(db *sql.DB) ([]Table, error) {
-	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type='table'`)
+// keyValues returns the values of the key columns of a row laid out as
+// rowColumns.
+func (t Table) keyValues(values []interface{}) []interface{} {
+	cols := t.rowColumns()
+	key := make([]interface{}, len(t.keyCols))
+	for i, k := range t.keyCols {
+		key[i] = values[slices.IndexFunc(cols, func(c string) bool { return strings.EqualFold(c, k) })]
+	}
+	return key
+}
+
+// pkDeclared reports whether the pk column is one of table.columns.
+func (t Table) pkDeclared() bool {
+	return containsFold(t.columns, t.pkCol)
+}
+
+// writtenColumns returns the columns written to the target for a row laid
+// out as rowColumns: table.columns, preceded by the pk column only when it
+// isn't one of them, as the rowid.
+func (t Table) writtenColumns() []string {
+	if t.pkDeclared() {
+		return t.columns
+	}
+	return t.rowColumns()
+}
+
+// writtenValues returns the values of writtenColumns of a row laid out as
+// rowColumns.
+func (t Table) writtenValues(values []interface{}) []interface{} {
+	if t.pkDeclared() {
+		return values[1:]
+	}
+	return values
+}
+
+// quoteIdent returns name quoted as an SQL identifier, so that names with
+// spaces, dashes, quotes or of reserved words can be part of the queries.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteIdents returns the comma separated list of the quoted names.
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// checkIdent fails when name can't be an identifier, holding a NUL
+// character SQLite would end it at.
+func checkIdent(name string) error {
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("invalid identifier %q", name)
+	}
+	return nil
+}
+
+// getTables returns the tables of a database, and the virtual tables left
+// out because their module is not loaded.
+func getTables(ctx context.Context, db *sql.DB) ([]Table, []Unsupported, error) {
+	shadows, err := shadowTables(ctx, db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing shadow tables: %w", err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT name, sql FROM sqlite_master WHERE type='table'`)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
-	var tables []Table
+	var (
+		tables      []Table
+		unsupported []Unsupported
+	)
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
+		var (
+			name string
+			ddl  sql.NullString
+		)
+		if err := rows.Scan(&name, &ddl); err != nil {
+			return nil, nil, err
+		}
+		if shadows[name] || name == changeLog || name == historyTable || name == appliedTable || name == sequenceTable {
+			continue
 		}
 
-		table, err := getTableInfo(db, name)
+		table, err := getTableInfo(ctx, db, name)
 		if err != nil {
-			return nil, err
+			module := virtualModule(ddl.String)
+			if module != "" && isMissingModule(err) {
+				unsupported = append(unsupported, Unsupported{
+					Type:   "table",
+					Name:   name,
+					Reason: fmt.Sprintf("virtual table module %s is not loaded", module),
+				})
+				continue
+			}
+			if module != "" {
+				return nil, nil, fmt.Errorf("virtual table %s using %s: %w", name, module, err)
+			}
+			return nil, nil, err
 		}
+		table.module = virtualModule(ddl.String)
+		table.content, table.external = ftsContent(ddl.String)
 		tables = append(tables, table)
 	}
-	return tables, nil
+	return tables, unsupported, rows.Err()
 }
 
-func getTableInfo(db *sql.DB, tableName string) (Table, error) {
-	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
+func getTableInfo(ctx context.Context, db *sql.DB, tableName string) (Table, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(tableName)))
 	if err != nil {
 		return Table{}, err
 	}
@@ -103,6 +1196,7 @@ func getTableInfo(db *sql.DB, tableName string) (Table, error) {
 	var table Table
 	table.name = tableName
 
+	pkCols := map[int]string{}
 	for rows.Next() {
 		var (
 			cid      int
@@ -116,106 +1210,278 @@ func getTableInfo(db *sql.DB, tableName string) (Table, error) {
 			return Table{}, err
 		}
 		table.columns = append(table.columns, name)
+		table.schema = append(table.schema, columnDef{name: name, typ: type_, notNull: notnull != 0, dflt: dflt_val})
 		if pk > 0 {
 			table.pkCol = name
+			pkCols[pk] = name
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return Table{}, err
+	}
+	rows.Close()
 
 	if table.pkCol == "" {
 		table.pkCol = "rowid" // SQLite default
 	}
 
+	keyCols := make([]string, len(pkCols))
+	for i := range keyCols {
+		keyCols[i] = pkCols[i+1]
+	}
+	table.pageKey, err = pageKeyFor(ctx, db, table, keyCols)
+	if err != nil {
+		return Table{}, err
+	}
+	table.keyCols = keyCols
+	if len(keyCols) == 0 {
+		table.keyCols = []string{table.pkCol}
+	}
+
 	return table, nil
 }
 
-func syncTable(src, dst *sql.DB, table Table, cfg Config) error {
-	tx, err := dst.Begin()
-	if err != nil {
-		return err
+func syncTable(ctx context.Context, srcs []*sql.DB, dst *sql.DB, lock gosync.Locker, table Table, cfg Config, rec *recorder) (stats TableStats, err error) {
+	log := cfg.logger().With("table", table.name)
+	log.InfoContext(ctx, "table started", "target", table.targetName())
+	if table.keyless() && !table.byContent {
+		log.WarnContext(ctx, "table has no primary key: rows are matched by rowid, duplicating the target rows not written by rslite; see --keyless")
 	}
-	defer tx.Rollback()
+	defer func(start time.Time) { logTable(ctx, log, stats, start, err) }(time.Now())
 
-	// Prepare statements
-	insertQuery := buildInsertQuery(table)
-	insert, err := tx.Prepare(insertQuery)
+	var attach string
+	if canAttach(cfg) {
+		attach = readOnlyDSN(cfg.sourcePath(), sourceParams(cfg))
+	}
+	w, err := newTableWriter(ctx, dst, lock, table, cfg, attach)
 	if err != nil {
-		return err
+		return TableStats{Table: table.name}, err
+	}
+	defer w.close()
+	if attach != "" {
+		return syncAttached(ctx, w, cfg)
 	}
-	defer insert.Close()
 
-	deleteStmt, err := tx.Prepare(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table.name, table.pkCol))
+	srcTxs, err := beginSources(ctx, srcs, table, cfg)
+	defer func() {
+		for _, tx := range srcTxs {
+			tx.Rollback()
+		}
+	}()
 	if err != nil {
-		return err
+		return w.stats, err
 	}
-	defer deleteStmt.Close()
+
+	rec.table(table)
+	defer func() { rec.done(table.name, err) }()
 
 	// Sync rows from source to target
-	selectQuery := buildSelectQuery(table, cfg)
-	var rows *sql.Rows
-	if cfg.Value != "" {
-		rows, err = src.Query(selectQuery, cfg.Value)
-	} else {
-		rows, err = src.Query(selectQuery)
+	cols := table.rowColumns()
+	where, args := buildFilter(table, cfg)
+	if cfg.Tracked {
+		if w.stats.lastChange, err = lastChange(ctx, srcTxs[0].Tx, table); err != nil {
+			return w.stats, err
+		}
+		where, args = trackedFilter(table, where, args, w.stats.lastChange)
 	}
-	if err != nil {
-		return err
+	tombstone := -1
+	if cfg.SoftDeleteColumn != "" {
+		tombstone = slices.IndexFunc(cols, func(c string) bool { return strings.EqualFold(c, cfg.SoftDeleteColumn) })
 	}
-	defer rows.Close()
-
-	cols := append([]string{table.pkCol}, table.columns...)
-	values := make([]interface{}, len(cols))
-	scanPtrs := make([]interface{}, len(cols))
-	for i := range values {
-		scanPtrs[i] = &values[i]
+	watermark := -1
+	if cfg.state != nil {
+		if watermark, err = watermarkIndex(table, cfg); err != nil {
+			return w.stats, err
+		}
 	}
-
-	for rows.Next() {
-		if err := rows.Scan(scanPtrs...); err != nil {
-			return err
+	pageSize := cfg.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	var progress *progressReporter
+	if cfg.Progress != nil {
+		var total int64
+		for _, tx := range srcTxs {
+			n, err := countSourceRows(ctx, tx, quoteIdent(table.name), where, args)
+			if err != nil {
+				return w.stats, err
+			}
+			total += n
 		}
-		if _, err := insert.Exec(values...); err != nil {
-			return err
+		progress = newProgressReporter(cfg, table, total)
+	}
+	read := func(ctx context.Context, fn func(values []interface{}) error) error {
+		return scanSources(ctx, srcTxs, table, cols, where, args, pageSize, fn)
+	}
+	err = pipeRows(ctx, pageSize, read, func(values []interface{}) error {
+		progress.add(1)
+		if watermark >= 0 {
+			w.stats.Watermark = maxWatermark(w.stats.Watermark, values[watermark])
 		}
+		if tombstone >= 0 && isTombstone(values[tombstone]) {
+			if table.byContent {
+				// Left out of the staged rows, so deleted like the others
+				w.skip()
+				return nil
+			}
+			key := table.keyValues(values)
+			rec.remove(table.name, key)
+			return w.deleteKey(ctx, key)
+		}
+		if cfg.RowTransform != nil {
+			keep, err := transformRow(table, values, cfg.RowTransform)
+			if err != nil {
+				return fmt.Errorf("transforming row %v: %w", values[0], err)
+			}
+			if !keep {
+				rec.skip(table.name)
+				w.skip()
+				return nil
+			}
+		}
+		if masks := cfg.TableOptions[table.name].Mask; len(masks) > 0 {
+			maskRow(table, values, masks)
+		}
+		if cfg.FixEncoding != "" {
+			reason, reject := fixEncoding(table, values, cfg.FixEncoding)
+			if reason != "" {
+				issue := RowIssue{PK: values[0], Reason: reason}
+				rec.flag(table.name, issue)
+				w.flag(issue)
+			}
+			if reject {
+				rec.skip(table.name)
+				w.skip()
+				return nil
+			}
+		}
+		if reason := checkRow(table, values, cfg); reason != "" {
+			issue := RowIssue{PK: values[0], Reason: reason}
+			rec.flag(table.name, issue)
+			w.flag(issue)
+			if cfg.SkipFlagged {
+				rec.skip(table.name)
+				w.skip()
+				return nil
+			}
+		}
+		if cfg.SkipUnchanged && !table.byContent {
+			same, err := w.unchanged(ctx, values)
+			if err != nil {
+				return fmt.Errorf("comparing with target: %w", err)
+			}
+			if same {
+				rec.unchanged(table.name)
+				w.skipUnchangedRow()
+				return nil
+			}
+		}
+		rec.row(table.name, values)
+		return w.upsert(ctx, values)
+	})
+	if err != nil {
+		return w.stats, err
 	}
 
 	// Delete orphaned rows if not using no-delete flag
-	if !cfg.NoDelete {
-		// Get list of IDs from source
-		var sourceIDs []interface{}
-		srcRows, err := src.Query(fmt.Sprintf("SELECT %s FROM %s", table.pkCol, table.name))
-		if err != nil {
-			return fmt.Errorf("querying source IDs: %w", err)
+	if table.byContent {
+		if err := w.applyStaged(ctx, cfg.deletes(table)); err != nil {
+			return w.stats, err
 		}
-		defer srcRows.Close()
-
-		for srcRows.Next() {
-			var id interface{}
-			if err := srcRows.Scan(&id); err != nil {
-				return fmt.Errorf("scanning source ID: %w", err)
+	} else if cfg.Tracked {
+		if cfg.deletes(table) {
+			if err := deleteTracked(ctx, srcTxs[0].Tx, w, rec, w.stats.lastChange); err != nil {
+				return w.stats, err
 			}
-			sourceIDs = append(sourceIDs, id)
+		}
+	} else if cfg.deletes(table) {
+		// Stage the keys from source
+		err := scanSources(ctx, srcTxs, table, table.keyCols, "", nil, cfg.PageSize, func(values []interface{}) error {
+			rec.keep(table.name, values)
+			return w.keep(ctx, values)
+		})
+		if err != nil {
+			return w.stats, fmt.Errorf("staging source IDs: %w", err)
 		}
 
 		// Delete rows from target that don't exist in source
-		if len(sourceIDs) > 0 {
-			placeholders := strings.Repeat("?,", len(sourceIDs))
-			placeholders = placeholders[:len(placeholders)-1] // Remove trailing comma
-			query := fmt.Sprintf("DELETE FROM %s WHERE %s NOT IN (%s)",
-				table.name, table.pkCol, placeholders)
-
-			if _, err := tx.Exec(query, sourceIDs...); err != nil {
-				return fmt.Errorf("deleting orphaned rows: %w", err)
-			}
+		rec.deleteOrphans(table.name)
+		if err := w.deleteOrphans(ctx); err != nil {
+			return w.stats, err
 		}
 	}
 
-	return tx.Commit()
+	if stats, err = w.commit(ctx); err == nil {
+		progress.done()
+	}
+	return stats, err
+}
+
+// sourceTx is a transaction on one of the source databases, with the page
+// key of the synced table in that database.
+type sourceTx struct {
+	*sql.Tx
+	pageKey []string
 }
 
-func buildSelectQuery(table Table, cfg Config) string {
-	cols := append([]string{table.pkCol}, table.columns...)
-	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), table.name)
+// beginSources starts a source transaction on every source database holding
+// the table, checking that it has the columns read from the first one.
+func beginSources(ctx context.Context, srcs []*sql.DB, table Table, cfg Config) ([]sourceTx, error) {
+	srcOpts := cfg.SrcTxOptions
+	if srcOpts == nil {
+		srcOpts = &sql.TxOptions{ReadOnly: true}
+	}
+
+	var txs []sourceTx
+	for i, src := range srcs {
+		pageKey := table.pageKey
+		if i > 0 {
+			exists, err := tableExists(ctx, src, table.name)
+			if err != nil {
+				return txs, err
+			}
+			if !exists {
+				continue
+			}
+			other, err := getTableInfo(ctx, src, table.name)
+			if err != nil {
+				return txs, err
+			}
+			for _, c := range table.columns {
+				if !containsFold(other.columns, c) {
+					return txs, classify(ErrSchemaMismatch, fmt.Errorf("source %s has no column %s", cfg.UnionSources[i-1], c))
+				}
+			}
+			pageKey = other.pageKey
+		}
+
+		tx, err := src.BeginTx(ctx, srcOpts)
+		if err != nil {
+			return txs, fmt.Errorf("starting source transaction: %w", err)
+		}
+		txs = append(txs, sourceTx{tx, pageKey})
+	}
+	return txs, nil
+}
 
+// scanSources is scanPages over every source transaction in turn.
+func scanSources(ctx context.Context, txs []sourceTx, table Table, cols []string, where string, args []interface{}, pageSize int, fn func(values []interface{}) error) error {
+	for _, tx := range txs {
+		table.pageKey = tx.pageKey
+		if err := scanPages(ctx, tx, table, cols, where, args, pageSize, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildFilter returns the condition selecting the source rows to sync, and its
+// arguments. An empty condition selects every row.
+func buildFilter(table Table, cfg Config) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
 	if cfg.Filter != "" && cfg.Value != "" {
 		var op string
 		switch cfg.Filter {
@@ -229,66 +1495,123 @@ func buildSelectQuery(table Table, cfg Config) string {
 			op = "<="
 		}
 		if op != "" {
-			query += fmt.Sprintf(" WHERE %s %s ?", table.pkCol, op)
+			col := table.pkCol
+			if cfg.FilterColumn != "" {
+				col = cfg.FilterColumn
+			}
+			conds = append(conds, fmt.Sprintf("%s %s ?", quoteIdent(col), op))
+			args = append(args, cfg.Value)
+		}
+	}
+	if cfg.Since != "" {
+		// validate checked it
+		since, _ := parseSince(cfg.Since)
+		conds = append(conds, fmt.Sprintf(
+			"(CASE WHEN typeof(%[1]s) IN ('integer', 'real') THEN %[1]s ELSE unixepoch(%[1]s, 'subsec') END) > ?",
+			quoteIdent(cfg.UpdatedColumn)))
+		args = append(args, float64(since.UnixNano())/1e9)
+	}
+	if cfg.state != nil {
+		col := watermarkColumn(table, cfg)
+		if since, ok := cfg.state.since(table.name, col); ok {
+			conds = append(conds, fmt.Sprintf("%s > ?", quoteIdent(col)))
+			args = append(args, since)
+		}
+	}
+	for _, where := range []string{cfg.Where, cfg.TableWhere[table.name], cfg.TableOptions[table.name].Where} {
+		if where != "" {
+			conds = append(conds, "("+where+")")
 		}
 	}
-	return query
+	return strings.Join(conds, " AND "), args
 }
 
-func buildInsertQuery(table Table) string {
-	cols := append([]string{table.pkCol}, table.columns...)
+// isTombstone reports whether a value of Config.SoftDeleteColumn marks a
+// deleted row.
+func isTombstone(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case int64:
+		return x != 0
+	case float64:
+		return x != 0
+	case bool:
+		return x
+	case string:
+		return x != "" && x != "0"
+	case []byte:
+		return len(x) > 0
+	default:
+		return true
+	}
+}
+
+// jsonColumns returns the columns of a table listed in Config.JSONColumns.
+func jsonColumns(table Table, cfg Config) []string {
+	var cols []string
+	for _, c := range table.columns {
+		for _, name := range cfg.JSONColumns {
+			if strings.EqualFold(name, c) || strings.EqualFold(name, table.name+"."+c) {
+				cols = append(cols, c)
+				break
+			}
+		}
+	}
+	return cols
+}
+
+// buildUpsertQuery returns the statement writing the writtenColumns of a
+// row, updating the target row with the same primary key in place.
+func buildUpsertQuery(table Table, cfg Config) string {
+	cols := table.writtenColumns()
 	placeholders := make([]string, len(cols))
 	for i := range placeholders {
 		placeholders[i] = "?"
 	}
+
 	return fmt.Sprintf(
-		"INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
-		table.name,
-		strings.Join(cols, ", "),
+		"INSERT INTO %s (%s) VALUES (%s) %s",
+		quoteIdent(table.targetName()),
+		quoteIdents(cols),
 		strings.Join(placeholders, ", "),
+		upsertClause(table, cfg),
 	)
 }
 
-func deleteOrphans(src, dst *sql.DB, table Table, deleteStmt *sql.Stmt, cfg Config) error {
-	query := buildSelectQuery(table, cfg)
-	var rows *sql.Rows
-	var err error
-
-	if cfg.Value != "" {
-		rows, err = dst.Query(query, cfg.Value)
-	} else {
-		rows, err = dst.Query(query)
-	}
-	if err != nil {
-		return err
+// upsertClause returns the ON CONFLICT clause of buildUpsertQuery.
+func upsertClause(table Table, cfg Config) string {
+	jsonCols := jsonColumns(table, cfg)
+	var set []string
+	for _, c := range table.columns {
+		switch {
+		case containsFold(table.keyCols, c):
+		case containsFold(jsonCols, c):
+			set = append(set, fmt.Sprintf(
+				"%[1]s = CASE WHEN json_valid(%[1]s) AND json_valid(excluded.%[1]s) THEN json_patch(%[1]s, excluded.%[1]s) ELSE excluded.%[1]s END", quoteIdent(c)))
+		default:
+			set = append(set, fmt.Sprintf("%[1]s = excluded.%[1]s", quoteIdent(c)))
+		}
 	}
-	defer rows.Close()
-
-	values := make([]interface{}, len(table.columns)+1)
-	scanPtrs := make([]interface{}, len(values))
-	for i := range values {
-		scanPtrs[i] = &values[i]
+	action := "NOTHING"
+	if len(set) > 0 {
+		action = "UPDATE SET " + strings.Join(set, ", ")
 	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO %s", quoteIdents(table.keyCols), action)
+}
 
-	for rows.Next() {
-		if err := rows.Scan(scanPtrs...); err != nil {
-			return err
-		}
-
-		var exists bool
-		err := src.QueryRow(
-			fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s = ?)", table.name, table.pkCol),
-			values[0],
-		).Scan(&exists)
-		if err != nil {
-			return err
-		}
-
-		if !exists {
-			if _, err := deleteStmt.Exec(values[0]); err != nil {
-				return err
-			}
-		}
+// buildInsertQuery returns the statement writing the writtenColumns of a
+// row, replacing the target row with the same primary key.
+func buildInsertQuery(table Table) string {
+	cols := table.writtenColumns()
+	placeholders := make([]string, len(cols))
+	for i := range placeholders {
+		placeholders[i] = "?"
 	}
-	return nil
+	return fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		quoteIdent(table.targetName()),
+		quoteIdents(cols),
+		strings.Join(placeholders, ", "),
+	)
 }
@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Syncer syncs a source database into a target. It is built with New and
+// Options, which set the fields of the Config it holds, so that new settings
+// can be added without breaking its users. A Syncer may be run any number of
+// times, but not concurrently on the same target.
+type Syncer struct {
+	cfg Config
+}
+
+// Option configures a Syncer.
+type Option func(*Config)
+
+// New returns a Syncer copying the source database at src into the target
+// database at dst.
+func New(src, dst string, opts ...Option) *Syncer {
+	s := &Syncer{cfg: Config{SrcDbPath: src, DstDbPath: dst}}
+	for _, opt := range opts {
+		opt(&s.cfg)
+	}
+	return s
+}
+
+// Config returns the configuration of the Syncer.
+func (s *Syncer) Config() Config {
+	return s.cfg
+}
+
+// Explain returns the tables Sync would copy and how, see Explain.
+func (s *Syncer) Explain(ctx context.Context) (*Explanation, error) {
+	return Explain(ctx, s.cfg)
+}
+
+// Plan writes the statements Sync would turn the target with, see Plan.
+func (s *Syncer) Plan(ctx context.Context, w io.Writer) (*Stats, error) {
+	return Plan(ctx, s.cfg, w)
+}
+
+// WithTables selects the tables to sync by name or glob pattern, see
+// Config.Tables.
+func WithTables(tables ...string) Option {
+	return func(cfg *Config) { cfg.Tables = append(cfg.Tables, tables...) }
+}
+
+// WithExcludeTables leaves tables out of the sync by name or glob pattern,
+// see Config.ExcludeTables.
+func WithExcludeTables(tables ...string) Option {
+	return func(cfg *Config) { cfg.ExcludeTables = append(cfg.ExcludeTables, tables...) }
+}
+
+// WithFilter selects the rows whose primary key compares to value with op:
+// gt, lt, gte or lte, see Config.Filter.
+func WithFilter(op, value string) Option {
+	return func(cfg *Config) { cfg.Filter, cfg.Value = op, value }
+}
+
+// WithWhere selects the source rows with an SQL condition, see
+// Config.Where. Given for a single table, the condition only applies to it.
+func WithWhere(where string, table ...string) Option {
+	return func(cfg *Config) {
+		if len(table) == 0 {
+			cfg.Where = where
+			return
+		}
+		if cfg.TableWhere == nil {
+			cfg.TableWhere = make(map[string]string)
+		}
+		for _, name := range table {
+			cfg.TableWhere[name] = where
+		}
+	}
+}
+
+// WithTableOptions sets the options of a table, see Config.TableOptions.
+func WithTableOptions(table string, opts TableOptions) Option {
+	return func(cfg *Config) {
+		if cfg.TableOptions == nil {
+			cfg.TableOptions = make(map[string]TableOptions)
+		}
+		cfg.TableOptions[table] = opts
+	}
+}
+
+// WithNoDelete keeps the target rows missing from the source, see
+// Config.NoDelete.
+func WithNoDelete() Option {
+	return func(cfg *Config) { cfg.NoDelete = true }
+}
+
+// WithLogger logs the run to logger, see Config.Logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *Config) { cfg.Logger = logger }
+}
+
+// WithHooks calls hooks around the run and every table, see Config.Hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(cfg *Config) { cfg.Hooks = hooks }
+}
+
+// WithProgress reports the progress of the tables to fn, see
+// Config.Progress.
+func WithProgress(fn func(Progress)) Option {
+	return func(cfg *Config) { cfg.Progress = fn }
+}
+
+// WithRowTransform rewrites or drops the rows before they are written, see
+// Config.RowTransform.
+func WithRowTransform(fn func(table string, row map[string]interface{}) (map[string]interface{}, bool, error)) Option {
+	return func(cfg *Config) { cfg.RowTransform = fn }
+}
+
+// WithConnectHook sets up every connection opened to the databases, see
+// Config.ConnectHook.
+func WithConnectHook(fn func(*sqlite3.SQLiteConn) error) Option {
+	return func(cfg *Config) { cfg.ConnectHook = fn }
+}
+
+// WithConfig changes any field of the Config, for the settings without an
+// Option of their own.
+func WithConfig(fn func(*Config)) Option {
+	return Option(fn)
+}
@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSyncer(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}, {3, "Carol"}},
+			tgtData: [][]interface{}{{9, "Zoe"}},
+		},
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, total INTEGER)`,
+			srcData: [][]interface{}{{1, 10}},
+		},
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	var log bytes.Buffer
+	s := New(srcPath, tgtPath,
+		WithTables("users"),
+		WithFilter("gt", "1"),
+		WithWhere("name != 'Carol'", "users"),
+		WithNoDelete(),
+		WithLogger(slog.New(slog.NewTextHandler(&log, nil))),
+	)
+	stats, err := s.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Inserted != 1 {
+		t.Errorf("Tables = %+v, want Bob inserted into users only", stats.Tables)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("users has %d rows, want Zoe kept and Bob added", n)
+	}
+	if n := countTestRows(t, tgtDB, "orders"); n != 0 {
+		t.Errorf("orders has %d rows, want it left out", n)
+	}
+	if !strings.Contains(log.String(), "table finished") {
+		t.Errorf("the logger got nothing:\n%s", log.String())
+	}
+
+	cfg := s.Config()
+	if cfg.SrcDbPath != srcPath || cfg.TableWhere["users"] != "name != 'Carol'" || !cfg.NoDelete {
+		t.Errorf("Config() = %+v, want the options applied", cfg)
+	}
+}
@@ -0,0 +1,248 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TableOptions holds the settings of a single table, see
+// Config.TableOptions.
+type TableOptions struct {
+	// Key is the column matching source and target rows instead of the
+	// primary key. The target needs a unique index on it. The primary key
+	// is still copied unless excluded, letting the target number the rows.
+	Key string `yaml:"key"`
+	// Where is a condition selecting the source rows of the table, like
+	// Config.TableWhere.
+	Where string `yaml:"where"`
+	// NoDelete keeps the target rows of the table missing from the source,
+	// like Config.NoDelete, and MaxDeleteFraction overrides
+	// Config.MaxDeleteFraction for the table.
+	NoDelete          bool    `yaml:"no_delete"`
+	MaxDeleteFraction float64 `yaml:"max_delete_fraction"`
+	// Columns lists the only columns copied, along with the key columns,
+	// and Exclude columns that are never copied. Target rows get their
+	// default values for the columns left out.
+	Columns []string `yaml:"columns"`
+	Exclude []string `yaml:"exclude"`
+	// Target is the name of the table in the target, when it differs.
+	Target string `yaml:"target"`
+	// Mask holds the masking rules of columns, see MaskNull, MaskEmpty and
+	// MaskHash, applied to the rows as they are copied.
+	Mask map[string]string `yaml:"mask"`
+	// Backfill holds SQL expressions, by column name, filling the column in
+	// the existing target rows when Config.MigrateSchema adds it: a
+	// constant, or an expression over the other columns of each row.
+	Backfill map[string]string `yaml:"backfill"`
+}
+
+// LoadTableOptions reads the per-table settings of a YAML configuration file
+// laid out as:
+//
+//	tables:
+//	  users:
+//	    key: email
+//	    where: active = 1
+//	    no_delete: true
+//	    max_delete_fraction: 0.1
+//	    columns: [id, email, name]
+//	    target: app_users
+//	  orders:
+//	    exclude: [card_number]
+//	    backfill:
+//	      currency: "'EUR'"
+//	mask:
+//	  users.email: hash
+//	  users.ssn: null
+//
+// Masking rules can also be set under each table, by column name.
+func LoadTableOptions(path string) (map[string]TableOptions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var file struct {
+		Tables map[string]TableOptions `yaml:"tables"`
+		Mask   map[string]string       `yaml:"mask"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	for name, rule := range file.Mask {
+		table, column, ok := strings.Cut(name, ".")
+		if !ok || table == "" || column == "" {
+			return nil, fmt.Errorf("parsing config %s: invalid masked column %q: want TABLE.COLUMN", path, name)
+		}
+		if file.Tables == nil {
+			file.Tables = make(map[string]TableOptions)
+		}
+		opts := file.Tables[table]
+		if opts.Mask == nil {
+			opts.Mask = make(map[string]string)
+		}
+		opts.Mask[column] = rule
+		file.Tables[table] = opts
+	}
+	// A bare null reads as an empty rule
+	for _, opts := range file.Tables {
+		for column, rule := range opts.Mask {
+			if rule == "" {
+				opts.Mask[column] = MaskNull
+			}
+		}
+	}
+	return file.Tables, nil
+}
+
+// LoadTargets reads the target databases listed in a YAML configuration file,
+// the one of LoadTableOptions, for SyncTargets:
+//
+//	targets:
+//	  - replica1.db
+//	  - replica2.db
+func LoadTargets(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var file struct {
+		Targets []string `yaml:"targets"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return file.Targets, nil
+}
+
+// selects reports whether a table is selected by Config.Tables and
+// ExcludeTables.
+func (cfg Config) selects(name string) bool {
+	return (len(cfg.Tables) == 0 || matchTable(cfg.Tables, name)) && !matchTable(cfg.ExcludeTables, name)
+}
+
+// matchTable reports whether a table name matches one of the patterns, which
+// validate checked.
+func matchTable(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// masks reports whether a table of Config.TableOptions has masked columns.
+func (cfg Config) masks() bool {
+	for _, opts := range cfg.TableOptions {
+		if len(opts.Mask) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// deletes reports whether the target rows of a table missing from the
+// source are deleted.
+func (cfg Config) deletes(table Table) bool {
+	return !cfg.NoDelete && !cfg.TableOptions[table.name].NoDelete
+}
+
+// maxDeleteFraction returns the fraction of the target rows of a table its
+// sync may delete, zero for any.
+func (cfg Config) maxDeleteFraction(table Table) float64 {
+	if cfg.Force {
+		return 0
+	}
+	if f := cfg.TableOptions[table.name].MaxDeleteFraction; f > 0 {
+		return f
+	}
+	return cfg.MaxDeleteFraction
+}
+
+// selectTables returns the tables selected by Config.Tables and
+// ExcludeTables, with their Config.TableOptions applied.
+func selectTables(tables []Table, cfg Config) ([]Table, error) {
+	filtered := make([]Table, 0, len(tables))
+	for _, table := range tables {
+		if cfg.selects(table.name) {
+			filtered = append(filtered, table)
+		}
+	}
+	tables = filtered
+	sources := make(map[string]string, len(tables))
+	for i := range tables {
+		var err error
+		if tables[i], err = applyTableOptions(tables[i], cfg.TableOptions[tables[i].name]); err != nil {
+			return nil, err
+		}
+		// Each would delete the rows of the other as orphans
+		target := strings.ToLower(tables[i].targetName())
+		if other, ok := sources[target]; ok {
+			return nil, fmt.Errorf("tables %s and %s both sync to target table %s", other, tables[i].name, tables[i].targetName())
+		}
+		sources[target] = tables[i].name
+	}
+	return tables, nil
+}
+
+// applyTableOptions returns table with the key, excluded columns and target
+// name of opts, checking the columns its other options name.
+func applyTableOptions(table Table, opts TableOptions) (Table, error) {
+	if opts.Key != "" {
+		if !containsFold(table.columns, opts.Key) {
+			return table, fmt.Errorf("table %s has no key column %s", table.name, opts.Key)
+		}
+		table.pkCol = opts.Key
+		table.keyCols = []string{opts.Key}
+	}
+	for c := range opts.Mask {
+		if !containsFold(table.columns, c) {
+			return table, fmt.Errorf("table %s has no column %s to mask", table.name, c)
+		}
+		if containsFold(table.keyCols, c) {
+			return table, fmt.Errorf("table %s: can't mask key column %s", table.name, c)
+		}
+	}
+	if len(opts.Columns) > 0 {
+		for _, c := range opts.Columns {
+			if !containsFold(table.columns, c) {
+				return table, fmt.Errorf("table %s has no column %s", table.name, c)
+			}
+		}
+		columns := make([]string, 0, len(opts.Columns))
+		for _, c := range table.columns {
+			if containsFold(opts.Columns, c) || containsFold(table.keyCols, c) {
+				columns = append(columns, c)
+			}
+		}
+		table.columns = columns
+	}
+	if len(opts.Exclude) > 0 {
+		for _, c := range opts.Exclude {
+			if !containsFold(table.columns, c) {
+				return table, fmt.Errorf("table %s has no column %s to exclude", table.name, c)
+			}
+			if containsFold(table.keyCols, c) {
+				return table, fmt.Errorf("table %s: can't exclude key column %s", table.name, c)
+			}
+		}
+		columns := make([]string, 0, len(table.columns))
+		for _, c := range table.columns {
+			if !containsFold(opts.Exclude, c) {
+				columns = append(columns, c)
+			}
+		}
+		table.columns = columns
+	}
+	for c := range opts.Backfill {
+		if !containsFold(table.columns, c) || containsFold(table.keyCols, c) {
+			return table, fmt.Errorf("table %s has no synced column %s to backfill", table.name, c)
+		}
+	}
+	table.target = opts.Target
+	return table, nil
+}
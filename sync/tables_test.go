@@ -0,0 +1,217 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadTableOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	data := `tables:
+  users:
+    key: email
+    exclude: [password_hash]
+  log:
+    where: v > 1
+    no_delete: true
+    target: events
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTableOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]TableOptions{
+		"users": {Key: "email", Exclude: []string{"password_hash"}},
+		"log":   {Where: "v > 1", NoDelete: true, Target: "events"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadTableOptions() = %+v, want %+v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("tables: [users]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTableOptions(path); err == nil {
+		t.Error("LoadTableOptions() error = nil, want the list rejected")
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	data := `targets: [replica1.db, replica2.db]
+tables:
+  users:
+    key: email
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTargets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"replica1.db", "replica2.db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadTargets() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncTableOptions(t *testing.T) {
+	for _, noAttach := range []bool{false, true} {
+		t.Run(map[bool]string{false: "attach", true: "rows"}[noAttach], func(t *testing.T) {
+			tables := []testTable{
+				{
+					name:    "users",
+					schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT, password_hash TEXT DEFAULT 'unset')`,
+					srcData: [][]interface{}{{1, "a@x", "Alice", "h1"}, {2, "b@x", "Bob", "h2"}},
+					tgtData: [][]interface{}{{10, "a@x", "Old", "keep"}, {11, "z@x", "Zed", "z"}},
+				},
+				{
+					name:    "log",
+					schema:  `CREATE TABLE log (id INTEGER PRIMARY KEY, v INTEGER)`,
+					srcData: [][]interface{}{{1, 1}, {2, 2}},
+				},
+			}
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+			if _, err := tgtDB.Exec(`CREATE TABLE events (id INTEGER PRIMARY KEY, v INTEGER); INSERT INTO events VALUES (5, 9)`); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := Config{
+				SrcDbPath: srcPath,
+				DstDbPath: tgtPath,
+				NoAttach:  noAttach,
+				TableOptions: map[string]TableOptions{
+					"users": {Key: "email", Exclude: []string{"id", "password_hash"}},
+					"log":   {Where: "v > 1", NoDelete: true, Target: "events"},
+				},
+			}
+			if _, err := Sync(cfg); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			// Rows match on email, the target ids and password hashes stay as
+			// they are
+			users, err := getTableData(tgtDB, "users")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantUsers := [][]interface{}{{10, "a@x", "Alice", "keep"}, {12, "b@x", "Bob", "unset"}}
+			if !compareData(users, wantUsers) {
+				t.Errorf("users = %v, want %v", users, wantUsers)
+			}
+			events, err := getTableData(tgtDB, "events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantEvents := [][]interface{}{{2, 2}, {5, 9}}
+			if !compareData(events, wantEvents) {
+				t.Errorf("events = %v, want %v", events, wantEvents)
+			}
+			if n := countTestRows(t, tgtDB, "log"); n != 0 {
+				t.Errorf("log has %d rows, want none written under the source name", n)
+			}
+		})
+	}
+}
+
+func TestSyncTableOptionsInvalid(t *testing.T) {
+	tables := []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+	}}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	for name, opts := range map[string]TableOptions{
+		"unknown key":      {Key: "mail"},
+		"unknown column":   {Exclude: []string{"mail"}},
+		"excluded key":     {Exclude: []string{"id"}},
+		"excluded new key": {Key: "email", Exclude: []string{"email"}},
+	} {
+		cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, TableOptions: map[string]TableOptions{"users": opts}}
+		if _, err := Sync(cfg); err == nil {
+			t.Errorf("%s: Sync() error = nil, want the options rejected", name)
+		}
+	}
+}
+
+func TestSyncTableGlobs(t *testing.T) {
+	var tables []testTable
+	for _, name := range []string{"log_1", "log_2", "log_2_tmp", "users"} {
+		tables = append(tables, testTable{
+			name:    name,
+			schema:  "CREATE TABLE " + name + " (id INTEGER PRIMARY KEY)",
+			srcData: [][]interface{}{{1}},
+		})
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"log_*"}, ExcludeTables: []string{"*_tmp"}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	var synced []string
+	for _, ts := range stats.Tables {
+		synced = append(synced, ts.Table)
+	}
+	sort.Strings(synced)
+	if want := []string{"log_1", "log_2"}; !reflect.DeepEqual(synced, want) {
+		t.Errorf("synced %v, want %v", synced, want)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, ExcludeTables: []string{"log_["}}); err == nil {
+		t.Error("Sync() error = nil, want the invalid pattern rejected")
+	}
+}
+
+func TestSyncTableTargetConflict(t *testing.T) {
+	tables := []testTable{
+		{name: "users", schema: `CREATE TABLE users (id INTEGER PRIMARY KEY)`},
+		{name: "src_users", schema: `CREATE TABLE src_users (id INTEGER PRIMARY KEY)`},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	cfg := Config{
+		SrcDbPath:    srcPath,
+		DstDbPath:    tgtPath,
+		TableOptions: map[string]TableOptions{"src_users": {Target: "Users"}},
+	}
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "both sync to target table") {
+		t.Errorf("Sync() error = %v, want the shared target rejected", err)
+	}
+	cfg.ExcludeTables = []string{"users"}
+	if _, err := Sync(cfg); err != nil {
+		t.Errorf("Sync() error = %v", err)
+	}
+}
+
+func TestSyncTableColumns(t *testing.T) {
+	for _, noAttach := range []bool{false, true} {
+		tables := []testTable{{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, secret TEXT DEFAULT 'none')`,
+			srcData: [][]interface{}{{1, "Alice", "a@x", "s1"}},
+		}}
+		srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+		cfg := Config{
+			SrcDbPath:    srcPath,
+			DstDbPath:    tgtPath,
+			NoAttach:     noAttach,
+			TableOptions: map[string]TableOptions{"users": {Columns: []string{"name", "secret"}, Exclude: []string{"secret"}}},
+		}
+		if _, err := Sync(cfg); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+		got, err := getTableData(tgtDB, "users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := [][]interface{}{{1, "Alice", nil, "none"}}; !compareData(got, want) {
+			t.Errorf("noAttach=%v: users = %v, want %v", noAttach, got, want)
+		}
+	}
+}
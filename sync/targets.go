@@ -0,0 +1,246 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TargetError is the error of a target that failed to sync, see SyncTargets.
+type TargetError struct {
+	Target string
+	Err    error
+}
+
+func (e *TargetError) Error() string { return "syncing target " + e.Target + ": " + e.Err.Error() }
+func (e *TargetError) Unwrap() error { return e.Err }
+
+// SyncTargets syncs the source of cfg to several targets at once, ignoring
+// cfg.DstDbPath. The source is read a single time: it is copied as with
+// Config.Snapshot, then every target is synced from the copy concurrently,
+// as by Sync with its own DstDbPath, so the targets end up with the same
+// rows. It returns the Stats of every target in the order of targets, and
+// the errors of the failed ones joined, each in a TargetError; a failed
+// target doesn't stop the others.
+//
+// With AllOrNothing, the targets are either all changed or none of them,
+// see syncAllOrNothing.
+//
+// The options writing a file about the run, StatePath, RecordPath and
+// ReportPath, AttachmentDirs and RangeDiff can't be shared by several
+// targets and are rejected. Hooks and Progress are called concurrently for
+// different targets, and Logger logs with a "target" attribute.
+func SyncTargets(ctx context.Context, cfg Config, targets []string) ([]*Stats, error) {
+	stats := make([]*Stats, len(targets))
+	for i := range stats {
+		stats[i] = &Stats{}
+	}
+	if err := validateTargets(cfg, targets); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return stats, classify(ErrInvalidConfig, err)
+	}
+	if len(targets) == 1 && !cfg.AllOrNothing {
+		cfg.DstDbPath = targets[0]
+		var err error
+		if stats[0], err = SyncContext(ctx, cfg); err != nil {
+			err = &TargetError{Target: targets[0], Err: err}
+		}
+		return stats, err
+	}
+
+	dir, err := os.MkdirTemp("", "rslite-snapshot-")
+	if err != nil {
+		return stats, err
+	}
+	defer os.RemoveAll(dir)
+	// Download the remote sources first
+	local, err := fetchSources(ctx, cfg, dir)
+	if err != nil {
+		return stats, err
+	}
+	cfg.snapshots, err = snapshotSources(ctx, append([]string{local.SrcDbPath}, local.UnionSources...), dir, cfg.driver(), sourceParams(cfg))
+	if err != nil {
+		return stats, err
+	}
+
+	if cfg.AllOrNothing && !cfg.Simulate {
+		return stats, syncAllOrNothing(ctx, cfg, targets, stats)
+	}
+	return stats, syncEach(ctx, cfg, targets, targets, stats)
+}
+
+// syncEach syncs the source of cfg to the databases at paths concurrently,
+// standing for targets in the logs and errors, and sets their stats.
+func syncEach(ctx context.Context, cfg Config, targets, paths []string, stats []*Stats) error {
+	errs := make([]error, len(targets))
+	done := make(chan struct{})
+	for i, target := range targets {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			tcfg := cfg
+			tcfg.DstDbPath = paths[i]
+			tcfg.Logger = cfg.logger().With("target", target)
+			if stats[i], errs[i] = SyncContext(ctx, tcfg); errs[i] != nil {
+				errs[i] = &TargetError{Target: target, Err: errs[i]}
+			}
+		}()
+	}
+	for range targets {
+		<-done
+	}
+	return errors.Join(errs...)
+}
+
+// syncAllOrNothing syncs every target into a shadow copy next to it, like
+// Rebuild, and only once all of them were synced, and verified when asked
+// to, renames the copies over the targets. When a rename fails, the targets
+// renamed before are put back from the hard links kept to their previous
+// file, so a failure at any step leaves every target as it was; the stats
+// of the targets are then those of their discarded copies. The targets are
+// locked for the whole run, and must be local databases nothing else
+// writes to, see Rebuild about their journal.
+func syncAllOrNothing(ctx context.Context, cfg Config, targets []string, stats []*Stats) error {
+	shadows := make([]string, len(targets))
+	modes := make([]string, len(targets))
+	defer func() {
+		for _, shadow := range shadows {
+			if shadow != "" {
+				os.Remove(shadow)
+			}
+		}
+	}()
+	for i, target := range targets {
+		tcfg := cfg
+		tcfg.DstDbPath = target
+		unlock, err := waitLock(ctx, tcfg)
+		if err != nil {
+			return &TargetError{Target: target, Err: err}
+		}
+		defer unlock()
+		if shadows[i], modes[i], err = shadowTarget(ctx, tcfg); err != nil {
+			return &TargetError{Target: target, Err: err}
+		}
+	}
+
+	cfg.locked = true // the shadows aren't shared
+	if err := syncEach(ctx, cfg, targets, shadows, stats); err != nil {
+		return err
+	}
+	for i, target := range targets {
+		if modes[i] == "wal" {
+			if err := setWAL(ctx, cfg.driver(), shadows[i]); err != nil {
+				return &TargetError{Target: target, Err: err}
+			}
+		}
+	}
+
+	// Links to the previous files, nil for the targets that didn't exist
+	previous := make([]string, len(targets))
+	defer func() {
+		for _, path := range previous {
+			if path != "" {
+				os.Remove(path)
+			}
+		}
+	}()
+	for i, target := range targets {
+		file := dbFile(target)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		previous[i] = file + ".previous"
+		os.Remove(previous[i])
+		if err := os.Link(file, previous[i]); err != nil {
+			previous[i] = ""
+			return &TargetError{Target: target, Err: classify(ErrOpenTarget, fmt.Errorf("keeping the previous target: %w", err))}
+		}
+	}
+	for i, target := range targets {
+		if err := os.Rename(shadows[i], dbFile(target)); err != nil {
+			err = classify(ErrOpenTarget, fmt.Errorf("replacing target db: %w", err))
+			for j := range i {
+				if previous[j] == "" {
+					err = errors.Join(err, os.Remove(dbFile(targets[j])))
+				} else {
+					err = errors.Join(err, os.Rename(previous[j], dbFile(targets[j])))
+					previous[j] = ""
+				}
+			}
+			return &TargetError{Target: target, Err: err}
+		}
+		shadows[i] = ""
+	}
+	return nil
+}
+
+// shadowTarget copies the target of cfg into a shadow file next to it, an
+// empty one when it doesn't exist, and returns its path and the journal
+// mode of the target, whose WAL it checkpoints.
+func shadowTarget(ctx context.Context, cfg Config) (string, string, error) {
+	target := dbFile(cfg.DstDbPath)
+	shadow := target + ".shadow"
+	if err := os.Remove(shadow); err != nil && !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("removing leftover shadow db: %w", err)
+	}
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		return shadow, "", nil
+	}
+	mode, err := journalMode(ctx, cfg)
+	if err != nil {
+		return "", "", err
+	}
+	db, err := openReadOnly(cfg.driver(), cfg.DstDbPath, nil)
+	if err != nil {
+		return "", "", classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", shadow); err != nil {
+		os.Remove(shadow)
+		return "", "", classify(ErrOpenTarget, fmt.Errorf("copying target db: %w", err))
+	}
+	return shadow, mode, nil
+}
+
+// validateTargets checks the targets of SyncTargets and the options they
+// can't share.
+func validateTargets(cfg Config, targets []string) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no target database")
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if seen[dbFile(target)] {
+			return fmt.Errorf("target %s given twice", target)
+		}
+		seen[dbFile(target)] = true
+	}
+	if cfg.AllOrNothing {
+		for _, target := range targets {
+			if isRemote(target) {
+				return fmt.Errorf("all-or-nothing syncs replace local targets, not %s", target)
+			}
+		}
+		if cfg.ExternalizeBlobs > 0 {
+			return fmt.Errorf("externalized BLOBs are written to the targets before all-or-nothing syncs commit")
+		}
+	}
+	if len(targets) == 1 {
+		return nil
+	}
+	switch {
+	case cfg.StatePath != "":
+		return fmt.Errorf("a state file can't be shared by several targets")
+	case cfg.RecordPath != "":
+		return fmt.Errorf("a recording can't be shared by several targets")
+	case cfg.ReportPath != "":
+		return fmt.Errorf("a report can't be shared by several targets")
+	case len(cfg.AttachmentDirs) > 0:
+		return fmt.Errorf("attachment directories can't be shared by several targets")
+	case cfg.RangeDiff:
+		return fmt.Errorf("range diffing compares the source with a single target")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncTargets(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{3, "Carol"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	other, err := createTestDB(otherPath, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	stats, err := SyncTargets(context.Background(), Config{SrcDbPath: srcPath}, []string{tgtPath, otherPath})
+	if err != nil {
+		t.Fatalf("SyncTargets() error = %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d stats, want 2", len(stats))
+	}
+	if total := stats[0].Total(); total.Inserted != 2 || total.Deleted != 1 {
+		t.Errorf("first target stats = %+v, want 2 inserted and 1 deleted", total)
+	}
+	if total := stats[1].Total(); total.Inserted != 2 || total.Deleted != 0 {
+		t.Errorf("second target stats = %+v, want 2 inserted", total)
+	}
+	for _, path := range []string{tgtPath, otherPath} {
+		if n := countTestRows(t, openTestDB(t, path), "users"); n != 2 {
+			t.Errorf("%s has %d users, want 2", path, n)
+		}
+	}
+
+	// A failed target doesn't stop the others
+	missing := filepath.Join(t.TempDir(), "missing", "tgt.db")
+	stats, err = SyncTargets(context.Background(), Config{SrcDbPath: srcPath}, []string{tgtPath, missing})
+	var targetErr *TargetError
+	if !errors.As(err, &targetErr) || targetErr.Target != missing {
+		t.Fatalf("error = %v, want a TargetError for %s", err, missing)
+	}
+	if len(stats[0].Tables) != 1 {
+		t.Errorf("first target synced %d tables, want 1", len(stats[0].Tables))
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing target was created: %v", err)
+	}
+}
+
+func TestSyncTargetsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")
+	for name, tc := range map[string]struct {
+		cfg     Config
+		targets []string
+	}{
+		"no targets": {},
+		"duplicate":  {targets: []string{a, "file:" + a}},
+		"state":      {cfg: Config{StatePath: "sync.state"}, targets: []string{a, b}},
+		"report":     {cfg: Config{ReportPath: "run.json"}, targets: []string{a, b}},
+	} {
+		tc.cfg.SrcDbPath = filepath.Join(dir, "src.db")
+		if _, err := SyncTargets(context.Background(), tc.cfg, tc.targets); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: error = %v, want ErrInvalidConfig", name, err)
+		}
+	}
+}
+
+func TestSyncTargetsAllOrNothing(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}, {2, "Bob"}},
+			tgtData: [][]interface{}{{3, "Carol"}},
+		},
+	}
+	srcPath, tgtPath, _, _ := setupTestDBs(t, tables)
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	newPath := filepath.Join(t.TempDir(), "new.db")
+	cfg := Config{SrcDbPath: srcPath, AllOrNothing: true, Verify: true}
+
+	// A failed target leaves the others alone
+	missing := filepath.Join(t.TempDir(), "missing", "tgt.db")
+	_, err := SyncTargets(context.Background(), cfg, []string{tgtPath, missing})
+	var targetErr *TargetError
+	if !errors.As(err, &targetErr) || targetErr.Target != missing {
+		t.Fatalf("error = %v, want a TargetError for %s", err, missing)
+	}
+	got, err := getTableData(openTestDB(t, tgtPath), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{3, "Carol"}}; !compareData(got, want) {
+		t.Errorf("target changed by a failed run: got %v, want %v", got, want)
+	}
+
+	stats, err := SyncTargets(context.Background(), cfg, []string{tgtPath, otherPath, newPath})
+	if err != nil {
+		t.Fatalf("SyncTargets() error = %v", err)
+	}
+	if total := stats[0].Total(); total.Inserted != 2 || total.Deleted != 1 || len(stats[0].Verified) != 1 {
+		t.Errorf("first target stats = %+v, want 2 inserted, 1 deleted and verified", stats[0])
+	}
+	for _, path := range []string{tgtPath, otherPath, newPath} {
+		if n := countTestRows(t, openTestDB(t, path), "users"); n != 2 {
+			t.Errorf("%s has %d users, want 2", path, n)
+		}
+		for _, leftover := range []string{path + ".shadow", path + ".previous"} {
+			if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+				t.Errorf("%s left behind: %v", leftover, err)
+			}
+		}
+	}
+
+	if _, err := SyncTargets(context.Background(), Config{SrcDbPath: srcPath, AllOrNothing: true}, []string{"host:tgt.db"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("remote target: error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,251 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// changeLog is the table of a tracked source logging the primary key of
+// every row written, see EnableTracking. It is never synced.
+const changeLog = "_rslite_changes"
+
+// trackedOps are the statements logged by the triggers of a tracked table.
+var trackedOps = []string{"insert", "update", "delete"}
+
+// trackPrefix starts the names of the triggers logging the changes.
+const trackPrefix = "_rslite_track_"
+
+// trackTrigger returns the name of the trigger logging op on table.
+func trackTrigger(table, op string) string {
+	return trackPrefix + table + "_" + op
+}
+
+// EnableTracking installs change tracking on the source database of cfg:
+// the changeLog table and, on each of tables, or on every table when empty,
+// triggers logging the primary key of every inserted, updated or deleted row.
+// A sync with Config.Tracked then only replays the logged rows, deletes
+// included, and trims the log. Enabling it again is a no-op; the target has
+// to be synced in full once, since nothing before is logged. The log holds
+// a single key column, so tables with a composite primary key can't be
+// tracked.
+func EnableTracking(ctx context.Context, cfg Config, tables []string) error {
+	db, err := openTracked(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	specs, err := trackedTables(ctx, db, tables)
+	if err != nil {
+		return err
+	}
+	for _, t := range specs {
+		if err := trackable(t); err != nil {
+			if len(tables) == 0 {
+				return fmt.Errorf("%w; list the tables to track", err)
+			}
+			return err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		seq INTEGER PRIMARY KEY,
+		tbl TEXT NOT NULL,
+		pk,
+		op TEXT NOT NULL,
+		at TEXT NOT NULL DEFAULT (strftime('%%Y-%%m-%%dT%%H:%%M:%%fZ'))
+	)`, changeLog)); err != nil {
+		return fmt.Errorf("creating change log: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_tbl ON %[1]s (tbl, seq)", changeLog)); err != nil {
+		return fmt.Errorf("creating change log: %w", err)
+	}
+	for _, t := range specs {
+		logged := func(op, row string) string {
+			return fmt.Sprintf("INSERT INTO %s (tbl, pk, op) VALUES (%s, %s.%s, '%s')", changeLog, sqlLiteral(t.name), row, quoteIdent(t.pkCol), op)
+		}
+		bodies := map[string]string{
+			"insert": logged("insert", "NEW"),
+			// A changed key deletes the row of the old one
+			"update": fmt.Sprintf("%s; INSERT INTO %s (tbl, pk, op) SELECT %s, OLD.%s, 'delete' WHERE OLD.%[4]s IS NOT NEW.%[4]s",
+				logged("update", "NEW"), changeLog, sqlLiteral(t.name), quoteIdent(t.pkCol)),
+			"delete": logged("delete", "OLD"),
+		}
+		for _, op := range trackedOps {
+			query := fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s AFTER %s ON %s BEGIN %s; END",
+				quoteIdent(trackTrigger(t.name, op)), op, quoteIdent(t.name), bodies[op])
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("tracking table %s: %w", t.name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTracking removes the triggers of EnableTracking from tables, or
+// from every table along with the change log when tables is empty.
+func DisableTracking(ctx context.Context, cfg Config, tables []string) error {
+	db, err := openTracked(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	specs, err := trackedTables(ctx, db, tables)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range specs {
+		for _, op := range trackedOps {
+			if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+quoteIdent(trackTrigger(t.name, op))); err != nil {
+				return fmt.Errorf("untracking table %s: %w", t.name, err)
+			}
+		}
+	}
+	if len(tables) == 0 {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+changeLog); err != nil {
+			return fmt.Errorf("dropping change log: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// openTracked opens the source of cfg for writing its tracking.
+func openTracked(cfg Config) (*sql.DB, error) {
+	if isRemote(cfg.SrcDbPath) {
+		return nil, classify(ErrInvalidConfig, fmt.Errorf("can't track changes of the remote source %s", cfg.SrcDbPath))
+	}
+	params := url.Values{}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	// Written to, but not created
+	if _, err := os.Stat(dbFile(cfg.SrcDbPath)); err != nil {
+		return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	db, err := openDB(cfg.driver(), dsn(cfg.SrcDbPath, params))
+	if err != nil {
+		return nil, classify(ErrOpenSource, fmt.Errorf("opening source db: %w", err))
+	}
+	return db, nil
+}
+
+// trackedTables returns the tables of db named in tables, or all of them.
+func trackedTables(ctx context.Context, db *sql.DB, tables []string) ([]Table, error) {
+	all, _, err := getTables(ctx, db)
+	if err != nil {
+		return nil, classify(ErrOpenSource, err)
+	}
+	if len(tables) == 0 {
+		return all, nil
+	}
+	var specs []Table
+	for _, name := range tables {
+		i := -1
+		for j, t := range all {
+			if t.name == name {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, classify(ErrTableMissing, fmt.Errorf("source has no table %s", name))
+		}
+		specs = append(specs, all[i])
+	}
+	return specs, nil
+}
+
+// trackable fails when the changes of table can't be logged by their key.
+func trackable(table Table) error {
+	if len(table.keyCols) > 1 {
+		return classify(ErrInvalidConfig, fmt.Errorf("table %s has a composite primary key, which change tracking can't log", table.name))
+	}
+	return nil
+}
+
+// lastChange returns the sequence number of the last change logged for
+// table in the source transaction, failing when the table isn't tracked.
+func lastChange(ctx context.Context, tx *sql.Tx, table Table) (int64, error) {
+	if err := trackable(table); err != nil {
+		return 0, err
+	}
+	var triggers int
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ? AND name = ?",
+		table.name, trackTrigger(table.name, "insert")).Scan(&triggers)
+	if err != nil {
+		return 0, err
+	}
+	if triggers == 0 {
+		return 0, classify(ErrInvalidConfig, fmt.Errorf("table %s is not tracked, see rslite track enable", table.name))
+	}
+	var seq sql.NullInt64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT max(seq) FROM %s WHERE tbl = ?", changeLog), table.name).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("reading change log: %w", err)
+	}
+	return seq.Int64, nil
+}
+
+// trackedFilter returns the condition selecting the source rows of the
+// changes of table logged up to seq, on top of where.
+func trackedFilter(table Table, where string, args []interface{}, seq int64) (string, []interface{}) {
+	cond := fmt.Sprintf("%s IN (SELECT pk FROM %s WHERE tbl = ? AND seq <= ?)", quoteIdent(table.pkCol), changeLog)
+	if where != "" {
+		cond = "(" + where + ") AND " + cond
+	}
+	return cond, append(append([]interface{}{}, args...), table.name, seq)
+}
+
+// deleteTracked deletes the target rows of the changes of table logged up
+// to seq whose key is gone from the source.
+func deleteTracked(ctx context.Context, tx *sql.Tx, w *tableWriter, rec *recorder, seq int64) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT DISTINCT pk FROM %s WHERE tbl = ? AND seq <= ? AND pk NOT IN (SELECT %s FROM %s)",
+		changeLog, quoteIdent(w.table.pkCol), quoteIdent(w.table.name)), w.table.name, seq)
+	if err != nil {
+		return fmt.Errorf("reading change log: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pk interface{}
+		if err := rows.Scan(&pk); err != nil {
+			return err
+		}
+		rec.remove(w.table.name, []interface{}{pk})
+		if err := w.deleteKey(ctx, []interface{}{pk}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// trimChanges deletes from the change log of the source the changes
+// replayed by the committed tables of stats.
+func trimChanges(ctx context.Context, cfg Config, stats *Stats) error {
+	db, err := openTracked(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, t := range stats.Tables {
+		if t.lastChange == 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE tbl = ? AND seq <= ?", changeLog), t.Table, t.lastChange); err != nil {
+			return fmt.Errorf("trimming change log: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSyncTracked(t *testing.T) {
+	data := [][]interface{}{{1, "Alice"}, {2, "Bob"}, {3, "Carol"}}
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: data,
+			tgtData: data,
+		},
+		{
+			name:   "logs",
+			schema: `CREATE TABLE logs (msg TEXT)`,
+		},
+	})
+	ctx := context.Background()
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tracked: true, Tables: []string{"users"}}
+	if err := EnableTracking(ctx, cfg, []string{"users"}); err != nil {
+		t.Fatalf("EnableTracking() error = %v", err)
+	}
+	// Idempotent
+	if err := EnableTracking(ctx, cfg, []string{"users"}); err != nil {
+		t.Fatalf("second EnableTracking() error = %v", err)
+	}
+
+	for _, stmt := range []string{
+		`INSERT INTO users VALUES (4, 'Dan')`,
+		`UPDATE users SET name = 'Robert' WHERE id = 2`,
+		`UPDATE users SET id = 5 WHERE id = 3`,
+		`DELETE FROM users WHERE id = 1`,
+	} {
+		if _, err := srcDB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Untracked rows of the target are left alone
+	if _, err := tgtDB.Exec(`INSERT INTO users VALUES (9, 'Target only')`); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 2 || got.Replaced != 1 || got.Deleted != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{2, "Robert"}, {4, "Dan"}, {5, "Carol"}, {9, "Target only"}}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := countTestRows(t, srcDB, changeLog); n != 0 {
+		t.Errorf("change log holds %d entries after the sync, want 0", n)
+	}
+
+	// Nothing logged, nothing synced
+	if stats, err = Sync(cfg); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if got := stats.Tables[0]; got.Inserted != 0 || got.Replaced != 0 || got.Deleted != 0 {
+		t.Errorf("unexpected second run stats %+v", got)
+	}
+
+	// logs isn't tracked
+	cfg.Tables = nil
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("untracked table: error = %v, want ErrInvalidConfig", err)
+	}
+
+	if err := DisableTracking(ctx, cfg, nil); err != nil {
+		t.Fatalf("DisableTracking() error = %v", err)
+	}
+	if exists, err := tableExists(ctx, srcDB, changeLog); err != nil || exists {
+		t.Errorf("change log left after DisableTracking(): %v", err)
+	}
+	if _, err := srcDB.Exec(`INSERT INTO users VALUES (6, 'Eve')`); err != nil {
+		t.Fatalf("writing the untracked source: %v", err)
+	}
+}
+
+func TestTrackCompositeKey(t *testing.T) {
+	srcPath, tgtPath, srcDB, _ := setupTestDBs(t, []testTable{
+		{
+			name:   "users",
+			schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		},
+		{
+			name:   "pairs",
+			schema: `CREATE TABLE pairs (a INTEGER, b INTEGER, PRIMARY KEY (a, b))`,
+		},
+	})
+	ctx := context.Background()
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tracked: true}
+	for _, tables := range [][]string{nil, {"pairs"}} {
+		if err := EnableTracking(ctx, cfg, tables); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "composite primary key") {
+			t.Errorf("EnableTracking(%v) error = %v, want a composite key error", tables, err)
+		}
+	}
+	if exists, err := tableExists(ctx, srcDB, changeLog); err != nil || exists {
+		t.Errorf("change log created by a failed EnableTracking(): %v", err)
+	}
+
+	// Nor synced with triggers installed otherwise
+	if err := EnableTracking(ctx, cfg, []string{"users"}); err != nil {
+		t.Fatalf("EnableTracking() error = %v", err)
+	}
+	if _, err := srcDB.Exec(`CREATE TRIGGER _rslite_track_pairs_insert AFTER INSERT ON pairs BEGIN SELECT 1; END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "composite primary key") {
+		t.Errorf("Sync() error = %v, want a composite key error", err)
+	}
+}
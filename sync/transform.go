@@ -0,0 +1,22 @@
+package sync
+
+// transformRow passes a row laid out as the key column followed by
+// table.columns to Config.RowTransform, and writes the returned row back
+// into values. It reports whether the row is kept.
+func transformRow(table Table, values []interface{}, fn func(string, map[string]interface{}) (map[string]interface{}, bool, error)) (bool, error) {
+	row := make(map[string]interface{}, len(table.columns))
+	for i, c := range table.columns {
+		row[c] = values[i+1]
+	}
+	out, keep, err := fn(table.name, row)
+	if err != nil || !keep {
+		return false, err
+	}
+	for i, c := range table.columns {
+		values[i+1] = out[c]
+		if c == table.pkCol {
+			values[0] = out[c]
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSyncRowTransform(t *testing.T) {
+	tables := []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)`,
+		srcData: [][]interface{}{{1, "Alice", "alice@example.com"}, {2, "Bob", "bob@example.com"}, {3, "test", "t@example.com"}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	cfg := Config{
+		SrcDbPath: srcPath,
+		DstDbPath: tgtPath,
+		RowTransform: func(table string, row map[string]interface{}) (map[string]interface{}, bool, error) {
+			if table != "users" {
+				return nil, false, errors.New("unexpected table " + table)
+			}
+			if row["name"] == "test" {
+				return nil, false, nil
+			}
+			email := row["email"].(string)
+			row["email"] = "user@" + email[strings.Index(email, "@")+1:]
+			return row, true, nil
+		},
+	}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if ts := stats.Tables[0]; ts.Inserted != 2 || ts.Skipped != 1 {
+		t.Errorf("stats = %+v, want 2 rows inserted and 1 skipped", ts)
+	}
+	got, err := getTableData(tgtDB, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{1, "Alice", "user@example.com"}, {2, "Bob", "user@example.com"}}
+	if !compareData(got, want) {
+		t.Errorf("users = %v, want %v", got, want)
+	}
+
+	cfg.RowTransform = func(string, map[string]interface{}) (map[string]interface{}, bool, error) {
+		return nil, false, errors.New("boom")
+	}
+	if _, err := Sync(cfg); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Sync() error = %v, want the transform error", err)
+	}
+}
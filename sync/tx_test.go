@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyncConcurrentTargetWriter syncs while another connection holds the
+// target write lock. A deferred transaction that already read the target
+// can't be serialized after the other writer and fails; an immediate one
+// waits for the lock and sees the other writer's committed rows, and so does
+// a deferred one synced again with Retries.
+func TestSyncConcurrentTargetWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		lock      string
+		retries   int
+		wantError bool
+	}{
+		{name: "deferred", lock: "deferred", wantError: true},
+		{name: "immediate", lock: "immediate"},
+		{name: "deferred with retries", lock: "deferred", retries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+				name:    "items",
+				schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+				srcData: [][]interface{}{{1, "a"}, {2, "b"}},
+				tgtData: [][]interface{}{{1, "old"}},
+			}})
+			if _, err := tgtDB.Exec(`PRAGMA journal_mode=WAL`); err != nil {
+				t.Fatal(err)
+			}
+
+			writer, err := tgtDB.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer writer.Rollback()
+			if _, err := writer.Exec(`INSERT INTO items VALUES (99, 'concurrent')`); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error)
+			go func() {
+				_, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, DstTxLock: tt.lock, Retries: tt.retries})
+				done <- err
+			}()
+
+			time.Sleep(200 * time.Millisecond)
+			if err := writer.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			err = <-done
+			if tt.wantError {
+				if err == nil || !strings.Contains(err.Error(), "locked") {
+					t.Fatalf("Sync() error = %v, want a locking error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			got, err := getTableData(tgtDB, "items")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := [][]interface{}{{1, "a"}, {2, "b"}}; !compareData(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package sync
+
+import (
+	"context"
+	"strings"
+)
+
+// Unsupported is an object of the source database that a sync doesn't
+// cover, see Stats.Unsupported.
+type Unsupported struct {
+	Type   string // "table", "column", "view" or "trigger"
+	Name   string // columns are named TABLE.COLUMN
+	Reason string
+}
+
+// isMissingModule reports whether err comes from reading a virtual table
+// whose module is not loaded in the connection.
+func isMissingModule(err error) bool {
+	return strings.Contains(err.Error(), "no such module")
+}
+
+// findUnsupported lists the views of the source and the triggers on the
+// synced tables, which are not synced without Config.SchemaObjects, and the
+// generated columns of the tables, which the target computes itself.
+func findUnsupported(ctx context.Context, db queryer, tables []Table, cfg Config) ([]Unsupported, error) {
+	synced := make(map[string]bool, len(tables))
+	renamed := make(map[string]bool)
+	for _, t := range tables {
+		synced[t.name] = true
+		renamed[t.name] = t.targetName() != t.name
+	}
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name FROM sqlite_master
+		WHERE type IN ('view', 'trigger') AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY type, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var objects []Unsupported
+	for rows.Next() {
+		var (
+			o     Unsupported
+			table string
+		)
+		if err := rows.Scan(&o.Type, &o.Name, &table); err != nil {
+			return nil, err
+		}
+		if o.Type == "trigger" && (!synced[table] || strings.HasPrefix(o.Name, trackPrefix)) {
+			// Or part of the change tracking
+			continue
+		}
+		switch {
+		case !cfg.SchemaObjects:
+			o.Reason = "schema objects are not synced, only table rows"
+		case o.Type == "trigger" && renamed[table]:
+			o.Reason = "triggers of a table renamed in the target are not synced"
+		default:
+			continue
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, t := range tables {
+		rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_xinfo(?) WHERE hidden IN (2, 3)", t.name)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			objects = append(objects, Unsupported{
+				Type:   "column",
+				Name:   t.name + "." + name,
+				Reason: "generated column, computed by the target",
+			})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
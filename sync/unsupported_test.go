@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSyncUnsupported(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, upper_name TEXT AS (upper(name)))`,
+			srcData: [][]interface{}{{1, "alice"}},
+		},
+		{name: "log", schema: `CREATE TABLE log (id INTEGER PRIMARY KEY, user_id INTEGER)`},
+	}
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+	for _, ddl := range []string{
+		`CREATE VIEW named AS SELECT name FROM users`,
+		`CREATE TRIGGER users_log AFTER INSERT ON users BEGIN INSERT INTO log (user_id) VALUES (new.id); END`,
+		`CREATE TRIGGER log_noop AFTER INSERT ON log BEGIN SELECT 1; END`,
+		// Only the module can read it, which the test connections lack
+		`PRAGMA writable_schema = ON`,
+		`INSERT INTO sqlite_master VALUES ('table', 'fences', 'fences', 0, 'CREATE VIRTUAL TABLE fences USING geopoly(name)')`,
+	} {
+		if _, err := srcDB.Exec(ddl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srcDB.Close()
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"users", "fences"}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	want := []Unsupported{
+		{Type: "table", Name: "fences", Reason: "virtual table module geopoly is not loaded"},
+		{Type: "trigger", Name: "users_log", Reason: "schema objects are not synced, only table rows"},
+		{Type: "view", Name: "named", Reason: "schema objects are not synced, only table rows"},
+		{Type: "column", Name: "users.upper_name", Reason: "generated column, computed by the target"},
+	}
+	if !reflect.DeepEqual(stats.Unsupported, want) {
+		t.Errorf("Unsupported = %+v, want %+v", stats.Unsupported, want)
+	}
+	var upper string
+	if err := tgtDB.QueryRow(`SELECT upper_name FROM users WHERE id = 1`).Scan(&upper); err != nil || upper != "ALICE" {
+		t.Errorf("upper_name = %q, %v, want it computed by the target", upper, err)
+	}
+}
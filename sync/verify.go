@@ -0,0 +1,369 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+// maxReportedKeys caps the keys of the differing rows kept in a
+// Verification.
+const maxReportedKeys = 20
+
+// Verification is the outcome of comparing a synced table with its source,
+// see Config.Verify. The checksums hash the compared rows in key order
+// with the Config.Checksum algorithm, or in sampling order when Sampled.
+type Verification struct {
+	Table          string `json:"table"`
+	Sampled        bool   `json:"sampled,omitempty"` // see Config.VerifySample
+	SourceRows     int64  `json:"source_rows"`
+	TargetRows     int64  `json:"target_rows"`
+	Missing        int64  `json:"missing"` // source rows the target lacks
+	Extra          int64  `json:"extra"`   // target rows the source lacks
+	Changed        int64  `json:"changed"` // rows held by both with different values
+	Checksum       string `json:"checksum"`
+	TargetChecksum string `json:"target_checksum"`
+	// Keys holds the keys of the first rows missing, extra or changed
+	Keys [][]interface{} `json:"keys,omitempty"`
+}
+
+// differs counts a row differing between source and target with the given
+// key.
+func (v *Verification) differs(n *int64, key []interface{}) {
+	*n++
+	if len(v.Keys) < maxReportedKeys {
+		v.Keys = append(v.Keys, key)
+	}
+}
+
+// Matches reports whether the target holds the rows of the source.
+func (v Verification) Matches() bool {
+	return v.SourceRows == v.TargetRows && v.Checksum == v.TargetChecksum
+}
+
+// verifyTables compares every synced table with its source, see
+// verifyTable, and fails with ErrVerification on those that differ.
+func verifyTables(ctx context.Context, src, dst *sql.DB, tables []Table, cfg Config) ([]Verification, error) {
+	var (
+		verified []Verification
+		errs     []error
+	)
+	for _, table := range tables {
+		verify := verifyTable
+		if cfg.VerifySample > 0 {
+			verify = sampleTable
+		}
+		v, err := verify(ctx, src, dst, table, cfg)
+		if err != nil {
+			return verified, &TableError{Table: table.name, Err: fmt.Errorf("verifying: %w", err)}
+		}
+		verified = append(verified, v)
+		if !v.Matches() {
+			compared := "rows"
+			if v.Sampled {
+				compared = "sampled rows"
+			}
+			errs = append(errs, &TableError{Table: table.name, Err: classify(ErrVerification, fmt.Errorf(
+				"target differs from the source: %d %s, %d in the source, %d missing, %d extra, %d changed, checksum %s, %s in the source, first keys %v",
+				v.TargetRows, compared, v.SourceRows, v.Missing, v.Extra, v.Changed, v.TargetChecksum, v.Checksum, v.Keys))})
+		}
+	}
+	return verified, errors.Join(errs...)
+}
+
+// verifyTable counts and hashes the rows of a table in the source and in the
+// target, selected by the filters of the sync. The source rows are masked
+// and transformed as they were written, those with a tombstone left out,
+// and the target rows the source lacks are only compared when the sync
+// deletes them. Rows left out or fixed by the data guards, or changed in
+// the source since, make the table differ.
+func verifyTable(ctx context.Context, src, dst *sql.DB, table Table, cfg Config) (Verification, error) {
+	v := Verification{Table: table.name}
+	srcTx, err := src.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return v, fmt.Errorf("starting source transaction: %w", err)
+	}
+	defer srcTx.Rollback()
+	dstTx, err := dst.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return v, fmt.Errorf("starting target transaction: %w", err)
+	}
+	defer dstTx.Rollback()
+
+	cols := table.rowColumns()
+	query, args, keys := orderedQuery(table, table.name, cfg)
+	keyIdx := make([]int, len(keys))
+	for i, k := range keys {
+		keyIdx[i] = slices.IndexFunc(cols, func(c string) bool { return strings.EqualFold(c, k) })
+	}
+	source, err := openCursor(ctx, srcTx, query, args, cols)
+	if err != nil {
+		return v, fmt.Errorf("reading source: %w", err)
+	}
+	defer source.close()
+	source.blobs = newBlobStore(cfg)
+	targetQuery, _, _ := orderedQuery(table, table.targetName(), cfg)
+	target, err := openCursor(ctx, dstTx, targetQuery, args, cols)
+	if err != nil {
+		return v, fmt.Errorf("reading target: %w", err)
+	}
+	defer target.close()
+
+	tombstone := tombstoneIndex(cols, cfg)
+	// next returns the next source row as it was written, and its key
+	next := func() ([]interface{}, []interface{}, error) {
+		for {
+			values, err := source.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+			key := rowKey(values, keyIdx)
+			values, ok, err := asWritten(table, cfg, tombstone, values)
+			if err != nil || ok {
+				return values, key, err
+			}
+		}
+	}
+	hashed := func(values []interface{}) []interface{} { return comparedValues(table, values) }
+
+	srcHash, dstHash := newChecksum(cfg.Checksum), newChecksum(cfg.Checksum)
+	s, sKey, err := next()
+	if err != nil && err != io.EOF {
+		return v, fmt.Errorf("reading source: %w", err)
+	}
+	t, err := target.Next()
+	if err != nil && err != io.EOF {
+		return v, fmt.Errorf("reading target: %w", err)
+	}
+	deletes := cfg.deletes(table)
+	for s != nil || t != nil {
+		// Compared by key, the side whose rows are left coming first
+		c := -1
+		switch {
+		case s == nil:
+			c = 1
+		case t != nil:
+			c = diff.CompareKeys(sKey, rowKey(t, keyIdx))
+		}
+		switch {
+		case c < 0:
+			v.differs(&v.Missing, sKey)
+		case c > 0 && deletes:
+			v.differs(&v.Extra, rowKey(t, keyIdx))
+		case c == 0 && !slices.EqualFunc(hashed(s), hashed(t), diff.Equal):
+			v.differs(&v.Changed, sKey)
+		}
+		if c <= 0 {
+			countRow(&v.SourceRows, srcHash, hashed(s))
+			if s, sKey, err = next(); err != nil && err != io.EOF {
+				return v, fmt.Errorf("reading source: %w", err)
+			}
+		}
+		if c >= 0 {
+			if c == 0 || deletes {
+				countRow(&v.TargetRows, dstHash, hashed(t))
+			}
+			if t, err = target.Next(); err != nil && err != io.EOF {
+				return v, fmt.Errorf("reading target: %w", err)
+			}
+		}
+	}
+	v.Checksum = hex.EncodeToString(srcHash.Sum(nil))
+	v.TargetChecksum = hex.EncodeToString(dstHash.Sum(nil))
+	return v, nil
+}
+
+// sampleTable compares Config.VerifySample rows of a table picked at random
+// among those of the source selected by the filters of the sync with the
+// target rows with the same keys, like verifyTable. Only the keys of the
+// source rows are read in full.
+func sampleTable(ctx context.Context, src, dst *sql.DB, table Table, cfg Config) (Verification, error) {
+	v := Verification{Table: table.name, Sampled: true}
+	srcTx, err := src.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return v, fmt.Errorf("starting source transaction: %w", err)
+	}
+	defer srcTx.Rollback()
+	dstTx, err := dst.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return v, fmt.Errorf("starting target transaction: %w", err)
+	}
+	defer dstTx.Rollback()
+
+	_, _, keys := orderedQuery(table, table.name, cfg)
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(keys), quoteIdent(table.name))
+	where, args := buildFilter(table, cfg)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := srcTx.QueryContext(ctx, query+" ORDER BY random() LIMIT ?", append(args, cfg.VerifySample)...)
+	if err != nil {
+		return v, fmt.Errorf("sampling source: %w", err)
+	}
+	var sample [][]interface{}
+	row := newRowScanner(keys)
+	for rows.Next() {
+		if err := row.scan(rows); err != nil {
+			rows.Close()
+			return v, fmt.Errorf("sampling source: %w", err)
+		}
+		sample = append(sample, slices.Clone(row.values))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return v, fmt.Errorf("sampling source: %w", err)
+	}
+
+	cols := table.rowColumns()
+	match := make([]string, len(keys))
+	for i, k := range keys {
+		match[i] = quoteIdent(k) + " IS ?"
+	}
+	lookup := func(name string) string {
+		return fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT 1", selectList(cols), quoteIdent(name), strings.Join(match, " AND "))
+	}
+	source, err := srcTx.PrepareContext(ctx, lookup(table.name))
+	if err != nil {
+		return v, err
+	}
+	defer source.Close()
+	target, err := dstTx.PrepareContext(ctx, lookup(table.targetName()))
+	if err != nil {
+		return v, err
+	}
+	defer target.Close()
+	blobs := newBlobStore(cfg)
+	tombstone := tombstoneIndex(cols, cfg)
+	srcHash, dstHash := newChecksum(cfg.Checksum), newChecksum(cfg.Checksum)
+	srcRow, dstRow := newRowScanner(cols), newRowScanner(cols)
+	for _, key := range sample {
+		s, err := lookupRow(ctx, source, key, srcRow)
+		if err != nil {
+			return v, fmt.Errorf("reading source: %w", err)
+		}
+		s, ok, err := asWritten(table, cfg, tombstone, blobs.refs(s))
+		if err != nil {
+			return v, err
+		}
+		if !ok {
+			continue
+		}
+		countRow(&v.SourceRows, srcHash, comparedValues(table, s))
+		t, err := lookupRow(ctx, target, key, dstRow)
+		if err != nil {
+			return v, fmt.Errorf("reading target: %w", err)
+		}
+		if t == nil {
+			v.differs(&v.Missing, key)
+			continue
+		}
+		countRow(&v.TargetRows, dstHash, comparedValues(table, t))
+		if !slices.EqualFunc(comparedValues(table, s), comparedValues(table, t), diff.Equal) {
+			v.differs(&v.Changed, key)
+		}
+	}
+	v.Checksum = hex.EncodeToString(srcHash.Sum(nil))
+	v.TargetChecksum = hex.EncodeToString(dstHash.Sum(nil))
+	return v, nil
+}
+
+// lookupRow reads the row a prepared query selects by key into row, and
+// returns its values, nil when there is none.
+func lookupRow(ctx context.Context, stmt *sql.Stmt, key []interface{}, row *rowScanner) ([]interface{}, error) {
+	rows, err := stmt.QueryContext(ctx, key...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	if err := row.scan(rows); err != nil {
+		return nil, err
+	}
+	return row.values, nil
+}
+
+// asWritten returns a source row laid out as rowColumns as the sync wrote
+// it, transformed and masked, or false for a row it didn't write: one with
+// a tombstone, at the tombstone index of the row, or dropped by
+// Config.RowTransform. values is left as is.
+func asWritten(table Table, cfg Config, tombstone int, values []interface{}) ([]interface{}, bool, error) {
+	if tombstone >= 0 && isTombstone(values[tombstone]) {
+		return nil, false, nil
+	}
+	values = slices.Clone(values)
+	if cfg.RowTransform != nil {
+		keep, err := transformRow(table, values, cfg.RowTransform)
+		if err != nil || !keep {
+			return nil, false, err
+		}
+	}
+	if masks := cfg.TableOptions[table.name].Mask; len(masks) > 0 {
+		maskRow(table, values, masks)
+	}
+	return values, true, nil
+}
+
+// tombstoneIndex returns the index of Config.SoftDeleteColumn in cols, -1
+// without one.
+func tombstoneIndex(cols []string, cfg Config) int {
+	if cfg.SoftDeleteColumn == "" {
+		return -1
+	}
+	return slices.IndexFunc(cols, func(c string) bool { return strings.EqualFold(c, cfg.SoftDeleteColumn) })
+}
+
+// comparedValues returns the values of a row compared with the target: all
+// of them, but the rowid of a row matched by content, which isn't synced.
+func comparedValues(table Table, values []interface{}) []interface{} {
+	if table.byContent {
+		return values[1:]
+	}
+	return values
+}
+
+// orderedQuery selects the rowColumns of the table called name, filtered like
+// the sync, in the order of the returned key columns, sorted with the
+// BINARY collation like diff.CompareKeys. A keyless table matched by
+// content is ordered by all its columns, its rowids being those of the
+// database.
+func orderedQuery(table Table, name string, cfg Config) (string, []interface{}, []string) {
+	keys := table.keyCols
+	if table.byContent {
+		keys = table.columns
+	}
+	order := make([]string, len(keys))
+	for i, k := range keys {
+		order[i] = quoteIdent(k) + " COLLATE BINARY"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList(table.rowColumns()), quoteIdent(name))
+	where, args := buildFilter(table, cfg)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query + " ORDER BY " + strings.Join(order, ", "), args, keys
+}
+
+// rowKey returns the key values of a row, at the given indexes.
+func rowKey(values []interface{}, idx []int) []interface{} {
+	key := make([]interface{}, len(idx))
+	for i, j := range idx {
+		key[i] = values[j]
+	}
+	return key
+}
+
+// countRow adds a row to the count and the checksum of one side.
+func countRow(n *int64, h hash.Hash, values []interface{}) {
+	*n++
+	hashRow(h, values)
+}
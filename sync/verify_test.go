@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSyncVerify(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)`,
+			srcData: [][]interface{}{{1, "Alice", "alice@example.com"}, {2, "Bob", "bob@example.com"}, {3, "Carol", nil}},
+			tgtData: [][]interface{}{{9, "Zed", nil}},
+		},
+		{
+			name:    "tags",
+			schema:  `CREATE TABLE tags (name TEXT)`,
+			srcData: [][]interface{}{{"a"}, {"b"}, {"a"}},
+		},
+	})
+	cfg := Config{
+		SrcDbPath:    srcPath,
+		DstDbPath:    tgtPath,
+		Verify:       true,
+		Checksum:     ChecksumSHA256,
+		KeylessMatch: KeylessColumns,
+		TableOptions: map[string]TableOptions{"users": {Mask: map[string]string{"email": MaskHash}}},
+	}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Verified) != 2 {
+		t.Fatalf("Verified = %+v, want both tables", stats.Verified)
+	}
+	for _, v := range stats.Verified {
+		if !v.Matches() || v.SourceRows != 3 || len(v.Checksum) != 64 {
+			t.Errorf("unexpected verification %+v", v)
+		}
+	}
+
+	// A trigger the sync doesn't know of changes what it writes
+	if _, err := tgtDB.Exec(`CREATE TRIGGER users_rename AFTER UPDATE ON users WHEN NEW.id = 2 BEGIN UPDATE users SET name = 'Robert' WHERE id = 2; END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tgtDB.Exec(`INSERT INTO users VALUES (4, 'Dan', NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	cfg.NoDelete = true
+	stats, err = Sync(cfg)
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("Sync() error = %v, want ErrVerification", err)
+	}
+	var tableErr *TableError
+	if !errors.As(err, &tableErr) || tableErr.Table != "users" {
+		t.Errorf("error = %v, want a TableError for users", err)
+	}
+	// The extra row is kept without deletes, so not compared
+	if v := stats.Verified[0]; v.Matches() || v.Changed != 1 || v.Extra != 0 || v.Missing != 0 || v.TargetRows != 3 {
+		t.Errorf("unexpected verification %+v", v)
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Verify: true, UnionSources: []string{srcPath}}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("verifying union sources: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestSyncVerifySample(t *testing.T) {
+	var rows [][]interface{}
+	for i := 1; i <= 200; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("user%d", i)})
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: rows,
+	}})
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, VerifySample: 50, Where: "id > 100"}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if v := stats.Verified; len(v) != 1 || !v[0].Sampled || !v[0].Matches() || v[0].SourceRows != 50 {
+		t.Errorf("unexpected verification %+v", v)
+	}
+
+	// A trigger the sync doesn't know of changes every row it writes
+	if _, err := tgtDB.Exec(`CREATE TRIGGER users_mark AFTER UPDATE ON users WHEN NEW.name NOT LIKE '%!' BEGIN UPDATE users SET name = name || '!' WHERE id = NEW.id; END`); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Sync(cfg)
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("Sync() error = %v, want ErrVerification", err)
+	}
+	if v := stats.Verified[0]; v.Changed != 50 || v.Missing != 0 || len(v.Keys) != maxReportedKeys {
+		t.Errorf("unexpected verification %+v", v)
+	}
+	for _, key := range stats.Verified[0].Keys {
+		if id, _ := key[0].(int64); id <= 100 {
+			t.Errorf("sampled key %v outside the filter", key)
+		}
+	}
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, VerifySample: -1}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("negative sample: error = %v, want ErrInvalidConfig", err)
+	}
+}
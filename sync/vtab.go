@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// virtualModuleRE matches the module name of a CREATE VIRTUAL TABLE
+// statement.
+var virtualModuleRE = regexp.MustCompile(`(?is)^\s*CREATE\s+VIRTUAL\s+TABLE\s+.+?\s+USING\s+(\w+)`)
+
+// virtualModule returns the module of a virtual table from its DDL, or ""
+// for an ordinary table.
+func virtualModule(ddl string) string {
+	m := virtualModuleRE.FindStringSubmatch(ddl)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// rtreeShadows are the suffixes of the shadow tables of the R*Tree modules.
+var rtreeShadows = []string{"_node", "_parent", "_rowid"}
+
+// ftsShadows are the suffixes of the shadow tables of the full-text search
+// modules.
+var ftsShadows = map[string][]string{
+	"fts3": {"_content", "_segments", "_segdir"},
+	"fts4": {"_content", "_segments", "_segdir", "_docsize", "_stat"},
+	"fts5": {"_data", "_idx", "_content", "_docsize", "_config"},
+}
+
+// ftsContentRE matches the content option of a full-text table.
+var ftsContentRE = regexp.MustCompile(`(?i)[(,]\s*content\s*=\s*('(?:[^']|'')*'|"(?:[^"]|"")*"|\w+)\s*[,)]`)
+
+// ftsContent returns the content option of a full-text table from its DDL:
+// the table holding its rows when they are indexed from another table, ""
+// for a contentless table, and ok false when the table holds its rows
+// itself, or isn't a full-text table.
+func ftsContent(ddl string) (content string, ok bool) {
+	if _, fts := ftsShadows[virtualModule(ddl)]; !fts {
+		return "", false
+	}
+	m := ftsContentRE.FindStringSubmatch(ddl)
+	if m == nil {
+		return "", false
+	}
+	content = m[1]
+	if q := content[0]; q == '\'' || q == '"' {
+		content = strings.ReplaceAll(content[1:len(content)-1], string(q)+string(q), string(q))
+	}
+	return content, true
+}
+
+// rebuildIndexes recomputes the full-text indexes of the target tables
+// from their content, see Config.RebuildFTS. Contentless tables, which
+// have nothing to recompute from, are left alone.
+func rebuildIndexes(ctx context.Context, dst *sql.DB, tables []Table, cfg Config) error {
+	for _, t := range tables {
+		if _, fts := ftsShadows[t.module]; !fts || t.external && t.content == "" {
+			continue
+		}
+		exists, err := tableExists(ctx, dst, t.targetName())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return classify(ErrTableMissing, fmt.Errorf("target has no table %s", t.targetName()))
+		}
+		name := quoteIdent(t.targetName())
+		if _, err := dst.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES ('rebuild')", name, name)); err != nil {
+			return fmt.Errorf("rebuilding full-text index %s: %w", t.targetName(), err)
+		}
+		cfg.logger().InfoContext(ctx, "full-text index rebuilt", "table", t.targetName())
+	}
+	return nil
+}
+
+// indexTables splits out of tables the full-text tables indexing the rows
+// of another table, or none, which are rebuilt rather than synced: their
+// rows can't be read back, and writing them would index the rows twice.
+func indexTables(tables []Table) (synced, indexes []Table) {
+	for _, t := range tables {
+		if t.external {
+			indexes = append(indexes, t)
+		} else {
+			synced = append(synced, t)
+		}
+	}
+	return synced, indexes
+}
+
+// shadowTables returns the names of the tables holding the data of virtual
+// tables. They are synced through their virtual table: writing them directly
+// would corrupt its index. PRAGMA table_list only knows the shadow tables of
+// the modules loaded in the connection, so those of the R*Tree family,
+// geopoly being an optional module, and of the full-text search modules are
+// found by name too.
+func shadowTables(ctx context.Context, db queryer) (map[string]bool, error) {
+	shadows := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, `SELECT name, sql FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name string
+			ddl  sql.NullString
+		)
+		if err := rows.Scan(&name, &ddl); err != nil {
+			return nil, err
+		}
+		module := virtualModule(ddl.String)
+		switch module {
+		case "rtree", "rtree_i32", "geopoly":
+			for _, suffix := range rtreeShadows {
+				shadows[name+suffix] = true
+			}
+		case "fts3", "fts4", "fts5":
+			for _, suffix := range ftsShadows[module] {
+				shadows[name+suffix] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'shadow'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		shadows[name] = true
+	}
+	return shadows, rows.Err()
+}
@@ -0,0 +1,230 @@
+package sync
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSyncRTree(t *testing.T) {
+	for _, cfg := range []Config{{}, {NoAttach: true}, {Simulate: true}} {
+		name := "attach"
+		switch {
+		case cfg.NoAttach:
+			name = "rows"
+		case cfg.Simulate:
+			name = "simulate"
+		}
+		t.Run(name, func(t *testing.T) {
+			tables := []testTable{{
+				name:   "zones",
+				schema: `CREATE VIRTUAL TABLE zones USING rtree(id, minx, maxx, miny, maxy, +label)`,
+				// 0.1 isn't exact in the 32-bit floats of the index
+				srcData: [][]interface{}{{1, 0.1, 1.5, -3.25, 2, "depot"}, {2, 10, 20, 10, 20, "park"}},
+				tgtData: [][]interface{}{{2, 0, 0, 0, 0, "old"}, {3, 5, 6, 5, 6, "gone"}},
+			}}
+			srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+
+			cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+			stats, err := Sync(cfg)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			for _, ts := range stats.Tables {
+				if strings.HasPrefix(ts.Table, "zones_") {
+					t.Errorf("shadow table %s was synced", ts.Table)
+				}
+			}
+			if len(stats.Tables) != 1 {
+				t.Fatalf("Tables = %+v, want zones only", stats.Tables)
+			}
+			// The simulation starts from the target schema, without rows
+			if cfg.Simulate {
+				return
+			}
+			if stats.Tables[0].Deleted != 1 {
+				t.Errorf("zones deleted %d rows, want 1", stats.Tables[0].Deleted)
+			}
+
+			want, err := getTableData(srcDB, "zones")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := getTableData(tgtDB, "zones")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !compareData(got, want) {
+				t.Errorf("target zones = %v, want %v", got, want)
+			}
+			var id int
+			if err := tgtDB.QueryRow(`SELECT id FROM zones WHERE minx <= 15 AND maxx >= 15 AND miny <= 15 AND maxy >= 15`).Scan(&id); err != nil || id != 2 {
+				t.Errorf("spatial query = %d, %v, want zone 2", id, err)
+			}
+			var check string
+			if err := tgtDB.QueryRow(`SELECT rtreecheck('zones')`).Scan(&check); err != nil || check != "ok" {
+				t.Errorf("rtreecheck = %q, %v, want ok", check, err)
+			}
+		})
+	}
+}
+
+func TestSyncGeopoly(t *testing.T) {
+	tables := []testTable{{
+		name:    "fences",
+		schema:  `CREATE VIRTUAL TABLE fences USING geopoly(name)`,
+		srcData: [][]interface{}{{1, "[[0,0],[1,0],[1,1],[0,0]]", "home"}},
+	}}
+	if _, err := createTestDB(t.TempDir()+"/probe.db", tables); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skip("geopoly is not compiled in")
+		}
+		t.Fatal(err)
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	var area float64
+	if err := tgtDB.QueryRow(`SELECT geopoly_area(_shape) FROM fences WHERE geopoly_contains_point(_shape, 0.75, 0.25)`).Scan(&area); err != nil || area != 0.5 {
+		t.Errorf("geopoly area = %v, %v, want 0.5", area, err)
+	}
+}
+
+func TestVirtualModule(t *testing.T) {
+	for ddl, want := range map[string]string{
+		`CREATE VIRTUAL TABLE zones USING rtree(id, minx, maxx)`:    "rtree",
+		"create virtual table \"a b\"\nusing GeoPoly (name)":        "geopoly",
+		`CREATE TABLE zones (id INTEGER PRIMARY KEY, note TEXT)`:    "",
+		`CREATE TABLE using_rtree (id INTEGER PRIMARY KEY, x REAL)`: "",
+	} {
+		if got := virtualModule(ddl); got != want {
+			t.Errorf("virtualModule(%q) = %q, want %q", ddl, got, want)
+		}
+	}
+}
+
+func TestSyncFTS(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{
+		{
+			name:    "notes",
+			schema:  `CREATE VIRTUAL TABLE notes USING fts4(body)`,
+			srcData: [][]interface{}{{"the quick fox"}, {"a lazy dog"}},
+		},
+		{
+			name:    "docs",
+			schema:  `CREATE TABLE docs (id INTEGER PRIMARY KEY, title TEXT)`,
+			srcData: [][]interface{}{{1, "sqlite replication"}, {2, "full text search"}},
+			tgtData: [][]interface{}{{3, "stale replication"}},
+		},
+		{
+			name:   "docs_fts",
+			schema: `CREATE VIRTUAL TABLE docs_fts USING fts4(content="docs", title)`,
+		},
+	})
+	for _, db := range []*sql.DB{srcDB, tgtDB} {
+		if _, err := db.Exec(`INSERT INTO docs_fts (docs_fts) VALUES ('rebuild')`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath}
+	stats, err := Sync(cfg)
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	var synced []string
+	for _, ts := range stats.Tables {
+		synced = append(synced, ts.Table)
+	}
+	if strings.Join(synced, ",") != "notes,docs" && strings.Join(synced, ",") != "docs,notes" {
+		t.Errorf("synced tables %v, want notes and docs", synced)
+	}
+	if len(stats.Unsupported) != 1 || stats.Unsupported[0].Name != "docs_fts" {
+		t.Errorf("Unsupported = %+v, want docs_fts", stats.Unsupported)
+	}
+	match := func(table, term string) int {
+		t.Helper()
+		var n int
+		if err := tgtDB.QueryRow(`SELECT count(*) FROM `+table+` WHERE `+table+` MATCH ?`, term).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := match("notes", "fox"); n != 1 {
+		t.Errorf("notes matching fox: %d, want 1", n)
+	}
+	// The index still holds the stale target row
+	if n := match("docs_fts", "stale"); n != 1 {
+		t.Errorf("docs_fts matching stale before the rebuild: %d, want 1", n)
+	}
+
+	cfg.RebuildFTS = true
+	if stats, err = Sync(cfg); err != nil {
+		t.Fatalf("Sync() with RebuildFTS error = %v", err)
+	}
+	if len(stats.Unsupported) != 0 {
+		t.Errorf("Unsupported = %+v, want none", stats.Unsupported)
+	}
+	if n := match("docs_fts", "replication"); n != 1 {
+		t.Errorf("docs_fts matching replication: %d, want 1", n)
+	}
+	if n := match("docs_fts", "stale"); n != 0 {
+		t.Errorf("docs_fts matching stale: %d, want 0", n)
+	}
+	if _, err := tgtDB.Exec(`INSERT INTO notes (notes) VALUES ('integrity-check')`); err != nil {
+		t.Errorf("notes integrity check: %v", err)
+	}
+}
+
+func TestSyncFTS5(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "docs",
+			schema:  `CREATE TABLE docs (id INTEGER PRIMARY KEY, title TEXT)`,
+			srcData: [][]interface{}{{1, "sqlite replication"}},
+		},
+		{
+			name:   "docs_fts",
+			schema: `CREATE VIRTUAL TABLE docs_fts USING fts5(title, content='docs', content_rowid='id')`,
+		},
+	}
+	if _, err := createTestDB(t.TempDir()+"/probe.db", tables); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skip("fts5 is not compiled in")
+		}
+		t.Fatal(err)
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, RebuildFTS: true})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if len(stats.Tables) != 1 || stats.Tables[0].Table != "docs" {
+		t.Errorf("Tables = %+v, want docs only", stats.Tables)
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT count(*) FROM docs_fts WHERE docs_fts MATCH 'replication'`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("docs_fts matching replication: %d, %v, want 1", n, err)
+	}
+}
+
+func TestFTSContent(t *testing.T) {
+	for _, tt := range []struct {
+		ddl     string
+		content string
+		ok      bool
+	}{
+		{`CREATE VIRTUAL TABLE f USING fts5(title, content='docs', content_rowid='id')`, "docs", true},
+		{`CREATE VIRTUAL TABLE f USING fts4(content="my docs", title)`, "my docs", true},
+		{`CREATE VIRTUAL TABLE f USING fts5(title, content = '')`, "", true},
+		{`CREATE VIRTUAL TABLE f USING fts5(title, content)`, "", false},
+		{`CREATE VIRTUAL TABLE f USING rtree(id, content, x)`, "", false},
+		{`CREATE TABLE f (content TEXT)`, "", false},
+	} {
+		content, ok := ftsContent(tt.ddl)
+		if content != tt.content || ok != tt.ok {
+			t.Errorf("ftsContent(%q) = %q, %v, want %q, %v", tt.ddl, content, ok, tt.content, tt.ok)
+		}
+	}
+}
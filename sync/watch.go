@@ -0,0 +1,255 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch syncs cfg right away, then every interval until ctx is done, and
+// calls fn with the outcome of every run. A failed run doesn't stop it, but
+// an invalid Config does. The state of incremental syncs is kept in memory
+// between runs, and still saved after each of them.
+//
+// Every run locks the target like any sync, see Config.WaitLock, so that
+// watchers and other syncs of the same target take turns.
+func Watch(ctx context.Context, cfg Config, interval time.Duration, fn func(*Stats, error)) error {
+	if interval <= 0 {
+		return classify(ErrInvalidConfig, fmt.Errorf("invalid watch interval %s", interval))
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	return watch(ctx, cfg, true, fn, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		}
+	})
+}
+
+// WatchChanges is like Watch, but syncs again when the source database
+// files change instead of on a schedule: once they were left untouched for
+// debounce, so that a burst of writes triggers a single run. Changes are
+// watched on the directories of the source and union source databases, for
+// the database files and their journal or WAL.
+func WatchChanges(ctx context.Context, cfg Config, debounce time.Duration, fn func(*Stats, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watching source db: %w", err)
+	}
+	defer watcher.Close()
+
+	// Writes to the database files, or a database file replaced. Reading a
+	// WAL database creates its -wal file, which must not trigger a run.
+	files := make(map[string]bool) // true for the database files
+	for _, src := range append([]string{cfg.SrcDbPath}, cfg.UnionSources...) {
+		if isRemote(src) {
+			return classify(ErrInvalidConfig, fmt.Errorf("can't watch remote source %s for changes", src))
+		}
+		path, err := filepath.Abs(dbFile(src))
+		if err != nil {
+			return fmt.Errorf("watching source db: %w", err)
+		}
+		files[path] = true
+		files[path+"-wal"] = false
+		files[path+"-journal"] = false
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("watching source db: %w", err)
+		}
+	}
+	changed := func(event fsnotify.Event) bool {
+		path, err := filepath.Abs(event.Name)
+		if err != nil {
+			return false
+		}
+		db, ok := files[path]
+		return ok && (event.Has(fsnotify.Write) || db && event.Has(fsnotify.Create))
+	}
+
+	return watch(ctx, cfg, true, fn, func(ctx context.Context) error {
+		// Wait for a change, then for the files to settle
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case err := <-watcher.Errors:
+				return fmt.Errorf("watching source db: %w", err)
+			case event := <-watcher.Events:
+				if changed(event) {
+					settled = time.After(debounce)
+				}
+			case <-settled:
+				return nil
+			}
+		}
+	})
+}
+
+// watch runs cfg until ctx is done or wait fails, calling wait between runs,
+// and before the first one unless now is set, see Watch.
+func watch(ctx context.Context, cfg Config, now bool, fn func(*Stats, error), wait func(context.Context) error) error {
+	if err := cfg.validate(); err != nil {
+		return classify(ErrInvalidConfig, err)
+	}
+	if cfg.StatePath != "" {
+		var err error
+		if cfg.state, err = loadState(cfg.StatePath, cfg.Checksum); err != nil {
+			return err
+		}
+	}
+
+	s := &Syncer{cfg: cfg}
+	for first := true; ; first = false {
+		if !first || !now {
+			if err := wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+		stats, err := s.Sync(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		fn(stats, err)
+		if errors.Is(err, ErrInvalidConfig) {
+			return err
+		}
+	}
+}
+
+// lockTargetRetry is how often waitLock tries to take a lock held by
+// another run.
+var lockTargetRetry = 100 * time.Millisecond
+
+// waitLock takes the lock of the target of cfg, trying again while another
+// run holds it until Config.WaitLock has elapsed.
+func waitLock(ctx context.Context, cfg Config) (func(), error) {
+	deadline := time.Now().Add(cfg.WaitLock)
+	for {
+		unlock, err := lockTarget(cfg.DstDbPath, cfg.lockedTables())
+		if !errors.Is(err, ErrTargetLocked) || !time.Now().Before(deadline) {
+			return unlock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockTargetRetry):
+		}
+	}
+}
+
+// lockedTables returns the target tables a run of cfg locks one by one,
+// or nil when it locks the whole target: only the runs limited to tables
+// named in Tables, without patterns, and changing nothing else of the
+// target lock their tables, so that runs of other tables aren't kept
+// waiting.
+func (cfg Config) lockedTables() []string {
+	if len(cfg.Tables) == 0 || cfg.Mirror || cfg.Vacuum || cfg.CopyVersion || cfg.DropSchemaObjects {
+		return nil
+	}
+	tables := make([]string, 0, len(cfg.Tables))
+	for _, name := range cfg.Tables {
+		if strings.ContainsAny(name, `*?[\`) {
+			return nil
+		}
+		if opts, ok := cfg.TableOptions[name]; ok && opts.Target != "" {
+			name = opts.Target
+		}
+		tables = append(tables, strings.ToLower(name))
+	}
+	slices.Sort(tables)
+	return slices.Compact(tables)
+}
+
+// lockTarget locks the lock file of a target, its path with a .lock suffix,
+// held by every run writing it, and returns the function releasing it. The
+// locks are released with the process, and the files left in place. With
+// tables, the target lock is shared with the other runs of some tables,
+// and each table locked in a file of the directory next to the target with
+// a .locks suffix.
+func lockTarget(target string, tables []string) (func(), error) {
+	if isRemote(target) {
+		// Nothing to lock on this host
+		return func() {}, nil
+	}
+	path := dbFile(target) + ".lock"
+	f, err := lockFile(path, len(tables) > 0)
+	if err != nil || f == nil {
+		return nil, lockError(err, "target is locked by %s", path)
+	}
+	held := []*os.File{f}
+	unlock := func() {
+		for _, f := range held {
+			f.Close()
+		}
+	}
+	if len(tables) > 0 {
+		dir := dbFile(target) + ".locks"
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			unlock()
+			return nil, lockError(err, "", "")
+		}
+		for _, table := range tables {
+			path := filepath.Join(dir, url.PathEscape(table))
+			f, err := lockFile(path, false)
+			if err != nil || f == nil {
+				unlock()
+				return nil, lockError(err, "table "+table+" of the target is locked by %s", path)
+			}
+			held = append(held, f)
+		}
+	}
+	return unlock, nil
+}
+
+// lockFile takes a lock on the file at path, creating it, and returns the
+// file holding it, nil when another run holds it. An exclusive lock writes
+// the process id in the file, for the errors of the runs waiting for it.
+func lockFile(path string, shared bool) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := tryLock(f, shared)
+	if err == nil && ok {
+		// Shared owners, which can't all write it, leave it empty
+		if err = f.Truncate(0); err == nil && !shared {
+			_, err = fmt.Fprintln(f, os.Getpid())
+		}
+	}
+	if err != nil || !ok {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lockError returns the error of a lock which couldn't be taken: an
+// ErrTargetLocked of the format naming its owner, as written in the lock
+// file at path, or the ErrOpenTarget of err.
+func lockError(err error, format, path string) error {
+	if err != nil {
+		return classify(ErrOpenTarget, fmt.Errorf("locking target: %w", err))
+	}
+	owner := "another process"
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			owner = fmt.Sprintf("process %d", pid)
+		}
+	}
+	return classify(ErrTargetLocked, fmt.Errorf(format, owner))
+}
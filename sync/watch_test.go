@@ -0,0 +1,189 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+	}
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := 0
+	err := Watch(ctx, Config{SrcDbPath: srcPath, DstDbPath: tgtPath}, 10*time.Millisecond, func(stats *Stats, err error) {
+		if err != nil {
+			t.Errorf("run %d: error = %v", runs, err)
+		}
+		runs++
+		switch runs {
+		case 1:
+			// Only locked during the runs
+			unlock, err := lockTarget(tgtPath, nil)
+			if err != nil {
+				t.Errorf("locking the target between runs: error = %v", err)
+			} else {
+				unlock()
+			}
+			if _, err := srcDB.Exec(`INSERT INTO users VALUES (2, 'Bob')`); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("%d runs, want 2", runs)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("users has %d rows, want Bob synced by the second run", n)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	tables := []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+	}
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, tables)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runs := 0
+	err := WatchChanges(ctx, Config{SrcDbPath: srcPath, DstDbPath: tgtPath}, 20*time.Millisecond, func(stats *Stats, err error) {
+		if err != nil {
+			t.Errorf("run %d: error = %v", runs, err)
+		}
+		runs++
+		switch runs {
+		case 1:
+			// Nothing changed since: the run must not trigger another one
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				if _, err := srcDB.Exec(`INSERT INTO users VALUES (2, 'Bob')`); err != nil {
+					t.Error(err)
+				}
+			}()
+		case 2:
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("WatchChanges() error = %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("%d runs, want one at the start and one for the change", runs)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 2 {
+		t.Errorf("users has %d rows, want Bob synced after the change", n)
+	}
+}
+
+func TestSyncWaitLock(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "users",
+		schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "Alice"}},
+	}})
+	unlock, err := lockTarget(tgtPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); !errors.Is(err, ErrTargetLocked) || !errors.Is(err, ErrOpenTarget) {
+		t.Fatalf("Sync() of a locked target: error = %v, want ErrTargetLocked", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 0 {
+		t.Errorf("locked target written: %d rows", n)
+	}
+	// A simulation doesn't take the lock
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Simulate: true}); err != nil {
+		t.Errorf("simulated Sync() error = %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, unlock)
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, WaitLock: 5 * time.Second}); err != nil {
+		t.Fatalf("Sync() waiting for the lock: error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "users"); n != 1 {
+		t.Errorf("users holds %d rows, want 1", n)
+	}
+	if _, err := lockTarget(tgtPath, nil); err != nil {
+		t.Errorf("target still locked after the run: %v", err)
+	}
+}
+
+func TestSyncStaleLock(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{{
+		name:   "users",
+		schema: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+	}})
+	// Left behind by a killed process, whose lock went with it
+	if err := os.WriteFile(tgtPath+".lock", []byte("999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath}); err != nil {
+		t.Errorf("Sync() with a stale lock file: error = %v", err)
+	}
+}
+
+func TestSyncTableLocks(t *testing.T) {
+	srcPath, tgtPath, _, _ := setupTestDBs(t, []testTable{
+		{
+			name:    "users",
+			schema:  `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`,
+			srcData: [][]interface{}{{1, "Alice"}},
+		},
+		{
+			name:    "orders",
+			schema:  `CREATE TABLE orders (id INTEGER PRIMARY KEY, total REAL)`,
+			srcData: [][]interface{}{{1, 9.5}},
+		},
+	})
+	unlock, err := lockTarget(tgtPath, []string{"users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Runs of other tables go on, the others wait
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"orders"}}); err != nil {
+		t.Errorf("Sync() of another table: error = %v", err)
+	}
+	for _, cfg := range []Config{
+		{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"users", "orders"}},
+		{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"orders"}, Vacuum: true},
+		{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"o*"}},
+		{SrcDbPath: srcPath, DstDbPath: tgtPath},
+	} {
+		if _, err := Sync(cfg); !errors.Is(err, ErrTargetLocked) {
+			t.Errorf("Sync() of tables %v, vacuum %v: error = %v, want ErrTargetLocked", cfg.Tables, cfg.Vacuum, err)
+		}
+	}
+	unlock()
+
+	// A run of the whole target keeps out those of its tables
+	if unlock, err = lockTarget(tgtPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	_, err = Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"orders"}})
+	if !errors.Is(err, ErrTargetLocked) || !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+		t.Errorf("Sync() of a table of a locked target: error = %v, want ErrTargetLocked naming the owner", err)
+	}
+}
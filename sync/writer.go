@@ -0,0 +1,535 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// tableWriter applies rows to a single target table and keeps the statistics
+// for it. Rows are written in one transaction, or committed every batchSize
+// rows when batching is enabled; the orphan delete always runs in the last
+// transaction.
+//
+// Writers of the same target share its write lock, taken for the lifetime of
+// each transaction. SQLite only allows one writer anyway, and serializing in
+// process avoids lock upgrade deadlocks between concurrent transactions.
+type tableWriter struct {
+	table         Table
+	conn          *sql.Conn // pinned so the staging table survives batch commits
+	lock          gosync.Locker
+	locked        bool
+	tx            *sql.Tx
+	insert        *sql.Stmt
+	lookup        *sql.Stmt // reads the target row by key, for SkipUnchanged
+	txOptions     *sql.TxOptions
+	query         string // writing a row
+	conflict      string // ON CONFLICT clause of query, empty with REPLACE
+	attached      bool   // the source is attached, see canAttach
+	shared        *sharedTx
+	committed     bool
+	blobs         *blobStore
+	batchSize     int
+	skipUnchanged bool
+	checksum      string // algorithm comparing rows for skipUnchanged
+	pending       int    // rows written since the last commit
+	start         time.Time
+	before        int64
+	written       int64
+	kept          *sql.Stmt // inserts into the keepTable staging table
+	maxDeletes    float64   // fraction of the before rows, see Config.MaxDeleteFraction
+	stats         TableStats
+	log           *slog.Logger
+}
+
+// keepTable is the temporary table staging the source keys for
+// deleteOrphans, one column for each key column of the table. It lives in the temp schema of the writer's connection.
+const keepTable = "temp.rslite_keep"
+
+// newTableWriter starts writing a table. lock may be nil when the target has
+// a single writer. When attach is not empty, the database file at that path
+// is attached to the connection as attachedSchema.
+func newTableWriter(ctx context.Context, dst *sql.DB, lock gosync.Locker, table Table, cfg Config, attach string) (*tableWriter, error) {
+	w := &tableWriter{
+		table:         table,
+		lock:          lock,
+		skipUnchanged: cfg.SkipUnchanged,
+		checksum:      cfg.Checksum,
+		txOptions:     cfg.DstTxOptions,
+		blobs:         newBlobStore(cfg),
+		start:         time.Now(),
+		stats:         TableStats{Table: table.name},
+		shared:        cfg.shared,
+		maxDeletes:    cfg.maxDeleteFraction(table),
+		log:           cfg.logger().With("table", table.name),
+	}
+	if !cfg.SingleTx && w.shared == nil {
+		w.batchSize = cfg.BatchSize
+	}
+
+	var (
+		conn *sql.Conn
+		db   queryer
+		err  error
+	)
+	if w.shared != nil {
+		// The run transaction already attached the source
+		conn, db, attach = w.shared.conn, w.shared.tx, ""
+	} else {
+		if conn, err = dst.Conn(ctx); err != nil {
+			return nil, classify(ErrOpenTarget, fmt.Errorf("opening target db: %w", err))
+		}
+		db = conn
+	}
+	w.conn = conn
+
+	if err := checkTargetTable(ctx, db, table, cfg); err != nil {
+		w.closeConn()
+		return nil, err
+	}
+	virtual, err := isVirtualTable(ctx, db, table.targetName())
+	if err != nil {
+		w.closeConn()
+		return nil, err
+	}
+	w.query = buildUpsertQuery(table, cfg)
+	w.conflict = upsertClause(table, cfg)
+	if cfg.Replace || virtual {
+		w.query = buildInsertQuery(table)
+		w.conflict = ""
+	}
+
+	if table.byContent {
+		w.skipUnchanged = false
+		if err := w.stageRows(ctx); err != nil {
+			w.closeConn()
+			return nil, err
+		}
+	}
+
+	// ATTACH fails inside a transaction
+	if attach != "" {
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+attachedSchema, attach); err != nil {
+			w.closeConn()
+			return nil, fmt.Errorf("attaching source: %w", err)
+		}
+		w.attached = true
+	}
+
+	if err := w.begin(ctx); err != nil {
+		w.detach()
+		w.closeConn()
+		return nil, err
+	}
+
+	w.before, err = countRows(ctx, w.tx, table)
+	if err != nil {
+		w.close()
+		return nil, fmt.Errorf("counting target rows: %w", err)
+	}
+	return w, nil
+}
+
+func (w *tableWriter) begin(ctx context.Context) error {
+	if w.lock != nil {
+		w.lock.Lock()
+		w.locked = true
+	}
+	tx, err := w.beginTx(ctx)
+	if err != nil {
+		w.unlock()
+		return err
+	}
+	insert, err := tx.PrepareContext(ctx, w.query)
+	if err != nil {
+		w.rollback(tx)
+		w.unlock()
+		return err
+	}
+	var lookup *sql.Stmt
+	if w.skipUnchanged {
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", selectList(w.table.rowColumns()), quoteIdent(w.table.targetName()), quoteIdent(w.table.pkCol))
+		if lookup, err = tx.PrepareContext(ctx, query); err != nil {
+			insert.Close()
+			w.rollback(tx)
+			w.unlock()
+			return err
+		}
+	}
+	w.tx, w.insert, w.lookup, w.pending = tx, insert, lookup, 0
+	return nil
+}
+
+// sharedSavepoint is the savepoint a writer sets in the run transaction of a
+// Config.Atomic sync, so a failed table can be rolled back and synced again.
+const sharedSavepoint = "rslite_table"
+
+// beginTx starts the transaction of a batch, or a savepoint in the run
+// transaction.
+func (w *tableWriter) beginTx(ctx context.Context) (*sql.Tx, error) {
+	if w.shared == nil {
+		return w.conn.BeginTx(ctx, w.txOptions)
+	}
+	if _, err := w.shared.tx.ExecContext(ctx, "SAVEPOINT "+sharedSavepoint); err != nil {
+		return nil, err
+	}
+	return w.shared.tx, nil
+}
+
+// rollback rolls back a transaction started by beginTx. It doesn't take a
+// context: the savepoint has to be rolled back even when the run was
+// cancelled.
+func (w *tableWriter) rollback(tx *sql.Tx) {
+	if w.shared == nil {
+		tx.Rollback()
+		return
+	}
+	tx.Exec("ROLLBACK TO " + sharedSavepoint)
+	tx.Exec("RELEASE " + sharedSavepoint)
+}
+
+// unchanged reports whether the target already holds a row identical to
+// values, comparing row checksums.
+func (w *tableWriter) unchanged(ctx context.Context, values []interface{}) (bool, error) {
+	rows, err := w.lookup.QueryContext(ctx, values[0])
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	current := newRowScanner(w.table.rowColumns())
+	if err := current.scan(rows); err != nil {
+		return false, err
+	}
+	return bytes.Equal(rowChecksum(w.checksum, current.values), rowChecksum(w.checksum, w.blobs.refs(values))), nil
+}
+
+// skipUnchangedRow counts a source row skipped because the target already
+// holds it.
+func (w *tableWriter) skipUnchangedRow() {
+	w.stats.Skipped++
+	w.stats.Unchanged++
+}
+
+func (w *tableWriter) unlock() {
+	if w.locked {
+		w.locked = false
+		w.lock.Unlock()
+	}
+}
+
+// upsert writes one row, laid out as the pk column followed by table.columns.
+func (w *tableWriter) upsert(ctx context.Context, values []interface{}) error {
+	values, err := w.blobs.externalize(values)
+	if err != nil {
+		return err
+	}
+	if _, err := w.insert.ExecContext(ctx, w.table.writtenValues(values)...); err != nil {
+		return err
+	}
+	w.written++
+	w.pending++
+
+	if w.batchSize > 0 && w.pending >= w.batchSize {
+		w.closeStmts()
+		err := w.tx.Commit()
+		w.unlock()
+		if err != nil {
+			return fmt.Errorf("committing batch: %w", err)
+		}
+		if err := w.begin(ctx); err != nil {
+			return fmt.Errorf("starting batch: %w", err)
+		}
+	}
+	return nil
+}
+
+// skip counts a source row that was deliberately not written.
+func (w *tableWriter) skip() {
+	w.stats.Skipped++
+}
+
+// flag reports a source row caught by the data guards.
+func (w *tableWriter) flag(issue RowIssue) {
+	w.stats.Flagged++
+	if len(w.stats.Issues) < maxReportedIssues {
+		w.stats.Issues = append(w.stats.Issues, issue)
+	}
+}
+
+// keep marks a key, the values of the key columns of the table, as present
+// in the source so deleteOrphans leaves it alone. The keys are staged in a
+// temporary table rather than in memory.
+func (w *tableWriter) keep(ctx context.Context, key []interface{}) error {
+	if err := w.stageKeys(ctx); err != nil {
+		return err
+	}
+	_, err := w.kept.ExecContext(ctx, key...)
+	return err
+}
+
+// stageKeys creates the staging table of keep, unless done already.
+func (w *tableWriter) stageKeys(ctx context.Context) error {
+	if w.kept != nil {
+		return nil
+	}
+	cols := make([]string, len(w.table.keyCols))
+	for i := range cols {
+		cols[i] = fmt.Sprintf("k%d", i)
+	}
+	// Left by another table, whose key may have other columns
+	if _, err := w.tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+keepTable); err != nil {
+		return err
+	}
+	list := strings.Join(cols, ", ")
+	if _, err := w.tx.ExecContext(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s, PRIMARY KEY (%s))", keepTable, list, list)); err != nil {
+		return err
+	}
+	stmt, err := w.tx.PrepareContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", keepTable, list, strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+	if err != nil {
+		return err
+	}
+	w.kept = stmt
+	return nil
+}
+
+// deleteOrphans deletes the target rows whose key was not passed to keep.
+// Nothing is deleted when no key was kept at all.
+func (w *tableWriter) deleteOrphans(ctx context.Context) error {
+	if w.kept == nil {
+		return nil
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE NOT %s", quoteIdent(w.table.targetName()), w.keptKey())
+	if err := w.deleteKept(ctx, query); err != nil {
+		return err
+	}
+	return w.dropKept(ctx)
+}
+
+// deleteOrphansIn deletes the target rows of the key ranges whose key was
+// not passed to keep, even when no key was kept at all.
+func (w *tableWriter) deleteOrphansIn(ctx context.Context, ranges []keyRange) error {
+	if err := w.stageKeys(ctx); err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		where, args := r.where(w.table.pkCol)
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s AND NOT %s", quoteIdent(w.table.targetName()), where, w.keptKey())
+		if err := w.deleteKept(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return w.dropKept(ctx)
+}
+
+// keptKey returns the condition of the target rows whose key was passed to
+// keep, comparing every key column with IS so that NULL keys match too.
+func (w *tableWriter) keptKey() string {
+	conds := make([]string, len(w.table.keyCols))
+	for i, c := range w.table.keyCols {
+		conds[i] = fmt.Sprintf("k%d IS %s.%s", i, quoteIdent(w.table.targetName()), quoteIdent(c))
+	}
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s)", keepTable, strings.Join(conds, " AND "))
+}
+
+// deleteKey deletes the target row with the values of key in the key
+// columns of the table, see Config.Tracked and SoftDeleteColumn.
+func (w *tableWriter) deleteKey(ctx context.Context, key []interface{}) error {
+	conds := make([]string, len(w.table.keyCols))
+	for i, c := range w.table.keyCols {
+		conds[i] = quoteIdent(c) + " = ?"
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(w.table.targetName()), strings.Join(conds, " AND "))
+	return w.deleteKept(ctx, query, key...)
+}
+
+// deleteKept runs a delete of orphaned rows, counting them.
+func (w *tableWriter) deleteKept(ctx context.Context, query string, args ...interface{}) error {
+	res, err := w.exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("deleting orphaned rows: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting orphaned rows: %w", err)
+	}
+	w.stats.Deleted += deleted
+	return w.checkDeletes()
+}
+
+// checkDeletes fails once more target rows were deleted than the
+// MaxDeleteFraction of the table allows.
+func (w *tableWriter) checkDeletes() error {
+	if w.maxDeletes <= 0 || float64(w.stats.Deleted) <= w.maxDeletes*float64(w.before) {
+		return nil
+	}
+	return classify(ErrTooManyDeletes, fmt.Errorf("deleting %d of the %d target rows exceeds the delete fraction %g",
+		w.stats.Deleted, w.before, w.maxDeletes))
+}
+
+// dropKept drops the staging table of keep.
+func (w *tableWriter) dropKept(ctx context.Context) error {
+	w.kept.Close()
+	w.kept = nil
+	if _, err := w.tx.ExecContext(ctx, "DROP TABLE "+keepTable); err != nil {
+		return fmt.Errorf("dropping staged IDs: %w", err)
+	}
+	return nil
+}
+
+// exec runs a set-based statement in the current transaction, logging it
+// with its duration at debug level.
+func (w *tableWriter) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := w.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		w.log.DebugContext(ctx, "statement failed", "query", query, "duration", time.Since(start), "error", err)
+	} else {
+		w.log.DebugContext(ctx, "statement executed", "query", query, "duration", time.Since(start))
+	}
+	return res, err
+}
+
+// commit commits the transaction and finalizes the statistics.
+func (w *tableWriter) commit(ctx context.Context) (TableStats, error) {
+	after, err := countRows(ctx, w.tx, w.table)
+	if err != nil {
+		return w.stats, fmt.Errorf("counting target rows: %w", err)
+	}
+	// Deletes only ever remove rows, so whatever growth is left once they are
+	// added back comes from inserts.
+	w.stats.Inserted = max(after-w.before+w.stats.Deleted, 0)
+	w.stats.Replaced = w.written - w.stats.Inserted
+
+	if w.shared != nil {
+		_, err = w.tx.ExecContext(ctx, "RELEASE "+sharedSavepoint)
+	} else {
+		err = w.tx.Commit()
+	}
+	w.unlock()
+	if err != nil {
+		return w.stats, err
+	}
+	w.committed = true
+	w.stats.Duration = time.Since(w.start)
+	return w.stats, nil
+}
+
+// close releases the prepared statements and the connection, rolling back
+// the current transaction if it was not committed.
+func (w *tableWriter) close() {
+	if w.kept != nil {
+		w.kept.Close()
+	}
+	w.closeStmts()
+	if !w.committed {
+		w.rollback(w.tx)
+	}
+	w.unlock()
+	w.detach()
+	w.closeConn()
+}
+
+// closeConn returns the connection to the pool, unless it is the one of the
+// run transaction.
+func (w *tableWriter) closeConn() {
+	if w.shared == nil {
+		w.conn.Close()
+	}
+}
+
+// detach detaches the source from the connection before it goes back to the
+// pool, even when the run was cancelled.
+func (w *tableWriter) detach() {
+	if w.attached {
+		w.attached = false
+		w.conn.ExecContext(context.Background(), "DETACH DATABASE "+attachedSchema)
+	}
+}
+
+func (w *tableWriter) closeStmts() {
+	w.insert.Close()
+	if w.lookup != nil {
+		w.lookup.Close()
+	}
+}
+
+// checkTargetTable fails with ErrSchemaMismatch when the target lacks the
+// table or one of its synced columns, or unless Config.IgnoreSchemaDiff is
+// set, when its columns differ from those of the source.
+func checkTargetTable(ctx context.Context, db queryer, table Table, cfg Config) error {
+	rows, err := db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?)", table.targetName())
+	if err != nil {
+		return fmt.Errorf("reading target table: %w", err)
+	}
+	defer rows.Close()
+	var (
+		columns []string
+		schema  []columnDef
+	)
+	for rows.Next() {
+		var c columnDef
+		if err := rows.Scan(&c.name, &c.typ); err != nil {
+			return fmt.Errorf("reading target table: %w", err)
+		}
+		columns = append(columns, c.name)
+		schema = append(schema, c)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading target table: %w", err)
+	}
+
+	if len(columns) == 0 {
+		return classify(ErrTableMissing, fmt.Errorf("target has no table %s", table.targetName()))
+	}
+	for _, c := range table.columns {
+		if !containsFold(columns, c) {
+			return classify(ErrSchemaMismatch, fmt.Errorf("target table %s has no column %s", table.targetName(), c))
+		}
+	}
+	// A table read back from a recording has no source schema
+	if cfg.IgnoreSchemaDiff || table.schema == nil {
+		return nil
+	}
+	want, have := withoutUnsynced(table, table.schema), withoutUnsynced(table, schema)
+	if cfg.MigrateSchema {
+		want, have = migratedColumns(want), migratedColumns(have)
+	}
+	if diff := schemaDiff(want, have); diff != "" {
+		return classify(ErrSchemaMismatch, fmt.Errorf("columns of target table %s differ from the source (- source, + target):\n%s", table.targetName(), diff))
+	}
+	return nil
+}
+
+// isVirtualTable reports whether a target table is a virtual table, which
+// can't be written with an UPSERT.
+func isVirtualTable(ctx context.Context, db queryer, name string) (bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	var ddl sql.NullString
+	if err := rows.Scan(&ddl); err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(ddl.String)), "CREATE VIRTUAL TABLE"), nil
+}
+
+func countRows(ctx context.Context, tx *sql.Tx, table Table) (int64, error) {
+	var n int64
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdent(table.targetName()))).Scan(&n)
+	return n, err
+}
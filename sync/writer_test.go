@@ -0,0 +1,301 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/alvarolm/rslite/diff"
+)
+
+func TestSyncBatchCommits(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		wantRows int
+	}{
+		{name: "Batches before the failure are kept", config: Config{BatchSize: 2}, wantRows: 2},
+		{name: "Single transaction is all or nothing", config: Config{BatchSize: 2, SingleTx: true}, wantRows: 0},
+		{name: "No batch size is all or nothing", config: Config{}, wantRows: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+				name:    "items",
+				schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+				srcData: [][]interface{}{{1, "a"}, {2, "b"}, {3, nil}, {4, "d"}},
+			}})
+			// The target rejects the third source row
+			if _, err := tgtDB.Exec(`DROP TABLE items; CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := tt.config
+			cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+			if _, err := Sync(cfg); err == nil {
+				t.Fatal("Sync() succeeded writing a NULL into a NOT NULL column")
+			}
+
+			if got := countTestRows(t, tgtDB, "items"); got != tt.wantRows {
+				t.Errorf("got %d committed rows, want %d", got, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestSyncBatchCommitsDeletes(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}},
+		tgtData: [][]interface{}{{1, "old"}, {5, "e"}, {6, "f"}},
+	}})
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, BatchSize: 1, PageSize: 1})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got := stats.Tables[0]
+	if got.Inserted != 2 || got.Replaced != 1 || got.Deleted != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	data, err := getTableData(tgtDB, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{1, "a"}, {2, "b"}, {3, "c"}}; !compareData(data, want) {
+		t.Errorf("got %v, want %v", data, want)
+	}
+}
+
+// More source keys than SQLITE_MAX_VARIABLE_NUMBER (32766) can be bound to
+// a single statement are still kept by the orphan delete.
+func TestSyncDeleteOrphansLargeTable(t *testing.T) {
+	srcPath, tgtPath, srcDB, tgtDB := setupTestDBs(t, []testTable{{
+		name:   "items",
+		schema: `CREATE TABLE items (id INTEGER PRIMARY KEY)`,
+	}})
+	fill := `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?) INSERT INTO items SELECT i FROM n`
+	if _, err := srcDB.Exec(fill, 40000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tgtDB.Exec(fill, 40010); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, PageSize: 5000})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := stats.Tables[0].Deleted; got != 10 {
+		t.Errorf("deleted %d rows, want 10", got)
+	}
+	if got := countTestRows(t, tgtDB, "items"); got != 40000 {
+		t.Errorf("target has %d rows, want 40000", got)
+	}
+}
+
+// A target row is an orphan when the source lacks its whole key, even if it
+// holds the values of some key columns in other rows.
+func TestSyncDeleteOrphansCompositeKey(t *testing.T) {
+	for _, cfg := range []Config{{}, {NoAttach: true}} {
+		t.Run(fmt.Sprintf("noAttach=%v", cfg.NoAttach), func(t *testing.T) {
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+				name:    "pairs",
+				schema:  `CREATE TABLE pairs (a INTEGER, b INTEGER, v TEXT, PRIMARY KEY (a, b))`,
+				srcData: [][]interface{}{{1, 3, "x"}, {2, 4, "y"}},
+				tgtData: [][]interface{}{{1, 3, "x"}, {2, 3, "orphan"}},
+			}})
+			cfg.SrcDbPath, cfg.DstDbPath = srcPath, tgtPath
+			stats, err := Sync(cfg)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if got := stats.Tables[0].Deleted; got != 1 {
+				t.Errorf("deleted %d rows, want 1", got)
+			}
+			data, err := getTableData(tgtDB, "pairs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := [][]interface{}{{1, 3, "x"}, {2, 4, "y"}}; !compareData(data, want) {
+				t.Errorf("got %v, want %v", data, want)
+			}
+		})
+	}
+}
+
+func countTestRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSyncUpsert(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replace=%v", replace), func(t *testing.T) {
+			tables := []testTable{
+				{
+					name:    "parents",
+					schema:  `CREATE TABLE parents (id INTEGER PRIMARY KEY, name TEXT)`,
+					srcData: [][]interface{}{{1, "new"}},
+					tgtData: [][]interface{}{{1, "old"}},
+				},
+				{
+					name:    "children",
+					schema:  `CREATE TABLE children (id INTEGER PRIMARY KEY, parent INTEGER REFERENCES parents (id) ON DELETE CASCADE)`,
+					tgtData: [][]interface{}{{1, 1}},
+				},
+			}
+			srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+			// Only sync the parents, with foreign keys enforced on the target
+			cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath + "?_foreign_keys=1", Tables: []string{"parents"}, Replace: replace}
+			stats, err := Sync(cfg)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if got := stats.Tables[0]; got.Replaced != 1 || got.Inserted != 0 {
+				t.Errorf("unexpected stats %+v", got)
+			}
+
+			want := 1
+			if replace {
+				want = 0 // deleted by the cascade
+			}
+			if n := countTestRows(t, tgtDB, "children"); n != want {
+				t.Errorf("got %d children, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestSyncVirtualTable(t *testing.T) {
+	tables := []testTable{{
+		name:    "docs",
+		schema:  `CREATE VIRTUAL TABLE docs USING fts4(body)`,
+		srcData: [][]interface{}{{"hello world"}, {"second"}},
+		tgtData: [][]interface{}{{"stale"}},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	if _, err := Sync(Config{SrcDbPath: srcPath, DstDbPath: tgtPath, Tables: []string{"docs"}}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	var n int
+	if err := tgtDB.QueryRow(`SELECT COUNT(*) FROM docs WHERE docs MATCH 'hello'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || countTestRows(t, tgtDB, "docs") != 2 {
+		t.Errorf("unexpected target content: %d matches, %d rows", n, countTestRows(t, tgtDB, "docs"))
+	}
+}
+
+func TestSyncJSONColumns(t *testing.T) {
+	tables := []testTable{{
+		name:   "profiles",
+		schema: `CREATE TABLE profiles (id INTEGER PRIMARY KEY, data TEXT, note TEXT)`,
+		srcData: [][]interface{}{
+			{1, `{"name": "new", "tags": ["a"], "gone": null}`, `{"n": 1}`},
+			{2, `{"name": "b"}`, nil},
+		},
+		tgtData: [][]interface{}{
+			{1, `{"name": "old", "local": true, "gone": 1}`, `{"m": 1}`},
+			{2, `not json`, nil},
+		},
+	}}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, tables)
+
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, JSONColumns: []string{"profiles.data"}}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	got, err := getTableData(tgtDB, "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{1, `{"name":"new","local":true,"tags":["a"]}`, `{"n": 1}`},
+		{2, `{"name": "b"}`, nil},
+	}
+	if !compareData(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Left to differ: the member the source nulled, removed by the merge, and
+	// the one only the target has
+	var paths []map[string][]string
+	stats, err := Diff(context.Background(), cfg, func(d diff.Row) error {
+		paths = append(paths, d.Paths)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if want := []map[string][]string{{"data": {"$.gone", "$.local"}}}; !reflect.DeepEqual(paths, want) || stats.Tables[0].Identical != 1 {
+		t.Errorf("Diff() paths = %v, stats %+v, want %v", paths, stats.Tables[0], want)
+	}
+}
+
+func TestSyncMaxDeleteFraction(t *testing.T) {
+	var tgtData [][]interface{}
+	for i := 1; i <= 10; i++ {
+		tgtData = append(tgtData, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: tgtData[:3],
+		tgtData: tgtData,
+	}})
+
+	// 7 of 10 rows
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, MaxDeleteFraction: 0.5}
+	if _, err := Sync(cfg); !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("Sync() error = %v, want ErrTooManyDeletes", err)
+	}
+	if n := countTestRows(t, tgtDB, "items"); n != 10 {
+		t.Errorf("target has %d rows after the failed sync, want 10", n)
+	}
+	cfg.NoAttach = true
+	if _, err := Sync(cfg); !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("Sync() without attaching error = %v, want ErrTooManyDeletes", err)
+	}
+
+	cfg.TableOptions = map[string]TableOptions{"items": {MaxDeleteFraction: 0.8}}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("Sync() with the table fraction error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "items"); n != 3 {
+		t.Errorf("target has %d rows, want 3", n)
+	}
+
+	cfg.MaxDeleteFraction = 1.5
+	if _, err := Sync(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("fraction above 1: error = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestSyncMaxDeleteFractionForce(t *testing.T) {
+	srcPath, tgtPath, _, tgtDB := setupTestDBs(t, []testTable{{
+		name:    "items",
+		schema:  `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+		srcData: [][]interface{}{{1, "a"}},
+		tgtData: [][]interface{}{{1, "a"}, {2, "b"}},
+	}})
+	cfg := Config{SrcDbPath: srcPath, DstDbPath: tgtPath, MaxDeleteFraction: 0.1, Force: true}
+	if _, err := Sync(cfg); err != nil {
+		t.Fatalf("forced Sync() error = %v", err)
+	}
+	if n := countTestRows(t, tgtDB, "items"); n != 1 {
+		t.Errorf("target has %d rows, want 1", n)
+	}
+}
@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	for input, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		d := newXXHash64()
+		d.Write([]byte(input))
+		if got := d.Sum64(); got != want {
+			t.Errorf("xxhash64(%q) = %x, want %x", input, got, want)
+		}
+	}
+
+	// Written at once or in pieces
+	input := []byte(strings.Repeat("0123456789", 25))
+	whole := newXXHash64()
+	whole.Write(input)
+	for _, size := range []int{1, 3, 8, 31, 32, 33, 100} {
+		d := newXXHash64()
+		for b := input; len(b) > 0; {
+			n := min(size, len(b))
+			d.Write(b[:n])
+			b = b[n:]
+		}
+		if got, want := d.Sum64(), whole.Sum64(); got != want {
+			t.Errorf("written by %d bytes: %x, want %x", size, got, want)
+		}
+	}
+	if got := fmt.Sprintf("%x", whole.Sum(nil)); len(got) != 16 {
+		t.Errorf("Sum() = %s, want 8 bytes", got)
+	}
+}